@@ -0,0 +1,708 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Agent is a single agent conversation. The main agent is created from the
+// command line; sub-agents are spawned by it for independent subtasks and run
+// with their own conversation and step budget.
+type Agent struct {
+	Name         string
+	Task         string
+	Model        string
+	SystemPrompt string
+	Shell        string
+	Messages     []Message
+	MaxSteps     int
+	MaxTime      time.Duration
+	MaxFailures  int
+	Depth        int
+
+	Step   int
+	Events []AgentEvent
+
+	// SessionID names the session file the agent is saved to before every
+	// step; empty for sub-agents.
+	SessionID string
+	// unlockSession releases the session's lock file.
+	unlockSession func()
+
+	reader            *bufio.Reader
+	verificationTries int
+	failures          int
+	taskModel         string
+	cache             cacheStats
+	usage             Usage
+	// usageAtStart is the usage when the current top-level run started.
+	usageAtStart Usage
+	// Timings are the step timings, and timingsAtStart how many there were
+	// when the current run started.
+	Timings        []StepTiming
+	timingsAtStart int
+	clock          stepClock
+	// awaitingToolResult is set after a tool call, so that its result is
+	// sent back as a tool message.
+	awaitingToolResult bool
+	// approvedBy records how the last approval was given, for the audit log.
+	approvedBy string
+	// verdict is the critic's review of the last command, if there is one.
+	verdict string
+	// linted is the last command whose lint findings went back to the model.
+	linted        string
+	parentSession string
+	// chat is set for the agent of a `shai chat` session.
+	chat bool
+	// attempts are the most recent commands, for loop detection.
+	attempts []attempt
+	// Plan is the approved plan and its progress.
+	Plan []PlanStep
+	// Steps are the actions taken, for the Markdown transcript.
+	Steps []StepRecord
+	// Changes are the files the run created, modified and deleted.
+	Changes []FileChange
+	summary string
+	// remote is the `shai serve` session the agent reports to instead of
+	// the console.
+	remote *serveSession
+	// shell is the working directory and environment the agent's commands
+	// run in.
+	shell shellState
+}
+
+// AgentEvent records a decision or notable occurrence during a run, such as
+// which model was chosen for a step. Events are kept next to the
+// conversation so they can be reported on later.
+type AgentEvent struct {
+	Time   time.Time `json:"time"`
+	Step   int       `json:"step"`
+	Kind   string    `json:"kind"`
+	Detail string    `json:"detail"`
+}
+
+// AgentResult is how an agent run ended.
+type AgentResult struct {
+	Status  string
+	Summary string
+}
+
+const (
+	ResultComplete        = "COMPLETE"
+	ResultStopped         = "STOPPED"
+	ResultBudgetExhausted = "BUDGET_EXHAUSTED"
+)
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// dryRunOutput is the synthetic result of commands and queries in dry-run
+// mode.
+const dryRunOutput = "DRY RUN — not executed. Continue with the step you would take next, assuming it succeeded."
+
+// consoleMu serializes interactive prompts so that sub-agents running in
+// parallel never ask the user two things at once.
+var consoleMu sync.Mutex
+
+func newAgent(name string, task string, systemPrompt string, shell string, depth int) *Agent {
+	return &Agent{
+		Name:         name,
+		Task:         task,
+		Model:        executorModel(),
+		SystemPrompt: systemPrompt,
+		Shell:        shell,
+		Messages:     []Message{{Role: "user", Content: "START"}},
+		MaxSteps:     cfg.Budget.MaxSteps,
+		MaxTime:      time.Duration(cfg.Budget.MaxSeconds) * time.Second,
+		MaxFailures:  cfg.Budget.MaxConsecutiveFailures,
+		Depth:        depth,
+		reader:       stdinReader,
+	}
+}
+
+func (a *Agent) printf(format string, args ...any) {
+	if a.Name != "" {
+		format = "[" + a.Name + "] " + format
+	}
+	if a.remote != nil {
+		a.remote.emit("output", fmt.Sprintf(format, args...), nil)
+		return
+	}
+	fmt.Printf(format, args...)
+}
+
+func (a *Agent) confirm(message string) bool {
+	defer a.timeHuman(time.Now())
+	if a.Name != "" {
+		message = "[" + a.Name + "] " + message
+	}
+	approved, _ := cassetteAnswer(message, func() (bool, string) {
+		if a.remote != nil {
+			return a.remote.ask("confirm", message, "").approved, ""
+		}
+		return confirmAction(message, a.reader), ""
+	})
+	return approved
+}
+
+// approve asks for confirmation unless the approval policy auto-approves
+// commands of this risk tier.
+func (a *Agent) approve(risk string, message string) bool {
+	if approvalFor(risk) == approvalAllow {
+		a.printf("👍 Auto-approved (%s, %s policy)\n", risk, cfg.Approval)
+		a.approvedBy = "auto_approved"
+		return true
+	}
+	a.approvedBy = "approved"
+	defer a.notifyWaiting("approval", "shai needs your approval", message)()
+	return a.confirm(message)
+}
+
+// approveCommand is approve for a command the user may edit at the prompt.
+// It reports whether the command was approved, and updates it if edited.
+// With a critic, its verdict is shown first and kept in a.verdict, and a
+// risky verdict needs the user's approval whatever the policy.
+func (a *Agent) approveCommand(risk string, message string, command *string) bool {
+	a.verdict = a.criticize(*command, risk)
+	decision, reason, ruled := commandApproval(*command, risk)
+	if decision == approvalAllow && criticRisky(a.verdict) {
+		a.printf("🧐 The critic flagged this command, so it needs your approval despite %s.\n", reason)
+	} else if decision == approvalAllow {
+		a.printf("👍 Auto-approved by %s\n", reason)
+		a.approvedBy = "auto_approved"
+		return true
+	} else if ruled {
+		a.printf("📜 Asking because of %s\n", reason)
+	}
+	a.approvedBy = "approved"
+	if a.Name != "" {
+		message = "[" + a.Name + "] " + message
+	}
+	notified := a.notifyWaiting("approval", "shai needs your approval", "$ "+*command)
+	approved, edited := a.review(criticBanner(a.verdict)+message, *command, func(command string) {
+		printExplanation(command, a.Shell)
+	})
+	notified()
+	if approved && edited != *command {
+		a.approvedBy = "edited"
+		*command = edited
+	}
+	return approved
+}
+
+func (a *Agent) record(kind string, detail string) {
+	a.Events = append(a.Events, AgentEvent{Time: time.Now(), Step: a.Step, Kind: kind, Detail: detail})
+}
+
+// noteOutcome tracks consecutive failed steps: commands that errored and
+// responses that broke the protocol.
+func (a *Agent) noteOutcome(failed bool) {
+	if failed {
+		a.failures++
+	} else {
+		a.failures = 0
+	}
+}
+
+func (a *Agent) addUserMessage(content string) {
+	role := "user"
+	if a.awaitingToolResult {
+		role, a.awaitingToolResult = "tool", false
+	}
+	a.Messages = append(a.Messages, Message{Role: role, Content: redact(content)})
+	a.checkpoint()
+}
+
+func (a *Agent) Run() (AgentResult, error) {
+	started, startStep := time.Now(), a.Step
+
+	a.routeTask()
+	defer a.printCacheSummary()
+	a.usageAtStart = a.usage.clone()
+	a.timingsAtStart = len(a.Timings)
+	defer a.stopStepClock()
+	if a.SessionID != "" {
+		a.hook("session_start", HookPayload{})
+	}
+
+	for ; ; a.Step++ {
+		a.startStepClock()
+		a.checkpoint()
+		a.saveSession(sessionRunning)
+		if limit := a.exhaustedBudget(started, startStep); limit != "" {
+			return a.stopForBudget(limit), nil
+		}
+
+		a.manageContext()
+		a.routeStep()
+		if progress := a.usage.progress(); progress != "" {
+			a.printf("🤔 shai is thinking... (step %d; %s so far)\n", a.Step+1, progress)
+		} else {
+			a.printf("🤔 shai is thinking...\n")
+		}
+		ctx, stopInterject := a.interjectContext()
+		onToken, finishStream := a.streamTokens()
+		modelStart := time.Now()
+		resp, err := callModelTools(withNotices(ctx, a.printf), a.Model, a.Messages, a.SystemPrompt, a.samplingOptions(), toolsFor(a.Model), formatFor(), onToken)
+		finishStream()
+		if err != nil && ctx.Err() == nil && fallBackToText(a.Model, err) {
+			a.printf("ℹ️ %s does not support tool calling; using the text protocol.\n", a.Model)
+			a.record("protocol", "text")
+			onToken, finishStream = a.streamTokens()
+			resp, err = callModelStream(ctx, a.Model, a.Messages, a.SystemPrompt, a.samplingOptions(), onToken)
+			finishStream()
+		}
+		a.timeModel(modelStart)
+		if stopInterject() {
+			pauseStart := time.Now()
+			guidance := readResponse("\n✋ Paused. Guidance for shai (empty to stop this task): ", a.reader)
+			a.timeHuman(pauseStart)
+			if guidance == "" {
+				a.printf("🛑 Stopped by the user.\n")
+				return AgentResult{Status: ResultStopped, Summary: "Stopped by the user."}, nil
+			}
+			a.addUserMessage("USER_GUIDANCE: " + guidance)
+			continue
+		}
+		if err != nil {
+			debugLog.Debug("model error", "session", a.auditSession(), "agent", a.Name, "step", a.Step+1, "error", err.Error())
+			return AgentResult{}, fmt.Errorf("model API call failed: %w", err)
+		}
+		a.recordCacheStats(resp)
+		a.recordUsage(resp)
+		if cfg.ShowThinking && onToken == nil && resp.Message.Thinking != "" {
+			a.printf("\033[2m💭 %s\033[0m\n", resp.Message.Thinking)
+		}
+		response := resp.Message.Content
+
+		a.Messages = append(a.Messages, Message{Role: "assistant", Content: response, ToolCalls: resp.Message.ToolCalls})
+		a.checkpoint()
+
+		modelOutput := unfenceResponse(strings.TrimSpace(response))
+		action := ""
+		content := ""
+
+		idxSeparator := strings.IndexFunc(modelOutput, func(r rune) bool {
+			return r == ' ' || r == '\n'
+		})
+
+		if len(resp.Message.ToolCalls) > 0 {
+			// Only the first call is acted on; the model sees its result and
+			// can repeat the others.
+			action, content = toolCallAction(resp.Message.ToolCalls[0])
+			modelOutput = strings.TrimSpace(fmt.Sprintf("%s %s", action, content))
+			a.awaitingToolResult = true
+		} else if jsonAction, jsonContent, ok := parseJSONAction(modelOutput); ok && cfg.Protocol == "json" {
+			action, content = jsonAction, jsonContent
+		} else if idxSeparator == -1 {
+			action = strings.ToUpper(modelOutput)
+			content = ""
+		} else {
+			action = strings.ToUpper(modelOutput[:idxSeparator])
+			content = strings.TrimSpace(modelOutput[idxSeparator+1:])
+		}
+
+		debugLog.Debug("action", "session", a.auditSession(), "agent", a.Name, "step", a.Step+1, "action", action, "content", redact(content), "response", redact(response), "tool_calls", len(resp.Message.ToolCalls))
+
+		if action == "TASK_COMPLETE" {
+			if verified, review := a.verifyCompletion(content); !verified {
+				a.printf("🔍 The planner could not verify the task is complete:\n%s\n", review)
+				a.addUserMessage(fmt.Sprintf("PLANNER_REVIEW: The task is not verified as complete yet.\n%s\nContinue working until the goal is achieved and verified.", review))
+				continue
+			}
+			a.printf("✅ shai has completed the task successfully.\n")
+			a.printf("%s\n", content)
+			if a.Depth == 0 {
+				speakWait("shai has completed the task.")
+			}
+			return AgentResult{Status: ResultComplete, Summary: content}, nil
+		}
+		if action == "TASK_STOPPED" {
+			a.printf("🛑 shai has stopped the task, as it cannot proceed or needs human input.\n")
+			a.printf("%s\n", content)
+			if a.Depth == 0 {
+				speakWait("shai has stopped the task and needs your attention.")
+			}
+			return AgentResult{Status: ResultStopped, Summary: content}, nil
+		}
+
+		var packages []string
+		if action == "INSTALL" && cfg.InstallAction {
+			packages = strings.Fields(content)
+			command, err := installCommand(packages)
+			if err != nil {
+				a.addUserMessage(fmt.Sprintf("PREVIOUS_COMMAND_RESULT:\nSTATUS: ERROR\nOUTPUT:\nCould not translate INSTALL: %v\n\n", err))
+				a.noteOutcome(true)
+				continue
+			}
+			a.printf("📦 Translated INSTALL %s into: %s\n", content, command)
+			action, content = "RUN", command
+		}
+
+		// A script runs as a RUN in its language.
+		language := ""
+		if action == "RUN_SCRIPT" {
+			lang, script, err := parseRunScript(content)
+			if err != nil {
+				a.printf("⚠️ shai provided a malformed RUN_SCRIPT request (%v).\n", err)
+				a.addUserMessage(fmt.Sprintf("PREVIOUS_COMMAND_RESULT:\nSTATUS: ERROR\nOUTPUT:\nCould not run the script: %v\n\n", err))
+				a.noteOutcome(true)
+				continue
+			}
+			action, content, language = "RUN", script, lang
+		}
+
+		if action == "RUN" {
+			if language == "" {
+				content = cleanCommand(content)
+			}
+			var waits []string
+			if language == "" || language == "bash" {
+				var added []string
+				if content, added = makeNonInteractive(content); len(added) > 0 {
+					a.printf("🤖 Added %s so the command does not stop to ask.\n", strings.Join(added, ", "))
+				}
+				waits = interactiveWarnings(content)
+			}
+			if content == "" {
+				a.printf("⚠️ shai provided a malformed RUN command (missing command line). Response:\n---\n%s\n---\n", modelOutput)
+				a.addUserMessage(fmt.Sprintf("CRITICAL ERROR: Previous response was RUN but provided no command. Full response was:\n%s", modelOutput))
+				a.noteOutcome(true)
+				continue
+			}
+
+			command := content
+			status, output := "", ""
+			risk := scriptRisk(language, command)
+			if pattern, denied := deniedBy(command); denied {
+				a.printf("⛔ Blocked by the denylist (%s):\n\n%s\n\n", pattern, showScript(language, command))
+				status, output = "BLOCKED", fmt.Sprintf("POLICY_VIOLATION: the command matches the denylist pattern %q and was not executed. Do not try to work around the policy; find a safer approach or stop the task.", pattern)
+			} else if elevates(command) && !cfg.Sudo.Allowed {
+				a.printf("⛔ Blocked: sudo is disabled by the configuration:\n\n%s\n\n", showScript(language, command))
+				status, output = "BLOCKED", "POLICY_VIOLATION: sudo and doas are disabled by the configuration, so the command was not executed. Find a way that does not need root, or stop and tell the user what to run."
+			} else if cfg.DryRun {
+				a.printf("🧪 Dry run, not executing:\n\n%s\n\n", showScript(language, command))
+				status, output = "DRY_RUN", dryRunOutput
+			} else if decision, reason, _ := commandApproval(command, risk); decision == approvalDeny {
+				a.printf("⛔ Denied by %s:\n\n%s\n\n", reason, showScript(language, command))
+				status, output = "DENIED", fmt.Sprintf("The command is denied by %s, so it was not executed. Find a less risky approach or stop the task.", reason)
+			} else if findings := lintScript(language, command, a.Shell); a.bounceLint(command, findings) {
+				a.printf("🧹 The linter found problems; asking shai to fix the command first:\n   %s\n", strings.Join(findings, "\n   "))
+				status, output = "LINT", lintFeedback(findings)
+			} else if kubeBanner, kubeAllowed := a.kubeGuard(command); !kubeAllowed {
+				a.printf("🛑 Refusing to run a command against an untrusted Kubernetes context.\n")
+				status, output = "REJECTED", "Command targets a Kubernetes context the user has not trusted."
+			} else if discardSpeculation := a.startSpeculation("PREVIOUS_COMMAND_RESULT:\n"); !a.approveCommand(risk, fmt.Sprintf("%s%s%s%s✨ shai wants to run this %s command:\n\n%s\n\nAllow?", kubeBanner, lintBanner(findings), interactiveBanner(waits, usePTY(command)), envBanner(a.shell.environ()), risk, showScript(language, command)), &command) {
+				discardSpeculation()
+				a.printf("🛑 Rejecting command.\n")
+				status, output = "REJECTED", "Command rejected by user."
+				if criticRisky(a.verdict) {
+					output += "\nThe critic reviewing your commands said: " + a.verdict
+				}
+			} else if pattern, denied := deniedBy(command); denied && command != content {
+				discardSpeculation()
+				a.printf("⛔ The edited command is blocked by the denylist (%s).\n", pattern)
+				status, output = "BLOCKED", fmt.Sprintf("POLICY_VIOLATION: the command the user edited matches the denylist pattern %q and was not executed.", pattern)
+			} else if decision, reason, ruled := commandApproval(command, scriptRisk(language, command)); ruled && decision == approvalDeny && command != content {
+				discardSpeculation()
+				a.printf("⛔ The edited command is denied by %s.\n", reason)
+				status, output = "DENIED", fmt.Sprintf("The command the user edited is denied by %s and was not executed.", reason)
+			} else if command != content && !a.kubeTrusted(command) {
+				discardSpeculation()
+				a.printf("🛑 Refusing to run the edited command against an untrusted Kubernetes context.\n")
+				status, output = "REJECTED", "The command the user edited targets a Kubernetes context the user has not trusted."
+			} else if refusal := a.elevate(&command); refusal != "" {
+				discardSpeculation()
+				a.printf("🛑 Not running the command as root.\n")
+				status, output = "REJECTED", refusal
+				if strings.HasPrefix(refusal, "POLICY_VIOLATION") {
+					status = "BLOCKED"
+				}
+			} else if ok, hookOutput := a.hook("pre_command", HookPayload{Command: command, Risk: scriptRisk(language, command)}); !ok {
+				discardSpeculation()
+				a.printf("⛔ The pre_command hook vetoed the command.\n")
+				status, output = "BLOCKED", "POLICY_VIOLATION: a pre_command hook vetoed the command, so it was not executed."
+				if hookOutput != "" {
+					output += " It said: " + hookOutput
+				}
+			} else {
+				if command != content {
+					a.printf("✏️  Running the edited command:\n\n%s\n\n", showScript(language, command))
+				}
+				risk = scriptRisk(language, command)
+				if language != "" {
+					a.printf("🚀 Running the %s script...\n", language)
+				} else {
+					a.printf("🚀 Running command via %s...\n", a.Shell)
+				}
+				a.maybeSnapshot(command)
+				entry := newJournalEntry(a.auditSession(), a.Task, a.shell.workDir(), command, packages)
+				commandStart := time.Now()
+				status, output = cassetteResult("RUN", command, func() (string, string) {
+					return executeScript(language, command, a.Shell, &a.shell, a.console(), a.monitorCommand(command))
+				})
+				a.timeCommand(commandStart)
+				if err := appendJournal(entry, status); err != nil {
+					a.printf("⚠️ Failed to record the change for shai undo: %v\n", err)
+				}
+				a.hook("post_command", HookPayload{Command: command, Risk: risk, Status: status, Output: strings.TrimPrefix(output, "OUTPUT:\n")})
+			}
+			if language != "" {
+				a.audit("RUN_SCRIPT", command, risk, status, output)
+			} else {
+				a.audit("RUN", command, risk, status, output)
+			}
+
+			var feedback strings.Builder
+			feedback.WriteString("PREVIOUS_COMMAND_RESULT:\n")
+			if command != content {
+				feedback.WriteString(fmt.Sprintf("NOTE: The user edited your command before approving it. The command that was actually run is:\n%s\n", command))
+			}
+			feedback.WriteString(fmt.Sprintf("STATUS: %s\n", status))
+			feedback.WriteString("OUTPUT:\n")
+			feedback.WriteString(a.limitOutput(output))
+			feedback.WriteString("\n\n")
+			feedback.WriteString(errorHint(status, output))
+
+			if repeats := a.noteAttempt(command, status, output); repeats > 1 {
+				if cfg.Loop.MaxRepeats > 0 && repeats >= cfg.Loop.MaxRepeats {
+					a.printf("🔁 shai keeps running the same failing command; stopping.\n")
+					a.record("loop", command)
+					return AgentResult{Status: ResultStopped, Summary: fmt.Sprintf("Stopped after the command %q failed the same way %d times.", command, repeats)}, nil
+				}
+				a.printf("🔁 This command already failed the same way; telling shai to change approach.\n")
+				feedback.WriteString(loopWarning(repeats, status, output))
+			}
+
+			a.noteOutcome(strings.HasPrefix(status, "ERROR"))
+			a.addUserMessage(feedback.String())
+
+		} else if action == "SQL" {
+			database, query, _ := strings.Cut(content, "\n")
+			database, query = strings.TrimSpace(database), strings.TrimSpace(query)
+			if database == "" || query == "" {
+				a.printf("⚠️ shai provided a malformed SQL request (missing database or query). Response:\n---\n%s\n---\n", modelOutput)
+				a.addUserMessage(fmt.Sprintf("CRITICAL ERROR: Previous response was SQL but did not provide a database name and a query. Full response was:\n%s", modelOutput))
+				a.noteOutcome(true)
+				continue
+			}
+
+			status, output := "", ""
+			dbCfg, ok := cfg.Databases[database]
+			if !ok {
+				status, output = "ERROR", fmt.Sprintf("Unknown database %q. Configured databases: %s", database, strings.Join(databaseNames(), ", "))
+			} else if cfg.DryRun {
+				a.printf("🧪 Dry run, not querying %s:\n\n  %s\n\n", database, strings.ReplaceAll(query, "\n", "\n  "))
+				status, output = "DRY_RUN", dryRunOutput
+			} else if risk := queryRisk(dbCfg, query); approvalFor(risk) == approvalDeny {
+				a.printf("⛔ The %s approval policy denies %s queries.\n", cfg.Approval, risk)
+				status, output = "DENIED", fmt.Sprintf("The approval policy does not allow %s queries, so the query was not executed.", risk)
+			} else if discardSpeculation := a.startSpeculation("PREVIOUS_QUERY_RESULT:\n"); a.approve(risk, fmt.Sprintf("🗄️ shai wants to query database %s (%s, %s):\n\n  %s\n\nAllow?", database, dbCfg.Driver, dbCfg.accessMode(), strings.ReplaceAll(query, "\n", "\n  "))) {
+				a.printf("🚀 Querying %s...\n", database)
+				queryStart := time.Now()
+				status, output = cassetteResult("SQL", query, func() (string, string) {
+					return executeQuery(dbCfg, query)
+				})
+				a.timeCommand(queryStart)
+				a.printf("%s", output)
+			} else {
+				discardSpeculation()
+				a.printf("🛑 Rejecting query.\n")
+				status, output = "REJECTED", "Query rejected by user."
+			}
+			if ok {
+				a.audit("SQL", database+": "+query, queryRisk(dbCfg, query), status, output)
+			}
+
+			var feedback strings.Builder
+			feedback.WriteString("PREVIOUS_QUERY_RESULT:\n")
+			feedback.WriteString(fmt.Sprintf("STATUS: %s\n", status))
+			feedback.WriteString("OUTPUT:\n")
+			feedback.WriteString(a.limitOutput(output))
+			feedback.WriteString("\n\n")
+			feedback.WriteString(errorHint(status, output))
+
+			a.noteOutcome(strings.HasPrefix(status, "ERROR"))
+			a.addUserMessage(feedback.String())
+
+		} else if action == "SPAWN" && a.canSpawn() {
+			subtasks := parseSubtasks(content)
+			if len(subtasks) == 0 {
+				a.printf("⚠️ shai provided a malformed SPAWN request (no subtasks). Response:\n---\n%s\n---\n", modelOutput)
+				a.addUserMessage(fmt.Sprintf("CRITICAL ERROR: Previous response was SPAWN but listed no subtasks. Full response was:\n%s", modelOutput))
+				a.noteOutcome(true)
+				continue
+			}
+			if len(subtasks) > cfg.MaxSubagents {
+				a.addUserMessage(fmt.Sprintf("SPAWN_ERROR: You requested %d sub-agents but at most %d may run at once. Merge some subtasks and try again.", len(subtasks), cfg.MaxSubagents))
+				continue
+			}
+
+			var list strings.Builder
+			for i, subtask := range subtasks {
+				list.WriteString(fmt.Sprintf("  %d. %s\n", i+1, subtask))
+			}
+			if !a.confirm(fmt.Sprintf("🧬 shai wants to split the work into %d parallel sub-agents:\n\n%s\nAllow?", len(subtasks), list.String())) {
+				a.printf("🛑 Rejecting sub-agents.\n")
+				a.addUserMessage("SUBAGENT_RESULTS:\nSTATUS: REJECTED\nThe user rejected spawning sub-agents. Continue on your own.\n\n")
+				continue
+			}
+
+			a.addUserMessage(a.runSubagents(subtasks))
+
+		} else if action == "SCREENSHOT" && cfg.Vision {
+			if !a.confirm("📸 shai wants to take a screenshot of your screen. Allow?") {
+				a.printf("🛑 Rejecting screenshot.\n")
+				a.addUserMessage("SCREENSHOT_RESULT:\nSTATUS: REJECTED\nOUTPUT:\nScreenshot rejected by user.\n\n")
+				continue
+			}
+			image, err := takeScreenshot()
+			a.Messages = append(a.Messages, imageFeedback("SCREENSHOT", image, err))
+
+		} else if action == "VIEW_IMAGE" && cfg.Vision {
+			if content == "" {
+				a.printf("⚠️ shai provided a malformed VIEW_IMAGE request (missing path). Response:\n---\n%s\n---\n", modelOutput)
+				a.addUserMessage(fmt.Sprintf("CRITICAL ERROR: Previous response was VIEW_IMAGE but provided no path. Full response was:\n%s", modelOutput))
+				a.noteOutcome(true)
+				continue
+			}
+			a.printf("🖼️  shai is looking at %s\n", content)
+			image, err := loadImage(content)
+			a.Messages = append(a.Messages, imageFeedback("VIEW_IMAGE", image, err))
+
+		} else if tool, ok := fsTools[action]; ok && cfg.NativeTools {
+			status, output := a.runFSTool(action, content, tool)
+			a.noteOutcome(status != "SUCCESS")
+			a.addUserMessage(fmt.Sprintf("%s_RESULT:\nSTATUS: %s\nOUTPUT:\n%s\n\n", action, status, output))
+
+		} else if action == "MCP" && len(mcpClients) > 0 {
+			if strings.TrimSpace(content) == "" {
+				a.printf("⚠️ shai provided a malformed MCP request (missing tool name). Response:\n---\n%s\n---\n", modelOutput)
+				a.addUserMessage(fmt.Sprintf("CRITICAL ERROR: Previous response was MCP but named no tool. Full response was:\n%s", modelOutput))
+				a.noteOutcome(true)
+				continue
+			}
+			status, output := a.runMCPTool(content)
+			a.noteOutcome(strings.HasPrefix(status, "ERROR"))
+			a.addUserMessage(fmt.Sprintf("MCP_RESULT:\nSTATUS: %s\nOUTPUT:\n%s\n\n", status, a.limitOutput(output)))
+
+		} else if action == "PLAN_DONE" {
+			a.addUserMessage(a.markPlanStep(content))
+
+		} else if action == "READ_FILE" {
+			status, output := a.runFSTool(action, content, readFileAction)
+			a.noteOutcome(status != "SUCCESS")
+			a.addUserMessage(fmt.Sprintf("READ_FILE_RESULT:\nSTATUS: %s\nOUTPUT:\n%s\n\n", status, output))
+
+		} else if action == "WRITE_FILE" {
+			path, body, ok := parseWriteFile(content)
+			if !ok {
+				a.printf("⚠️ shai provided a malformed WRITE_FILE request (missing path). Response:\n---\n%s\n---\n", modelOutput)
+				a.addUserMessage(fmt.Sprintf("CRITICAL ERROR: Previous response was WRITE_FILE but provided no path. Full response was:\n%s", modelOutput))
+				a.noteOutcome(true)
+				continue
+			}
+			status, output := a.writeFile(path, body)
+			a.noteOutcome(strings.HasPrefix(status, "ERROR"))
+			a.addUserMessage(fmt.Sprintf("WRITE_FILE_RESULT:\nSTATUS: %s\nOUTPUT:\n%s\n\n", status, output))
+
+		} else if action == "SEARCH_FILES" {
+			expr, globs, _ := strings.Cut(content, "\n")
+			expr = strings.TrimSpace(expr)
+			if expr == "" {
+				a.printf("⚠️ shai provided a malformed SEARCH_FILES request (missing expression). Response:\n---\n%s\n---\n", modelOutput)
+				a.addUserMessage(fmt.Sprintf("CRITICAL ERROR: Previous response was SEARCH_FILES but provided no expression. Full response was:\n%s", modelOutput))
+				a.noteOutcome(true)
+				continue
+			}
+			a.printf("🔎 shai is searching files for: %s\n", expr)
+			status, output := searchFiles(a.shell.workDir(), expr, strings.Fields(globs))
+			a.noteOutcome(status != "SUCCESS")
+			a.addUserMessage(fmt.Sprintf("SEARCH_FILES_RESULT:\nSTATUS: %s\nOUTPUT:\n%s\n\n", status, output))
+
+		} else if action == "PICK_FILE" {
+			question, specs, _ := strings.Cut(content, "\n")
+			question = strings.TrimSpace(question)
+			if question == "" {
+				question = "Which file?"
+			}
+			candidates := pickerCandidates(a.shell.workDir(), strings.Split(specs, "\n"))
+			if len(candidates) == 0 {
+				a.addUserMessage("USER_PICKED_FILE:\nSTATUS: ERROR\nOUTPUT:\nNo files matched the candidates.\n\n")
+				continue
+			}
+			if cfg.NonInteractive {
+				a.addUserMessage("USER_CLARIFICATION: " + noHumanAnswer)
+				continue
+			}
+			speak(question)
+			if a.Name != "" {
+				question = "[" + a.Name + "] " + question
+			}
+			if a.remote != nil {
+				a.addUserMessage(fmt.Sprintf("USER_CLARIFICATION: %s", a.readAnswer(fmt.Sprintf("%s\n  %s", question, strings.Join(candidates, "\n  ")))))
+				continue
+			}
+			if choice := pickFile(question, candidates, a.reader); choice != "" {
+				a.addUserMessage(fmt.Sprintf("USER_PICKED_FILE: %s", choice))
+				continue
+			}
+			userInput := a.readAnswer("No file picked. Your response to shai: ")
+			a.addUserMessage(fmt.Sprintf("USER_CLARIFICATION: %s", userInput))
+
+		} else if action == "ASK" {
+			if content == "" {
+				a.printf("⚠️ shai provided a malformed ASK request (missing question). Response:\n---\n%s\n---\n", modelOutput)
+				a.record("unparseable", modelOutput)
+				a.addUserMessage(fmt.Sprintf("CRITICAL ERROR: Previous response was ASK but provided no question. Full response was:\n%s", modelOutput))
+				a.noteOutcome(true)
+				continue
+			}
+
+			question := content
+			a.record("ask", question)
+			a.hook("ask", HookPayload{Question: question})
+			if cfg.NonInteractive {
+				a.printf("\n❓ shai needs clarification:\n%s\n", question)
+				if cfg.OnAsk == "abort" {
+					a.printf("🛑 No human is available to answer; stopping the task.\n")
+					return AgentResult{Status: ResultStopped, Summary: "Needs human input: " + question}, nil
+				}
+				a.printf("🤖 No human is available to answer; asking shai to use its best judgement.\n")
+				a.addUserMessage("USER_CLARIFICATION: " + noHumanAnswer)
+				continue
+			}
+			notified := a.notifyWaiting("ask", "shai needs clarification", question)
+			if a.remote != nil {
+				a.addUserMessage(fmt.Sprintf("USER_CLARIFICATION: %s", a.readAnswer("❓ shai needs clarification:\n"+question)))
+				notified()
+				continue
+			}
+			consoleMu.Lock()
+			a.printf("\n❓ shai needs clarification:\n%s\n", question)
+			speak(question)
+
+			askStart := time.Now()
+			_, userInput := cassetteAnswer("Your response to shai:", func() (bool, string) {
+				return true, readResponse("Your response to shai: ", a.reader)
+			})
+			a.timeHuman(askStart)
+			consoleMu.Unlock()
+			notified()
+
+			a.addUserMessage(fmt.Sprintf("USER_CLARIFICATION: %s", userInput))
+
+		} else {
+			a.printf("⚠️ shai provided an UNRECOGNIZED response. Model response was:\n---\n%s\n---\n", modelOutput)
+			a.record("unparseable", modelOutput)
+			if !cfg.NonInteractive && !a.confirm("shai provided an unparseable response. Continue the loop?") {
+				return AgentResult{}, fmt.Errorf("user rejected unparseable model output, terminating")
+			}
+			a.addUserMessage(fmt.Sprintf("UNPARSEABLE_RESPONSE_ERROR: Your previous response did not follow the protocol. Your previous output was:\n%s", modelOutput))
+			a.noteOutcome(true)
+		}
+	}
+}
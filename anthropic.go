@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// AnthropicConfig holds settings specific to Anthropic's Messages API. The
+// model is ollama_model and the key is api_key or ANTHROPIC_API_KEY, as for
+// the other providers.
+type AnthropicConfig struct {
+	MaxTokens int    `json:"max_tokens"`
+	Version   string `json:"version"`
+}
+
+const anthropicDefaultURL = "https://api.anthropic.com"
+
+// anthropicProvider talks to Anthropic's /v1/messages. Unlike the
+// OpenAI-style APIs it takes the system prompt as a separate field,
+// requires max_tokens and strictly alternating user and assistant turns,
+// and streams typed events.
+type anthropicProvider struct{}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Stream        bool               `json:"stream"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+type anthropicContentBlock struct {
+	Type   string                `json:"type"`
+	Text   string                `json:"text,omitempty"`
+	Source *anthropicImageSource `json:"source,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicResponse struct {
+	Model   string                  `json:"model"`
+	Content []anthropicContentBlock `json:"content"`
+	Usage   anthropicUsage          `json:"usage"`
+}
+
+// anthropicEvent is one event of a streamed response; which fields are set
+// depends on its type.
+type anthropicEvent struct {
+	Type    string            `json:"type"`
+	Message anthropicResponse `json:"message"`
+	Delta   struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (anthropicProvider) name() string { return "Anthropic" }
+
+func (anthropicProvider) request(model string, messages []Message, options map[string]any, stream bool) (string, any) {
+	req := anthropicRequest{
+		Model:     model,
+		System:    messages[0].Content,
+		Messages:  toAnthropicMessages(messages[1:]),
+		MaxTokens: cfg.Anthropic.MaxTokens,
+		Stream:    stream,
+	}
+	if v, ok := optionInt(options, "num_predict"); ok {
+		req.MaxTokens = v
+	}
+	if v, ok := optionFloat(options, "temperature"); ok {
+		// The API accepts temperatures up to 1.
+		v = min(v, 1)
+		req.Temperature = &v
+	}
+	if v, ok := optionFloat(options, "top_p"); ok {
+		req.TopP = &v
+	}
+	if stop, ok := options["stop"].([]string); ok {
+		req.StopSequences = stop
+	}
+	return anthropicEndpoint("/messages"), req
+}
+
+// toAnthropicMessages converts the conversation. Tool results are sent as
+// user turns, and consecutive turns of the same role are merged, since the
+// API rejects two user messages in a row.
+func toAnthropicMessages(messages []Message) []anthropicMessage {
+	var converted []anthropicMessage
+	for _, message := range messages {
+		role := "user"
+		if message.Role == "assistant" {
+			role = "assistant"
+		}
+		var blocks []anthropicContentBlock
+		if text := messageText(message); text != "" {
+			blocks = append(blocks, anthropicContentBlock{Type: "text", Text: text})
+		}
+		for _, image := range message.Images {
+			blocks = append(blocks, anthropicContentBlock{
+				Type:   "image",
+				Source: &anthropicImageSource{Type: "base64", MediaType: imageMediaType(image), Data: image},
+			})
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		if n := len(converted); n > 0 && converted[n-1].Role == role {
+			converted[n-1].Content = append(converted[n-1].Content, blocks...)
+			continue
+		}
+		converted = append(converted, anthropicMessage{Role: role, Content: blocks})
+	}
+	return converted
+}
+
+func (anthropicProvider) parse(body io.Reader, onToken func(string)) (ChatResponse, error) {
+	if onToken != nil {
+		return parseAnthropicStream(body, onToken)
+	}
+	var message anthropicResponse
+	if err := json.NewDecoder(body).Decode(&message); err != nil {
+		return ChatResponse{}, err
+	}
+	var content strings.Builder
+	for _, block := range message.Content {
+		if block.Type == "text" {
+			content.WriteString(block.Text)
+		}
+	}
+	return ChatResponse{
+		Model:           message.Model,
+		Message:         Message{Role: "assistant", Content: content.String()},
+		Done:            true,
+		PromptEvalCount: message.Usage.InputTokens,
+		EvalCount:       message.Usage.OutputTokens,
+	}, nil
+}
+
+func parseAnthropicStream(body io.Reader, onToken func(string)) (ChatResponse, error) {
+	result := ChatResponse{Done: true}
+	var content strings.Builder
+	err := readServerSentEvents(body, func(data []byte) error {
+		var event anthropicEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		switch event.Type {
+		case "message_start":
+			result.Model = event.Message.Model
+			result.PromptEvalCount = event.Message.Usage.InputTokens
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" {
+				content.WriteString(event.Delta.Text)
+				onToken(event.Delta.Text)
+			}
+		case "message_delta":
+			result.EvalCount = event.Usage.OutputTokens
+		case "error":
+			return fmt.Errorf("%s: %s", event.Error.Type, event.Error.Message)
+		}
+		return nil
+	})
+	result.Message = Message{Role: "assistant", Content: content.String()}
+	return result, err
+}
+
+func (anthropicProvider) authorize(req *http.Request) {
+	if key := apiKey("ANTHROPIC_API_KEY"); key != "" {
+		req.Header.Set("x-api-key", key)
+	}
+	req.Header.Set("anthropic-version", cfg.Anthropic.Version)
+}
+
+// anthropicEndpoint joins a path onto the API URL and its /v1 prefix.
+func anthropicEndpoint(path string) string {
+	base := strings.TrimRight(modelAPIURL(), "/")
+	if !strings.HasSuffix(base, "/v1") {
+		base += "/v1"
+	}
+	return base + path
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	maxAttachmentSize = 32 << 10
+	// maxContextSize bounds everything attached with --context together.
+	maxContextSize = 128 << 10
+)
+
+// contextPaths are the files and directories given with --context.
+var contextPaths []string
+
+func init() {
+	flag.Func("context", "attach a file, or the text files in a directory, as context (repeatable)", func(path string) error {
+		contextPaths = append(contextPaths, path)
+		return nil
+	})
+}
+
+const attachmentTemplate = `
+
+ATTACHED CONTEXT (%s):
+---
+%s
+---`
+
+// attachContext adds a piece of context, such as the clipboard contents, to
+// the first message of the conversation.
+func (a *Agent) attachContext(name string, content string) {
+	content = strings.TrimRight(content, "\n")
+	if len(content) > maxAttachmentSize {
+		content = content[:maxAttachmentSize] + fmt.Sprintf("\n[... truncated, %d more bytes ...]", len(content)-maxAttachmentSize)
+	}
+	a.Messages[0].Content += fmt.Sprintf(attachmentTemplate, name, content)
+	a.printf("📎 Attached %s (%d bytes)\n", name, len(content))
+}
+
+// readClipboard returns the current clipboard text using the platform's
+// clipboard tool.
+func readClipboard() (string, error) {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbpaste"}}
+	case "windows":
+		candidates = [][]string{{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard -Raw"}}
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append(candidates, []string{"wl-paste", "--no-newline"})
+		}
+		candidates = append(candidates,
+			[]string{"xclip", "-selection", "clipboard", "-o"},
+			[]string{"xsel", "--clipboard", "--output"},
+		)
+	}
+
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate[0]); err != nil {
+			continue
+		}
+		out, err := exec.Command(candidate[0], candidate[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s failed: %w", candidate[0], err)
+		}
+		return string(out), nil
+	}
+	return "", fmt.Errorf("no clipboard tool found (install wl-clipboard, xclip or xsel)")
+}
+
+// writeClipboard puts text on the clipboard using the platform's clipboard
+// tool.
+func writeClipboard(text string) error {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		candidates = [][]string{{"clip.exe"}}
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append(candidates, []string{"wl-copy"})
+		}
+		candidates = append(candidates,
+			[]string{"xclip", "-selection", "clipboard"},
+			[]string{"xsel", "--clipboard", "--input"},
+		)
+	}
+
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(candidate[0], candidate[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s failed: %w", candidate[0], err)
+		}
+		return nil
+	}
+	return fmt.Errorf("no clipboard tool found (install wl-clipboard, xclip or xsel)")
+}
+
+// resolveClipboardMention replaces @clipboard in the task with a pointer to
+// the attachment, and reports whether the clipboard should be attached.
+func resolveClipboardMention(task string) (string, bool) {
+	if !strings.Contains(task, "@clipboard") {
+		return task, false
+	}
+	return strings.ReplaceAll(task, "@clipboard", "the attached clipboard contents"), true
+}
+
+// attachContextFiles attaches the --context files, and the text files under
+// the --context directories (skipping what .gitignore excludes), until
+// maxContextSize is used up. Binary files are skipped.
+func (a *Agent) attachContextFiles() error {
+	budget := maxContextSize
+	var skipped []string
+	attach := func(path string) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s (%v)", path, err))
+			return
+		}
+		if !isText(data) {
+			skipped = append(skipped, path+" (binary)")
+			return
+		}
+		size := min(len(data), maxAttachmentSize)
+		if size > budget {
+			skipped = append(skipped, path+" (over the size limit)")
+			return
+		}
+		budget -= size
+		a.attachContext(path, string(data))
+	}
+
+	for _, path := range contextPaths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("cannot attach %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			attach(path)
+			continue
+		}
+		err = walkFiles(path, func(rel string) error {
+			if budget == 0 {
+				return fs.SkipAll
+			}
+			attach(filepath.Join(path, filepath.FromSlash(rel)))
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("cannot attach %s: %w", path, err)
+		}
+	}
+	if len(skipped) > 0 {
+		a.printf("⚠️ Not attached: %s\n", strings.Join(skipped, ", "))
+	}
+	return nil
+}
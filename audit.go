@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry records one command, query or file write that shai proposed,
+// whether it was run, and a hash of what it produced. The audit log is only
+// ever appended to.
+type AuditEntry struct {
+	Time         time.Time `json:"time"`
+	Session      string    `json:"session,omitempty"`
+	Agent        string    `json:"agent,omitempty"`
+	Action       string    `json:"action"`
+	Command      string    `json:"command"`
+	Dir          string    `json:"dir"`
+	Risk         string    `json:"risk,omitempty"`
+	Decision     string    `json:"decision"`
+	Status       string    `json:"status"`
+	OutputBytes  int       `json:"output_bytes"`
+	OutputSHA256 string    `json:"output_sha256,omitempty"`
+}
+
+var auditMu sync.Mutex
+
+func auditPath() (string, error) {
+	dir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "audit.jsonl"), nil
+}
+
+// auditSession is the session an agent's actions belong to; sub-agents
+// report their top-level agent's session.
+func (a *Agent) auditSession() string {
+	if a.SessionID != "" {
+		return a.SessionID
+	}
+	return a.parentSession
+}
+
+// audit appends an entry for an action to the audit log and adds the step to
+// the session's transcript. The decision is derived from the status for
+// actions that did not run, and from how the last approval was given
+// otherwise. For file writes, output is the new contents.
+func (a *Agent) audit(action, command, risk, status, output string) {
+	decision := a.approvedBy
+	switch status {
+	case "BLOCKED", "DRY_RUN", "DENIED", "REJECTED", "LINT":
+		decision, output = strings.ToLower(status), ""
+	}
+	a.recordStep(action, command, risk, decision, status, output)
+	debugLog.Debug("result", "session", a.auditSession(), "agent", a.Name, "step", a.Step+1, "action", action, "command", redact(command), "risk", risk, "decision", decision, "status", status, "output", redact(output))
+	if !cfg.Audit {
+		return
+	}
+	entry := AuditEntry{
+		Time:        time.Now(),
+		Session:     a.auditSession(),
+		Agent:       a.Name,
+		Action:      action,
+		Command:     command,
+		Dir:         a.shell.workDir(),
+		Risk:        risk,
+		Decision:    decision,
+		Status:      status,
+		OutputBytes: len(output),
+	}
+	if output != "" {
+		sum := sha256.Sum256([]byte(output))
+		entry.OutputSHA256 = hex.EncodeToString(sum[:])
+	}
+
+	path, err := auditPath()
+	if err == nil {
+		auditMu.Lock()
+		defer auditMu.Unlock()
+		var f *os.File
+		if f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err == nil {
+			data, _ := json.Marshal(entry)
+			_, err = f.Write(append(data, '\n'))
+			f.Close()
+		}
+	}
+	if err != nil {
+		a.printf("⚠️ Failed to write the audit log: %v\n", err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AzureConfig holds settings for Azure OpenAI. api_url is the resource
+// endpoint, such as https://NAME.openai.azure.com, or AZURE_OPENAI_ENDPOINT
+// when api_url is not set. Requests go to deployments rather than models:
+// Deployments maps the model names shai uses (ollama_model and the planner
+// and router models) to deployment names, and a name without an entry is
+// taken as a deployment name. Auth is "key", for api_key or
+// AZURE_OPENAI_API_KEY, or "entra", for a Microsoft Entra ID (Azure AD)
+// token.
+type AzureConfig struct {
+	APIVersion  string            `json:"api_version"`
+	Deployments map[string]string `json:"deployments"`
+	Auth        string            `json:"auth"`
+}
+
+const azureDefaultAPIVersion = "2024-10-21"
+
+// azureTokenResource is the audience of Entra ID tokens for Azure OpenAI.
+const azureTokenResource = "https://cognitiveservices.azure.com"
+
+// azureProvider talks to Azure OpenAI's /chat/completions, which takes the
+// deployment in the path, the API version as a query parameter and the key
+// in an api-key header.
+type azureProvider struct{}
+
+func (azureProvider) name() string { return "Azure OpenAI" }
+
+func (azureProvider) request(model string, messages []Message, options map[string]any, stream bool) (string, any) {
+	req := newChatCompletionsRequest(model, messages, options, stream)
+	if stream {
+		req.StreamOptions = &chatCompletionsStreamOptions{IncludeUsage: true}
+	}
+	return azureEndpoint(azureDeployment(model), "/chat/completions"), req
+}
+
+func (azureProvider) withFormat(body any, schema any) any {
+	return openAIProvider{}.withFormat(body, schema)
+}
+
+func (azureProvider) parse(body io.Reader, onToken func(string)) (ChatResponse, error) {
+	return parseChatCompletionsResponse(body, onToken)
+}
+
+func (azureProvider) authorize(req *http.Request) {
+	if cfg.Azure.Auth == "entra" {
+		token, err := azureADToken()
+		if err != nil {
+			noticef(req.Context(), "⚠️ %v\n", err)
+		}
+		bearerAuth(req, token)
+		return
+	}
+	if key := apiKey("AZURE_OPENAI_API_KEY"); key != "" {
+		req.Header.Set("api-key", key)
+	}
+}
+
+// azureDeployment returns the deployment that serves a model.
+func azureDeployment(model string) string {
+	if deployment, ok := cfg.Azure.Deployments[model]; ok {
+		return deployment
+	}
+	return model
+}
+
+// azureEndpoint builds the URL of a deployment's operation, accepting an
+// api_url given with or without the /openai suffix.
+func azureEndpoint(deployment string, path string) string {
+	base := strings.TrimSuffix(strings.TrimRight(modelAPIURL(), "/"), "/openai")
+	return base + "/openai/deployments/" + url.PathEscape(deployment) + path + "?api-version=" + url.QueryEscape(cfg.Azure.APIVersion)
+}
+
+// azureDeployments lists the model names mapped to deployments. Azure
+// OpenAI has no data-plane call that lists deployments.
+func azureDeployments() ([]string, error) {
+	if len(cfg.Azure.Deployments) == 0 {
+		return nil, fmt.Errorf("Azure OpenAI does not list deployments; map model names to them in azure.deployments")
+	}
+	var names []string
+	for name := range cfg.Azure.Deployments {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// checkAzure reports Azure settings that cannot work.
+func checkAzure() error {
+	if cfg.Provider != "azure" {
+		return nil
+	}
+	if modelAPIURL() == "" {
+		return fmt.Errorf("the azure provider needs api_url (or AZURE_OPENAI_ENDPOINT) set to the resource endpoint, such as https://NAME.openai.azure.com")
+	}
+	if cfg.Azure.APIVersion == "" {
+		return fmt.Errorf("azure.api_version must not be empty")
+	}
+	switch cfg.Azure.Auth {
+	case "key", "entra":
+		return nil
+	}
+	return fmt.Errorf("unknown azure.auth %q (expected key or entra)", cfg.Azure.Auth)
+}
+
+var azureToken struct {
+	mu      sync.Mutex
+	value   string
+	expires time.Time
+}
+
+// azureADToken returns an Entra ID token for Azure OpenAI:
+// AZURE_OPENAI_AD_TOKEN when it is set, otherwise one from the Azure CLI,
+// kept until five minutes before it expires.
+func azureADToken() (string, error) {
+	if token := os.Getenv("AZURE_OPENAI_AD_TOKEN"); token != "" {
+		return token, nil
+	}
+	azureToken.mu.Lock()
+	defer azureToken.mu.Unlock()
+	if azureToken.value != "" && time.Until(azureToken.expires) > 5*time.Minute {
+		return azureToken.value, nil
+	}
+
+	out, err := exec.Command("az", "account", "get-access-token", "--resource", azureTokenResource, "--output", "json").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get an Entra ID token from the Azure CLI (run `az login`, or set AZURE_OPENAI_AD_TOKEN): %w", err)
+	}
+	var token struct {
+		AccessToken string `json:"accessToken"`
+		ExpiresOn   int64  `json:"expires_on"`
+	}
+	if err := json.Unmarshal(out, &token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("failed to read the Azure CLI token: %v", err)
+	}
+	azureToken.value = token.AccessToken
+	azureToken.expires = time.Unix(token.ExpiresOn, 0)
+	if token.ExpiresOn == 0 {
+		// Older Azure CLIs only give a local time; tokens last an hour.
+		azureToken.expires = time.Now().Add(30 * time.Minute)
+	}
+	return azureToken.value, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// `shai bench` runs a suite of canned tasks against one or more models in
+// dry-run mode and scores how well each follows the protocol: how often its
+// responses parse as actions, how many steps it takes, whether it finishes,
+// and which commands it keeps proposing again.
+
+var (
+	suiteFlag = flag.String("suite", "", "bench: file of tasks to run, one per line, instead of the built-in suite")
+	runsFlag  = flag.Int("runs", 1, "bench: how many times to run each task")
+)
+
+// benchSuite is the built-in suite: everyday tasks of a few steps, and one
+// ambiguous task a careful model should ask about.
+var benchSuite = []string{
+	"Show how much free space each mounted filesystem has.",
+	"Find the five largest files under the current directory.",
+	"Count the lines in each Go file in this directory and report the total.",
+	"Find out which process is listening on TCP port 8080.",
+	"Create a directory named backup and copy every .conf file in the current directory into it.",
+	"List the TODO comments in the source files here with their file and line.",
+	"Report the current git branch and the last three commits.",
+	"Clean up the old logs.",
+}
+
+// benchMaxSteps bounds each bench task unless --max-steps is given.
+const benchMaxSteps = 15
+
+// benchRun is the outcome of one task run.
+type benchRun struct {
+	model     string
+	task      string
+	status    string // a result status, or ERROR
+	responses int
+	unparsed  int
+	asks      int
+	repeats   int
+	repeated  []string
+	err       error
+	elapsed   time.Duration
+}
+
+func benchCommand(args []string) error {
+	// As with run, flags may follow "bench".
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	prepareRun()
+	if *runsFlag < 1 {
+		return fmt.Errorf("--runs must be at least 1")
+	}
+	tasks := benchSuite
+	if *suiteFlag != "" {
+		var err error
+		if tasks, err = readSuite(expandPath(*suiteFlag)); err != nil {
+			return err
+		}
+	}
+	models := flag.Args()
+	if len(models) == 0 {
+		models = []string{executorModel()}
+	}
+
+	// Only the model under test answers, nothing runs, and nobody is asked.
+	cfg.DryRun, cfg.NonInteractive, cfg.OnAsk = true, true, "proceed"
+	cfg.Router.Enabled, cfg.PlannerModel, cfg.Plan = false, "", false
+	cfg.Audit = false
+	if *maxStepsFlag == 0 {
+		cfg.Budget.MaxSteps = benchMaxSteps
+	}
+
+	fmt.Printf("🏁 Benchmarking %s on %d tasks, %d run(s) each, in dry-run mode\n\n", strings.Join(models, ", "), len(tasks), *runsFlag)
+	var runs []benchRun
+	for _, model := range models {
+		for _, task := range tasks {
+			for i := 0; i < *runsFlag; i++ {
+				run := benchTask(model, task)
+				runs = append(runs, run)
+				fmt.Printf("%s %-20s %s\n", benchIcon(run.status), truncateLine(model, 20), run.describe())
+			}
+		}
+	}
+	printBenchSummary(models, runs)
+	return nil
+}
+
+// readSuite reads a suite file: one task per line, ignoring blank lines and
+// # comments.
+func readSuite(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open suite: %w", err)
+	}
+	defer f.Close()
+	var tasks []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			tasks = append(tasks, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read suite: %w", err)
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("the suite %s has no tasks", path)
+	}
+	return tasks, nil
+}
+
+// benchTask runs one task with the agent's console output discarded.
+func benchTask(model string, task string) benchRun {
+	shell := defaultShell()
+	agent := newAgent("", task, generateSystemPrompt(task, runtime.GOOS, shell, false), shell, 0)
+	agent.Model = model
+
+	stdout := os.Stdout
+	if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devNull
+		defer devNull.Close()
+	}
+	started := time.Now()
+	result, err := agent.Run()
+	os.Stdout = stdout
+
+	run := benchRun{model: model, task: task, status: result.Status, elapsed: time.Since(started)}
+	if err != nil {
+		run.status, run.err = "ERROR", err
+	}
+	run.responses, _, _ = agent.usage.totals()
+	for _, event := range agent.Events {
+		switch event.Kind {
+		case "unparseable":
+			run.unparsed++
+		case "ask":
+			run.asks++
+		}
+	}
+	seen := map[string]bool{}
+	for _, step := range agent.Steps {
+		command := normalizeCommand(step.Command)
+		if seen[command] {
+			run.repeats++
+			run.repeated = append(run.repeated, step.Command)
+		}
+		seen[command] = true
+	}
+	return run
+}
+
+func benchIcon(status string) string {
+	switch status {
+	case ResultComplete:
+		return "✅"
+	case ResultStopped:
+		return "🛑"
+	case ResultBudgetExhausted:
+		return "⌛"
+	}
+	return "❌"
+}
+
+func (r benchRun) describe() string {
+	details := []string{fmt.Sprintf("%d steps", r.responses)}
+	if r.unparsed > 0 {
+		details = append(details, fmt.Sprintf("%d unparseable", r.unparsed))
+	}
+	if r.asks > 0 {
+		details = append(details, fmt.Sprintf("%d asks", r.asks))
+	}
+	if r.repeats > 0 {
+		details = append(details, fmt.Sprintf("%d repeats", r.repeats))
+	}
+	details = append(details, formatSeconds(r.elapsed.Seconds()))
+	if r.err != nil {
+		details = append(details, truncateLine(r.err.Error(), 80))
+	}
+	return fmt.Sprintf("%s (%s)", truncateLine(r.task, 50), strings.Join(details, ", "))
+}
+
+// printBenchSummary prints a row per model and the commands models repeated.
+func printBenchSummary(models []string, runs []benchRun) {
+	fmt.Printf("\n📊 Results:\n")
+	fmt.Printf("  %-24s %9s %10s %9s %7s %8s %9s\n", "model", "complete", "protocol", "steps", "asks", "repeats", "time")
+	for _, model := range models {
+		var n, complete, responses, unparsed, asks, repeats int
+		var elapsed time.Duration
+		for _, r := range runs {
+			if r.model != model {
+				continue
+			}
+			n++
+			if r.status == ResultComplete {
+				complete++
+			}
+			responses += r.responses
+			unparsed += r.unparsed
+			asks += r.asks
+			repeats += r.repeats
+			elapsed += r.elapsed
+		}
+		protocol := "-"
+		if responses > 0 {
+			protocol = fmt.Sprintf("%.0f%%", 100*float64(responses-unparsed)/float64(responses))
+		}
+		fmt.Printf("  %-24s %4d/%-4d %10s %9.1f %7d %8d %9s\n", truncateLine(model, 24), complete, n, protocol,
+			float64(responses)/float64(n), asks, repeats, formatSeconds(elapsed.Seconds()/float64(n)))
+	}
+	fmt.Println("\n  complete: tasks finished with TASK_COMPLETE; protocol: responses that parsed as an action;")
+	fmt.Println("  steps, time: averages per task; repeats: commands proposed again in the same task.")
+
+	var loops []benchRun
+	for _, r := range runs {
+		if r.repeats > 0 {
+			loops = append(loops, r)
+		}
+	}
+	if len(loops) > 0 {
+		fmt.Printf("\n🔁 Repeated commands:\n")
+		for _, r := range loops {
+			fmt.Printf("  %s — %s\n", truncateLine(r.model, 24), truncateLine(r.task, 60))
+			for _, command := range r.repeated {
+				fmt.Printf("    $ %s\n", truncateLine(command, 100))
+			}
+		}
+	}
+}
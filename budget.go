@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BudgetConfig bounds a run so that a model that keeps failing cannot loop
+// forever. Zero disables a limit. When a limit is hit shai stops, reports
+// what was accomplished and exits with exitBudgetExhausted.
+type BudgetConfig struct {
+	MaxSteps               int `json:"max_steps"`
+	MaxSeconds             int `json:"max_seconds"`
+	MaxConsecutiveFailures int `json:"max_consecutive_failures"`
+}
+
+// exhaustedBudget names the limit the current run has reached, if any.
+// Steps and time are counted from the start of this call to Run, so a
+// resumed session or a new chat message starts with a fresh budget.
+func (a *Agent) exhaustedBudget(started time.Time, startStep int) string {
+	switch {
+	case a.MaxSteps > 0 && a.Step-startStep >= a.MaxSteps:
+		return fmt.Sprintf("%d steps", a.MaxSteps)
+	case a.MaxTime > 0 && time.Since(started) >= a.MaxTime:
+		return fmt.Sprintf("%s of wall-clock time", a.MaxTime)
+	case a.MaxFailures > 0 && a.failures >= a.MaxFailures:
+		return fmt.Sprintf("%d consecutive failed steps", a.MaxFailures)
+	}
+	return ""
+}
+
+// stopForBudget ends a run whose budget is exhausted with a summary of the
+// progress made, so the user (or the parent agent) knows where it stands.
+func (a *Agent) stopForBudget(limit string) AgentResult {
+	a.printf("⏳ shai ran out of its budget of %s.\n", limit)
+	a.record("budget", limit)
+	progress := a.summarizeMessages(a.Messages)
+	a.printf("📋 Progress so far:\n%s\n", strings.TrimSpace(progress))
+	return AgentResult{
+		Status:  ResultBudgetExhausted,
+		Summary: fmt.Sprintf("Stopped after reaching the budget of %s. Progress so far:\n%s", limit, progress),
+	}
+}
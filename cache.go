@@ -0,0 +1,43 @@
+package main
+
+// cacheStats tracks how much of each prompt Ollama actually had to evaluate.
+// Ollama only reports the tokens it evaluated, so the size of the full prompt
+// is estimated from its length; the reuse figures are approximate.
+type cacheStats struct {
+	steps           int
+	promptTokens    int
+	evaluatedTokens int
+}
+
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+func (a *Agent) recordCacheStats(resp ChatResponse) {
+	if resp.PromptEvalCount == 0 {
+		return
+	}
+
+	prompt := estimateTokens(a.SystemPrompt)
+	for _, message := range a.Messages {
+		prompt += estimateTokens(message.Content)
+	}
+	evaluated := min(resp.PromptEvalCount, prompt)
+
+	a.cache.steps++
+	a.cache.promptTokens += prompt
+	a.cache.evaluatedTokens += evaluated
+
+	if cfg.CacheStats {
+		a.printf("♻️  Prompt cache: ~%d of ~%d prompt tokens reused, %d evaluated\n", prompt-evaluated, prompt, resp.PromptEvalCount)
+	}
+}
+
+func (a *Agent) printCacheSummary() {
+	if !cfg.CacheStats || a.cache.promptTokens == 0 {
+		return
+	}
+	reused := a.cache.promptTokens - a.cache.evaluatedTokens
+	a.printf("♻️  Prompt cache over %d steps: ~%d%% of prompt tokens reused (~%d of ~%d)\n",
+		a.cache.steps, reused*100/a.cache.promptTokens, reused, a.cache.promptTokens)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// With --record, shai writes every model response, command result and user
+// answer of a run to a cassette, one JSON object per line. With --replay, it
+// runs the agent loop again from a cassette: the model, the shell, databases,
+// MCP tools and file writes are never touched, and the user is never asked.
+// Replays are deterministic, so a cassette both reproduces a reported
+// misbehavior and serves as an integration test.
+
+var (
+	recordFlag = flag.String("record", "", "record model responses, command results and answers to this cassette file")
+	replayFlag = flag.String("replay", "", "replay a run from this cassette file instead of calling the model and the shell")
+)
+
+// Interaction is one line of a cassette.
+type Interaction struct {
+	Kind     string        `json:"kind"` // model, RUN, SQL, MCP, WRITE_FILE or answer
+	Input    string        `json:"input,omitempty"`
+	Response *ChatResponse `json:"response,omitempty"`
+	Status   string        `json:"status,omitempty"`
+	Output   string        `json:"output,omitempty"`
+	Approved bool          `json:"approved,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// cassette is the open recording or replay, if any. Interactions are replayed
+// in the order they were recorded.
+var cassette struct {
+	mu       sync.Mutex
+	file     *os.File
+	replay   []Interaction
+	next     int
+	diverged error
+}
+
+func recording() bool { return cassette.file != nil }
+func replaying() bool { return cassette.replay != nil }
+
+// openCassette starts recording or replaying as the flags ask.
+func openCassette() error {
+	if *recordFlag != "" && *replayFlag != "" {
+		return fmt.Errorf("--record and --replay cannot be used together")
+	}
+	if *recordFlag != "" {
+		f, err := os.OpenFile(expandPath(*recordFlag), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create cassette: %w", err)
+		}
+		cassette.file = f
+		fmt.Printf("📼 Recording to %s\n", *recordFlag)
+	}
+	if *replayFlag != "" {
+		interactions, err := loadCassette(expandPath(*replayFlag))
+		if err != nil {
+			return err
+		}
+		cassette.replay = interactions
+		fmt.Printf("📼 Replaying %d interactions from %s\n", len(interactions), *replayFlag)
+	}
+	return nil
+}
+
+func loadCassette(path string) ([]Interaction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cassette: %w", err)
+	}
+	defer f.Close()
+	interactions := []Interaction{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var interaction Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			return nil, fmt.Errorf("invalid cassette %s, line %d: %w", path, line, err)
+		}
+		interactions = append(interactions, interaction)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	return interactions, nil
+}
+
+// recordInteraction appends an interaction to the cassette being recorded.
+// Each line is written as it happens, so a crashed run still leaves a
+// cassette up to the crash.
+func recordInteraction(interaction Interaction) {
+	if !recording() {
+		return
+	}
+	line, _ := json.Marshal(interaction)
+	cassette.mu.Lock()
+	defer cassette.mu.Unlock()
+	cassette.file.Write(append(line, '\n'))
+}
+
+// replayInteraction returns the next recorded interaction, which must be of
+// the given kind and input. Once the run diverges from the cassette, every
+// later model call fails, which ends the run.
+func replayInteraction(kind, input string) (Interaction, error) {
+	cassette.mu.Lock()
+	defer cassette.mu.Unlock()
+	if cassette.diverged != nil {
+		return Interaction{}, cassette.diverged
+	}
+	if cassette.next >= len(cassette.replay) {
+		cassette.diverged = fmt.Errorf("replay diverged: the cassette has no %s interaction left after %d", kind, cassette.next)
+		return Interaction{}, cassette.diverged
+	}
+	interaction := cassette.replay[cassette.next]
+	if interaction.Kind != kind || (kind != "model" && kind != "answer" && interaction.Input != input) {
+		cassette.diverged = fmt.Errorf("replay diverged at interaction %d: expected %s %q, got %s %q", cassette.next+1, interaction.Kind, interaction.Input, kind, input)
+		return Interaction{}, cassette.diverged
+	}
+	cassette.next++
+	return interaction, nil
+}
+
+// cassetteModel records or replays a model call.
+func cassetteModel(onToken func(string), call func() (ChatResponse, error)) (ChatResponse, error) {
+	if replaying() {
+		interaction, err := replayInteraction("model", "")
+		if err != nil {
+			return ChatResponse{}, err
+		}
+		if interaction.Error != "" {
+			return ChatResponse{}, errors.New(interaction.Error)
+		}
+		if interaction.Response == nil {
+			return ChatResponse{}, fmt.Errorf("replay: model interaction %d has no response", cassette.next)
+		}
+		if onToken != nil && interaction.Response.Message.Content != "" {
+			onToken(interaction.Response.Message.Content)
+		}
+		return *interaction.Response, nil
+	}
+	resp, err := call()
+	if recording() {
+		interaction := Interaction{Kind: "model"}
+		if err != nil {
+			interaction.Error = err.Error()
+		} else {
+			interaction.Response = &resp
+		}
+		recordInteraction(interaction)
+	}
+	return resp, err
+}
+
+// cassetteResult records or replays a command, query, tool call or file
+// write. A divergent replay is reported to the model as an error; the next
+// model call then ends the run.
+func cassetteResult(kind, input string, run func() (string, string)) (string, string) {
+	if replaying() {
+		interaction, err := replayInteraction(kind, input)
+		if err != nil {
+			fmt.Printf("⚠️ %v\n", err)
+			return "ERROR", err.Error()
+		}
+		if kind == "RUN" {
+			fmt.Print(strings.TrimPrefix(interaction.Output, "OUTPUT:\n"))
+		}
+		return interaction.Status, interaction.Output
+	}
+	status, output := run()
+	recordInteraction(Interaction{Kind: kind, Input: input, Status: status, Output: output})
+	return status, output
+}
+
+// cassetteAnswer records or replays the user's answer to a prompt.
+func cassetteAnswer(prompt string, ask func() (bool, string)) (bool, string) {
+	if replaying() {
+		interaction, err := replayInteraction("answer", prompt)
+		if err != nil {
+			fmt.Printf("⚠️ %v\n", err)
+			return false, ""
+		}
+		answer := interaction.Output
+		if answer == "" || !interaction.Approved {
+			answer = map[bool]string{true: "yes", false: "no"}[interaction.Approved]
+		}
+		fmt.Printf("%s\n📼 %s\n", prompt, answer)
+		return interaction.Approved, interaction.Output
+	}
+	approved, text := ask()
+	recordInteraction(Interaction{Kind: "answer", Input: prompt, Approved: approved, Output: text})
+	return approved, text
+}
+
+// closeCassette flushes the recording and reports whether the replay used
+// the whole cassette.
+func closeCassette() {
+	if recording() {
+		cassette.file.Close()
+	}
+	if replaying() {
+		cassette.mu.Lock()
+		defer cassette.mu.Unlock()
+		if cassette.diverged == nil && cassette.next < len(cassette.replay) {
+			fmt.Printf("⚠️ The replay ended with %d of %d cassette interactions unused.\n", len(cassette.replay)-cassette.next, len(cassette.replay))
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ChangesConfig controls the summary of the files a task created, modified
+// and deleted in the working directory, found by scanning it before and
+// after the task. With Diff, text files are also shown as unified diffs.
+type ChangesConfig struct {
+	Enabled bool `json:"enabled"`
+	Diff    bool `json:"diff"`
+	// MaxFiles bounds the scan; larger trees are not tracked.
+	MaxFiles int `json:"max_files"`
+}
+
+const (
+	// maxTrackedFileSize is the largest file whose contents are kept for
+	// diffs, and maxTrackedBytes the most kept in total.
+	maxTrackedFileSize = 256 << 10
+	maxTrackedBytes    = 32 << 20
+)
+
+// FileChange is a file a task created, modified or deleted.
+type FileChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"` // created, modified or deleted
+	Diff   string `json:"diff,omitempty"`
+}
+
+type fileState struct {
+	size     int64
+	modTime  time.Time
+	mode     fs.FileMode
+	contents []byte // nil when not kept
+}
+
+// treeSnapshot is the state of the working tree's files, as walkFiles sees
+// them, so ignored and vendored directories are left out.
+type treeSnapshot struct {
+	root  string
+	files map[string]fileState
+}
+
+// snapshotTree scans the directory root. It returns nil when change
+// tracking is off or the tree has more than changes.max_files files.
+func snapshotTree(root string) *treeSnapshot {
+	if !cfg.Changes.Enabled {
+		return nil
+	}
+	snapshot := &treeSnapshot{root: root, files: map[string]fileState{}}
+	kept := 0
+	err := walkFiles(root, func(rel string) error {
+		if len(snapshot.files) >= cfg.Changes.MaxFiles {
+			return fs.SkipAll
+		}
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil
+		}
+		state := fileState{size: info.Size(), modTime: info.ModTime(), mode: info.Mode()}
+		if cfg.Changes.Diff && info.Size() <= maxTrackedFileSize && kept+int(info.Size()) <= maxTrackedBytes {
+			if data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(rel))); err == nil && isText(data) {
+				state.contents = data
+				kept += len(data)
+			}
+		}
+		snapshot.files[rel] = state
+		return nil
+	})
+	if err != nil || len(snapshot.files) >= cfg.Changes.MaxFiles {
+		return nil
+	}
+	return snapshot
+}
+
+func isText(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) < 0
+}
+
+// changesSince compares the working tree with an earlier snapshot.
+func (before *treeSnapshot) changesSince() []FileChange {
+	after := map[string]fileState{}
+	walkFiles(before.root, func(rel string) error {
+		if info, err := os.Stat(filepath.Join(before.root, filepath.FromSlash(rel))); err == nil {
+			after[rel] = fileState{size: info.Size(), modTime: info.ModTime(), mode: info.Mode()}
+		}
+		return nil
+	})
+
+	var changes []FileChange
+	for rel, old := range before.files {
+		now, exists := after[rel]
+		switch {
+		case !exists:
+			change := FileChange{Path: rel, Change: "deleted"}
+			if old.contents != nil {
+				change.Diff = unifiedDiff(rel, string(old.contents), "")
+			}
+			changes = append(changes, change)
+		case now.size != old.size || !now.modTime.Equal(old.modTime) || now.mode != old.mode:
+			change := FileChange{Path: rel, Change: "modified"}
+			if old.contents != nil {
+				data, err := os.ReadFile(filepath.Join(before.root, filepath.FromSlash(rel)))
+				if err == nil && bytes.Equal(data, old.contents) && now.mode == old.mode {
+					continue // touched, but the same
+				}
+				if err == nil && isText(data) {
+					change.Diff = unifiedDiff(rel, string(old.contents), string(data))
+				}
+			}
+			changes = append(changes, change)
+		}
+	}
+	for rel, now := range after {
+		if _, existed := before.files[rel]; existed {
+			continue
+		}
+		change := FileChange{Path: rel, Change: "created"}
+		if cfg.Changes.Diff && now.size <= maxTrackedFileSize {
+			if data, err := os.ReadFile(filepath.Join(before.root, filepath.FromSlash(rel))); err == nil && isText(data) {
+				change.Diff = unifiedDiff(rel, "", string(data))
+			}
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+var changeMarks = map[string]string{"created": "+", "modified": "~", "deleted": "-"}
+
+// reportChanges records and prints what the task changed since before was
+// taken.
+func (a *Agent) reportChanges(before *treeSnapshot) {
+	if before == nil {
+		return
+	}
+	a.Changes = before.changesSince()
+	if len(a.Changes) == 0 {
+		a.printf("📂 No files in %s were changed.\n", before.root)
+		return
+	}
+	a.printf("📂 Files changed in %s:\n", before.root)
+	for _, change := range a.Changes {
+		a.printf("  %s %s\n", changeMarks[change.Change], change.Path)
+	}
+	for _, change := range a.Changes {
+		if change.Diff != "" {
+			a.printf("\n%s", change.Diff)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// chatGoal stands in for the task in the system prompt of a chat session,
+// which stays the same as tasks come and go so the prompt cache holds.
+const chatGoal = `This is an interactive session. The user sends tasks and guidance as USER_MESSAGE messages, one at a time. Work on the latest task until it is complete or stopped, then the user will send the next one. Earlier tasks and their results remain in the conversation as context.`
+
+const chatHelp = `Type a task, or guidance about the previous one. Commands:
+  /model [name]  show or change the model
+  /reset         start a new conversation
+  /save [file]   show the session ID, and write the transcript to a file if given
+  /undo          reverse the last command shai ran
+  /help          show this help
+  /exit          leave (also Ctrl+D)
+While shai is thinking, press Ctrl+C to pause it and give guidance.`
+
+func chatCommand(args []string) error {
+	prepareRun()
+	userShell := defaultShell()
+	systemPrompt := generateSystemPrompt(chatGoal, runtime.GOOS, userShell, true)
+
+	newChatAgent := func() *Agent {
+		agent := newAgent("", "", systemPrompt, userShell, 0)
+		agent.Messages = nil
+		agent.claimSession()
+		agent.chat = true
+		return agent
+	}
+	agent := newChatAgent()
+	defer func() { agent.releaseSession() }()
+	trapInterrupts(agent)
+
+	printBanner("interactive chat (/help for commands)", userShell)
+	for {
+		input, err := readChatLine("💬 ")
+		if err != nil {
+			fmt.Println()
+			return nil
+		}
+		if input == "" {
+			continue
+		}
+
+		if command, ok := strings.CutPrefix(input, "/"); ok {
+			name, arg, _ := strings.Cut(command, " ")
+			arg = strings.TrimSpace(arg)
+			switch name {
+			case "exit", "quit":
+				return nil
+			case "help":
+				fmt.Println(chatHelp)
+			case "reset":
+				agent.releaseSession()
+				shell := agent.shell
+				agent = newChatAgent()
+				agent.shell = shell
+				trapInterrupts(agent)
+				fmt.Println("🧹 Started a new conversation.")
+			case "model":
+				if arg != "" {
+					cfg.ExecutorModel = arg
+					agent.Model = arg
+				}
+				fmt.Printf("🧠 Model: %s\n", agent.Model)
+			case "save":
+				fmt.Printf("💾 The session is saved as %s after every step; `shai resume %s` continues it.\n", agent.SessionID, agent.SessionID)
+				if arg != "" {
+					if err := os.WriteFile(expandPath(arg), []byte(renderTranscript(redactMessages(agent.Messages))), 0644); err != nil {
+						fmt.Printf("⚠️ Failed to save the transcript: %v\n", err)
+					} else {
+						fmt.Printf("💾 Wrote the transcript to %s.\n", arg)
+					}
+				}
+			case "undo":
+				if err := undoLast(stdinReader); err != nil {
+					fmt.Printf("⚠️ Undo failed: %v\n", err)
+				}
+			default:
+				fmt.Printf("⚠️ Unknown command /%s; type /help for the list.\n", name)
+			}
+			continue
+		}
+
+		agent.Task = input
+		agent.addUserMessage("USER_MESSAGE: " + input)
+		started := time.Now()
+		before := snapshotTree(agent.shell.workDir())
+		result, err := agent.Run()
+		agent.reportChanges(before)
+		agent.finishRun(result, err, started)
+		if err != nil {
+			fmt.Printf("⚠️ %v\n", err)
+		}
+		fmt.Println()
+	}
+}
+
+// readChatLine reads one line of chat input, failing at the end of input.
+func readChatLine(prompt string) (string, error) {
+	if voiceMode {
+		return readResponse(prompt, stdinReader), nil
+	}
+	fmt.Print(prompt)
+	return readLine(stdinReader)
+}
+
+// interjectContext returns the context for a model call. At the console the
+// user can cancel it with Ctrl+C to give guidance; stop ends the watch and
+// reports whether they did.
+func (a *Agent) interjectContext() (ctx context.Context, stop func() bool) {
+	if a.remote != nil || cfg.NonInteractive {
+		return context.Background(), func() bool { return false }
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopWatch := onInterrupt(cancel)
+	return ctx, func() bool {
+		interrupted := stopWatch()
+		cancel()
+		return interrupted
+	}
+}
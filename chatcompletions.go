@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// Wire types for the OpenAI-style /v1/chat/completions API, which several
+// backends implement.
+
+type chatCompletionsMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type chatCompletionsContentPart struct {
+	Type     string                   `json:"type"`
+	Text     string                   `json:"text,omitempty"`
+	ImageURL *chatCompletionsImageURL `json:"image_url,omitempty"`
+}
+
+type chatCompletionsImageURL struct {
+	URL string `json:"url"`
+}
+
+type chatCompletionsRequest struct {
+	Model       string                   `json:"model"`
+	Messages    []chatCompletionsMessage `json:"messages"`
+	Stream      bool                     `json:"stream"`
+	Temperature *float64                 `json:"temperature,omitempty"`
+	TopP        *float64                 `json:"top_p,omitempty"`
+	Seed        *int                     `json:"seed,omitempty"`
+	MaxTokens   *int                     `json:"max_tokens,omitempty"`
+	Stop        []string                 `json:"stop,omitempty"`
+
+	StreamOptions  *chatCompletionsStreamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat *chatCompletionsResponseFormat `json:"response_format,omitempty"`
+}
+
+type chatCompletionsResponseFormat struct {
+	Type       string         `json:"type"`
+	JSONSchema map[string]any `json:"json_schema,omitempty"`
+}
+
+type chatCompletionsStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// chatCompletionsChunk is one server-sent event of a streamed response.
+type chatCompletionsChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+type chatCompletionsResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Role             string `json:"role"`
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// toChatCompletionsMessages converts the conversation, sending attached
+// images as data URLs.
+func toChatCompletionsMessages(messages []Message) []chatCompletionsMessage {
+	converted := make([]chatCompletionsMessage, 0, len(messages))
+	for _, message := range messages {
+		if len(message.Images) == 0 {
+			converted = append(converted, chatCompletionsMessage{Role: message.Role, Content: message.Content})
+			continue
+		}
+		parts := []chatCompletionsContentPart{{Type: "text", Text: message.Content}}
+		for _, image := range message.Images {
+			parts = append(parts, chatCompletionsContentPart{
+				Type:     "image_url",
+				ImageURL: &chatCompletionsImageURL{URL: "data:" + imageMediaType(image) + ";base64," + image},
+			})
+		}
+		converted = append(converted, chatCompletionsMessage{Role: message.Role, Content: parts})
+	}
+	return converted
+}
+
+// newChatCompletionsRequest maps Ollama-style options onto the request.
+func newChatCompletionsRequest(model string, messages []Message, options map[string]any, stream bool) chatCompletionsRequest {
+	req := chatCompletionsRequest{
+		Model:    model,
+		Messages: toChatCompletionsMessages(messages),
+		Stream:   stream,
+	}
+	if v, ok := optionFloat(options, "temperature"); ok {
+		req.Temperature = &v
+	}
+	if v, ok := optionFloat(options, "top_p"); ok {
+		req.TopP = &v
+	}
+	if v, ok := optionInt(options, "seed"); ok {
+		req.Seed = &v
+	}
+	if v, ok := optionInt(options, "num_predict"); ok {
+		req.MaxTokens = &v
+	}
+	if stop, ok := options["stop"].([]string); ok {
+		req.Stop = stop
+	}
+	return req
+}
+
+func parseChatCompletionsResponse(body io.Reader, onToken func(string)) (ChatResponse, error) {
+	if onToken != nil {
+		return parseChatCompletionsStream(body, onToken)
+	}
+	var completion chatCompletionsResponse
+	if err := json.NewDecoder(body).Decode(&completion); err != nil {
+		return ChatResponse{}, err
+	}
+	result := ChatResponse{
+		Model:           completion.Model,
+		Done:            true,
+		PromptEvalCount: completion.Usage.PromptTokens,
+		EvalCount:       completion.Usage.CompletionTokens,
+	}
+	result.Message.Role = "assistant"
+	if len(completion.Choices) > 0 {
+		result.Message.Content = completion.Choices[0].Message.Content
+		result.Message.Thinking = completion.Choices[0].Message.ReasoningContent
+	}
+	return result, nil
+}
+
+func parseChatCompletionsStream(body io.Reader, onToken func(string)) (ChatResponse, error) {
+	result := ChatResponse{Done: true}
+	var content, thinking strings.Builder
+	stream := thinkingStream{onToken: onToken}
+	err := readServerSentEvents(body, func(data []byte) error {
+		var chunk chatCompletionsChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return err
+		}
+		if chunk.Model != "" {
+			result.Model = chunk.Model
+		}
+		if len(chunk.Choices) > 0 {
+			delta := chunk.Choices[0].Delta
+			content.WriteString(delta.Content)
+			thinking.WriteString(delta.ReasoningContent)
+			stream.write(delta.ReasoningContent, delta.Content)
+		}
+		if chunk.Usage != nil {
+			result.PromptEvalCount = chunk.Usage.PromptTokens
+			result.EvalCount = chunk.Usage.CompletionTokens
+		}
+		return nil
+	})
+	result.Message = Message{Role: "assistant", Content: content.String(), Thinking: thinking.String()}
+	return result, err
+}
+
+func optionFloat(options map[string]any, key string) (float64, bool) {
+	switch v := options[key].(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func optionInt(options map[string]any, key string) (int, bool) {
+	switch v := options[key].(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// imageMediaType sniffs the media type of a base64-encoded image from its
+// first bytes.
+func imageMediaType(image string) string {
+	switch {
+	case len(image) >= 4 && image[:4] == "/9j/":
+		return "image/jpeg"
+	case len(image) >= 4 && image[:4] == "R0lG":
+		return "image/gif"
+	case len(image) >= 4 && image[:4] == "UklG":
+		return "image/webp"
+	default:
+		return "image/png"
+	}
+}
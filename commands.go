@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// subcommand is a `shai <name>` command. A first argument that names a
+// subcommand, with an argument count it accepts, runs it; anything else is
+// taken as a task, so `shai "<task>"` is shorthand for `shai run "<task>"`.
+type subcommand struct {
+	name        string
+	args        string
+	description string
+	minArgs     int
+	maxArgs     int // -1 for no limit
+	run         func(args []string) error
+}
+
+var subcommands []subcommand
+
+func init() {
+	subcommands = []subcommand{
+		{"run", "[flags] \"<task>\"", "run a task (the default)", 0, -1, runCommand},
+		{"chat", "", "start an interactive session with several tasks", 0, 0, chatCommand},
+		{"explain", "\"<command>\"", "break a command down flag by flag, with a risk note; reads stdin without one", 0, -1, explainCommand},
+		{"suggest", "\"<what you want>\"", "suggest one command with an explanation, without running the agent", 1, -1, suggestCommand},
+		{"fix", "[flags] [guidance]", "diagnose and repair the last command the shell hook recorded", 0, -1, fixCommand},
+		{"init", "bash|zsh|fish", "print the shell hook that records commands for shai fix", 1, 1, initCommand},
+		{"resume", "[session-id]", "continue an interrupted session", 0, 1, resumeCommand},
+		{"sessions", "", "list saved sessions", 0, 0, func([]string) error { return printSessions() }},
+		{"history", "[count]", "list recent tasks", 0, 1, historyCommand},
+		{"serve", "[address]", "run tasks submitted over an HTTP API", 0, 1, serveCommand},
+		{"export", "[session-id] [file|-]", "write a session's Markdown transcript", 0, 2, exportCommand},
+		{"stats", "", "summarize past tasks", 0, 0, func([]string) error { return printStats() }},
+		{"models", "", "list the models the backend serves", 0, 0, func([]string) error { return printModels() }},
+		{"doctor", "", "check the setup and suggest fixes", 0, 0, doctorCommand},
+		{"bench", "[flags] [model...]", "score how well models follow the protocol on a suite of tasks, in dry-run mode", 0, -1, benchCommand},
+		{"pull", "<model>", "download a model into Ollama", 1, 1, pullCommand},
+		{"config", "get [key] | set <key> <value> | path", "show or change the configuration", 1, 3, configCommand},
+		{"undo", "[session-id]", "reverse the last command shai ran, or all of a session's, where possible", 0, 1, undoCommand},
+		{"rollback", "", "restore the last ZFS/btrfs snapshot or git checkpoint", 0, 0, func([]string) error { return rollback(stdinReader) }},
+		{"completion", "bash|zsh|fish|powershell", "print a shell completion script", 1, 1, completionCommand},
+		// Used by the completion scripts; not listed in the usage.
+		{"__complete", "", "", 0, -1, completeCommand},
+	}
+}
+
+// findSubcommand returns the subcommand args invoke, if any.
+func findSubcommand(args []string) (subcommand, bool) {
+	if len(args) == 0 {
+		return subcommand{}, false
+	}
+	for _, c := range subcommands {
+		n := len(args) - 1
+		if c.name == args[0] && n >= c.minArgs && (c.maxArgs < 0 || n <= c.maxArgs) {
+			return c, true
+		}
+	}
+	return subcommand{}, false
+}
+
+func runCommand(args []string) error {
+	// Flags may also follow "run".
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	prepareRun()
+	task, piped, err := taskInput(flag.Args())
+	if err != nil {
+		return err
+	}
+	runTask(task, piped...)
+	return nil
+}
+
+func resumeCommand(args []string) error {
+	prepareRun()
+	id := ""
+	if len(args) > 0 {
+		id = args[0]
+	}
+	agent, err := resumeSession(id)
+	if err != nil {
+		return err
+	}
+	printBanner(agent.Task, agent.Shell)
+	runAgent(agent)
+	return nil
+}
+
+func historyCommand(args []string) error {
+	count := 20
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid count %q", args[0])
+		}
+		count = n
+	}
+	entries, err := readHistory()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("🤷 No tasks recorded yet.")
+		return nil
+	}
+	if len(entries) > count {
+		entries = entries[len(entries)-count:]
+	}
+	for _, entry := range entries {
+		duration := (time.Duration(entry.Duration) * time.Second).Round(time.Second)
+		fmt.Printf("%s  %-16s %3d steps %8s  %s\n", entry.Time.Format("2006-01-02 15:04"), entry.Status, entry.Steps, duration, truncateLine(entry.Task, 60))
+	}
+	return nil
+}
+
+func configCommand(args []string) error {
+	switch {
+	case args[0] == "path" && len(args) == 1:
+		path, err := getConfigFilePath()
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+		return nil
+	case args[0] == "get" && len(args) <= 2:
+		key := ""
+		if len(args) == 2 {
+			key = args[1]
+		}
+		if err := effectiveConfig(); err != nil {
+			return err
+		}
+		value, err := configValue(key)
+		if err != nil {
+			return err
+		}
+		if s, ok := value.(string); ok {
+			fmt.Println(s)
+			return nil
+		}
+		data, _ := json.MarshalIndent(value, "", "  ")
+		fmt.Println(string(data))
+		return nil
+	case args[0] == "set" && len(args) == 3:
+		if err := setConfigValue(args[1], args[2]); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Set %s\n", args[1])
+		return nil
+	}
+	return fmt.Errorf("usage: shai config get [key] | set <key> <value> | path")
+}
+
+// configMap is the effective configuration as generic JSON values.
+func configMap() (map[string]any, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	return m, json.Unmarshal(data, &m)
+}
+
+// configValue looks up a dotted key, such as "router.mode", in the
+// effective configuration. An empty key returns the whole configuration.
+func configValue(key string) (any, error) {
+	var value any
+	value, err := configMap()
+	if err != nil || key == "" {
+		return value, err
+	}
+	for _, part := range strings.Split(key, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unknown config key %q", key)
+		}
+		if value, ok = m[part]; !ok {
+			return nil, fmt.Errorf("unknown config key %q", key)
+		}
+	}
+	return value, nil
+}
+
+// setConfigValue sets a dotted key in the config file. The value is parsed
+// as JSON if possible and taken as a string otherwise, and must have the
+// type the key expects.
+func setConfigValue(key string, raw string) error {
+	var value any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		value = raw
+	}
+
+	parts := strings.Split(key, ".")
+	if _, err := configValue(key); err != nil && len(parts) == 1 {
+		return err
+	}
+
+	// Check the type against a copy of the effective configuration before
+	// touching the file.
+	effective, err := configMap()
+	if err != nil {
+		return err
+	}
+	if err := setNested(effective, parts, value); err != nil {
+		return fmt.Errorf("unknown config key %q", key)
+	}
+	data, _ := json.Marshal(effective)
+	check := defaultConfig()
+	if err := json.Unmarshal(data, &check); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+
+	if len(parts) == 1 {
+		return updateConfigFile(key, value)
+	}
+	// Nested keys rewrite their top-level object, starting from what the
+	// file holds so defaults are not copied into it.
+	top := map[string]any{}
+	if path, err := getConfigFilePath(); err == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			var file map[string]any
+			if json.Unmarshal(data, &file) == nil {
+				if m, ok := file[parts[0]].(map[string]any); ok {
+					top = m
+				}
+			}
+		}
+	}
+	if err := setNested(top, parts[1:], value); err != nil {
+		return err
+	}
+	return updateConfigFile(parts[0], top)
+}
+
+func setNested(m map[string]any, parts []string, value any) error {
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]any)
+		if !ok {
+			if m[part] != nil {
+				return fmt.Errorf("%s is not an object", part)
+			}
+			next = map[string]any{}
+			m[part] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = value
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Shell completion: `shai completion <shell>` prints a script that asks
+// `shai __complete` for candidates, passing the words typed so far with the
+// one being completed last. The candidates come from the subcommand table
+// and the flag set, so the scripts never need updating; when there are none,
+// the shell falls back to completing file names.
+
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+const bashCompletion = `# shai: bash completion. Load it with: source <(shai completion bash)
+_shai() {
+  local IFS=$'\n'
+  COMPREPLY=($(shai __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _shai shai
+`
+
+const zshCompletion = `#compdef shai
+# shai: zsh completion. Load it with: source <(shai completion zsh)
+_shai() {
+  local -a candidates
+  candidates=("${(@f)$(shai __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+  if [[ -n $candidates[1] ]]; then
+    compadd -a candidates
+  else
+    _files
+  fi
+}
+compdef _shai shai
+`
+
+const fishCompletion = `# shai: fish completion. Load it with: shai completion fish | source
+function __shai_complete
+    set -l candidates (shai __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)
+    if test (count $candidates) -gt 0
+        printf '%s\n' $candidates
+    else
+        __fish_complete_path (commandline -ct)
+    end
+end
+complete -c shai -e
+complete -c shai -f -a '(__shai_complete)'
+`
+
+const powershellCompletion = `# shai: PowerShell completion. Load it with: shai completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName shai -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @($commandAst.CommandElements | Select-Object -Skip 1 |
+        Where-Object { $_.Extent.EndOffset -lt $cursorPosition - $wordToComplete.Length } |
+        ForEach-Object { $_.ToString() })
+    $words += $wordToComplete
+    shai __complete @words 2>$null | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`
+
+var completionScripts = map[string]string{
+	"bash":       bashCompletion,
+	"zsh":        zshCompletion,
+	"fish":       fishCompletion,
+	"powershell": powershellCompletion,
+}
+
+func completionCommand(args []string) error {
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q (use %s)", args[0], strings.Join(completionShells, ", "))
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// flagCompleters offer the values of flags that take a known set of them.
+var flagCompleters = map[string]func() []string{
+	"approval": fixed("manual", "auto", "unattended"),
+	"on-ask":   fixed("proceed", "abort"),
+	"profile":  profileNames,
+	"model":    modelNames,
+}
+
+// argCompleters offer a subcommand's next argument, given the ones before.
+var argCompleters = map[string]func(args []string) []string{
+	"resume":     firstArg(sessionIDs),
+	"export":     firstArg(sessionIDs),
+	"undo":       firstArg(sessionIDs),
+	"pull":       firstArg(modelNames),
+	"bench":      func([]string) []string { return modelNames() },
+	"init":       firstArg(fixed("bash", "zsh", "fish")),
+	"completion": firstArg(fixed(completionShells...)),
+	"config":     configArgs,
+}
+
+func fixed(values ...string) func() []string {
+	return func() []string { return values }
+}
+
+func firstArg(values func() []string) func(args []string) []string {
+	return func(args []string) []string {
+		if len(args) > 0 {
+			return nil
+		}
+		return values()
+	}
+}
+
+// completeCommand runs `shai __complete`, printing one candidate per line.
+func completeCommand(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	for _, candidate := range completeWords(args[:len(args)-1], args[len(args)-1]) {
+		fmt.Println(candidate)
+	}
+	return nil
+}
+
+// completeWords returns the candidates for current, the word being typed,
+// that start with it. words are the ones before it.
+func completeWords(words []string, current string) []string {
+	var sub string
+	var args []string
+	expecting := "" // a flag whose value comes next
+	for _, word := range words {
+		switch {
+		case expecting != "":
+			if expecting == "profile" {
+				cfg.Profile = word
+			}
+			expecting = ""
+		case len(word) > 1 && strings.HasPrefix(word, "-"):
+			name, _, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+			if f := flag.Lookup(name); f != nil && !hasValue && !isBoolFlag(f) {
+				expecting = name
+			}
+		case sub == "":
+			sub = word
+		default:
+			args = append(args, word)
+		}
+	}
+
+	var candidates []string
+	switch {
+	case expecting != "":
+		if values, ok := flagCompleters[expecting]; ok {
+			candidates = values()
+		}
+	case strings.HasPrefix(current, "-"):
+		if !strings.Contains(current, "=") {
+			flag.VisitAll(func(f *flag.Flag) {
+				if len(f.Name) == 1 {
+					candidates = append(candidates, "-"+f.Name)
+				} else {
+					candidates = append(candidates, "--"+f.Name)
+				}
+			})
+		}
+	case sub == "":
+		for _, c := range subcommands {
+			if c.description != "" {
+				candidates = append(candidates, c.name)
+			}
+		}
+	default:
+		if complete, ok := argCompleters[sub]; ok {
+			candidates = complete(args)
+		}
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func profileNames() []string {
+	var names []string
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// modelNames asks the backend which models it serves.
+// modelNames completes the models in the config and those the backend
+// listed last time. Completion never waits for the network.
+func modelNames() []string {
+	if err := effectiveConfig(); err != nil {
+		return nil
+	}
+	names := append(requiredModels(), cachedModels()...)
+	for name := range cfg.Azure.Deployments {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+func sessionIDs() []string {
+	sessions, _ := listSessions()
+	var ids []string
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	return ids
+}
+
+// configArgs completes `shai config get|set|path` and the keys and values
+// that follow.
+func configArgs(args []string) []string {
+	switch {
+	case len(args) == 0:
+		return []string{"get", "set", "path"}
+	case len(args) == 1 && (args[0] == "get" || args[0] == "set"):
+		return configKeys()
+	case len(args) == 2 && args[0] == "set":
+		key := args[1]
+		if key == "approval" {
+			return flagCompleters["approval"]()
+		}
+		if key == "model" || strings.HasSuffix(key, "_model") {
+			return modelNames()
+		}
+		if value, err := configValue(key); err == nil {
+			if _, ok := value.(bool); ok {
+				return []string{"true", "false"}
+			}
+		}
+	}
+	return nil
+}
+
+// configKeys lists the dotted keys of the configuration, nested ones
+// included.
+func configKeys() []string {
+	m, err := configMap()
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	var walk func(prefix string, m map[string]any)
+	walk = func(prefix string, m map[string]any) {
+		for key, value := range m {
+			keys = append(keys, prefix+key)
+			if nested, ok := value.(map[string]any); ok && key != "profiles" {
+				walk(prefix+key+".", nested)
+			}
+		}
+	}
+	walk("", m)
+	slices.Sort(keys)
+	return keys
+}
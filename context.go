@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ContextConfig bounds the conversation to the model's context window. When
+// the prompt nears SummarizeAt of NumCtx tokens, the older part of the
+// conversation is replaced by a digest, keeping the first message (the task)
+// and the KeepRecent most recent messages verbatim. NumCtx is also sent to
+// Ollama as num_ctx so the model really has that window.
+type ContextConfig struct {
+	NumCtx      int     `json:"num_ctx"`
+	SummarizeAt float64 `json:"summarize_at"`
+	KeepRecent  int     `json:"keep_recent"`
+}
+
+const defaultNumCtx = 8192
+
+const digestSystemPrompt = `You compress the history of a shell agent's work so it can continue within its context window.
+Below is the part of the transcript to compress: the agent's actions (EXECUTOR) and their results (RESULT).
+Write a compact digest of what was tried and learned: commands run and whether they succeeded, key facts discovered (paths, versions, names, errors and their causes), and files changed.
+Leave out verbose output. Do not suggest next steps. Reply with the digest only.`
+
+// maxDigestSourceChars bounds the transcript sent to be summarized, so the
+// summary request itself fits the window.
+const maxDigestSourceChars = 4 * defaultNumCtx
+
+// contextWindow is the number of tokens the model can attend to, or 0 if
+// unknown.
+func contextWindow() int {
+	if cfg.Provider == "llamacpp" {
+		return cfg.LlamaCpp.ContextSize
+	}
+	return cfg.Context.NumCtx
+}
+
+// withNumCtx adds the configured num_ctx to Ollama options, unless the
+// caller chose one.
+func withNumCtx(options map[string]any) map[string]any {
+	if cfg.Context.NumCtx <= 0 {
+		return options
+	}
+	if _, ok := options["num_ctx"]; ok {
+		return options
+	}
+	merged := map[string]any{"num_ctx": cfg.Context.NumCtx}
+	for key, value := range options {
+		merged[key] = value
+	}
+	return merged
+}
+
+// manageContext summarizes the older part of the conversation when the next
+// request would come close to filling the context window.
+func (a *Agent) manageContext() {
+	window := contextWindow()
+	if window <= 0 || cfg.Context.SummarizeAt <= 0 {
+		return
+	}
+	used := estimateTokens(a.SystemPrompt) + estimateRequestTokens(a.Messages)
+	if float64(used) < cfg.Context.SummarizeAt*float64(window) {
+		return
+	}
+
+	// Keep the task message and the recent messages, cutting just before an
+	// assistant message so that an action is never separated from its result.
+	cut := len(a.Messages) - max(cfg.Context.KeepRecent, 1)
+	for cut > 1 && a.Messages[cut].Role != "assistant" {
+		cut--
+	}
+	if cut <= 2 {
+		return
+	}
+	older := a.Messages[1:cut]
+
+	a.printf("🗜️  The conversation is using ~%d of %d tokens; summarizing %d earlier messages...\n", used, window, len(older))
+	digest := a.summarizeMessages(older)
+	if len(a.Plan) > 0 {
+		digest += "\nPLAN PROGRESS:\n" + planChecklist(a.Plan)
+	}
+
+	messages := []Message{a.Messages[0], {Role: "user", Content: "CONTEXT_DIGEST: Earlier steps were summarized to save space:\n" + digest}}
+	a.Messages = append(messages, a.Messages[cut:]...)
+	a.record("context", fmt.Sprintf("summarized %d messages at ~%d tokens", len(older), used))
+}
+
+// summarizeMessages asks the model for a digest of messages, falling back to
+// a mechanical one that keeps each action and the status of its result.
+func (a *Agent) summarizeMessages(messages []Message) string {
+	transcript := renderTranscript(messages)
+	if len(transcript) > maxDigestSourceChars {
+		transcript = transcript[len(transcript)-maxDigestSourceChars:]
+	}
+	resp, err := callModel(a.Model, []Message{{Role: "user", Content: transcript}}, digestSystemPrompt)
+	if err == nil && strings.TrimSpace(resp.Message.Content) != "" {
+		return strings.TrimSpace(resp.Message.Content)
+	}
+	if err != nil {
+		a.printf("⚠️ Summarizing failed (%v); keeping a short digest instead.\n", err)
+	}
+
+	var digest strings.Builder
+	for _, message := range messages {
+		text := strings.TrimSpace(messageText(message))
+		if message.Role == "assistant" {
+			digest.WriteString("- " + truncateLine(text, 200) + "\n")
+			continue
+		}
+		first, _, _ := strings.Cut(text, "\n")
+		for _, line := range strings.Split(text, "\n") {
+			if strings.HasPrefix(line, "STATUS:") {
+				first = strings.TrimSuffix(first, ":") + " " + line
+				break
+			}
+		}
+		digest.WriteString("  " + truncateLine(first, 200) + "\n")
+	}
+	return digest.String()
+}
+
+// messageText is the content of a message, with tool calls written as the
+// text protocol action they stand for.
+func messageText(message Message) string {
+	if len(message.ToolCalls) == 0 {
+		return message.Content
+	}
+	action, content := toolCallAction(message.ToolCalls[0])
+	return strings.TrimSpace(message.Content + "\n" + action + " " + content)
+}
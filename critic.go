@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// CriticConfig enables a second model that reviews every command before it
+// is approved. Its verdict is shown at the approval prompt, a risky verdict
+// turns auto-approval into a prompt, and when the command is rejected the
+// verdict is passed back to the executor.
+type CriticConfig struct {
+	Model string `json:"model"`
+}
+
+const criticSystemPrompt = `You review shell commands that an autonomous agent wants to run on a user's machine, before the user approves them.
+Judge the command against the task and this rubric:
+- Does it do what the task needs, and nothing beyond it?
+- Could it destroy or overwrite data, or change system configuration, users, permissions, the network or running services?
+- Does it touch paths or hosts outside what the task is about, use broad globs or recursion, or pipe downloads into a shell?
+- Could it leak secrets, or hang waiting for input?
+Reply with a single line: "SAFE" if the command is appropriate, or "RISKY: <short reason>" if the user should look closely before allowing it.`
+
+const criticRequestTemplate = `TASK: %s
+ENVIRONMENT: %s, shell %s, working directory %s
+RISK TIER (from pattern rules): %s
+COMMAND:
+%s`
+
+func criticEnabled() bool {
+	return cfg.Critic.Model != ""
+}
+
+// criticize asks the critic about a command and returns its verdict, which
+// is "SAFE", "RISKY: <reason>" or "" when there is no critic or it failed.
+func (a *Agent) criticize(command string, risk string) string {
+	if !criticEnabled() {
+		return ""
+	}
+	request := fmt.Sprintf(criticRequestTemplate, a.Task, runtime.GOOS, a.Shell, a.shell.workDir(), risk, command)
+	resp, err := callModelContext(context.Background(), cfg.Critic.Model, []Message{{Role: "user", Content: request}}, criticSystemPrompt, map[string]any{"temperature": 0, "num_predict": 200})
+	if err != nil {
+		a.printf("⚠️ The critic could not review the command: %v\n", err)
+		return ""
+	}
+	verdict := strings.TrimSpace(strings.SplitN(strings.TrimSpace(resp.Message.Content), "\n", 2)[0])
+	if strings.HasPrefix(strings.ToUpper(verdict), "SAFE") {
+		verdict = "SAFE"
+	} else if !strings.HasPrefix(strings.ToUpper(verdict), "RISKY") {
+		// A critic that does not follow the format is treated as a warning.
+		verdict = "RISKY: " + verdict
+	}
+	a.record("critic", verdict)
+	return verdict
+}
+
+func criticRisky(verdict string) bool {
+	return strings.HasPrefix(strings.ToUpper(verdict), "RISKY")
+}
+
+// criticBanner shows the verdict above the approval prompt.
+func criticBanner(verdict string) string {
+	switch {
+	case verdict == "":
+		return ""
+	case criticRisky(verdict):
+		return fmt.Sprintf("🧐 Critic (%s): %s\n", cfg.Critic.Model, verdict)
+	}
+	return fmt.Sprintf("🧐 Critic (%s): looks safe\n", cfg.Critic.Model)
+}
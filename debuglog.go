@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With --debug, shai writes JSON logs (log/slog) of every HTTP request and
+// response to the model backends, and of the action parsed at every step,
+// to logs/<session>.log in the state directory. Until a session starts, they
+// go to a file of their own, which moves into the session's log.
+
+var debugFlag = flag.Bool("debug", false, "write JSON debug logs, with backend requests and responses, to the state directory")
+
+// debugLog is a no-op logger unless --debug is given.
+var debugLog = slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+// maxLoggedBody bounds the request and response bodies kept in the log.
+const maxLoggedBody = 1 << 20
+
+// debugFile is where the debug log goes: the startup file, then the
+// current session's.
+var debugFile struct {
+	mu      sync.Mutex
+	f       *os.File
+	startup string // the startup file's path, until it is moved
+}
+
+type debugWriter struct{}
+
+func (debugWriter) Write(p []byte) (int, error) {
+	debugFile.mu.Lock()
+	defer debugFile.mu.Unlock()
+	if debugFile.f == nil {
+		return len(p), nil
+	}
+	return debugFile.f.Write(p)
+}
+
+func debugLogDir() (string, error) {
+	dir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "logs")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create log directory %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// startDebugLog turns debug logging on with --debug.
+func startDebugLog() error {
+	if !*debugFlag {
+		return nil
+	}
+	dir, err := debugLogDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("startup-%s-%d.log", newSessionID(), os.Getpid()))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the debug log: %w", err)
+	}
+	debugFile.f, debugFile.startup = f, path
+	debugLog = slog.New(slog.NewJSONHandler(debugWriter{}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	http.DefaultTransport = &debugTransport{base: http.DefaultTransport}
+	fmt.Printf("🐞 Writing debug logs to %s\n", path)
+	debugLog.Info("start", "args", os.Args[1:], "model", executorModel(), "url", modelAPIURL())
+	return nil
+}
+
+// debugSession moves the debug log to the session's file. What was logged
+// before the first session moves with it.
+func debugSession(id string) {
+	if !*debugFlag {
+		return
+	}
+	dir, err := debugLogDir()
+	if err != nil {
+		return
+	}
+	path := filepath.Join(dir, id+".log")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Printf("⚠️ Failed to open the debug log %s: %v\n", path, err)
+		return
+	}
+
+	debugFile.mu.Lock()
+	if debugFile.startup != "" {
+		if data, err := os.ReadFile(debugFile.startup); err == nil {
+			f.Write(data)
+			os.Remove(debugFile.startup)
+		}
+		debugFile.startup = ""
+	}
+	if debugFile.f != nil {
+		debugFile.f.Close()
+	}
+	debugFile.f = f
+	debugFile.mu.Unlock()
+
+	fmt.Printf("🐞 Debug log: %s\n", path)
+	debugLog.Info("session", "session", id)
+}
+
+// debugTransport logs each request and its response, whose body is logged
+// as it is read, so streaming is not held up.
+type debugTransport struct {
+	base http.RoundTripper
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.GetBody != nil {
+		if rc, err := req.GetBody(); err == nil {
+			body, _ = io.ReadAll(io.LimitReader(rc, maxLoggedBody+1))
+			rc.Close()
+		}
+	}
+	debugLog.Debug("http request", "method", req.Method, "url", req.URL.String(), "headers", loggedHeaders(req.Header), "body", loggedBody(body))
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		debugLog.Debug("http error", "url", req.URL.String(), "error", err.Error(), "duration", time.Since(start).String())
+		return nil, err
+	}
+	resp.Body = &loggedResponseBody{ReadCloser: resp.Body, url: req.URL.String(), status: resp.StatusCode, headers: loggedHeaders(resp.Header), start: start}
+	return resp, nil
+}
+
+type loggedResponseBody struct {
+	io.ReadCloser
+	url     string
+	status  int
+	headers map[string]string
+	start   time.Time
+	body    bytes.Buffer
+	once    sync.Once
+}
+
+func (b *loggedResponseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := maxLoggedBody + 1 - b.body.Len(); room > 0 {
+		b.body.Write(p[:min(n, room)])
+	}
+	if err != nil {
+		b.log()
+	}
+	return n, err
+}
+
+func (b *loggedResponseBody) Close() error {
+	b.log()
+	return b.ReadCloser.Close()
+}
+
+func (b *loggedResponseBody) log() {
+	b.once.Do(func() {
+		debugLog.Debug("http response", "url", b.url, "status", b.status, "headers", b.headers, "duration", time.Since(b.start).String(), "body", loggedBody(b.body.Bytes()))
+	})
+}
+
+// loggedHeaders flattens headers, hiding credentials.
+func loggedHeaders(h http.Header) map[string]string {
+	headers := map[string]string{}
+	for name, values := range h {
+		value := strings.Join(values, ", ")
+		switch strings.ToLower(name) {
+		case "authorization", "x-api-key", "api-key", "cookie", "set-cookie":
+			value = "[REDACTED]"
+		}
+		headers[name] = value
+	}
+	return headers
+}
+
+func loggedBody(body []byte) string {
+	text := redact(string(body))
+	if len(body) > maxLoggedBody {
+		text = text[:min(len(text), maxLoggedBody)] + "…[truncated]"
+	}
+	return text
+}
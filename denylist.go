@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// builtinDenylist matches commands that are catastrophic on almost any
+// machine. It is checked in addition to the user's denylist unless
+// builtin_denylist is false.
+var builtinDenylist = []string{
+	`\brm\s+(-[a-zA-Z]*\s+)*(-[a-zA-Z]*[rR][a-zA-Z]*|--recursive)\s+(-[a-zA-Z-]+\s+)*("?/\*?"?|~/?|\$HOME/?)(\s|$|;|&|\|)`,
+	`--no-preserve-root`,
+	`\bmkfs(\.\w+)?\b`,
+	`\bdd\b.*\bof=/dev/(sd|hd|vd|xvd|nvme|mmcblk|disk|rdisk)`,
+	`>\s*/dev/(sd|hd|vd|xvd|nvme|mmcblk|disk|rdisk)\w*`,
+	`\b(wipefs|shred)\b.*\s/dev/`,
+	`:\(\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`,
+	`\bchmod\s+(-[a-zA-Z]*\s+)*-[a-zA-Z]*R[a-zA-Z]*\s+(0?777|a\+rwx)\s+/(\s|$)`,
+	`\bchown\s+(-[a-zA-Z]*\s+)*-[a-zA-Z]*R[a-zA-Z]*\s+\S+\s+/(\s|$)`,
+}
+
+type denyRule struct {
+	source  string
+	pattern *regexp.Regexp
+}
+
+var denyRules []denyRule
+
+// compileDenylist compiles the built-in and configured patterns. It is
+// called once the configuration is loaded so that a bad pattern is reported
+// before the agent starts.
+func compileDenylist() error {
+	denyRules = nil
+	var patterns []string
+	if cfg.BuiltinDenylist {
+		patterns = append(patterns, builtinDenylist...)
+	}
+	patterns = append(patterns, cfg.Denylist...)
+
+	for _, source := range patterns {
+		pattern, err := regexp.Compile(source)
+		if err != nil {
+			return fmt.Errorf("invalid denylist pattern %q: %w", source, err)
+		}
+		denyRules = append(denyRules, denyRule{source: source, pattern: pattern})
+	}
+	return nil
+}
+
+// deniedBy returns the denylist pattern that command matches, if any.
+func deniedBy(command string) (string, bool) {
+	for _, rule := range denyRules {
+		if rule.pattern.MatchString(command) {
+			return rule.source, true
+		}
+	}
+	return "", false
+}
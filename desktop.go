@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// toastScript shows a Windows toast with the title and message from the
+// environment, under PowerShell's app ID, which Windows lets scripts use.
+const toastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode($env:SHAI_TITLE)) > $null
+$text.Item(1).AppendChild($xml.CreateTextNode($env:SHAI_MESSAGE)) > $null
+$app = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($app).Show([Windows.UI.Notifications.ToastNotification]::new($xml))`
+
+// desktopNotify shows a desktop notification: with osascript on macOS, a
+// toast on Windows and notify-send elsewhere. Prompts are marked urgent
+// where the platform supports it.
+func desktopNotify(event string, title string, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript",
+			"-e", "on run argv",
+			"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+			"-e", "end run",
+			title, message)
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-EncodedCommand", encodePowerShell(toastScript))
+		cmd.Env = append(os.Environ(), "SHAI_TITLE="+title, "SHAI_MESSAGE="+message)
+	default:
+		urgency := "normal"
+		if event == "ask" || event == "approval" {
+			urgency = "critical"
+		}
+		cmd = exec.Command("notify-send", "--app-name=shai", "--urgency="+urgency, title, message)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %w: %s", cmd.Args[0], err, msg)
+		}
+		return fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	return nil
+}
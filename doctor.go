@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// doctorSlowReply is how long a one-word reply may take before doctor warns
+// that the model is slow.
+const doctorSlowReply = 30 * time.Second
+
+// doctor tallies the outcome of its checks.
+type doctor struct {
+	failed bool
+}
+
+func (d *doctor) ok(check string, detail string) {
+	fmt.Printf("✅ %s: %s\n", check, detail)
+}
+
+func (d *doctor) warn(check string, detail string, fix string) {
+	fmt.Printf("⚠️  %s: %s\n   → %s\n", check, detail, fix)
+}
+
+func (d *doctor) fail(check string, detail string, fix string) {
+	d.failed = true
+	fmt.Printf("❌ %s: %s\n   → %s\n", check, detail, fix)
+}
+
+// doctorCommand checks the configuration, shell and backend, and says how
+// to fix what is wrong. It exits with status 1 if any check failed.
+func doctorCommand(args []string) error {
+	d := &doctor{}
+	fmt.Println("🩺 Checking shai's setup...")
+
+	d.checkConfigFile()
+	if err := effectiveConfig(); err != nil {
+		d.fail("Overrides", err.Error(), "check the profile name and the SHAI_* environment variables")
+	}
+	d.checkSettings()
+	d.checkShell()
+	if d.checkBackend() && d.checkModels() {
+		d.checkLatency()
+	}
+
+	if d.failed {
+		os.Exit(1)
+	}
+	fmt.Println("\n🎉 Everything looks good.")
+	return nil
+}
+
+// checkConfigFile validates the config file against the Config schema,
+// which loading alone does not do: unknown keys are silently ignored there.
+func (d *doctor) checkConfigFile() {
+	path, err := getConfigFilePath()
+	if err != nil {
+		d.fail("Config file", err.Error(), "set XDG_CONFIG_HOME or HOME to a writable directory")
+		return
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		d.warn("Config file", path+" does not exist", "run any shai command once to write the defaults")
+		return
+	} else if err != nil {
+		d.fail("Config file", err.Error(), "check the file's permissions")
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	schema := defaultConfig()
+	if err := decoder.Decode(&schema); err != nil {
+		fix := "fix the JSON syntax, or delete the file to start again from the defaults"
+		if strings.Contains(err.Error(), "unknown field") || strings.Contains(err.Error(), "cannot unmarshal") {
+			fix = "fix or remove the key; `shai config get` shows every valid key and its current value"
+		}
+		d.fail("Config file", fmt.Sprintf("%s: %v", path, err), fix)
+		return
+	}
+	d.ok("Config file", path)
+
+	if project := projectConfigPath(); project != "" {
+		d.ok("Project config", project)
+	}
+}
+
+// checkSettings runs the checks a run would fail on before it starts.
+func (d *doctor) checkSettings() {
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{"Denylist", compileDenylist},
+		{"Redaction patterns", compileRedactions},
+		{"Risk rules", compileRiskRules},
+		{"Policy rules", compilePolicy},
+		{"Hooks", checkHooks},
+		{"Notifications", checkNotify},
+		{"System prompt template", loadPromptTemplate},
+		{"Protocol", checkProtocol},
+		{"Non-interactive mode", checkNonInteractive},
+		{"PTY mode", checkPTY},
+	}
+	failed := false
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			d.fail(c.name, err.Error(), "correct the setting in the config file")
+			failed = true
+		}
+	}
+	if !failed {
+		d.ok("Settings", fmt.Sprintf("provider %s, approval %s, protocol %s", providerName(), cfg.Approval, cfg.Protocol))
+	}
+}
+
+func providerName() string {
+	if cfg.Provider == "" {
+		return "ollama"
+	}
+	return cfg.Provider
+}
+
+func (d *doctor) checkShell() {
+	shell := defaultShell()
+	path, err := exec.LookPath(shell)
+	if err != nil {
+		d.fail("Shell", fmt.Sprintf("%s was not found", shell), "set SHELL to the full path of an installed shell")
+		return
+	}
+	if runtime.GOOS != "windows" && os.Getenv("SHELL") == "" {
+		d.warn("Shell", "SHELL is not set, using "+path, "export SHELL to choose the shell shai runs commands with")
+		return
+	}
+	d.ok("Shell", path)
+}
+
+func (d *doctor) checkBackend() bool {
+	if err := backendReachable(); err != nil {
+		fix := "check that the server is running and that api_url is correct"
+		switch {
+		case cfg.Provider == "llamacpp":
+			fix = "build with -tags llamacpp and point llamacpp.model_path at a GGUF file"
+		case usesOllama():
+			fix = "start Ollama with `ollama serve`, or set ollama_url to where it runs"
+		}
+		d.fail("Model API", fmt.Sprintf("cannot reach %s: %v", modelAPIURL(), err), fix)
+		return false
+	}
+	d.ok("Model API", modelAPIURL())
+	return true
+}
+
+func (d *doctor) checkModels() bool {
+	if cfg.Provider == "azure" && len(cfg.Azure.Deployments) == 0 {
+		d.warn("Models", "Azure OpenAI does not list deployments, so "+strings.Join(requiredModels(), ", ")+" are taken as deployment names", "map model names to deployments in azure.deployments to check them")
+		return true
+	}
+	names, err := backendModels()
+	if err != nil {
+		d.fail("Models", err.Error(), "check api_key (or the provider's API key variable) and the URL setting")
+		return false
+	}
+	installed := map[string]bool{}
+	for _, name := range names {
+		installed[name] = true
+		if usesOllama() {
+			installed[ollamaModelName(name)] = true
+		}
+	}
+	ok := true
+	for _, model := range requiredModels() {
+		name := model
+		if usesOllama() {
+			name = ollamaModelName(model)
+		}
+		if installed[name] || cfg.Provider == "llamacpp" {
+			continue
+		}
+		ok = false
+		fix := "set ollama_model (or the planner and router models) to one of: " + strings.Join(names, ", ")
+		if cfg.Provider == "azure" {
+			fix = "map it to its deployment in azure.deployments"
+		}
+		if usesOllama() {
+			fix = fmt.Sprintf("run `shai pull %s`, or pick an installed model with `shai models`", model)
+		}
+		d.fail("Models", fmt.Sprintf("%s is not served by the backend", model), fix)
+	}
+	if ok {
+		d.ok("Models", strings.Join(requiredModels(), ", "))
+	}
+	return ok
+}
+
+// checkLatency times a tiny request, which includes loading the model.
+func (d *doctor) checkLatency() {
+	started := time.Now()
+	resp, err := callModel(executorModel(), []Message{{Role: "user", Content: "Reply with the single word OK."}}, "")
+	elapsed := time.Since(started).Round(time.Millisecond)
+	if err != nil {
+		d.fail("Round trip", err.Error(), "check the model name and the backend's logs")
+		return
+	}
+	if elapsed > doctorSlowReply {
+		d.warn("Round trip", fmt.Sprintf("%s took %s for a one-word reply", executorModel(), elapsed), "the model may still be loading or too large for this machine; try a smaller model or `\"warm_up\": true`")
+		return
+	}
+	d.ok("Round trip", fmt.Sprintf("%s replied %q in %s", executorModel(), truncateLine(resp.Message.Content, 20), elapsed))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// editText lets the user edit text in $VISUAL or $EDITOR, or on a single
+// line when neither is set.
+func editText(text string, reader *bufio.Reader) (string, error) {
+	editor := editorCommand()
+	if editor == "" {
+		fmt.Printf("Edit the command (empty keeps it as is):\n  $ ")
+		line, err := reader.ReadString('\n')
+		if strings.TrimSpace(line) == "" {
+			return text, err
+		}
+		return strings.TrimSpace(line), nil
+	}
+	return runEditor(editor, text, "shai-command-*.sh")
+}
+
+// editorCommand is $VISUAL or $EDITOR, or "" if neither is set.
+func editorCommand() string {
+	if editor := os.Getenv("VISUAL"); editor != "" {
+		return editor
+	}
+	return os.Getenv("EDITOR")
+}
+
+// runEditor opens text in editor, in a temporary file named after pattern,
+// and returns what the user saved.
+func runEditor(editor string, text string, pattern string) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(text + "\n")
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	// The editor setting may carry arguments, such as "code --wait".
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = windowsCommand("cmd.exe", editor+` "`+f.Name()+`"`)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", editor+" "+shellQuote(f.Name()))
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor %q failed: %w", editor, err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// confirmEditable is confirmAction with an extra (e)dit choice that opens
+// the text in an editor and, when explain is not nil, a (?) choice that
+// calls it on the text. It reports whether the action was approved and the
+// text to use, which differs from text if the user edited it.
+func confirmEditable(message string, text string, reader *bufio.Reader, explain func(string)) (bool, string) {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+
+	if cfg.NonInteractive {
+		fmt.Printf("\n%s [non-interactive: no]\n", message)
+		return false, text
+	}
+
+	choices := "(Y)es / (n)o / (e)dit / (q)uit"
+	if explain != nil {
+		choices = "(Y)es / (n)o / (e)dit / (?) explain / (q)uit"
+	}
+	for {
+		fmt.Printf("\n%s [ %s ]: ", message, choices)
+		input, _ := reader.ReadString('\n')
+		input = strings.TrimSpace(strings.ToLower(input))
+
+		switch {
+		case input == "?" && explain != nil:
+			explain(text)
+		case strings.HasPrefix(input, "q"):
+			os.Exit(0)
+		case strings.HasPrefix(input, "n"):
+			return false, text
+		case strings.HasPrefix(input, "e"):
+			edited, err := editText(text, reader)
+			if err != nil {
+				fmt.Printf("⚠️ %v\n", err)
+				continue
+			}
+			if strings.TrimSpace(edited) == "" {
+				fmt.Println("⚠️ The edited command is empty.")
+				continue
+			}
+			return true, edited
+		default:
+			return true, text
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With "ollama_endpoints", shai spreads its Ollama requests over several
+// servers. The endpoints are health-checked before a run; requests go to the
+// first healthy one ("failover", the default) or rotate between the healthy
+// ones that have the model loaded ("round_robin"). An endpoint that fails is
+// set aside for endpointCooldown and the request is sent to the next one.
+
+const (
+	endpointCooldown = 30 * time.Second
+	// loadedModelsTTL is how long /api/ps results are trusted.
+	loadedModelsTTL = 10 * time.Second
+)
+
+type endpointState struct {
+	url       string
+	downUntil time.Time
+	loaded    []string
+	checked   time.Time
+}
+
+type endpointPool struct {
+	mu        sync.Mutex
+	endpoints []*endpointState
+	next      int
+}
+
+var endpoints endpointPool
+
+// ollamaChatURL turns an endpoint given as a bare host into its chat URL.
+func ollamaChatURL(endpoint string) string {
+	if strings.Contains(endpoint, "/api/") {
+		return endpoint
+	}
+	return strings.TrimRight(endpoint, "/") + "/api/chat"
+}
+
+// ollamaBase is the server part of an Ollama URL.
+func ollamaBase(url string) string {
+	base, _, found := strings.Cut(url, "/api/")
+	if !found {
+		base = strings.TrimRight(url, "/")
+	}
+	return base
+}
+
+// onEndpoint is an Ollama API URL moved to another endpoint.
+func onEndpoint(url string, endpoint string) string {
+	return ollamaBase(endpoint) + strings.TrimPrefix(url, ollamaBase(url))
+}
+
+func usesEndpoints() bool {
+	return usesOllama() && len(cfg.OllamaEndpoints) > 0
+}
+
+func (p *endpointPool) init() {
+	if len(p.endpoints) == len(cfg.OllamaEndpoints) {
+		return
+	}
+	p.endpoints = nil
+	for _, url := range cfg.OllamaEndpoints {
+		p.endpoints = append(p.endpoints, &endpointState{url: ollamaChatURL(url)})
+	}
+}
+
+// ollamaURL is the chat URL Ollama requests go to: ollama_url, or the first
+// healthy endpoint.
+func ollamaURL() string {
+	if !usesEndpoints() {
+		return cfg.OllamaURL
+	}
+	endpoints.mu.Lock()
+	defer endpoints.mu.Unlock()
+	return endpoints.firstHealthy().url
+}
+
+// firstHealthy is the first endpoint not cooling down, or the one that will
+// be back soonest. p.mu must be held.
+func (p *endpointPool) firstHealthy() *endpointState {
+	p.init()
+	now := time.Now()
+	best := p.endpoints[0]
+	for _, e := range p.endpoints {
+		if !e.downUntil.After(now) {
+			return e
+		}
+		if e.downUntil.Before(best.downUntil) {
+			best = e
+		}
+	}
+	return best
+}
+
+// pickEndpoint chooses the endpoint for a request to model.
+func pickEndpoint(model string) string {
+	if cfg.EndpointStrategy != "round_robin" {
+		return ollamaURL()
+	}
+	endpoints.mu.Lock()
+	endpoints.init()
+	var candidates []*endpointState
+	for _, e := range endpoints.endpoints {
+		if !e.downUntil.After(time.Now()) {
+			candidates = append(candidates, e)
+		}
+	}
+	endpoints.mu.Unlock()
+
+	// Prefer the hosts that would not have to load the model first.
+	var loaded []*endpointState
+	for _, e := range candidates {
+		if e.hasLoaded(model) {
+			loaded = append(loaded, e)
+		}
+	}
+	if len(loaded) > 0 {
+		candidates = loaded
+	}
+	if len(candidates) == 0 {
+		return ollamaURL()
+	}
+
+	endpoints.mu.Lock()
+	defer endpoints.mu.Unlock()
+	endpoints.next++
+	return candidates[endpoints.next%len(candidates)].url
+}
+
+// hasLoaded reports whether the endpoint has model in memory, per /api/ps.
+func (e *endpointState) hasLoaded(model string) bool {
+	endpoints.mu.Lock()
+	fresh := time.Since(e.checked) < loadedModelsTTL
+	loaded := e.loaded
+	endpoints.mu.Unlock()
+
+	if !fresh {
+		loaded = nil
+		client := &http.Client{Timeout: networkCheckTimeout}
+		if resp, err := client.Get(ollamaBase(e.url) + "/api/ps"); err == nil {
+			var ps struct {
+				Models []struct {
+					Name string `json:"name"`
+				} `json:"models"`
+			}
+			if json.NewDecoder(resp.Body).Decode(&ps) == nil {
+				for _, m := range ps.Models {
+					loaded = append(loaded, ollamaModelName(m.Name))
+				}
+			}
+			resp.Body.Close()
+		}
+		endpoints.mu.Lock()
+		e.loaded, e.checked = loaded, time.Now()
+		endpoints.mu.Unlock()
+	}
+	for _, name := range loaded {
+		if name == ollamaModelName(model) {
+			return true
+		}
+	}
+	return false
+}
+
+// failOver sets a failed endpoint aside and reports whether another one is
+// available to retry on straight away.
+func failOver(ctx context.Context, url string, reason string) bool {
+	endpoints.mu.Lock()
+	defer endpoints.mu.Unlock()
+	endpoints.init()
+	for _, e := range endpoints.endpoints {
+		if ollamaBase(e.url) == ollamaBase(url) {
+			e.downUntil = time.Now().Add(endpointCooldown)
+		}
+	}
+	next := endpoints.firstHealthy()
+	if next.downUntil.After(time.Now()) {
+		return false
+	}
+	noticef(ctx, "🔀 %s (%s); switching to %s.\n", ollamaBase(url), reason, ollamaBase(next.url))
+	return true
+}
+
+// checkEndpointStrategy checks endpoint_strategy.
+func checkEndpointStrategy() error {
+	if cfg.EndpointStrategy != "" && cfg.EndpointStrategy != "failover" && cfg.EndpointStrategy != "round_robin" {
+		return fmt.Errorf("unknown endpoint_strategy %q (use failover or round_robin)", cfg.EndpointStrategy)
+	}
+	return nil
+}
+
+// checkEndpoints health-checks the endpoints before a run, so the first
+// request does not go to a host that is down.
+func checkEndpoints() error {
+	if !usesEndpoints() {
+		return nil
+	}
+
+	endpoints.mu.Lock()
+	endpoints.init()
+	list := endpoints.endpoints
+	endpoints.mu.Unlock()
+
+	var wg sync.WaitGroup
+	down := make([]error, len(list))
+	for i, e := range list {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := &http.Client{Timeout: networkCheckTimeout}
+			resp, err := client.Get(ollamaBase(e.url) + "/")
+			if err == nil {
+				resp.Body.Close()
+			}
+			down[i] = err
+		}()
+	}
+	wg.Wait()
+
+	endpoints.mu.Lock()
+	defer endpoints.mu.Unlock()
+	for i, e := range list {
+		if down[i] != nil {
+			e.downUntil = time.Now().Add(endpointCooldown)
+			fmt.Printf("🔀 Ollama endpoint %s is down: %v\n", ollamaBase(e.url), down[i])
+		}
+	}
+	return nil
+}
+
+// endpointModels lists the models installed on any healthy endpoint.
+func endpointModels() []string {
+	endpoints.mu.Lock()
+	endpoints.init()
+	var urls []string
+	for _, e := range endpoints.endpoints {
+		if !e.downUntil.After(time.Now()) {
+			urls = append(urls, e.url)
+		}
+	}
+	endpoints.mu.Unlock()
+
+	var names []string
+	client := &http.Client{Timeout: networkCheckTimeout}
+	for _, url := range urls {
+		resp, err := client.Get(ollamaBase(url) + "/api/tags")
+		if err != nil {
+			continue
+		}
+		var tags struct {
+			Models []struct {
+				Name string `json:"name"`
+			} `json:"models"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&tags) == nil {
+			for _, m := range tags.Models {
+				names = append(names, m.Name)
+			}
+		}
+		resp.Body.Close()
+	}
+	return names
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Commands inherit shai's environment, credentials included. The env policy
+// narrows what they see: an allowlist, a denylist and variables to set, all
+// applied in executeCommand. The approval prompt names the sensitive
+// variables a command will see, and those the policy withholds.
+
+// EnvConfig is the environment policy for commands. Allow and Deny hold
+// glob patterns of variable names (AWS_*); with an allowlist, only matching
+// variables and the few every command needs pass. Set adds variables, with
+// $VAR references expanded from shai's own environment. Sensitive adds
+// patterns to the built-in ones the approval prompt reports.
+type EnvConfig struct {
+	Allow     []string          `json:"allow"`
+	Deny      []string          `json:"deny"`
+	Set       map[string]string `json:"set"`
+	Sensitive []string          `json:"sensitive"`
+}
+
+// essentialVariables pass an allowlist unless denied by name: without them
+// most commands do not run.
+var essentialVariables = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "LANG", "LC_*", "TZ", "TMPDIR",
+	"SYSTEMROOT", "COMSPEC", "PATHEXT", "WINDIR", "TEMP", "TMP", "USERPROFILE", "APPDATA", "LOCALAPPDATA",
+}
+
+// sensitiveVariables are credentials and agent sockets, by name.
+var sensitiveVariables = []string{
+	"*TOKEN*", "*SECRET*", "*PASSWORD*", "*PASSWD*", "*_KEY", "*API_KEY*", "*ACCESS_KEY*",
+	"*CREDENTIALS*", "SSH_AUTH_SOCK", "GPG_AGENT_INFO", "KUBECONFIG", "VAULT_*",
+}
+
+// checkEnvPolicy reports patterns in the env policy that are not valid globs.
+func checkEnvPolicy() error {
+	for _, patterns := range [][]string{cfg.Env.Allow, cfg.Env.Deny, cfg.Env.Sensitive} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid env pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	for name := range cfg.Env.Set {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("invalid env.set variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// matchesVariable reports whether a variable name matches one of the
+// patterns. Names are case-insensitive on Windows.
+func matchesVariable(name string, patterns []string) bool {
+	if runtime.GOOS == "windows" {
+		name = strings.ToUpper(name)
+	}
+	for _, pattern := range patterns {
+		if runtime.GOOS == "windows" {
+			pattern = strings.ToUpper(pattern)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// passesEnv reports whether the policy lets commands see shai's variable.
+func passesEnv(name string) bool {
+	if matchesVariable(name, cfg.Env.Deny) {
+		return false
+	}
+	return len(cfg.Env.Allow) == 0 || matchesVariable(name, cfg.Env.Allow) || matchesVariable(name, essentialVariables)
+}
+
+// injectedEnv returns the variables commands get on top of shai's own: the
+// non-interactive ones, and those the policy sets, expanded.
+func injectedEnv() map[string]string {
+	injected := nonInteractiveEnv()
+	for name, value := range cfg.Env.Set {
+		injected[name] = os.ExpandEnv(value)
+	}
+	return injected
+}
+
+// commandEnv applies the policy to environ, an agent's environment or nil
+// for shai's own, and returns the environment for a command, or nil for
+// shai's own when there is nothing to change.
+func commandEnv(environ []string) []string {
+	injected := injectedEnv()
+	if environ == nil && len(cfg.Env.Allow) == 0 && len(cfg.Env.Deny) == 0 && len(injected) == 0 {
+		return nil
+	}
+	if environ == nil {
+		environ = os.Environ()
+	}
+	var env []string
+	for _, entry := range environ {
+		name, _, _ := strings.Cut(entry, "=")
+		if _, ok := injected[name]; !ok && passesEnv(name) {
+			env = append(env, entry)
+		}
+	}
+	for name, value := range injected {
+		env = append(env, name+"="+value)
+	}
+	return env
+}
+
+// envBanner summarizes a command's environment, starting from environ, for
+// the approval prompt: the sensitive variables it will see, those the policy
+// withholds and those it sets.
+func envBanner(environ []string) string {
+	sensitive := append(slices.Clone(sensitiveVariables), cfg.Env.Sensitive...)
+	injected := injectedEnv()
+	var passed, withheld, set []string
+	for _, entry := range environ {
+		name, _, _ := strings.Cut(entry, "=")
+		if _, ok := injected[name]; ok || !matchesVariable(name, sensitive) {
+			continue
+		}
+		if passesEnv(name) {
+			passed = append(passed, name)
+		} else {
+			withheld = append(withheld, name)
+		}
+	}
+	for name := range cfg.Env.Set {
+		set = append(set, name)
+	}
+
+	var parts []string
+	for _, part := range []struct {
+		verb  string
+		names []string
+	}{{"sees", passed}, {"does not see", withheld}, {"gets", set}} {
+		if len(part.names) > 0 {
+			slices.Sort(part.names)
+			parts = append(parts, part.verb+" "+strings.Join(part.names, ", "))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "🔑 Environment: the command " + strings.Join(parts, "; ") + "\n\n"
+}
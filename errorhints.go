@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type errorClass struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Hint    string
+}
+
+// errorClasses are checked in order against the output of failed commands;
+// the first match adds a remediation hint to the feedback so that small
+// models pick a sensible recovery instead of retrying blindly.
+var errorClasses = []errorClass{
+	{
+		Name:    "command_not_found",
+		Pattern: regexp.MustCompile(`(?i)command not found|not recognized as an internal or external command|is not recognized as the name of a cmdlet|executable file not found`),
+		Hint:    "The program is not installed or not on PATH. Check whether an alternative tool is available, or install it with the system package manager if the task allows.",
+	},
+	{
+		Name:    "permission_denied",
+		Pattern: regexp.MustCompile(`(?i)permission denied|operation not permitted|access is denied|EACCES|must be run as root|are you root`),
+		Hint:    "The command lacks the required permissions. Check ownership and mode with ls -l, work on a copy in a writable location, or use sudo only if the task really requires system changes.",
+	},
+	{
+		Name:    "no_space",
+		Pattern: regexp.MustCompile(`(?i)no space left on device|disk quota exceeded|ENOSPC`),
+		Hint:    "The disk is full. Check free space with df -h and find large files with du before retrying; do not retry the same write.",
+	},
+	{
+		Name:    "read_only_fs",
+		Pattern: regexp.MustCompile(`(?i)read-only file system`),
+		Hint:    "The target is on a read-only filesystem. Write somewhere else instead of retrying.",
+	},
+	{
+		Name:    "network_unreachable",
+		Pattern: regexp.MustCompile(`(?i)network is unreachable|could not resolve host|temporary failure in name resolution|name or service not known|connection timed out|no route to host|failed to connect`),
+		Hint:    "The network or the remote host is unreachable. Check connectivity (e.g. ping or curl a known host) before retrying, and prefer offline alternatives.",
+	},
+	{
+		Name:    "connection_refused",
+		Pattern: regexp.MustCompile(`(?i)connection refused`),
+		Hint:    "Nothing is listening at that address. Check that the service is running and which port it uses.",
+	},
+	{
+		Name:    "package_manager_locked",
+		Pattern: regexp.MustCompile(`(?i)could not get lock|waiting for cache lock|another process is using|unable to acquire the dpkg`),
+		Hint:    "Another package manager process holds the lock. Wait for it to finish rather than deleting the lock file.",
+	},
+	{
+		Name:    "file_not_found",
+		Pattern: regexp.MustCompile(`(?i)no such file or directory|cannot find the (file|path) specified|does not exist`),
+		Hint:    "A path does not exist. List the relevant directory to find the correct name before retrying.",
+	},
+	{
+		Name:    "python_module_missing",
+		Pattern: regexp.MustCompile(`ModuleNotFoundError|No module named`),
+		Hint:    "A Python module is missing. Check whether a virtual environment should be used, or install the module with pip into one.",
+	},
+}
+
+// classifyError returns the class and remediation hint for a failed command,
+// or empty strings if the failure is not recognised.
+func classifyError(status string, output string) (class string, hint string) {
+	switch status {
+	case stopTimeout:
+		return "timeout", fmt.Sprintf("The command was killed after the %ds command timeout. Run long jobs in the background with their output sent to a file, or break them into smaller steps.", cfg.CommandTimeoutSeconds)
+	case stopInterrupted:
+		return "interrupted", "The user pressed Ctrl+C to stop the command. Do not rerun it as is; ask the user if it is unclear why."
+	case stopOutputLimit:
+		return "output_limit", fmt.Sprintf("The command was stopped after writing %d MB of output, the limit. Narrow it, or send the output to a file and search that.", cfg.Limits.OutputMB)
+	}
+	if !strings.HasPrefix(status, "ERROR") {
+		return "", ""
+	}
+	if hint := limitHint(status, output); hint != "" {
+		return "resource_limit", hint
+	}
+	for _, ec := range errorClasses {
+		if ec.Pattern.MatchString(output) {
+			return ec.Name, ec.Hint
+		}
+	}
+	return "", ""
+}
+
+func errorHint(status string, output string) string {
+	class, hint := classifyError(status, output)
+	if class == "" {
+		return ""
+	}
+	return fmt.Sprintf("HINT (%s): %s\n", class, hint)
+}
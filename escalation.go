@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// EscalationConfig varies the sampling parameters when the model keeps
+// failing, so a deterministic model does not retry the same dead end forever.
+// After AfterFailures consecutive failed steps the temperature is raised by
+// TemperatureStep per further failure, up to MaxTemperature, and a fresh
+// random seed is used for every retry.
+type EscalationConfig struct {
+	Enabled         bool    `json:"enabled"`
+	AfterFailures   int     `json:"after_failures"`
+	TemperatureStep float64 `json:"temperature_step"`
+	MaxTemperature  float64 `json:"max_temperature"`
+}
+
+// ollamaDefaultTemperature is what Ollama samples with when no temperature is
+// set, and so the starting point for escalation unless one is configured.
+const ollamaDefaultTemperature = 0.8
+
+// samplingOptions returns the model options for the next step, or nil to use
+// the model's defaults.
+func (a *Agent) samplingOptions() map[string]any {
+	esc := cfg.Escalation
+	if !esc.Enabled || esc.AfterFailures <= 0 || a.failures < esc.AfterFailures {
+		return nil
+	}
+
+	retries := a.failures - esc.AfterFailures + 1
+	base := ollamaDefaultTemperature
+	if configured, ok := optionFloat(cfg.Options, "temperature"); ok {
+		base = configured
+	}
+	temperature := min(base+esc.TemperatureStep*float64(retries), esc.MaxTemperature)
+	seed := rand.IntN(1 << 31)
+
+	a.printf("🎲 %d failed steps in a row, retrying with temperature %.2f and seed %d\n", a.failures, temperature, seed)
+	a.record("sampling", fmt.Sprintf("temperature %.2f, seed %d after %d consecutive failures", temperature, seed, a.failures))
+	return map[string]any{"temperature": temperature, "seed": seed}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+const explainSystemPrompt = `You explain shell commands to someone deciding whether to run them. You do not run anything.
+
+ENVIRONMENT:
+%s
+
+Reply in this format, in plain text without code fences:
+SUMMARY: <one sentence on what the command does as a whole>
+BREAKDOWN:
+- <each pipeline stage, command, option and argument that matters, in order, with what it does>
+RISK: <what the command changes or deletes, what could go wrong, and whether it can be undone; "Read-only" if it changes nothing>`
+
+// explainText asks the model to break a command down.
+func explainText(command string, shell string) (string, error) {
+	resp, err := callModel(executorModel(), []Message{{Role: "user", Content: command}}, fmt.Sprintf(explainSystemPrompt, environmentBlock(runtime.GOOS, shell)))
+	if err != nil {
+		return "", fmt.Errorf("model API call failed: %w", err)
+	}
+	return strings.TrimSpace(resp.Message.Content), nil
+}
+
+// printExplanation explains a command along with shai's own risk tier and
+// denylist or policy verdict, which do not depend on the model.
+func printExplanation(command string, shell string) {
+	fmt.Println("🔎 Explaining...")
+	explanation, err := explainText(command, shell)
+	if err != nil {
+		fmt.Printf("⚠️ %v\n", err)
+		return
+	}
+	fmt.Printf("\n%s\n\nshai's risk tier: %s\n", explanation, classifyRisk(command))
+	if pattern, denied := deniedBy(command); denied {
+		fmt.Printf("⛔ The command matches the denylist pattern %q.\n", pattern)
+	} else if rule, ok := matchPolicy(command); ok {
+		fmt.Printf("📜 The %s policy rule %q applies: %s.\n", cfg.Policy, rule.Pattern, rule.Decision)
+	}
+}
+
+// explainCommand runs `shai explain`: the command is the arguments, or what
+// is piped to stdin.
+func explainCommand(args []string) error {
+	command := strings.Join(args, " ")
+	if command == "" || command == "-" {
+		if command == "" && isTerminal(os.Stdin) {
+			return fmt.Errorf("give the command to explain as an argument or on stdin")
+		}
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+		command = strings.TrimSpace(string(data))
+	}
+	if command == "" {
+		return fmt.Errorf("no command to explain")
+	}
+	prepareCall()
+	printExplanation(command, defaultShell())
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// skippedDirs are never descended into when shai lists files itself: they
+// are large and almost never what a task is about.
+var skippedDirs = map[string]bool{
+	".git": true, ".hg": true, ".svn": true, "node_modules": true,
+	"__pycache__": true, ".venv": true, ".cache": true,
+}
+
+// ignoreRule is one pattern from a .gitignore file.
+type ignoreRule struct {
+	base    string // slash-separated directory of the .gitignore, relative to the walk root
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// parseIgnoreLine converts a .gitignore line into a rule, following the
+// gitignore(5) pattern format. ok is false for blank lines and comments.
+func parseIgnoreLine(base, line string) (rule ignoreRule, ok bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	rule.base = base
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, `\`)
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return rule, false
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case strings.HasPrefix(line[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(line[i:], "/**") && i+3 == len(line):
+			expr.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(line[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			if end := strings.IndexByte(line[i+1:], ']'); end >= 0 {
+				class := line[i+1 : i+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				expr.WriteString("[" + class + "]")
+				i += end + 1
+			} else {
+				expr.WriteString(`\[`)
+			}
+		case c == '\\' && i+1 < len(line):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(line[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	pattern, err := regexp.Compile(expr.String())
+	if err != nil {
+		return rule, false
+	}
+	rule.pattern = pattern
+	return rule, true
+}
+
+func readIgnoreFile(file, base string) []ignoreRule {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(base, scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// ignored reports whether rel (slash-separated, relative to the walk root)
+// is excluded by the rules; as in git, the last matching rule wins.
+func ignored(rules []ignoreRule, rel string, isDir bool) bool {
+	result := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		local := rel
+		if rule.base != "." {
+			var ok bool
+			if local, ok = strings.CutPrefix(rel, rule.base+"/"); !ok {
+				continue
+			}
+		}
+		if rule.pattern.MatchString(local) {
+			result = !rule.negate
+		}
+	}
+	return result
+}
+
+// walkFiles calls fn with the slash-separated path, relative to root, of
+// every regular file under root, skipping skippedDirs and anything excluded
+// by .gitignore files (and .git/info/exclude) along the way. fn may return
+// fs.SkipAll to stop early.
+func walkFiles(root string, fn func(rel string) error) error {
+	rules := readIgnoreFile(filepath.Join(root, ".git", "info", "exclude"), ".")
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		rel, relErr := filepath.Rel(root, p)
+		if relErr != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if p != root && (skippedDirs[d.Name()] || ignored(rules, rel, true)) {
+				return fs.SkipDir
+			}
+			rules = append(rules, readIgnoreFile(filepath.Join(p, ".gitignore"), rel)...)
+			return nil
+		}
+		if !d.Type().IsRegular() || ignored(rules, rel, false) {
+			return nil
+		}
+		return fn(rel)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Shell integration: `shai init <shell>` prints a hook that records each
+// command line, its directory and its exit status after it runs, and
+// `shai fix` hands the last one to the agent.
+
+const bashHook = `# shai: remember the last command for "shai fix".
+__shai_record() {
+  local exit_status=$? dir="${XDG_STATE_HOME:-$HOME/.local/state}/shai"
+  mkdir -p "$dir"
+  printf '%s\n%s\n%s\n' "$exit_status" "$PWD" "$(HISTTIMEFORMAT= history 1 | sed 's/^ *[0-9]* *//')" > "$dir/last-command"
+  return $exit_status
+}
+PROMPT_COMMAND="__shai_record${PROMPT_COMMAND:+; $PROMPT_COMMAND}"
+`
+
+const zshHook = `# shai: remember the last command for "shai fix".
+__shai_record() {
+  local exit_status=$? dir="${XDG_STATE_HOME:-$HOME/.local/state}/shai"
+  mkdir -p "$dir"
+  printf '%s\n%s\n%s\n' "$exit_status" "$PWD" "$(fc -ln -1)" > "$dir/last-command"
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook precmd __shai_record
+`
+
+const fishHook = `# shai: remember the last command for "shai fix".
+function __shai_record --on-event fish_postexec
+    set -l exit_status $status
+    set -l dir $HOME/.local/state/shai
+    set -q XDG_STATE_HOME; and set dir $XDG_STATE_HOME/shai
+    mkdir -p $dir
+    printf '%s\n%s\n%s\n' $exit_status $PWD $argv[1] > $dir/last-command
+end
+`
+
+var shellHooks = map[string]string{"bash": bashHook, "zsh": zshHook, "fish": fishHook}
+
+// initCommand prints the hook for a shell, to be evaluated in its rc file.
+func initCommand(args []string) error {
+	hook, ok := shellHooks[args[0]]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q (use bash, zsh or fish)", args[0])
+	}
+	fmt.Print(hook)
+	return nil
+}
+
+const fixTaskTemplate = `The command below, run in %s, failed with exit status %s:
+
+%s
+
+Find out why it failed and fix the cause, then run the command again to verify that it now succeeds. If the command itself is wrong (a typo, a wrong flag, a missing argument), run the corrected command instead.`
+
+// lastCommand reads what the shell hook recorded.
+func lastCommand() (status string, dir string, command string, err error) {
+	stateDir, err := getStateDir()
+	if err != nil {
+		return "", "", "", err
+	}
+	data, err := os.ReadFile(filepath.Join(stateDir, "last-command"))
+	if os.IsNotExist(err) {
+		return "", "", "", fmt.Errorf("no command recorded yet; add the shell hook first, e.g. eval \"$(shai init bash)\" in ~/.bashrc")
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	parts := strings.SplitN(strings.TrimRight(string(data), "\n"), "\n", 3)
+	if len(parts) < 3 || strings.TrimSpace(parts[2]) == "" {
+		return "", "", "", fmt.Errorf("the recorded command is incomplete")
+	}
+	return parts[0], parts[1], strings.TrimSpace(parts[2]), nil
+}
+
+// fixCommand runs `shai fix`: the agent diagnoses and repairs the last
+// command the shell recorded. Read-only commands are run again first, so
+// the model sees their error output.
+func fixCommand(args []string) error {
+	// As with run, flags may follow "fix".
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	status, dir, command, err := lastCommand()
+	if err != nil {
+		return err
+	}
+	if command == "shai fix" || strings.HasPrefix(command, "shai fix ") {
+		return fmt.Errorf("the last command was shai fix itself; run the failing command again first")
+	}
+	if status == "0" {
+		fmt.Printf("ℹ️ The last command succeeded: %s\n", command)
+	}
+	prepareRun()
+	if err := os.Chdir(dir); err != nil {
+		return fmt.Errorf("failed to return to %s: %w", dir, err)
+	}
+
+	task := fmt.Sprintf(fixTaskTemplate, dir, status, command)
+	if guidance := strings.Join(flag.Args(), " "); guidance != "" {
+		task += "\n\nThe user adds: " + guidance
+	}
+	var attachments []attachment
+	if classifyRisk(command) == riskReadOnly {
+		if _, denied := deniedBy(command); !denied {
+			fmt.Printf("🔁 Running the command again to see its output: %s\n", command)
+			_, output := executeCommand(command, defaultShell(), nil, io.Discard, nil)
+			attachments = append(attachments, attachment{"output of the failed command", strings.TrimPrefix(output, "OUTPUT:\n")})
+		}
+	}
+	runTask(task, attachments...)
+	return nil
+}
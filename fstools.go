@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const fsToolsPromptSection = `
+READING FILES:
+For read-only reconnaissance, prefer these built-in actions to shell commands; they run without a shell and have no quoting problems. Each takes a single path on the same line (paths may contain spaces and need no quotes):
+   - "LS" lists a directory (default: the working directory).
+   - "STAT" shows the type, size, permissions and modification time of a path.
+   - "CAT" shows a text file.
+   - "HEAD" shows the first lines of a text file; to choose how many, put the number before the path, e.g. "HEAD 100 notes.txt".
+`
+
+const (
+	maxCatSize      = 64 << 10
+	maxListEntries  = 500
+	defaultHeadSize = 40
+)
+
+// fsTools are the built-in read-only filesystem actions, keyed by action.
+// Relative paths are relative to the agent's working directory.
+var fsTools = map[string]func(state *shellState, arg string) (status string, output string){
+	"LS":   listDirectory,
+	"STAT": statPath,
+	"CAT":  catFile,
+	"HEAD": headFile,
+}
+
+// runFSTool runs a filesystem action under the same checks as the command
+// it stands in for (CAT PATH and READ_FILE PATH are checked as "cat PATH"):
+// the denylist, the policy rules and the approval policy for read-only
+// commands.
+func (a *Agent) runFSTool(action string, arg string, tool func(state *shellState, arg string) (string, string)) (status string, output string) {
+	arg = strings.TrimSpace(arg)
+	name := strings.ToLower(action)
+	if action == "READ_FILE" {
+		name = "cat"
+	}
+	command := strings.TrimSpace(name + " " + arg)
+	defer func() { a.audit(action, arg, riskReadOnly, status, output) }()
+
+	if pattern, denied := deniedBy(command); denied {
+		a.printf("⛔ Blocked by the denylist (%s): %s %s\n", pattern, action, arg)
+		return "BLOCKED", fmt.Sprintf("POLICY_VIOLATION: %s matches the denylist pattern %q and was not run. Do not try to work around the policy.", command, pattern)
+	}
+	decision, reason, ruled := commandApproval(command, riskReadOnly)
+	if decision == approvalDeny {
+		a.printf("⛔ %s %s is denied by %s.\n", action, arg, reason)
+		return "DENIED", fmt.Sprintf("%s is denied by %s and was not run.", command, reason)
+	}
+	message := fmt.Sprintf("📖 shai wants to read:\n\n  %s %s\n\nAllow?", action, arg)
+	approved := true
+	switch {
+	case ruled && decision == approvalAllow:
+		a.printf("👍 Auto-approved by %s\n", reason)
+		a.approvedBy = "auto_approved"
+	case ruled:
+		a.printf("📜 Asking because of %s\n", reason)
+		a.approvedBy = "approved"
+		done := a.notifyWaiting("approval", "shai needs your approval", message)
+		approved = a.confirm(message)
+		done()
+	default:
+		approved = a.approve(riskReadOnly, message)
+	}
+	if !approved {
+		a.printf("🛑 Rejecting %s.\n", action)
+		return "REJECTED", action + " rejected by user."
+	}
+
+	a.printf("📖 %s %s\n", action, arg)
+	return tool(&a.shell, arg)
+}
+
+func fsToolsPromptSectionText() string {
+	if !cfg.NativeTools {
+		return ""
+	}
+	return fsToolsPromptSection
+}
+
+func expandPath(path string) string {
+	path = strings.Trim(strings.TrimSpace(path), `"'`)
+	if rest, ok := strings.CutPrefix(path, "~"); ok && (rest == "" || rest[0] == '/' || rest[0] == filepath.Separator) {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = home + rest
+		}
+	}
+	return path
+}
+
+func listDirectory(state *shellState, arg string) (string, string) {
+	dir := state.resolve(arg)
+	if dir == "" {
+		dir = state.workDir()
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "ERROR", err.Error()
+	}
+
+	var out strings.Builder
+	for i, entry := range entries {
+		if i == maxListEntries {
+			fmt.Fprintf(&out, "[%d more entries not shown]\n", len(entries)-maxListEntries)
+			break
+		}
+		info, err := entry.Info()
+		if err != nil {
+			fmt.Fprintf(&out, "?          %s\n", entry.Name())
+			continue
+		}
+		name := entry.Name()
+		switch {
+		case info.IsDir():
+			name += "/"
+		case info.Mode()&os.ModeSymlink != 0:
+			if target, err := os.Readlink(filepath.Join(dir, entry.Name())); err == nil {
+				name += " -> " + target
+			}
+		}
+		fmt.Fprintf(&out, "%s %10d %s %s\n", info.Mode(), info.Size(), info.ModTime().Format(time.DateTime), name)
+	}
+	if len(entries) == 0 {
+		out.WriteString("(empty directory)\n")
+	}
+	return "SUCCESS", out.String()
+}
+
+func statPath(state *shellState, arg string) (string, string) {
+	path := state.resolve(arg)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "ERROR", err.Error()
+	}
+
+	kind := "file"
+	switch {
+	case info.IsDir():
+		kind = "directory"
+	case info.Mode()&os.ModeSymlink != 0:
+		kind = "symlink"
+		if target, err := os.Readlink(path); err == nil {
+			kind += " to " + target
+		}
+	case !info.Mode().IsRegular():
+		kind = "special file"
+	}
+	abs, _ := filepath.Abs(path)
+	return "SUCCESS", fmt.Sprintf("Path: %s\nType: %s\nSize: %d bytes\nMode: %s\nModified: %s\n", abs, kind, info.Size(), info.Mode(), info.ModTime().Format(time.RFC3339))
+}
+
+// readTextFile reads at most limit bytes of a file, refusing binary files.
+func readTextFile(path string, limit int) (data []byte, truncated bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return nil, false, fmt.Errorf("%s is a directory", path)
+	}
+
+	data, err = io.ReadAll(io.LimitReader(f, int64(limit)+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return nil, false, fmt.Errorf("%s looks like a binary file", path)
+	}
+	if len(data) > limit {
+		return data[:limit], true, nil
+	}
+	return data, false, nil
+}
+
+func catFile(state *shellState, arg string) (string, string) {
+	path := state.resolve(arg)
+	data, truncated, err := readTextFile(path, maxCatSize)
+	if err != nil {
+		return "ERROR", err.Error()
+	}
+	output := string(data)
+	if truncated {
+		output += fmt.Sprintf("\n[file truncated at %d KB; use HEAD or SEARCH_FILES for the rest]\n", maxCatSize>>10)
+	}
+	return "SUCCESS", output
+}
+
+func headFile(state *shellState, arg string) (string, string) {
+	lines := defaultHeadSize
+	if count, rest, ok := strings.Cut(strings.TrimSpace(arg), " "); ok {
+		if n, err := strconv.Atoi(count); err == nil && n > 0 {
+			lines, arg = n, rest
+		}
+	}
+	data, _, err := readTextFile(state.resolve(arg), maxCatSize)
+	if err != nil {
+		return "ERROR", err.Error()
+	}
+
+	var out strings.Builder
+	for i, line := range strings.SplitAfter(string(data), "\n") {
+		if i == lines {
+			break
+		}
+		out.WriteString(line)
+	}
+	return "SUCCESS", out.String()
+}
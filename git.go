@@ -0,0 +1,215 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// With "git_checkpoint": true, shai records the state of the git repository
+// it works in before the first mutating command of a run: a commit of the
+// whole working tree, untracked files included, made with a temporary index
+// so neither the user's index nor their branch changes. It is kept under
+// refs/shai/checkpoints/ and restored by `shai rollback`.
+
+const gitCheckpointRefs = "refs/shai/checkpoints/"
+
+// gitOutput runs git in dir and returns its trimmed output.
+func gitOutput(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitRoot is the top level of the repository containing dir, or "" if it is
+// not in one.
+func gitRoot(dir string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		return ""
+	}
+	root, err := gitOutput(dir, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return ""
+	}
+	return root
+}
+
+// withTempIndex runs fn with GIT_INDEX_FILE pointing to a scratch index.
+func withTempIndex(fn func(env []string) error) error {
+	dir, err := os.MkdirTemp("", "shai-index")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	return fn([]string{"GIT_INDEX_FILE=" + filepath.Join(dir, "index")})
+}
+
+func takeGitCheckpoint(task, dir string) (Snapshot, error) {
+	snap := Snapshot{Time: time.Now(), Task: task, Dir: dir, FS: "git", Source: gitRoot(dir)}
+	if snap.Source == "" {
+		return snap, fmt.Errorf("%s is not in a git repository", dir)
+	}
+	root := snap.Source
+
+	head, err := gitOutput(root, nil, "rev-parse", "--verify", "-q", "HEAD")
+	if err != nil {
+		head = "" // a repository without commits yet
+	}
+	snap.Head = head
+	snap.Branch, _ = gitOutput(root, nil, "symbolic-ref", "-q", "--short", "HEAD")
+	if snap.IndexTree, err = gitOutput(root, nil, "write-tree"); err != nil {
+		return snap, fmt.Errorf("failed to record the index: %w", err)
+	}
+
+	var commit string
+	err = withTempIndex(func(env []string) error {
+		if _, err := gitOutput(root, env, "add", "-A"); err != nil {
+			return err
+		}
+		tree, err := gitOutput(root, env, "write-tree")
+		if err != nil {
+			return err
+		}
+		args := []string{"commit-tree", tree, "-m", "shai checkpoint before: " + truncateLine(task, 200)}
+		if head != "" {
+			args = append(args, "-p", head)
+		}
+		commit, err = gitOutput(root, append(env, "GIT_AUTHOR_NAME=shai", "GIT_AUTHOR_EMAIL=shai@localhost", "GIT_COMMITTER_NAME=shai", "GIT_COMMITTER_EMAIL=shai@localhost"), args...)
+		return err
+	})
+	if err != nil {
+		return snap, fmt.Errorf("failed to create the checkpoint: %w", err)
+	}
+	snap.Name = gitCheckpointRefs + snap.Time.Format("20060102-150405")
+	if _, err := gitOutput(root, nil, "update-ref", snap.Name, commit); err != nil {
+		return snap, err
+	}
+	return snap, recordSnapshot(snap)
+}
+
+// rollbackGit returns the repository to a checkpoint: the branch and HEAD,
+// every file that is not ignored, and the index.
+func rollbackGit(snap Snapshot) error {
+	root := snap.Source
+	if snap.Branch != "" {
+		if current, _ := gitOutput(root, nil, "symbolic-ref", "-q", "--short", "HEAD"); current != snap.Branch {
+			if _, err := gitOutput(root, nil, "checkout", "-q", "-f", snap.Branch); err != nil {
+				return err
+			}
+		}
+	}
+	if snap.Head != "" {
+		if _, err := gitOutput(root, nil, "reset", "-q", "--soft", snap.Head); err != nil {
+			return err
+		}
+	}
+
+	saved, err := gitOutput(root, nil, "ls-tree", "-r", "-z", "--name-only", snap.Name)
+	if err != nil {
+		return err
+	}
+	keep := map[string]bool{}
+	for _, path := range strings.Split(saved, "\x00") {
+		keep[path] = true
+	}
+	current, err := gitOutput(root, nil, "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return err
+	}
+	for _, path := range strings.Split(current, "\x00") {
+		if path != "" && !keep[path] {
+			if err := os.Remove(filepath.Join(root, filepath.FromSlash(path))); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	err = withTempIndex(func(env []string) error {
+		if _, err := gitOutput(root, env, "read-tree", snap.Name); err != nil {
+			return err
+		}
+		_, err := gitOutput(root, env, "checkout-index", "-a", "-f")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = gitOutput(root, nil, "read-tree", snap.IndexTree)
+	return err
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return cmp.Or(hash, "no commit")
+}
+
+// gitEnvironmentLine describes the repository the working directory is in:
+// branch, upstream and uncommitted changes.
+func gitEnvironmentLine() string {
+	dir := getwd()
+	root := gitRoot(dir)
+	if root == "" {
+		return ""
+	}
+	status, err := gitOutput(dir, nil, "status", "--porcelain=v1", "--branch")
+	if err != nil {
+		return ""
+	}
+
+	branch := "no commits yet"
+	var staged, modified, untracked, conflicted int
+	for _, line := range strings.Split(status, "\n") {
+		if header, ok := strings.CutPrefix(line, "## "); ok {
+			branch = header
+			continue
+		}
+		if len(line) < 2 {
+			continue
+		}
+		x, y := line[0], line[1]
+		switch {
+		case x == '?':
+			untracked++
+		case x == 'U' || y == 'U' || (x == 'A' && y == 'A') || (x == 'D' && y == 'D'):
+			conflicted++
+		default:
+			if x != ' ' {
+				staged++
+			}
+			if y != ' ' {
+				modified++
+			}
+		}
+	}
+
+	state := "clean"
+	var counts []string
+	for _, c := range []struct {
+		n    int
+		what string
+	}{{staged, "staged"}, {modified, "modified"}, {untracked, "untracked"}, {conflicted, "conflicted"}} {
+		if c.n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", c.n, c.what))
+		}
+	}
+	if len(counts) > 0 {
+		state = "uncommitted changes: " + strings.Join(counts, ", ")
+	}
+	return fmt.Sprintf("\nGit: repository %s, branch %s, %s", root, branch, state)
+}
@@ -1,3 +1,3 @@
-module shai
+module github.com/ChristianWSmith/shai
 
 go 1.25.4
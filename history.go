@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// HistoryEntry summarizes one finished top-level task for `shai stats`.
+type HistoryEntry struct {
+	Time         time.Time      `json:"time"`
+	Task         string         `json:"task"`
+	Status       string         `json:"status"`
+	Steps        int            `json:"steps"`
+	Duration     float64        `json:"duration_seconds"`
+	PromptTokens int            `json:"prompt_tokens"`
+	OutputTokens int            `json:"output_tokens"`
+	ModelSteps   map[string]int `json:"model_steps"`
+	Session      string         `json:"session,omitempty"`
+	Cost         float64        `json:"cost,omitempty"`
+}
+
+func (a *Agent) recordUsage(resp ChatResponse) {
+	a.usage.add(a.Model, resp)
+}
+
+func historyPath() (string, error) {
+	dir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+// appendHistory records how a task ended. Errors are ignored: history is
+// only used for statistics.
+func appendHistory(a *Agent, result AgentResult, runErr error, started time.Time) {
+	status := result.Status
+	if runErr != nil {
+		status = "ERROR"
+	}
+	// A chat runs several tasks on one agent; each counts what it used.
+	usage := a.usage.since(a.usageAtStart)
+	steps, prompt, output := usage.totals()
+	cost, _ := usage.cost()
+	entry := HistoryEntry{
+		Time:         started,
+		Task:         a.Task,
+		Status:       status,
+		Steps:        steps,
+		Duration:     time.Since(started).Seconds(),
+		PromptTokens: prompt,
+		OutputTokens: output,
+		ModelSteps:   usage.modelSteps(),
+		Session:      a.SessionID,
+		Cost:         cost,
+	}
+
+	path, err := historyPath()
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	data, _ := json.Marshal(entry)
+	f.Write(append(data, '\n'))
+}
+
+func readHistory() ([]HistoryEntry, error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1<<20), 1<<24)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
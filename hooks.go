@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Hooks are user commands run on lifecycle events, with the event as JSON
+// on stdin and its name in $SHAI_EVENT. A pre_command hook that fails
+// (or times out) vetoes the command; other hooks only get a warning.
+var hookEvents = []string{"session_start", "pre_command", "post_command", "ask", "complete", "stopped"}
+
+// HookPayload is the JSON a hook receives. Text fields are redacted.
+type HookPayload struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Session  string    `json:"session,omitempty"`
+	Agent    string    `json:"agent,omitempty"`
+	Task     string    `json:"task"`
+	Step     int       `json:"step"`
+	Dir      string    `json:"dir"`
+	Command  string    `json:"command,omitempty"`
+	Risk     string    `json:"risk,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	Output   string    `json:"output,omitempty"`
+	Question string    `json:"question,omitempty"`
+	Summary  string    `json:"summary,omitempty"`
+}
+
+// checkHooks rejects hooks for events shai does not have.
+func checkHooks() error {
+	for event := range cfg.Hooks {
+		if !slices.Contains(hookEvents, event) {
+			return fmt.Errorf("unknown hook event %q (use %s)", event, strings.Join(hookEvents, ", "))
+		}
+	}
+	return nil
+}
+
+// hook runs the hooks for an event. It reports whether they all succeeded,
+// and the output of the first one that did not.
+func (a *Agent) hook(event string, payload HookPayload) (ok bool, output string) {
+	commands := cfg.Hooks[event]
+	if len(commands) == 0 || replaying() {
+		return true, ""
+	}
+	payload.Event = event
+	payload.Time = time.Now()
+	payload.Session = a.auditSession()
+	payload.Agent = a.Name
+	payload.Task = redact(a.Task)
+	payload.Step = a.Step
+	payload.Dir = a.shell.workDir()
+	payload.Command = redact(payload.Command)
+	payload.Output = redact(payload.Output)
+	payload.Question = redact(payload.Question)
+	payload.Summary = redact(payload.Summary)
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return false, err.Error()
+	}
+	data = append(data, '\n')
+
+	for _, command := range commands {
+		out, err := runHook(event, command, data)
+		if err != nil {
+			a.printf("⚠️ The %s hook `%s` failed: %v\n", event, truncateLine(command, 60), err)
+			if out != "" {
+				a.printf("%s\n", out)
+			}
+			return false, out
+		}
+	}
+	return true, ""
+}
+
+func runHook(event string, command string, payload []byte) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = windowsCommand(defaultShell(), command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "SHAI_EVENT="+event)
+	cmd.Stdin = bytes.NewReader(payload)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	timeout := time.Duration(max(cfg.HookTimeoutSeconds, 1)) * time.Second
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		cmd.Process.Kill()
+	})
+	err := cmd.Wait()
+	if !timer.Stop() && timedOut.Load() {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	return strings.TrimSpace(out.String()), err
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// A command that stops to wait for input hangs the session: a pager opened
+// by git, apt asking to continue, npm init asking questions. shai adds the
+// flags that make such commands run unattended, sets variables that turn
+// pagers and prompts off, and warns at the approval prompt about commands it
+// cannot fix.
+
+// InteractiveConfig controls commands that would wait for input. Mode is
+// "rewrite" (the default) to add non-interactive flags and variables and
+// warn about the rest, "warn" to only warn, or "off". Rules add to the
+// built-in rewrite rules, and Env to the built-in variables.
+type InteractiveConfig struct {
+	Mode  string            `json:"mode"`
+	Rules []InteractiveRule `json:"rules"`
+	Env   map[string]string `json:"env"`
+}
+
+// InteractiveRule adds Flag right after what Match (a regular expression)
+// matches in a command, unless Unless matches the rest of that command.
+type InteractiveRule struct {
+	Match  string `json:"match"`
+	Unless string `json:"unless"`
+	Flag   string `json:"flag"`
+}
+
+// commandStart matches where a program name starts a simple command: at the
+// start of a line, after an operator or sudo, and after variable
+// assignments.
+const commandStart = `(?m)(?:^|[;&|(]|\bsudo|\bdoas)\s*(?:\w+=\S*\s+)*`
+
+// builtinInteractiveRules make package managers and project generators
+// proceed without asking.
+var builtinInteractiveRules = []InteractiveRule{
+	{commandStart + `(apt|apt-get|aptitude|dnf|yum|microdnf)(\s+-\S+)*\s+(install|reinstall|remove|purge|upgrade|full-upgrade|dist-upgrade|autoremove|erase|update|downgrade)\b`, `(^|\s)(-[a-zA-Z]*y[a-zA-Z]*|--yes|--assume-yes)\b`, "-y"},
+	{commandStart + `zypper\b`, `(^|\s)(-n|--non-interactive)\b`, "--non-interactive"},
+	{commandStart + `pacman(\s+-\S+)*\s+-[SRU][a-zA-Z]*`, `--noconfirm`, "--noconfirm"},
+	{commandStart + `(npm|yarn|pnpm)\s+init\b`, `(^|\s)(-y|--yes)\b`, "-y"},
+	{commandStart + `npx\b`, `(^|\s)(-y|--yes|--no)\b`, "--yes"},
+	{commandStart + `pip3?\s+uninstall\b`, `(^|\s)(-y|--yes)\b`, "-y"},
+	{commandStart + `(conda|mamba)\s+(install|remove|update|create|uninstall)\b`, `(^|\s)(-y|--yes)\b`, "-y"},
+	{commandStart + `docker\s+((system|image|container|volume|network|builder)\s+)?prune\b`, `(^|\s)(-f|--force)\b`, "-f"},
+}
+
+// builtinInteractiveEnv turns off pagers, package manager dialogs and
+// credential prompts.
+var builtinInteractiveEnv = map[string]string{
+	"PAGER":               "cat",
+	"GIT_PAGER":           "cat",
+	"MANPAGER":            "cat",
+	"SYSTEMD_PAGER":       "cat",
+	"AWS_PAGER":           "",
+	"DEBIAN_FRONTEND":     "noninteractive",
+	"GIT_TERMINAL_PROMPT": "0",
+	"PIP_NO_INPUT":        "1",
+	"NPM_CONFIG_YES":      "true",
+}
+
+type interactiveRule struct {
+	InteractiveRule
+	match  *regexp.Regexp
+	unless *regexp.Regexp
+}
+
+var interactiveRules []interactiveRule
+
+// compileInteractive checks the mode and compiles the rewrite rules.
+func compileInteractive() error {
+	switch cfg.Interactive.Mode {
+	case "rewrite", "warn", "off":
+	default:
+		return fmt.Errorf("unknown interactive mode %q (expected rewrite, warn or off)", cfg.Interactive.Mode)
+	}
+	interactiveRules = nil
+	for _, rule := range append(slices.Clone(builtinInteractiveRules), cfg.Interactive.Rules...) {
+		match, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return fmt.Errorf("invalid interactive rule %q: %w", rule.Match, err)
+		}
+		var unless *regexp.Regexp
+		if rule.Unless != "" {
+			if unless, err = regexp.Compile(rule.Unless); err != nil {
+				return fmt.Errorf("invalid interactive rule %q: %w", rule.Unless, err)
+			}
+		}
+		if rule.Flag == "" {
+			return fmt.Errorf("interactive rule %q has no flag", rule.Match)
+		}
+		interactiveRules = append(interactiveRules, interactiveRule{rule, match, unless})
+	}
+	return nil
+}
+
+// nonInteractiveEnv returns the variables every command gets in rewrite
+// mode.
+func nonInteractiveEnv() map[string]string {
+	env := map[string]string{}
+	if cfg.Interactive.Mode != "rewrite" {
+		return env
+	}
+	maps.Copy(env, builtinInteractiveEnv)
+	maps.Copy(env, cfg.Interactive.Env)
+	return env
+}
+
+// makeNonInteractive adds the rules' flags to a command and returns it with
+// the flags it added.
+func makeNonInteractive(command string) (string, []string) {
+	if cfg.Interactive.Mode != "rewrite" {
+		return command, nil
+	}
+	var added []string
+	for _, rule := range interactiveRules {
+		var b strings.Builder
+		last := 0
+		for _, loc := range rule.match.FindAllStringIndex(command, -1) {
+			// The rest of this simple command, up to the next operator.
+			rest := command[loc[1]:]
+			if end := strings.IndexAny(rest, ";&|\n"); end >= 0 {
+				rest = rest[:end]
+			}
+			if rule.unless != nil && rule.unless.MatchString(command[loc[0]:loc[1]]+rest) {
+				continue
+			}
+			b.WriteString(command[last:loc[1]])
+			b.WriteString(" " + rule.Flag)
+			last = loc[1]
+			if !slices.Contains(added, rule.Flag) {
+				added = append(added, rule.Flag)
+			}
+		}
+		if last > 0 {
+			b.WriteString(command[last:])
+			command = b.String()
+		}
+	}
+	return command, added
+}
+
+// editors open a file and wait for the user to close it.
+var editors = []string{"vi", "vim", "nvim", "nano", "emacs", "pico", "joe", "micro", "ed"}
+
+// repls start an interactive interpreter when given no script.
+var repls = []string{"python", "python3", "node", "irb", "ghci", "lua", "R", "sqlite3", "psql", "mysql"}
+
+// interactiveWarnings describes what in a command would wait for input and
+// has no non-interactive flag shai can add.
+func interactiveWarnings(command string) []string {
+	if cfg.Interactive.Mode == "off" {
+		return nil
+	}
+	var warnings []string
+	for _, words := range splitPipeline(command) {
+		words = commandWords(words)
+		if len(words) > 1 && slices.Contains(escalationPrograms, filepath.Base(words[0])) {
+			words = commandWords(words[1:])
+		}
+		name := filepath.Base(words[0])
+		args := words[1:]
+		switch {
+		case slices.Contains(editors, name):
+			warnings = append(warnings, name+" is an editor and waits until you quit it")
+		case name == "less" && !slices.ContainsFunc(args, func(arg string) bool {
+			return arg == "--quit-if-one-screen" || strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "F")
+		}):
+			warnings = append(warnings, "less without -F waits until you quit it")
+		case name == "more" || name == "most":
+			warnings = append(warnings, name+" is a pager and waits for you to page through")
+		case (name == "top" || name == "htop" || name == "btop") && !slices.Contains(args, "-b") && !slices.Contains(args, "-n"):
+			warnings = append(warnings, name+" runs until you quit it")
+		case name == "watch":
+			warnings = append(warnings, "watch runs until it is stopped")
+		case slices.Contains(repls, name) && len(args) == 0:
+			warnings = append(warnings, name+" with no script starts an interactive session")
+		case name == "git" && len(args) > 0 && args[0] == "commit" && !slices.ContainsFunc(args[1:], func(arg string) bool {
+			return arg == "--no-edit" || strings.HasPrefix(arg, "--message") || strings.HasPrefix(arg, "--file") || strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.ContainsAny(arg, "mFC")
+		}):
+			warnings = append(warnings, "git commit without -m opens an editor for the message")
+		case name == "git" && len(args) > 0 && args[0] == "rebase" && slices.ContainsFunc(args, func(arg string) bool { return arg == "-i" || arg == "--interactive" }):
+			warnings = append(warnings, "git rebase -i opens an editor")
+		}
+	}
+	return warnings
+}
+
+// interactiveBanner is the approval prompt's warning about commands that
+// would wait for input.
+func interactiveBanner(warnings []string, terminal bool) string {
+	if len(warnings) == 0 {
+		return ""
+	}
+	consequence := "it has no terminal, so it may hang or fail."
+	if terminal {
+		consequence = "it runs in your terminal, so you will have to answer it."
+	}
+	return "⌨️  This command may wait for input, and " + consequence + "\n   " + strings.Join(warnings, "\n   ") + "\n"
+}
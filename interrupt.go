@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Ctrl+C is handled in two stages. The first press interrupts whatever shai
+// is waiting on: a model call is cancelled so the user can give guidance, and
+// a running command is killed. A press while nothing can be interrupted,
+// which is usually the second one, or a SIGTERM saves the session and exits
+// with a hint for resuming it.
+//
+// The signal arrives on its own goroutine while the agent keeps running, so
+// the session saved on exit is the agent's last checkpoint: a copy it takes
+// of its own state at the start of each step and whenever a message is added.
+
+var interrupts struct {
+	mu         sync.Mutex
+	once       sync.Once
+	agent      *Agent
+	checkpoint *Agent
+	handlers   map[int]func()
+	next       int
+}
+
+// trapInterrupts takes over SIGINT and SIGTERM for a top-level agent. It can
+// be called again to save a different agent on exit.
+func trapInterrupts(agent *Agent) {
+	interrupts.mu.Lock()
+	interrupts.agent = agent
+	interrupts.checkpoint = nil
+	interrupts.mu.Unlock()
+	agent.checkpoint()
+
+	interrupts.once.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			for sig := range signals {
+				interrupts.mu.Lock()
+				handlers := interrupts.handlers
+				interrupts.handlers = nil
+				interrupts.mu.Unlock()
+
+				if sig == os.Interrupt && len(handlers) > 0 {
+					for _, interrupt := range handlers {
+						interrupt()
+					}
+					continue
+				}
+				exitInterrupted(sig)
+			}
+		}()
+	})
+}
+
+// onInterrupt makes interrupt the first press's action until stop is called.
+// stop reports whether it was pressed.
+func onInterrupt(interrupt func()) (stop func() bool) {
+	interrupts.mu.Lock()
+	defer interrupts.mu.Unlock()
+	if interrupts.handlers == nil {
+		interrupts.handlers = map[int]func(){}
+	}
+	interrupts.next++
+	id := interrupts.next
+	interrupts.handlers[id] = interrupt
+	return func() bool {
+		interrupts.mu.Lock()
+		defer interrupts.mu.Unlock()
+		_, waiting := interrupts.handlers[id]
+		delete(interrupts.handlers, id)
+		return !waiting
+	}
+}
+
+// checkpoint records a copy of the agent's state for exitInterrupted, if it
+// is the agent saved on exit. Only the agent's own goroutine may call it.
+func (a *Agent) checkpoint() {
+	interrupts.mu.Lock()
+	defer interrupts.mu.Unlock()
+	if interrupts.agent != a {
+		return
+	}
+	snapshot := *a
+	snapshot.Messages = slices.Clone(a.Messages)
+	snapshot.Events = slices.Clone(a.Events)
+	snapshot.Timings = slices.Clone(a.Timings)
+	snapshot.Plan = slices.Clone(a.Plan)
+	snapshot.Steps = slices.Clone(a.Steps)
+	snapshot.Changes = slices.Clone(a.Changes)
+	snapshot.attempts = slices.Clone(a.attempts)
+	snapshot.usage = a.usage.clone()
+	snapshot.usageAtStart = a.usageAtStart.clone()
+	interrupts.checkpoint = &snapshot
+}
+
+// exitInterrupted saves the last checkpoint of the running agent and exits.
+func exitInterrupted(sig os.Signal) {
+	interrupts.mu.Lock()
+	agent := interrupts.checkpoint
+	interrupts.mu.Unlock()
+
+	what, code := "Interrupted", 130
+	if sig != os.Interrupt {
+		what, code = "Terminated", 143
+	}
+	fmt.Printf("\n🛑 %s; saving the session and exiting.\n", what)
+	if agent != nil && agent.SessionID != "" {
+		agent.saveSession(sessionRunning)
+		agent.hook("stopped", HookPayload{Status: strings.ToUpper(what), Summary: what + " by a signal."})
+		agent.releaseSession()
+		fmt.Printf("⏯️  Resume with: shai resume %s\n", agent.SessionID)
+	}
+	os.Exit(code)
+}
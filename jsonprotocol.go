@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// With "protocol": "json" the model answers with a JSON object naming the
+// action instead of a leading keyword. Providers that support it constrain
+// generation to actionSchema, which all but rules out unparseable replies;
+// replies that are not JSON anyway still go through the text parser.
+
+const jsonProtocolPromptSection = `
+OUTPUT FORMAT:
+Respond with a single JSON object and nothing else: {"action": "<action>", "input": "<text>"}. The action is one of the keywords described above in lowercase (for example "run", "ask", "task_complete", "task_stopped", "write_file"), and input is exactly what you would have written after the keyword, including any further lines. Example: {"action": "run", "input": "ls -la"}
+`
+
+// protocolActions are the actions the JSON schema allows.
+var protocolActions = []string{
+	"run", "run_script", "ask", "task_complete", "task_stopped", "plan_done",
+	"install", "spawn", "sql", "mcp",
+	"read_file", "write_file", "ls", "stat", "cat", "head",
+	"search_files", "pick_file", "screenshot", "view_image",
+}
+
+// structuredOutput is implemented by providers that can constrain a
+// response to a JSON schema.
+type structuredOutput interface {
+	withFormat(body any, schema any) any
+}
+
+func actionSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"action": map[string]any{"type": "string", "enum": protocolActions},
+			"input":  map[string]any{"type": "string"},
+		},
+		"required":             []string{"action", "input"},
+		"additionalProperties": false,
+	}
+}
+
+// formatFor returns the schema to constrain model's responses to, or nil.
+func formatFor() any {
+	if cfg.Protocol != "json" {
+		return nil
+	}
+	return actionSchema()
+}
+
+func jsonProtocolPromptSectionText() string {
+	if cfg.Protocol != "json" {
+		return ""
+	}
+	return jsonProtocolPromptSection
+}
+
+// parseJSONAction reads an action from a JSON reply, tolerating a code
+// fence around it and the common alternatives to "input".
+func parseJSONAction(output string) (action string, content string, ok bool) {
+	output = strings.TrimSpace(output)
+	output = strings.TrimPrefix(output, "```json")
+	output = strings.Trim(strings.TrimSpace(output), "`")
+	if !strings.HasPrefix(output, "{") {
+		return "", "", false
+	}
+	var reply map[string]any
+	if err := json.Unmarshal([]byte(output), &reply); err != nil {
+		return "", "", false
+	}
+	name, _ := reply["action"].(string)
+	if name == "" {
+		return "", "", false
+	}
+	for _, key := range []string{"input", "command", "question", "summary", "content"} {
+		if value, found := reply[key].(string); found {
+			content = value
+			break
+		}
+	}
+	return strings.ToUpper(strings.TrimSpace(name)), strings.TrimSpace(content), true
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type kubeContext struct {
+	Name      string
+	Namespace string
+}
+
+var kubeCommandPattern = regexp.MustCompile(`(^|[\s;&|(])(\S*/)?(kubectl|helm)(\s|$)`)
+var kubeContextFlagPattern = regexp.MustCompile(`--(?:kube-)?context(?:=|\s+)("[^"]*"|'[^']*'|\S+)`)
+var kubeNamespaceFlagPattern = regexp.MustCompile(`(?:--namespace|-n)(?:=|\s+)("[^"]*"|'[^']*'|\S+)`)
+var kubeconfigFlagPattern = regexp.MustCompile(`--kubeconfig(?:=|\s+)("[^"]*"|'[^']*'|\S+)`)
+var kubeUseContextPattern = regexp.MustCompile(`\bconfig\s+use-context\s+("[^"]*"|'[^']*'|\S+)`)
+
+var (
+	sessionKubeTrustMu sync.Mutex
+	sessionKubeTrust   = map[string]bool{}
+)
+
+// detectKubeContext asks kubectl for the active context and namespace of a
+// kubeconfig ("" for the default), looking it up in an agent's directory and
+// environment (nil for shai's own). It returns false when kubectl is missing
+// or no context is configured.
+func detectKubeContext(state *shellState, kubeconfig string) (kubeContext, bool) {
+	if _, err := exec.LookPath("kubectl"); err != nil {
+		return kubeContext{}, false
+	}
+
+	name, err := kubectlOutput(state, kubeconfig, "config", "current-context")
+	if err != nil || name == "" {
+		return kubeContext{}, false
+	}
+	return kubeContext{Name: name, Namespace: kubeNamespace(state, kubeconfig, "")}, true
+}
+
+// kubeNamespace returns the namespace of a context ("" for the current one).
+func kubeNamespace(state *shellState, kubeconfig string, name string) string {
+	args := []string{"config", "view", "--minify", "-o", "jsonpath={..namespace}"}
+	if name != "" {
+		args = append(args, "--context", name)
+	}
+	namespace, err := kubectlOutput(state, kubeconfig, args...)
+	if err != nil || namespace == "" {
+		return "default"
+	}
+	return namespace
+}
+
+func kubectlOutput(state *shellState, kubeconfig string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if kubeconfig != "" {
+		args = append([]string{"--kubeconfig", kubeconfig}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	if state != nil {
+		cmd.Dir = state.workDir()
+		cmd.Env = commandEnv(state.environ())
+	}
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func kubeEnvironmentLine() string {
+	if !cfg.KubeGuard {
+		return ""
+	}
+	kctx, ok := detectKubeContext(nil, "")
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("\nKubernetes Context: %s (namespace: %s)", kctx.Name, kctx.Namespace)
+}
+
+func isKubeCommand(command string) bool {
+	return kubeCommandPattern.MatchString(command)
+}
+
+// kubeTargets works out the contexts a command acts on, in order. kubectl
+// and helm use the current context of the kubeconfig unless given
+// --context/--kube-context, and the kubeconfig is switched by a KUBECONFIG
+// assignment or --kubeconfig; "kubectl config use-context" switches the
+// context of everything after it, so the context it selects is a target too.
+func kubeTargets(command string, state *shellState) []kubeContext {
+	var targets []kubeContext
+	kubeconfig := ""
+	current := map[string]kubeContext{} // by kubeconfig
+	currentContext := func(kubeconfig string) (kubeContext, bool) {
+		if kctx, ok := current[kubeconfig]; ok {
+			return kctx, kctx.Name != ""
+		}
+		kctx, ok := detectKubeContext(state, kubeconfig)
+		current[kubeconfig] = kctx
+		return kctx, ok
+	}
+
+	for _, words := range splitPipeline(command) {
+		stageConfig := kubeconfig
+		i := 0
+		for ; i < len(words) && (words[i] == "env" || words[i] == "exec" || strings.Contains(words[i], "=")); i++ {
+			if value, ok := strings.CutPrefix(words[i], "KUBECONFIG="); ok {
+				stageConfig = value
+			}
+		}
+		switch {
+		case i == len(words):
+			// An assignment on its own sets the variable for what follows.
+			kubeconfig = stageConfig
+			continue
+		case words[i] == "export":
+			for _, word := range words[i+1:] {
+				if value, ok := strings.CutPrefix(word, "KUBECONFIG="); ok {
+					kubeconfig = value
+				}
+			}
+			continue
+		}
+		args := words[i:]
+		if name := filepath.Base(args[0]); name != "kubectl" && name != "helm" {
+			continue
+		}
+
+		line := strings.Join(args, " ")
+		if m := kubeconfigFlagPattern.FindStringSubmatch(line); m != nil {
+			stageConfig = strings.Trim(m[1], `"'`)
+		}
+		var target kubeContext
+		if m := kubeContextFlagPattern.FindStringSubmatch(line); m != nil {
+			target.Name = strings.Trim(m[1], `"'`)
+		} else if kctx, ok := currentContext(stageConfig); ok {
+			target = kctx
+		}
+		if m := kubeUseContextPattern.FindStringSubmatch(line); m != nil {
+			target = kubeContext{Name: strings.Trim(m[1], `"'`)}
+			current[stageConfig] = kubeContext{Name: target.Name, Namespace: kubeNamespace(state, stageConfig, target.Name)}
+		}
+		if target.Name == "" {
+			continue
+		}
+		if m := kubeNamespaceFlagPattern.FindStringSubmatch(line); m != nil {
+			target.Namespace = strings.Trim(m[1], `"'`)
+		} else if target.Namespace == "" {
+			target.Namespace = kubeNamespace(state, stageConfig, target.Name)
+		}
+		if !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// trustKubeContext trusts a context for the rest of the process. The
+// configuration is shared by parallel agents and never changed after it is
+// loaded, so contexts trusted "always" are also kept here.
+func trustKubeContext(name string) {
+	sessionKubeTrustMu.Lock()
+	defer sessionKubeTrustMu.Unlock()
+	sessionKubeTrust[name] = true
+}
+
+func isKubeContextTrusted(name string) bool {
+	if slices.Contains(cfg.TrustedKubeContexts, name) {
+		return true
+	}
+	sessionKubeTrustMu.Lock()
+	defer sessionKubeTrustMu.Unlock()
+	return sessionKubeTrust[name]
+}
+
+// kubeGuard checks kubectl/helm commands against the set of trusted contexts,
+// asking the user to trust unknown contexts explicitly. Contexts are looked
+// up in the agent's directory and environment. It returns a banner to show
+// above the approval prompt and whether the command may proceed.
+func (a *Agent) kubeGuard(command string) (banner string, allowed bool) {
+	if !cfg.KubeGuard || !isKubeCommand(command) {
+		return "", true
+	}
+
+	targets := kubeTargets(command, &a.shell)
+	for _, target := range targets {
+		banner += fmt.Sprintf("☸️  Kubernetes context: %s (namespace: %s)\n", target.Name, target.Namespace)
+	}
+	for _, target := range targets {
+		if !isKubeContextTrusted(target.Name) && !a.askKubeTrust(banner, target.Name) {
+			return banner, false
+		}
+	}
+	return banner, true
+}
+
+// askKubeTrust asks the user whether to trust a context.
+func (a *Agent) askKubeTrust(banner string, name string) bool {
+	if cfg.NonInteractive {
+		a.printf("\n%s⚠️  shai has not been trusted to run commands against context %q, and cannot ask in non-interactive mode.\n", banner, name)
+		return false
+	}
+
+	input := a.readAnswer(fmt.Sprintf("\n%s⚠️  shai has not been trusted to run commands against context %q.\nTrust context %q? [ (y)es for this session / (a)lways / (N)o ]: ", banner, name, name))
+	input = strings.TrimSpace(strings.ToLower(input))
+
+	switch {
+	case strings.HasPrefix(input, "a"):
+		trustKubeContext(name)
+		if err := addToConfigList("trusted_kube_contexts", name); err != nil {
+			a.printf("⚠️ Failed to save trusted context: %v\n", err)
+		}
+		return true
+	case strings.HasPrefix(input, "y"):
+		trustKubeContext(name)
+		return true
+	default:
+		return false
+	}
+}
+
+// kubeTrusted is kubeGuard for a command whose banner is not needed, such as
+// one the user edited at the approval prompt.
+func (a *Agent) kubeTrusted(command string) bool {
+	_, allowed := a.kubeGuard(command)
+	return allowed
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Resource limits keep a runaway find or compile from taking down the
+// machine. On Unix, POSIX shells set them with ulimit and renice before the
+// command, so they bind the shell and everything it starts; on Windows the
+// command's process joins a Job Object. The output limit is enforced by shai
+// itself, which stops the command once it has written that much.
+
+// LimitsConfig bounds each command. Zero means no limit. CPUSeconds and
+// MemoryMB apply to each process the command starts; Nice lowers the
+// command's scheduling priority (1 to 19).
+type LimitsConfig struct {
+	CPUSeconds int `json:"cpu_seconds"`
+	MemoryMB   int `json:"memory_mb"`
+	OutputMB   int `json:"output_mb"`
+	Nice       int `json:"nice"`
+}
+
+// stopOutputLimit is the status of a command stopped for writing more than
+// limits.output_mb.
+const stopOutputLimit = "OUTPUT_LIMIT"
+
+// limitFailure matches what processes and shells print when a process runs
+// into the CPU or memory limit, and the exit statuses of SIGKILL and SIGXCPU.
+var limitFailure = regexp.MustCompile(`(?i)cpu time limit exceeded|cannot allocate memory|out of memory|memoryerror|bad_alloc|fatal error: runtime: cannot allocate|exit status 1(37|52)|\bkilled\b`)
+
+func checkLimits() error {
+	l := cfg.Limits
+	if l.CPUSeconds < 0 || l.MemoryMB < 0 || l.OutputMB < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if l.Nice < 0 || l.Nice > 19 {
+		return fmt.Errorf("limits.nice must be between 0 and 19, not %d", l.Nice)
+	}
+	return nil
+}
+
+// outputLimit is the most output, in bytes, a command may write; 0 for no
+// limit.
+func outputLimit() int {
+	return cfg.Limits.OutputMB << 20
+}
+
+// withLimits prefixes a command for a POSIX shell with the ulimit and renice
+// calls that apply the CPU, memory and priority limits. Limits the system
+// does not support are skipped.
+func withLimits(command string, shellPath string) string {
+	l := cfg.Limits
+	if !tracksShellState(shellPath) || (l.CPUSeconds == 0 && l.MemoryMB == 0 && l.Nice == 0) {
+		return command
+	}
+	var prefix strings.Builder
+	if l.CPUSeconds > 0 {
+		fmt.Fprintf(&prefix, "ulimit -t %d 2>/dev/null\n", l.CPUSeconds)
+	}
+	if l.MemoryMB > 0 {
+		fmt.Fprintf(&prefix, "ulimit -v %d 2>/dev/null\n", l.MemoryMB<<10)
+	}
+	if l.Nice > 0 {
+		fmt.Fprintf(&prefix, "renice -n %d -p $$ >/dev/null 2>&1\n", l.Nice)
+	}
+	return prefix.String() + command
+}
+
+// describeLimits lists the CPU and memory limits for a hint, or returns ""
+// without any.
+func describeLimits() string {
+	var limits []string
+	if cfg.Limits.CPUSeconds > 0 {
+		limits = append(limits, fmt.Sprintf("%ds of CPU time", cfg.Limits.CPUSeconds))
+	}
+	if cfg.Limits.MemoryMB > 0 {
+		limits = append(limits, fmt.Sprintf("%d MB of memory", cfg.Limits.MemoryMB))
+	}
+	return strings.Join(limits, " and ")
+}
+
+// limitHint explains a failure that looks like the command ran into the CPU
+// or memory limit, or returns "".
+func limitHint(status string, output string) string {
+	limits := describeLimits()
+	if limits == "" || !limitFailure.MatchString(status+"\n"+output) {
+		return ""
+	}
+	return fmt.Sprintf("The command probably ran into the resource limits: each process may use at most %s. Narrow the command (a smaller directory, fewer files, a lighter build) instead of retrying it as is.", limits)
+}
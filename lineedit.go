@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// Answers typed at shai's prompts get basic line editing on Unix terminals:
+// arrow keys, Home/End, the usual Emacs control keys and a history of
+// earlier answers. A line consisting of \e opens $VISUAL or $EDITOR for a
+// longer, multi-line answer.
+
+const editorEscape = `\e`
+
+// inputHistory holds the answers given so far in this process.
+var inputHistory []string
+
+// readLine reads one answer from reader. The error is io.EOF at the end of
+// input.
+func readLine(reader *bufio.Reader) (string, error) {
+	var line string
+	var err error
+	if lineEditingAvailable() {
+		line, err = editLine(reader)
+	} else {
+		line, err = reader.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+	}
+	line = strings.TrimSpace(line)
+	if err == nil && line == editorEscape {
+		line, err = readLongAnswer(reader)
+	}
+	if line != "" && (len(inputHistory) == 0 || inputHistory[len(inputHistory)-1] != line) {
+		inputHistory = append(inputHistory, line)
+	}
+	return line, err
+}
+
+// readLongAnswer reads a multi-line answer in the user's editor, or up to a
+// line with a single "." when no editor is configured.
+func readLongAnswer(reader *bufio.Reader) (string, error) {
+	if editor := editorCommand(); editor != "" {
+		text, err := runEditor(editor, "", "shai-answer-*.md")
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("📝 Answer from the editor (%d lines)\n", strings.Count(strings.TrimSpace(text), "\n")+1)
+		return strings.TrimSpace(text), nil
+	}
+	fmt.Println("Type your answer; end it with a line containing only \".\":")
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "." || (err != nil && line == "") {
+			return strings.TrimSpace(strings.Join(lines, "\n")), nil
+		}
+		lines = append(lines, line)
+		if err != nil {
+			return strings.TrimSpace(strings.Join(lines, "\n")), nil
+		}
+	}
+}
+
+func lineEditingAvailable() bool {
+	return runtime.GOOS != "windows" && isTerminal(os.Stdin) && isTerminal(os.Stdout)
+}
+
+// stty runs stty on the terminal and returns its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// editLine reads a line with the terminal in non-canonical mode, echoing
+// and editing it itself.
+func editLine(reader *bufio.Reader) (string, error) {
+	saved, err := stty("-g")
+	if err != nil {
+		line, err := reader.ReadString('\n')
+		return line, err
+	}
+	if _, err := stty("-icanon", "-echo", "-isig", "-ixon", "min", "1"); err != nil {
+		line, err := reader.ReadString('\n')
+		return line, err
+	}
+	defer stty(saved)
+
+	e := lineEdit{history: len(inputHistory)}
+	for {
+		r, _, err := reader.ReadRune()
+		if err != nil {
+			fmt.Println()
+			return string(e.buf), err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Println()
+			return string(e.buf), nil
+		case 3: // Ctrl+C
+			fmt.Println("^C")
+			stty(saved)
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(os.Interrupt)
+			}
+			return "", nil
+		case 4: // Ctrl+D
+			if len(e.buf) == 0 {
+				fmt.Println()
+				return "", io.EOF
+			}
+			e.delete()
+		case 127, 8:
+			e.backspace()
+		case 1:
+			e.move(-e.pos)
+		case 5:
+			e.move(len(e.buf) - e.pos)
+		case 2:
+			e.move(-1)
+		case 6:
+			e.move(1)
+		case 11:
+			e.replace(e.buf[:e.pos], e.pos)
+		case 21:
+			e.replace(e.buf[e.pos:], 0)
+		case 23:
+			e.deleteWord()
+		case 16:
+			e.recall(-1)
+		case 14:
+			e.recall(1)
+		case 27:
+			e.escape(reader)
+		default:
+			if unicode.IsPrint(r) {
+				e.insert(r)
+			}
+		}
+	}
+}
+
+// lineEdit is the state of the line being edited. The cursor is kept in
+// step with pos by moving it relative to where it is.
+type lineEdit struct {
+	buf     []rune
+	pos     int
+	history int
+	draft   []rune
+}
+
+// escape handles the arrow, Home, End and Delete key sequences.
+func (e *lineEdit) escape(reader *bufio.Reader) {
+	kind, _, err := reader.ReadRune()
+	if err != nil || (kind != '[' && kind != 'O') {
+		return
+	}
+	key, _, err := reader.ReadRune()
+	if err != nil {
+		return
+	}
+	for key >= '0' && key <= '9' {
+		next, _, err := reader.ReadRune()
+		if err != nil {
+			return
+		}
+		if next == '~' {
+			switch key {
+			case '3':
+				e.delete()
+			case '1', '7':
+				e.move(-e.pos)
+			case '4', '8':
+				e.move(len(e.buf) - e.pos)
+			}
+			return
+		}
+		key = next
+	}
+	switch key {
+	case 'A':
+		e.recall(-1)
+	case 'B':
+		e.recall(1)
+	case 'C':
+		e.move(1)
+	case 'D':
+		e.move(-1)
+	case 'H':
+		e.move(-e.pos)
+	case 'F':
+		e.move(len(e.buf) - e.pos)
+	}
+}
+
+func (e *lineEdit) move(delta int) {
+	target := min(max(e.pos+delta, 0), len(e.buf))
+	if target < e.pos {
+		fmt.Printf("\033[%dD", e.pos-target)
+	} else if target > e.pos {
+		fmt.Printf("\033[%dC", target-e.pos)
+	}
+	e.pos = target
+}
+
+// replace sets the line to buf with the cursor at pos and redraws it.
+func (e *lineEdit) replace(buf []rune, pos int) {
+	e.move(-e.pos)
+	e.buf = append([]rune(nil), buf...)
+	fmt.Print(string(e.buf) + "\033[K")
+	e.pos = len(e.buf)
+	e.move(pos - e.pos)
+}
+
+func (e *lineEdit) insert(r rune) {
+	if e.pos == len(e.buf) {
+		e.buf = append(e.buf, r)
+		e.pos++
+		fmt.Print(string(r))
+		return
+	}
+	buf := append(append(append([]rune(nil), e.buf[:e.pos]...), r), e.buf[e.pos:]...)
+	e.replace(buf, e.pos+1)
+}
+
+func (e *lineEdit) backspace() {
+	if e.pos > 0 {
+		buf := append(append([]rune(nil), e.buf[:e.pos-1]...), e.buf[e.pos:]...)
+		e.replace(buf, e.pos-1)
+	}
+}
+
+func (e *lineEdit) delete() {
+	if e.pos < len(e.buf) {
+		buf := append(append([]rune(nil), e.buf[:e.pos]...), e.buf[e.pos+1:]...)
+		e.replace(buf, e.pos)
+	}
+}
+
+func (e *lineEdit) deleteWord() {
+	start := e.pos
+	for start > 0 && e.buf[start-1] == ' ' {
+		start--
+	}
+	for start > 0 && e.buf[start-1] != ' ' {
+		start--
+	}
+	buf := append(append([]rune(nil), e.buf[:start]...), e.buf[e.pos:]...)
+	e.replace(buf, start)
+}
+
+// recall steps through the history; stepping past the newest entry
+// restores what was being typed.
+func (e *lineEdit) recall(delta int) {
+	target := e.history + delta
+	if target < 0 || target > len(inputHistory) {
+		return
+	}
+	if e.history == len(inputHistory) {
+		e.draft = append([]rune(nil), e.buf...)
+	}
+	e.history = target
+	if target == len(inputHistory) {
+		e.replace(e.draft, len(e.draft))
+		return
+	}
+	entry := []rune(inputHistory[target])
+	e.replace(entry, len(entry))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// LintConfig controls the checks run on commands before they are approved.
+// shellcheck is used when it is installed, and a few built-in checks
+// otherwise. With Feedback, the findings are first sent back to the model
+// to fix; a command it repeats unchanged goes to the approval prompt with
+// the findings shown.
+type LintConfig struct {
+	Enabled  bool `json:"enabled"`
+	Feedback bool `json:"feedback"`
+}
+
+// lintDialect is the shellcheck dialect of a shell, or "" for shells it
+// cannot check.
+func lintDialect(shellPath string) string {
+	switch filepath.Base(shellPath) {
+	case "sh", "dash", "ash", "busybox":
+		return "sh"
+	case "bash":
+		return "bash"
+	case "ksh", "mksh":
+		return "ksh"
+	}
+	return ""
+}
+
+// lintCommand returns the problems found in a command, one per line.
+func lintCommand(command string, shellPath string) []string {
+	dialect := lintDialect(shellPath)
+	if !cfg.Lint.Enabled || dialect == "" {
+		return nil
+	}
+	if path, err := exec.LookPath("shellcheck"); err == nil {
+		return shellcheck(path, dialect, command)
+	}
+	return builtinLint(command)
+}
+
+// shellcheckLine is one finding in shellcheck's gcc format:
+// "-:1:5: warning: Double quote to prevent globbing ... [SC2086]".
+var shellcheckLine = regexp.MustCompile(`^-:\d+:\d+: (\w+): (.*)$`)
+
+func shellcheck(path string, dialect string, command string) []string {
+	cmd := exec.Command(path, "--shell="+dialect, "--format=gcc", "-")
+	cmd.Stdin = strings.NewReader(command)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	// shellcheck exits 1 when it finds anything.
+	cmd.Run()
+	var findings []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if match := shellcheckLine.FindStringSubmatch(line); match != nil {
+			findings = append(findings, fmt.Sprintf("%s: %s", match[1], match[2]))
+		}
+	}
+	return findings
+}
+
+// builtinChecks are a few of shellcheck's checks, for when it is not
+// installed. They look at the command with quoted strings removed.
+var builtinChecks = []struct {
+	pattern *regexp.Regexp
+	finding string
+}{
+	{regexp.MustCompile(`\brm\s[^|;&]*\$\{?\w+\}?/\*?(\s|$)`), `warning: Use "${var:?}" to ensure this never expands to /* [SC2115]`},
+	{regexp.MustCompile(`\brm\s+(-\w+\s+)*(/\*|\*|~/\*|\.\*)(\s|$)`), `warning: This glob removes everything in the directory; name what to delete instead`},
+	{regexp.MustCompile(`(^|[\s;&|(])\$\{?[A-Za-z_]\w*\}?`), `info: Double quote variables to prevent globbing and word splitting [SC2086]`},
+	{regexp.MustCompile(`\bcat\s+[^\s|;&<>-][^|;&<>]*\|\s*(grep|awk|sed|head|tail|wc|sort|cut|tr)\b`), `style: Useless cat. Consider 'cmd < file | ..' or 'cmd file | ..' instead [SC2002]`},
+	{regexp.MustCompile("`"), "style: Use $(...) notation instead of legacy backticks [SC2006]"},
+	{regexp.MustCompile(`\bfor\s+\w+\s+in\s+\$\(\s*ls\b`), `warning: Iterating over ls output is fragile. Use globs [SC2045]`},
+}
+
+var quotedString = regexp.MustCompile(`'[^']*'|"(\\.|[^"\\])*"`)
+
+func builtinLint(command string) []string {
+	unquoted := quotedString.ReplaceAllString(command, "''")
+	var findings []string
+	for _, check := range builtinChecks {
+		if check.pattern.MatchString(unquoted) {
+			findings = append(findings, check.finding)
+		}
+	}
+	return findings
+}
+
+// lintBanner shows the findings above the approval prompt.
+func lintBanner(findings []string) string {
+	if len(findings) == 0 {
+		return ""
+	}
+	return "🧹 Lint:\n   " + strings.Join(findings, "\n   ") + "\n"
+}
+
+// bounceLint reports whether a command's findings should go back to the
+// model instead of to the approval prompt: only once per command, so a
+// command the model stands by is shown to the user.
+func (a *Agent) bounceLint(command string, findings []string) bool {
+	if !cfg.Lint.Feedback || len(findings) == 0 || command == a.linted {
+		return false
+	}
+	a.linted = command
+	return true
+}
+
+func lintFeedback(findings []string) string {
+	return fmt.Sprintf("The command was not run. A linter found these problems:\n%s\nFix them and propose the corrected command, or repeat the command unchanged to have it reviewed as it is.", strings.Join(findings, "\n"))
+}
@@ -0,0 +1,27 @@
+package main
+
+import "strings"
+
+// LlamaCppConfig configures the experimental in-process provider
+// ("provider": "llamacpp"), which runs a GGUF model inside shai itself.
+// It is only available in binaries built with -tags llamacpp.
+type LlamaCppConfig struct {
+	ModelPath   string `json:"model_path"`
+	ContextSize int    `json:"context_size"`
+	GPULayers   int    `json:"gpu_layers"`
+	Threads     int    `json:"threads"`
+}
+
+const defaultLlamaCppContextSize = 8192
+
+const llamaCppDefaultMaxTokens = 1024
+
+// llamaCppModelPath resolves the model to load: a model name ending in .gguf
+// (for example a router or planner model) is used as a path, anything else
+// means the configured model_path.
+func llamaCppModelPath(model string) string {
+	if strings.HasSuffix(strings.ToLower(model), ".gguf") {
+		return model
+	}
+	return cfg.LlamaCpp.ModelPath
+}
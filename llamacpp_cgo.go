@@ -0,0 +1,114 @@
+//go:build llamacpp
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	llama "github.com/go-skynet/go-llama.cpp"
+)
+
+const llamaCppAvailable = true
+
+// llamaCppMu serializes inference: a loaded model holds a single context
+// and sub-agents may call the model concurrently.
+var (
+	llamaCppMu     sync.Mutex
+	llamaCppModels = map[string]*llama.LLama{}
+)
+
+func loadLlamaCppModel(path string) (*llama.LLama, error) {
+	if model, ok := llamaCppModels[path]; ok {
+		return model, nil
+	}
+	if path == "" {
+		return nil, fmt.Errorf("llamacpp.model_path is not set")
+	}
+
+	contextSize := cfg.LlamaCpp.ContextSize
+	if contextSize <= 0 {
+		contextSize = defaultLlamaCppContextSize
+	}
+	fmt.Printf("📦 Loading %s...\n", path)
+	model, err := llama.New(path,
+		llama.SetContext(contextSize),
+		llama.SetGPULayers(cfg.LlamaCpp.GPULayers),
+		llama.EnableF16Memory,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	llamaCppModels[path] = model
+	return model, nil
+}
+
+// completeLlamaCpp runs the conversation through an in-process model. The
+// prompt is flattened with prompt_format, as for the raw /generate
+// providers. Inference cannot be interrupted once started, so ctx is only
+// checked before it begins.
+func completeLlamaCpp(ctx context.Context, model string, messages []Message, options map[string]any, onToken func(string)) (ChatResponse, error) {
+	llamaCppMu.Lock()
+	defer llamaCppMu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return ChatResponse{}, err
+	}
+	path := llamaCppModelPath(model)
+	loaded, err := loadLlamaCppModel(path)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+
+	format, ok := promptFormats[cfg.PromptFormat]
+	if !ok {
+		format = promptFormats["chatml"]
+	}
+
+	threads := cfg.LlamaCpp.Threads
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	maxTokens := llamaCppDefaultMaxTokens
+	if v, ok := optionInt(options, "num_predict"); ok {
+		maxTokens = v
+	}
+	predictOptions := []llama.PredictOption{
+		llama.SetThreads(threads),
+		llama.SetTokens(maxTokens),
+		llama.SetStopWords(format.stop...),
+	}
+	if v, ok := optionFloat(options, "temperature"); ok {
+		predictOptions = append(predictOptions, llama.SetTemperature(float32(v)))
+	}
+	if v, ok := optionFloat(options, "top_p"); ok {
+		predictOptions = append(predictOptions, llama.SetTopP(float32(v)))
+	}
+	if v, ok := optionInt(options, "seed"); ok {
+		predictOptions = append(predictOptions, llama.SetSeed(v))
+	}
+	if onToken != nil {
+		predictOptions = append(predictOptions, llama.SetTokenCallback(func(token string) bool {
+			onToken(token)
+			return true
+		}))
+	}
+
+	prompt := buildPrompt(format, messages)
+	text, err := loaded.Predict(prompt, predictOptions...)
+	if err != nil {
+		return ChatResponse{}, fmt.Errorf("llama.cpp inference failed: %w", err)
+	}
+
+	// The bindings do not report token counts, so estimate them.
+	result := ChatResponse{
+		Model:           path,
+		Done:            true,
+		PromptEvalCount: estimateTokens(prompt),
+		EvalCount:       estimateTokens(text),
+	}
+	result.Message = Message{Role: "assistant", Content: text}
+	return result, nil
+}
@@ -0,0 +1,14 @@
+//go:build !llamacpp
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+const llamaCppAvailable = false
+
+func completeLlamaCpp(ctx context.Context, model string, messages []Message, options map[string]any, onToken func(string)) (ChatResponse, error) {
+	return ChatResponse{}, fmt.Errorf("this shai binary was built without llama.cpp support; rebuild with -tags llamacpp (see README) or use another provider")
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// provider adapts the conversation to one model API. Providers only deal
+// with the wire format; rate limiting, retries and error reporting are
+// shared by callModelContext.
+type provider interface {
+	// name is used in error messages.
+	name() string
+	// request returns the URL and JSON body for a completion request. The
+	// first message is always the system prompt.
+	request(model string, messages []Message, options map[string]any, stream bool) (url string, body any)
+	// parse decodes a successful response. For streamed requests onToken is
+	// non-nil and receives the text as it arrives; the returned response
+	// still holds the whole message.
+	parse(body io.Reader, onToken func(string)) (ChatResponse, error)
+	// authorize adds authentication headers, if the API needs them.
+	authorize(req *http.Request)
+}
+
+func currentProvider() (provider, error) {
+	switch cfg.Provider {
+	case "", "ollama":
+		return ollamaChatProvider{}, nil
+	case "ollama-generate":
+		return ollamaGenerateProvider{}, nil
+	case "openai":
+		return openAIProvider{}, nil
+	case "tgi":
+		return tgiChatProvider{}, nil
+	case "tgi-generate":
+		return tgiGenerateProvider{}, nil
+	case "anthropic":
+		return anthropicProvider{}, nil
+	case "azure":
+		return azureProvider{}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+	}
+}
+
+// usesOllama reports whether the configured provider talks to Ollama, whose
+// extras (keep_alive, warm-up, model management) other servers lack.
+func usesOllama() bool {
+	return cfg.Provider == "" || strings.HasPrefix(cfg.Provider, "ollama")
+}
+
+// modelAPIURL is the URL of the configured model API: ollama_url for Ollama
+// and api_url for every other provider, with Anthropic's public API and
+// AZURE_OPENAI_ENDPOINT standing in for the default api_url. For the
+// in-process llamacpp provider it is the model file.
+func modelAPIURL() string {
+	switch {
+	case usesOllama():
+		return ollamaURL()
+	case cfg.Provider == "llamacpp":
+		return cfg.LlamaCpp.ModelPath
+	case cfg.Provider == "anthropic" && (cfg.APIURL == "" || cfg.APIURL == defaultAPIURL):
+		return anthropicDefaultURL
+	case cfg.Provider == "azure" && (cfg.APIURL == "" || cfg.APIURL == defaultAPIURL):
+		return os.Getenv("AZURE_OPENAI_ENDPOINT")
+	}
+	return cfg.APIURL
+}
+
+// noticeKey is the context key of the function that shows the notices of
+// model calls, such as retries.
+type noticeKey struct{}
+
+// withNotices makes the model calls made with ctx show their notices through
+// printf, such as an agent's, instead of on the console.
+func withNotices(ctx context.Context, printf func(format string, args ...any)) context.Context {
+	return context.WithValue(ctx, noticeKey{}, printf)
+}
+
+// noticef shows a notice of a model call made with ctx.
+func noticef(ctx context.Context, format string, args ...any) {
+	if printf, ok := ctx.Value(noticeKey{}).(func(string, ...any)); ok {
+		printf(format, args...)
+		return
+	}
+	fmt.Printf(format, args...)
+}
+
+// callModel sends the conversation to the model API. The system prompt and
+// earlier messages are always sent first and byte-for-byte unchanged, so the
+// server can reuse its cached prompt prefix and only evaluate the newest
+// messages.
+func callModel(model string, messages []Message, systemInstruction string) (ChatResponse, error) {
+	return callModelContext(context.Background(), model, messages, systemInstruction, nil)
+}
+
+// callModelContext is callModel with a cancellable context and optional
+// model options (sampling parameters, num_predict and so on).
+func callModelContext(ctx context.Context, model string, messages []Message, systemInstruction string, options map[string]any) (ChatResponse, error) {
+	return callModelStream(ctx, model, messages, systemInstruction, options, nil)
+}
+
+// callModelStream is callModelContext that streams the response, passing
+// the text to onToken as it is generated. A nil onToken disables streaming.
+func callModelStream(ctx context.Context, model string, messages []Message, systemInstruction string, options map[string]any, onToken func(string)) (ChatResponse, error) {
+	return callModelTools(ctx, model, messages, systemInstruction, options, nil, nil, onToken)
+}
+
+// callModelTools is callModelStream that also offers tools to the model, or
+// constrains its response to the JSON schema format, if the provider
+// supports it.
+func callModelTools(ctx context.Context, model string, messages []Message, systemInstruction string, options map[string]any, tools []Tool, format any, onToken func(string)) (ChatResponse, error) {
+	return cassetteModel(onToken, func() (ChatResponse, error) {
+		return sendModelRequest(ctx, model, messages, systemInstruction, options, tools, format, onToken)
+	})
+}
+
+// sendModelRequest is callModelTools without the cassette.
+func sendModelRequest(ctx context.Context, model string, messages []Message, systemInstruction string, options map[string]any, tools []Tool, format any, onToken func(string)) (ChatResponse, error) {
+	fullMessages := []Message{
+		{Role: "system", Content: systemInstruction},
+	}
+	fullMessages = append(fullMessages, messages...)
+	options = withConfigOptions(options)
+
+	if cfg.Provider == "llamacpp" {
+		result, err := completeLlamaCpp(ctx, model, fullMessages, options, onToken)
+		result.Message = withoutReasoning(result.Message)
+		return result, err
+	}
+
+	p, err := currentProvider()
+	if err != nil {
+		return ChatResponse{}, err
+	}
+
+	url, reqBody := p.request(model, fullMessages, options, onToken != nil)
+	if caller, ok := p.(toolCaller); ok && len(tools) > 0 {
+		reqBody = caller.withTools(reqBody, tools)
+	}
+	if structured, ok := p.(structuredOutput); ok && format != nil {
+		reqBody = structured.withFormat(reqBody, format)
+	}
+	jsonBody, _ := json.Marshal(reqBody)
+
+	release, err := acquireRateLimit(ctx, estimateRequestTokens(fullMessages))
+	if err != nil {
+		return ChatResponse{}, err
+	}
+
+	// A response is only retried before any of it has been streamed.
+	streamed := false
+	if onToken != nil {
+		forward := onToken
+		onToken = func(text string) {
+			streamed = true
+			forward(text)
+		}
+	}
+	failures := 0
+	retry := func(reason string) bool {
+		if failures >= cfg.Retry.MaxRetries || streamed {
+			return false
+		}
+		wait := backoffDelay(failures)
+		failures++
+		noticef(ctx, "⚠️ %s; retrying in %s (%d/%d)...\n", reason, wait.Round(100*time.Millisecond), failures, cfg.Retry.MaxRetries)
+		return sleepContext(ctx, wait) == nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		reqURL := url
+		if usesEndpoints() {
+			reqURL = onEndpoint(url, pickEndpoint(model))
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", reqURL, bytes.NewBuffer(jsonBody))
+		if err != nil {
+			release(0)
+			return ChatResponse{}, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		p.authorize(req)
+
+		client := &http.Client{Timeout: 5 * time.Minute}
+		resp, err := client.Do(req)
+		if err != nil {
+			if retryableError(ctx, err) && usesEndpoints() && failOver(ctx, reqURL, err.Error()) {
+				continue
+			}
+			if retryableError(ctx, err) && retry(fmt.Sprintf("Could not reach %s (%v)", p.name(), err)) {
+				continue
+			}
+			release(0)
+			return ChatResponse{}, fmt.Errorf("failed to send request to %s: %w. Is it running at %s?", p.name(), err, url)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < cfg.RateLimit.MaxRetries {
+			wait := retryAfter(resp.Header.Get("Retry-After"), attempt)
+			resp.Body.Close()
+			noticef(ctx, "⏳ Rate limited by the model API, retrying in %s...\n", wait.Round(time.Second))
+			if err := sleepContext(ctx, wait); err != nil {
+				release(0)
+				return ChatResponse{}, err
+			}
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if retryableStatus(resp.StatusCode) && usesEndpoints() && failOver(ctx, reqURL, fmt.Sprintf("status %d", resp.StatusCode)) {
+				continue
+			}
+			if retryableStatus(resp.StatusCode) && retry(fmt.Sprintf("%s returned status %d", p.name(), resp.StatusCode)) {
+				continue
+			}
+			release(0)
+			return ChatResponse{}, fmt.Errorf("%s API returned non-200 status code: %d. Body: %s", p.name(), resp.StatusCode, string(bodyBytes))
+		}
+
+		result, err := p.parse(resp.Body, onToken)
+		resp.Body.Close()
+		if err != nil {
+			if retryableError(ctx, err) && retry(fmt.Sprintf("The %s response broke off (%v)", p.name(), err)) {
+				continue
+			}
+			release(0)
+			return ChatResponse{}, fmt.Errorf("failed to decode %s response: %w", p.name(), err)
+		}
+
+		release(result.PromptEvalCount + result.EvalCount)
+		result.Message = withoutReasoning(result.Message)
+		return result, nil
+	}
+}
+
+// ollamaChatProvider talks to Ollama's /api/chat, which applies the model's
+// own chat template.
+type ollamaChatProvider struct{}
+
+func (ollamaChatProvider) name() string { return "Ollama" }
+
+func (ollamaChatProvider) request(model string, messages []Message, options map[string]any, stream bool) (string, any) {
+	return ollamaURL(), ChatRequest{
+		Model:     model,
+		Messages:  messages,
+		Stream:    stream,
+		KeepAlive: cfg.KeepAlive,
+		Options:   withNumCtx(options),
+	}
+}
+
+func (ollamaChatProvider) withTools(body any, tools []Tool) any {
+	req := body.(ChatRequest)
+	req.Tools = tools
+	return req
+}
+
+func (ollamaChatProvider) withFormat(body any, schema any) any {
+	req := body.(ChatRequest)
+	req.Format = schema
+	return req
+}
+
+func (ollamaChatProvider) authorize(req *http.Request) {}
+
+func (ollamaChatProvider) parse(body io.Reader, onToken func(string)) (ChatResponse, error) {
+	if onToken == nil {
+		var ollamaResp ChatResponse
+		err := json.NewDecoder(body).Decode(&ollamaResp)
+		return ollamaResp, err
+	}
+
+	// A stream is a sequence of JSON objects, each holding the next piece of
+	// the message; the last one carries the token counts. Tool calls arrive
+	// whole, in any chunk. Reasoning reported separately is streamed in
+	// <think> tags, like that of models that write it into the content.
+	var result ChatResponse
+	var content, thinking strings.Builder
+	var toolCalls []ToolCall
+	stream := thinkingStream{onToken: onToken}
+	decoder := json.NewDecoder(body)
+	for !result.Done {
+		var chunk ChatResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			return ChatResponse{}, err
+		}
+		content.WriteString(chunk.Message.Content)
+		thinking.WriteString(chunk.Message.Thinking)
+		stream.write(chunk.Message.Thinking, chunk.Message.Content)
+		toolCalls = append(toolCalls, chunk.Message.ToolCalls...)
+		result = chunk
+	}
+	result.Message = Message{Role: "assistant", Content: content.String(), ToolCalls: toolCalls, Thinking: thinking.String()}
+	return result, nil
+}
+
+// ollamaGenerateProvider talks to Ollama's /api/generate in raw mode with a
+// prompt built by shai, for models whose chat template is missing or broken.
+type ollamaGenerateProvider struct{}
+
+type GenerateRequest struct {
+	Model     string         `json:"model"`
+	Prompt    string         `json:"prompt"`
+	Images    []string       `json:"images,omitempty"`
+	Raw       bool           `json:"raw"`
+	Stream    bool           `json:"stream"`
+	KeepAlive string         `json:"keep_alive"`
+	Options   map[string]any `json:"options,omitempty"`
+}
+
+type GenerateResponse struct {
+	Model           string `json:"model"`
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+}
+
+// promptFormat describes how to flatten a conversation into a raw prompt.
+type promptFormat struct {
+	system, user, assistant string // Sprintf formats taking the content
+	prefix, generation      string // start of the prompt, and the opening of the reply
+	stop                    []string
+	systemInUser            bool // the format has no system role
+}
+
+var promptFormats = map[string]promptFormat{
+	"chatml": {
+		system:     "<|im_start|>system\n%s<|im_end|>\n",
+		user:       "<|im_start|>user\n%s<|im_end|>\n",
+		assistant:  "<|im_start|>assistant\n%s<|im_end|>\n",
+		generation: "<|im_start|>assistant\n",
+		stop:       []string{"<|im_end|>"},
+	},
+	"llama3": {
+		prefix:     "<|begin_of_text|>",
+		system:     "<|start_header_id|>system<|end_header_id|>\n\n%s<|eot_id|>",
+		user:       "<|start_header_id|>user<|end_header_id|>\n\n%s<|eot_id|>",
+		assistant:  "<|start_header_id|>assistant<|end_header_id|>\n\n%s<|eot_id|>",
+		generation: "<|start_header_id|>assistant<|end_header_id|>\n\n",
+		stop:       []string{"<|eot_id|>"},
+	},
+	"mistral": {
+		prefix:       "<s>",
+		user:         "[INST] %s [/INST]",
+		assistant:    " %s</s>",
+		stop:         []string{"</s>", "[INST]"},
+		systemInUser: true,
+	},
+	"plain": {
+		system:     "### System:\n%s\n\n",
+		user:       "### User:\n%s\n\n",
+		assistant:  "### Assistant:\n%s\n\n",
+		generation: "### Assistant:\n",
+		stop:       []string{"### User:", "### System:"},
+	},
+}
+
+func buildPrompt(format promptFormat, messages []Message) string {
+	var prompt strings.Builder
+	prompt.WriteString(format.prefix)
+
+	pendingSystem := ""
+	for _, message := range messages {
+		switch message.Role {
+		case "system":
+			if format.systemInUser {
+				pendingSystem = message.Content + "\n\n"
+			} else {
+				prompt.WriteString(fmt.Sprintf(format.system, message.Content))
+			}
+		case "assistant":
+			prompt.WriteString(fmt.Sprintf(format.assistant, message.Content))
+		default:
+			prompt.WriteString(fmt.Sprintf(format.user, pendingSystem+message.Content))
+			pendingSystem = ""
+		}
+	}
+
+	prompt.WriteString(format.generation)
+	return prompt.String()
+}
+
+func (ollamaGenerateProvider) name() string { return "Ollama" }
+
+func (ollamaGenerateProvider) request(model string, messages []Message, options map[string]any, stream bool) (string, any) {
+	format, ok := promptFormats[cfg.PromptFormat]
+	if !ok {
+		format = promptFormats["chatml"]
+	}
+
+	merged := map[string]any{"stop": format.stop}
+	for key, value := range withNumCtx(options) {
+		merged[key] = value
+	}
+
+	var images []string
+	for _, message := range messages {
+		images = append(images, message.Images...)
+	}
+
+	return ollamaEndpoint("/api/generate"), GenerateRequest{
+		Model:     model,
+		Prompt:    buildPrompt(format, messages),
+		Images:    images,
+		Raw:       true,
+		Stream:    stream,
+		KeepAlive: cfg.KeepAlive,
+		Options:   merged,
+	}
+}
+
+func (ollamaGenerateProvider) authorize(req *http.Request) {}
+
+func (ollamaGenerateProvider) parse(body io.Reader, onToken func(string)) (ChatResponse, error) {
+	var generateResp GenerateResponse
+	var content strings.Builder
+	decoder := json.NewDecoder(body)
+	for {
+		var chunk GenerateResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			return ChatResponse{}, err
+		}
+		content.WriteString(chunk.Response)
+		generateResp = chunk
+		if onToken == nil {
+			break
+		}
+		onToken(chunk.Response)
+		if chunk.Done {
+			break
+		}
+	}
+	return ChatResponse{
+		Model:           generateResp.Model,
+		Message:         Message{Role: "assistant", Content: content.String()},
+		Done:            generateResp.Done,
+		PromptEvalCount: generateResp.PromptEvalCount,
+		EvalCount:       generateResp.EvalCount,
+	}, nil
+}
+
+// ollamaEndpoint derives another Ollama API endpoint from the configured
+// chat URL.
+func ollamaEndpoint(path string) string {
+	return ollamaBase(ollamaURL()) + path
+}
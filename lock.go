@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Lock files keep concurrent shai processes from trampling each other's
+// state: each running session holds sessions/<id>.lock, and the undo journal
+// is rewritten only under journal.lock. A lock is an operating system lock
+// on the file, so it is released when its process dies and never goes
+// stale. The file also holds the ID of the owner, for reporting.
+
+var errLocked = errors.New("in use")
+
+// tryLock locks the lock file at path, creating it if needed, or fails with
+// errLocked if another process holds it.
+func tryLock(path string) (release func(), err error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			if errors.Is(err, errLocked) {
+				if pid, ok := lockOwner(path); ok {
+					return nil, fmt.Errorf("%w by process %d", errLocked, pid)
+				}
+			}
+			return nil, err
+		}
+		// The previous owner removes the file before unlocking it, so the
+		// file locked may no longer be the one at path.
+		if opened, err := f.Stat(); err != nil {
+			f.Close()
+			return nil, err
+		} else if current, err := os.Stat(path); err != nil || !os.SameFile(opened, current) {
+			f.Close()
+			continue
+		}
+		f.Truncate(0)
+		fmt.Fprintf(f, "%d\n", os.Getpid())
+		return func() {
+			f.Truncate(0)
+			os.Remove(path)
+			f.Close()
+		}, nil
+	}
+}
+
+// waitLock is tryLock, retrying for up to timeout while the lock is held.
+func waitLock(path string, timeout time.Duration) (release func(), err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		release, err := tryLock(path)
+		if !errors.Is(err, errLocked) || time.Now().After(deadline) {
+			return release, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func lockOwner(path string) (int, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid, err == nil && pid > 0
+}
+
+func processAlive(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// On Windows, finding the process is the check; elsewhere it always
+	// succeeds and signal 0 tells.
+	if runtime.GOOS == "windows" {
+		p.Release()
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func sessionLockPath(id string) (string, error) {
+	dir, err := sessionsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, id+".lock"), nil
+}
+
+// lockSession claims a session for this process until release is called,
+// or until the process exits.
+func lockSession(id string) (release func(), err error) {
+	path, err := sessionLockPath(id)
+	if err != nil {
+		return nil, err
+	}
+	release, err = tryLock(path)
+	if err != nil {
+		return nil, fmt.Errorf("session %s is %w", id, err)
+	}
+	return release, nil
+}
+
+// sessionInUse reports whether another live process holds a session.
+func sessionInUse(id string) bool {
+	path, err := sessionLockPath(id)
+	if err != nil {
+		return false
+	}
+	pid, ok := lockOwner(path)
+	return ok && pid != os.Getpid() && processAlive(pid)
+}
+
+// claimSessionID picks a new session ID and locks it. IDs are timestamps,
+// so processes started in the same second are told apart by a suffix.
+func claimSessionID() (id string, release func()) {
+	return claimSessionIDFrom(newSessionID())
+}
+
+// claimSessionIDFrom is claimSessionID for IDs that start with base.
+func claimSessionIDFrom(base string) (id string, release func()) {
+	dir, err := sessionsDir()
+	if err != nil {
+		// Without a state directory, the session is not saved anyway.
+		return base, func() {}
+	}
+	id = base
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(dir, id+".json")); os.IsNotExist(err) {
+			if release, err := lockSession(id); err == nil {
+				return id, release
+			} else if !errors.Is(err, errLocked) {
+				return id, func() {}
+			}
+		}
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// claimSession gives the agent a new, locked session ID.
+func (a *Agent) claimSession() {
+	a.SessionID, a.unlockSession = claimSessionID()
+	debugSession(a.SessionID)
+}
+
+// releaseSession lets other processes resume the agent's session.
+func (a *Agent) releaseSession() {
+	if a.unlockSession != nil {
+		a.unlockSession()
+		a.unlockSession = nil
+	}
+}
+
+// lockJournal serializes changes to the undo journal.
+func lockJournal() (release func(), err error) {
+	dir, err := getStateDir()
+	if err != nil {
+		return nil, err
+	}
+	return waitLock(filepath.Join(dir, "journal.lock"), 10*time.Second)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = kernel32.NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// lockFile takes an exclusive lock on an open file without waiting, failing
+// with errLocked if another process holds it. The lock goes away when the
+// file is closed, including when the process dies. Windows locks keep other
+// processes from reading the bytes they cover, so the lock is on a byte far
+// past the owner's process ID.
+func lockFile(f *os.File) error {
+	overlapped := syscall.Overlapped{OffsetHigh: 1}
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r != 0 {
+		return nil
+	}
+	if errors.Is(err, errorLockViolation) {
+		return errLocked
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LongCommandConfig controls interim feedback for commands that run for a
+// long time. Once a command has run for ThresholdSeconds, the model is shown
+// the last TailLines lines of output every IntervalSeconds and decides
+// whether to keep waiting or abort it.
+type LongCommandConfig struct {
+	Enabled          bool `json:"enabled"`
+	ThresholdSeconds int  `json:"threshold_seconds"`
+	IntervalSeconds  int  `json:"interval_seconds"`
+	TailLines        int  `json:"tail_lines"`
+}
+
+// commandMonitor is asked whether a still-running command should be allowed
+// to continue. It receives the elapsed time and the tail of the output.
+type commandMonitor func(elapsed time.Duration, tail string) bool
+
+const stillRunningTemplate = `COMMAND_STILL_RUNNING:
+The command has been running for %s and has not finished yet. The last %d lines of its output are:
+%s
+
+Reply with "WAIT" to let it keep running, or "ABORT" to kill it if it looks stuck or is doing the wrong thing.`
+
+// lockedBuffer is a bytes.Buffer that can be written by the command while
+// the monitor reads from it.
+// lockedBuffer collects a command's output. With max set, it keeps only
+// that many bytes and closes overflow when the command writes more.
+type lockedBuffer struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	max      int
+	overflow chan struct{}
+	full     bool
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.max > 0 && b.buf.Len()+len(p) > b.max {
+		b.buf.Write(p[:max(b.max-b.buf.Len(), 0)])
+		if !b.full {
+			b.full = true
+			close(b.overflow)
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func lastLines(text string, n int) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Reasons a command was stopped before it finished.
+const (
+	stopAborted     = "ABORTED"
+	stopTimeout     = "TIMEOUT"
+	stopInterrupted = "INTERRUPTED"
+)
+
+// waitWithMonitor waits for a started command, consulting the monitor on the
+// configured schedule. The command and everything it started are stopped
+// when the monitor says so, when it exceeds the command timeout, or when the
+// user presses Ctrl+C, which stops only the command and not shai. It reports
+// the command's exit error and why it was stopped, if it was.
+func waitWithMonitor(tree *processTree, done <-chan error, output *lockedBuffer, monitor commandMonitor) (error, string) {
+	interrupts := make(chan struct{}, 1)
+	defer onInterrupt(func() { interrupts <- struct{}{} })()
+
+	var timeout <-chan time.Time
+	if cfg.CommandTimeoutSeconds > 0 {
+		timer := time.NewTimer(time.Duration(cfg.CommandTimeoutSeconds) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	lc := cfg.LongCommand
+	var check <-chan time.Time
+	var checkTimer *time.Timer
+	if monitor != nil && lc.Enabled && lc.ThresholdSeconds > 0 {
+		checkTimer = time.NewTimer(time.Duration(lc.ThresholdSeconds) * time.Second)
+		defer checkTimer.Stop()
+		check = checkTimer.C
+	}
+	start := time.Now()
+	interval := time.Duration(max(lc.IntervalSeconds, 1)) * time.Second
+
+	stop := func(reason string) (error, string) {
+		return stopTree(tree, done), reason
+	}
+	for {
+		select {
+		case err := <-done:
+			return err, ""
+		case <-interrupts:
+			fmt.Println("\n🛑 Interrupted; stopping the command.")
+			return stop(stopInterrupted)
+		case <-timeout:
+			fmt.Printf("\n⏰ The command timed out after %ds; stopping it.\n", cfg.CommandTimeoutSeconds)
+			return stop(stopTimeout)
+		case <-output.overflow:
+			fmt.Printf("\n📏 The command wrote more than %d MB of output; stopping it.\n", cfg.Limits.OutputMB)
+			return stop(stopOutputLimit)
+		case <-check:
+			if !monitor(time.Since(start), lastLines(output.String(), lc.TailLines)) {
+				return stop(stopAborted)
+			}
+			checkTimer.Reset(interval)
+		}
+	}
+}
+
+// monitorCommand returns a monitor that lets the model decide whether a
+// long-running command should keep going. The exchange is not added to the
+// conversation; only its outcome is recorded.
+func (a *Agent) monitorCommand(command string) commandMonitor {
+	return func(elapsed time.Duration, tail string) bool {
+		elapsed = elapsed.Round(time.Second)
+		a.printf("\n⏱️  Command still running after %s, asking shai whether to keep waiting...\n", elapsed)
+
+		messages := append(slices.Clone(a.Messages), Message{
+			Role:    "user",
+			Content: fmt.Sprintf(stillRunningTemplate, elapsed, cfg.LongCommand.TailLines, tail),
+		})
+		resp, err := callModel(a.Model, messages, a.SystemPrompt)
+		if err != nil {
+			a.printf("⚠️ Could not ask shai (%v), letting the command run.\n", err)
+			return true
+		}
+
+		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(resp.Message.Content)), "ABORT") {
+			a.printf("🛑 shai decided to abort the command.\n")
+			a.record("abort", fmt.Sprintf("aborted %q after %s", command, elapsed))
+			return false
+		}
+		a.printf("⏱️  shai decided to keep waiting.\n")
+		return true
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LoopConfig tunes the detection of a model stuck re-issuing a command that
+// keeps failing the same way. The last Window commands are remembered; a
+// repeat earns a warning, and MaxRepeats identical failures stop the task.
+type LoopConfig struct {
+	Window     int `json:"window"`
+	MaxRepeats int `json:"max_repeats"`
+}
+
+// attempt is a command that was run, or refused, and how it ended.
+type attempt struct {
+	command string
+	status  string
+	output  string
+}
+
+var (
+	loopSpace  = regexp.MustCompile(`\s+`)
+	loopDigits = regexp.MustCompile(`[0-9]+`)
+)
+
+// normalizeCommand makes near-identical commands compare equal: spacing,
+// quoting and trailing separators do not matter.
+func normalizeCommand(command string) string {
+	command = strings.NewReplacer(`"`, "", `'`, "").Replace(command)
+	command = loopSpace.ReplaceAllString(strings.TrimSpace(command), " ")
+	return strings.TrimRight(command, " ;&")
+}
+
+// normalizeOutput ignores numbers, such as timestamps, PIDs and durations,
+// that differ between otherwise identical failures.
+func normalizeOutput(output string) string {
+	return loopSpace.ReplaceAllString(loopDigits.ReplaceAllString(strings.TrimSpace(output), "N"), " ")
+}
+
+func failedStatus(status string) bool {
+	return status != "SUCCESS" && status != "DRY_RUN"
+}
+
+// noteAttempt records a command's result and returns how many times it has
+// now failed in the same way within the window, counting this time.
+func (a *Agent) noteAttempt(command, status, output string) int {
+	current := attempt{command: normalizeCommand(command), status: status, output: normalizeOutput(output)}
+	repeats := 0
+	if failedStatus(status) {
+		repeats = 1
+		for _, previous := range a.attempts {
+			if previous == current {
+				repeats++
+			}
+		}
+	}
+	a.attempts = append(a.attempts, current)
+	if window := cfg.Loop.Window; window > 0 && len(a.attempts) > window {
+		a.attempts = a.attempts[len(a.attempts)-window:]
+	}
+	return repeats
+}
+
+// loopWarning is the intervention appended to the result of a repeated
+// failure. It quotes the last line of output, where errors usually are.
+func loopWarning(repeats int, status string, output string) string {
+	reason := status
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" && line != "OUTPUT:" {
+			reason += ": " + truncateLine(line, 200)
+			break
+		}
+	}
+	return fmt.Sprintf("LOOP_WARNING: You have now run this command %d times and it failed the same way every time (%s). Running it again will not help. Try a different approach, investigate the cause first, or stop the task.\n", repeats, reason)
+}
@@ -2,32 +2,186 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 )
 
 type Config struct {
-	OllamaURL         string `json:"ollama_url"`
-	OllamaModel       string `json:"ollama_model"`
-	AdditionalContext string `json:"additional_context"`
+	OllamaURL                string                     `json:"ollama_url"`
+	OllamaModel              string                     `json:"ollama_model"`
+	AdditionalContext        string                     `json:"additional_context"`
+	Databases                map[string]DatabaseConfig  `json:"databases"`
+	KubeGuard                bool                       `json:"kube_guard"`
+	TrustedKubeContexts      []string                   `json:"trusted_kube_contexts"`
+	MaxSubagents             int                        `json:"max_subagents"`
+	SubagentMaxSteps         int                        `json:"subagent_max_steps"`
+	PlannerModel             string                     `json:"planner_model"`
+	ExecutorModel            string                     `json:"executor_model"`
+	Profile                  string                     `json:"profile"`
+	Profiles                 map[string]json.RawMessage `json:"profiles"`
+	Router                   RouterConfig               `json:"router"`
+	KeepAlive                string                     `json:"keep_alive"`
+	CacheStats               bool                       `json:"cache_stats"`
+	WarmUp                   bool                       `json:"warm_up"`
+	SpeculativePrefill       bool                       `json:"speculative_prefill"`
+	RateLimit                RateLimitConfig            `json:"rate_limit"`
+	LongCommand              LongCommandConfig          `json:"long_command"`
+	Voice                    VoiceConfig                `json:"voice"`
+	TTS                      TTSConfig                  `json:"tts"`
+	Vision                   bool                       `json:"vision"`
+	PackageManager           string                     `json:"package_manager"`
+	InstallAction            bool                       `json:"install_action"`
+	NetworkCheck             bool                       `json:"network_check"`
+	ConnectivityCheckAddress string                     `json:"connectivity_check_address"`
+	OfflineProfile           string                     `json:"offline_profile"`
+	Escalation               EscalationConfig           `json:"escalation"`
+	Provider                 string                     `json:"provider"`
+	PromptFormat             string                     `json:"prompt_format"`
+	APIURL                   string                     `json:"api_url"`
+	LlamaCpp                 LlamaCppConfig             `json:"llamacpp"`
+	Snapshots                bool                       `json:"snapshots"`
+	NativeTools              bool                       `json:"native_tools"`
+	APIKey                   string                     `json:"api_key"`
+	Stream                   bool                       `json:"stream"`
+	DryRun                   bool                       `json:"dry_run"`
+	Denylist                 []string                   `json:"denylist"`
+	BuiltinDenylist          bool                       `json:"builtin_denylist"`
+	Risk                     RiskConfig                 `json:"risk"`
+	Approval                 string                     `json:"approval"`
+	Protocol                 string                     `json:"protocol"`
+	NonInteractive           bool                       `json:"non_interactive"`
+	OnAsk                    string                     `json:"on_ask"`
+	Context                  ContextConfig              `json:"context"`
+	Output                   OutputConfig               `json:"output"`
+	CommandTimeoutSeconds    int                        `json:"command_timeout_seconds"`
+	Audit                    bool                       `json:"audit"`
+	MCPServers               map[string]MCPServerConfig `json:"mcp_servers"`
+	Redact                   RedactConfig               `json:"redact"`
+	Budget                   BudgetConfig               `json:"budget"`
+	Anthropic                AnthropicConfig            `json:"anthropic"`
+	Loop                     LoopConfig                 `json:"loop"`
+	Reports                  bool                       `json:"reports"`
+	ReportsDir               string                     `json:"reports_dir"`
+	PTY                      PTYConfig                  `json:"pty"`
+	ShowThinking             bool                       `json:"show_thinking"`
+	Plan                     bool                       `json:"plan"`
+	Serve                    ServeConfig                `json:"serve"`
+	AutoPull                 bool                       `json:"auto_pull"`
+	Retry                    RetryConfig                `json:"retry"`
+	Options                  map[string]any             `json:"options,omitempty"`
+	OllamaEndpoints          []string                   `json:"ollama_endpoints,omitempty"`
+	EndpointStrategy         string                     `json:"endpoint_strategy,omitempty"`
+	Critic                   CriticConfig               `json:"critic"`
+	Lint                     LintConfig                 `json:"lint"`
+	ToolInventory            []string                   `json:"tool_inventory"`
+	Changes                  ChangesConfig              `json:"changes"`
+	GitCheckpoint            bool                       `json:"git_checkpoint"`
+	SystemPromptTemplate     string                     `json:"system_prompt_template"`
+	PromptVars               map[string]string          `json:"prompt_vars"`
+	Policy                   string                     `json:"policy"`
+	Policies                 map[string]PolicyRules     `json:"policies"`
+	Hooks                    map[string][]string        `json:"hooks"`
+	HookTimeoutSeconds       int                        `json:"hook_timeout_seconds"`
+	Notify                   NotifyConfig               `json:"notify"`
+	Prices                   map[string]ModelPrice      `json:"prices"`
+	Timings                  bool                       `json:"timings"`
+	Sudo                     SudoConfig                 `json:"sudo"`
+	Env                      EnvConfig                  `json:"env"`
+	Limits                   LimitsConfig               `json:"limits"`
+	Interactive              InteractiveConfig          `json:"interactive"`
+	Azure                    AzureConfig                `json:"azure"`
 }
 
 const defaultOllamaURL = "http://localhost:11434/api/chat"
+const defaultAPIURL = "http://localhost:8080"
 const defaultOllamaModel = "qwen3:8b"
 const defaultAdditionalContext = ""
+const defaultKeepAlive = "30m"
+const defaultConnectivityCheckAddress = "1.1.1.1:443"
+const defaultRateLimitRetries = 5
+const defaultMaxSubagents = 4
+const defaultSubagentMaxSteps = 20
 
 var cfg Config
 
+func defaultConfig() Config {
+	return Config{
+		OllamaURL:                defaultOllamaURL,
+		OllamaModel:              defaultOllamaModel,
+		AdditionalContext:        defaultAdditionalContext,
+		Databases:                map[string]DatabaseConfig{},
+		KubeGuard:                true,
+		TrustedKubeContexts:      []string{},
+		MaxSubagents:             defaultMaxSubagents,
+		SubagentMaxSteps:         defaultSubagentMaxSteps,
+		Profiles:                 map[string]json.RawMessage{},
+		Router:                   RouterConfig{Mode: "task", Method: "heuristic"},
+		KeepAlive:                defaultKeepAlive,
+		InstallAction:            true,
+		NetworkCheck:             true,
+		Provider:                 "ollama",
+		PromptFormat:             "chatml",
+		APIURL:                   defaultAPIURL,
+		ConnectivityCheckAddress: defaultConnectivityCheckAddress,
+		RateLimit:                RateLimitConfig{MaxRetries: defaultRateLimitRetries},
+		LongCommand: LongCommandConfig{
+			Enabled:          true,
+			ThresholdSeconds: 60,
+			IntervalSeconds:  120,
+			TailLines:        20,
+		},
+		Voice: VoiceConfig{STTURL: defaultSTTURL, STTAPI: "whisper.cpp"},
+		Escalation: EscalationConfig{
+			Enabled:         true,
+			AfterFailures:   2,
+			TemperatureStep: 0.2,
+			MaxTemperature:  1.4,
+		},
+		LlamaCpp:           LlamaCppConfig{ContextSize: defaultLlamaCppContextSize},
+		NativeTools:        true,
+		Stream:             true,
+		BuiltinDenylist:    true,
+		Approval:           "manual",
+		Protocol:           "auto",
+		OnAsk:              "proceed",
+		Context:            ContextConfig{NumCtx: defaultNumCtx, SummarizeAt: 0.75, KeepRecent: 8},
+		Output:             OutputConfig{MaxBytes: 16 << 10, HeadLines: 100, TailLines: 100, SaveFull: true},
+		Audit:              true,
+		MCPServers:         map[string]MCPServerConfig{},
+		Redact:             RedactConfig{Enabled: true, Builtin: true},
+		Budget:             BudgetConfig{MaxSteps: 100, MaxConsecutiveFailures: 10},
+		Anthropic:          AnthropicConfig{MaxTokens: 8192, Version: "2023-06-01"},
+		Loop:               LoopConfig{Window: 10, MaxRepeats: 3},
+		Reports:            true,
+		PTY:                PTYConfig{Mode: "off"},
+		Serve:              ServeConfig{Listen: "127.0.0.1:8765"},
+		Retry:              RetryConfig{MaxRetries: 3, BaseDelaySeconds: 1, MaxDelaySeconds: 30},
+		Lint:               LintConfig{Enabled: true},
+		ToolInventory:      defaultToolInventory,
+		Changes:            ChangesConfig{Enabled: true, MaxFiles: 20000},
+		Policies:           map[string]PolicyRules{},
+		Hooks:              map[string][]string{},
+		HookTimeoutSeconds: 30,
+		Notify:             NotifyConfig{Events: []string{"ask", "approval", "complete", "failed"}},
+		Prices:             map[string]ModelPrice{},
+		Timings:            true,
+		Sudo:               SudoConfig{Allowed: true, Offer: true},
+		Env:                EnvConfig{Set: map[string]string{}},
+		Interactive:        InteractiveConfig{Mode: "rewrite", Env: map[string]string{}},
+		Azure:              AzureConfig{APIVersion: azureDefaultAPIVersion, Deployments: map[string]string{}, Auth: "key"},
+	}
+}
+
 func getConfigFilePath() (string, error) {
 	var dir string
 	const appName = "shai"
@@ -66,18 +220,108 @@ func getConfigFilePath() (string, error) {
 	return filepath.Join(appDir, "config.json"), nil
 }
 
-func loadConfig() error {
+// updateConfigFile sets a single top-level key in the config file, leaving
+// every other key exactly as the user wrote it.
+func updateConfigFile(key string, value any) error {
 	configPath, err := getConfigFilePath()
 	if err != nil {
 		return fmt.Errorf("failed to get config path: %w", err)
 	}
 
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		cfg = Config{
-			OllamaURL:         defaultOllamaURL,
-			OllamaModel:       defaultOllamaModel,
-			AdditionalContext: defaultAdditionalContext,
+	raw := map[string]json.RawMessage{}
+	data, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config file %s: %w", configPath, err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", configPath, err)
+		}
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config value for %s: %w", key, err)
+	}
+	raw[key] = encoded
+
+	data, err = json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config file %s: %w", configPath, err)
+	}
+	return nil
+}
+
+// addToConfigList adds an item to a list in the global config file. The list
+// is read from the file rather than cfg, which may hold items merged in from
+// a project config or a profile.
+func addToConfigList(key string, item string) error {
+	configPath, err := getConfigFilePath()
+	if err != nil {
+		return fmt.Errorf("failed to get config path: %w", err)
+	}
+
+	raw := map[string]json.RawMessage{}
+	data, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config file %s: %w", configPath, err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", configPath, err)
+		}
+	}
+
+	var list []string
+	if encoded, ok := raw[key]; ok {
+		if err := json.Unmarshal(encoded, &list); err != nil {
+			return fmt.Errorf("failed to parse %s in config file %s: %w", key, configPath, err)
 		}
+	}
+	if slices.Contains(list, item) {
+		return nil
+	}
+	return updateConfigFile(key, append(list, item))
+}
+
+// applyProfile overlays the named profile onto the loaded configuration. A
+// profile is a partial config object; any key it sets wins over the base.
+func applyProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+	raw, ok := cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return fmt.Errorf("failed to parse profile %q: %w", name, err)
+	}
+	cfg.Profile = name
+	return nil
+}
+
+// executorModel is the model that produces the step-by-step actions.
+func executorModel() string {
+	if cfg.ExecutorModel != "" {
+		return cfg.ExecutorModel
+	}
+	return cfg.OllamaModel
+}
+
+// loadConfig loads the global config and the project config; askProject is
+// passed on to loadProjectConfig.
+func loadConfig(askProject bool) error {
+	configPath, err := getConfigFilePath()
+	if err != nil {
+		return fmt.Errorf("failed to get config path: %w", err)
+	}
+
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		cfg = defaultConfig()
 
 		data, err := json.MarshalIndent(cfg, "", "  ")
 		if err != nil {
@@ -88,7 +332,7 @@ func loadConfig() error {
 			return fmt.Errorf("failed to write default config: %w", err)
 		}
 
-		return nil
+		return loadProjectConfig(askProject)
 	}
 
 	data, err := os.ReadFile(configPath)
@@ -96,11 +340,12 @@ func loadConfig() error {
 		return fmt.Errorf("failed to read config file %s: %w", configPath, err)
 	}
 
+	cfg = defaultConfig()
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return fmt.Errorf("failed to parse config file %s: %w", configPath, err)
 	}
 
-	return nil
+	return loadProjectConfig(askProject)
 }
 
 const systemPromptTemplate = `You are an autonomous shell agent called 'shai' (Shell AI).
@@ -108,9 +353,7 @@ const systemPromptTemplate = `You are an autonomous shell agent called 'shai' (S
 CURRENT TASK GOAL: %s
 
 CURRENT ENVIRONMENT:
-Operating System: %s
-Shell: %s
-Current Working Directory: %s
+%s
 
 RULES:
 1. I will send you the result of the previous command or user input as a 'user' message.
@@ -121,7 +364,7 @@ RULES:
    - If the task is VERIFIED and the goal state is achieved, output "TASK_COMPLETE" followed by any additional information.
    - If you determine the task cannot be completed or requires external human action, output "TASK_STOPPED" followed by any additional information.
 4. Your command lines MUST be a single line appropriate current environment's shell.
-5. Assume that your commands are being run in the current working directory.
+5. Assume that your commands are being run in the current working directory. Directory changes (cd) and exported environment variables carry over to later commands.
 6. Do not ask questions which you could find the answer to yourself by running commands (such as "is X package installed?"). Find the answer for yourself whenever possible.
 7. Do not ask questions you already know the answer to.
 8. Make sensible assumptions whenever possible.
@@ -136,15 +379,23 @@ ADDITIONAL CONTEXT:
 `
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// Thinking is the reasoning of a response; it is never sent back.
+	Thinking string `json:"thinking,omitempty"`
 }
 
 type ChatRequest struct {
-	Model     string    `json:"model"`
-	Messages  []Message `json:"messages"`
-	Stream    bool      `json:"stream"`
-	KeepAlive string    `json:"keep_alive"`
+	Model     string         `json:"model"`
+	Messages  []Message      `json:"messages"`
+	Stream    bool           `json:"stream"`
+	KeepAlive string         `json:"keep_alive"`
+	Options   map[string]any `json:"options,omitempty"`
+	Tools     []Tool         `json:"tools,omitempty"`
+	Format    any            `json:"format,omitempty"`
 }
 
 type ChatResponse struct {
@@ -152,192 +403,307 @@ type ChatResponse struct {
 	CreatedAt time.Time `json:"created_at"`
 	Message   Message   `json:"message"`
 	Done      bool      `json:"done"`
+
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: shai \"<task description>\"")
-		fmt.Println("Example: shai \"convert all files under this dir from flac to mp3\"")
-		os.Exit(1)
+func usage() {
+	fmt.Println("Usage: shai [flags] \"<task description>\"")
+	fmt.Println("       shai [flags]                 (interactive chat)")
+	fmt.Println("       shai <command> [arguments]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, c := range subcommands {
+		if c.description == "" {
+			continue
+		}
+		fmt.Printf("  %-46s %s\n", strings.TrimSpace(c.name+" "+c.args), c.description)
 	}
+	fmt.Println()
+	fmt.Println("Flags:")
+	flag.PrintDefaults()
+	fmt.Println()
+	fmt.Println("Example: shai \"convert all files under this dir from flac to mp3\"")
+}
 
-	if err := loadConfig(); err != nil {
-		log.Fatalf("Fatal Error loading configuration: %v", err)
-	}
+var (
+	profileFlag  = flag.String("profile", "", "name of the config profile to use")
+	pasteFlag    = flag.Bool("paste", false, "attach the clipboard contents as context")
+	taskFileFlag = flag.String("f", "", "read the task from a file, or from stdin with -")
+	stdinFlag    = flag.Bool("stdin", false, "attach what is piped to stdin as context")
+	dryRunFlag   = flag.Bool("dry-run", false, "show what would be run without executing anything")
+	approvalFlag = flag.String("approval", "", "approval policy: manual, auto or unattended")
+	yesFlag      = flag.Bool("yes", false, "auto-approve read-only commands and deny destructive ones (--approval auto)")
+
+	nonInteractiveFlag = flag.Bool("non-interactive", false, "never read stdin: deny approvals the policy would ask for and answer questions automatically")
+	onAskFlag          = flag.String("on-ask", "", "what a question does in non-interactive mode: proceed (default) or abort")
+	timeoutFlag        = flag.Duration("timeout", 0, "kill commands that run longer than this, e.g. 10m")
+	planFlag           = flag.Bool("plan", false, "have the model write a plan for you to approve or edit before it starts")
+	diffFlag           = flag.Bool("diff", false, "show diffs of the files the task changed when it ends")
+	maxStepsFlag       = flag.Int("max-steps", 0, "stop after this many steps")
+	maxTimeFlag        = flag.Duration("max-time", 0, "stop the task after this much wall-clock time, e.g. 30m")
+)
 
-	userShell := os.Getenv("SHELL")
-	if runtime.GOOS == "windows" {
-		if strings.Contains(strings.ToLower(userShell), "powershell") {
-			userShell = "powershell.exe"
-		} else {
-			userShell = "cmd.exe"
+func init() {
+	flag.BoolVar(&voiceMode, "voice", false, "dictate the task and clarifications")
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	// Completion runs on every TAB, so it must not stop to ask whether to
+	// trust a project config, or fail on a broken one.
+	completing := flag.Arg(0) == "__complete"
+	if err := loadConfig(!completing); err != nil {
+		// The doctor reports a broken config file itself.
+		if flag.Arg(0) != "doctor" && !completing {
+			log.Fatalf("Fatal Error loading configuration: %v", err)
 		}
-	} else if userShell == "" {
-		userShell = "/bin/bash"
+		cfg = defaultConfig()
+	}
+	if profile := os.Getenv("SHAI_PROFILE"); profile != "" {
+		cfg.Profile = profile
+	}
+	if *profileFlag != "" {
+		cfg.Profile = *profileFlag
 	}
-	currentOS := runtime.GOOS
-
-	initialTask := strings.Join(os.Args[1:], " ")
 
-	fullSystemPrompt := generateSystemPrompt(initialTask, currentOS, userShell)
+	// Without a task, start a chat; with --voice, dictate a single task.
+	if flag.NArg() < 1 && !voiceMode && *taskFileFlag == "" {
+		if err := chatCommand(nil); err != nil {
+			log.Fatalf("shai chat: %v", err)
+		}
+		return
+	}
 
-	err := runAgent(fullSystemPrompt, userShell)
+	if c, ok := findSubcommand(flag.Args()); ok {
+		if err := c.run(flag.Args()[1:]); err != nil {
+			log.Fatalf("shai %s: %v", c.name, err)
+		}
+		return
+	}
+	prepareRun()
+	task, piped, err := taskInput(flag.Args())
 	if err != nil {
-		log.Fatalf("Agent error: %v", err)
+		log.Fatalf("%v", err)
 	}
+	runTask(task, piped...)
 }
 
-func runAgent(fullSystemPrompt string, userShell string) error {
-	messages := []Message{
-		{Role: "user", Content: "START"},
+// prepareRun applies the profile and command-line flags to the configuration
+// and checks the backend, before an agent runs.
+// prepareRun sets up an agent run: prepareCall, then checking that the
+// model API is reachable and has the models, warming them up and starting
+// the MCP servers.
+func prepareRun() {
+	prepareCall()
+	if replaying() {
+		startMCPServers()
+		return
 	}
-	reader := bufio.NewReader(os.Stdin)
-
-	for {
-
-		fmt.Println("🤔 shai is thinking...")
-		response, err := callOllama(messages, fullSystemPrompt)
-		if err != nil {
-			return fmt.Errorf("Ollama API call failed: %w", err)
-		}
+	if err := checkEndpoints(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := checkNetwork(); err != nil {
+		log.Fatalf("Network check failed: %v", err)
+	}
+	if err := ensureModels(); err != nil {
+		log.Fatalf("Model check failed: %v", err)
+	}
+	startWarmUp()
+	startMCPServers()
+}
 
-		messages = append(messages, Message{Role: "assistant", Content: response})
+// prepareCall applies the flags and checks the configuration, which is all
+// one-shot commands such as explain and suggest need: they make a single
+// model call and start nothing else.
+func prepareCall() {
+	if err := effectiveConfig(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if *dryRunFlag {
+		cfg.DryRun = true
+	}
+	if *yesFlag {
+		cfg.Approval = "auto"
+	}
+	if *approvalFlag != "" {
+		cfg.Approval = *approvalFlag
+	}
+	if *nonInteractiveFlag {
+		cfg.NonInteractive = true
+	}
+	if *onAskFlag != "" {
+		cfg.OnAsk = *onAskFlag
+	}
+	if *timeoutFlag > 0 {
+		cfg.CommandTimeoutSeconds = int(timeoutFlag.Round(time.Second).Seconds())
+	}
+	if *planFlag {
+		cfg.Plan = true
+	}
+	if *diffFlag {
+		cfg.Changes.Enabled, cfg.Changes.Diff = true, true
+	}
+	applyOptionFlags()
+	if *maxStepsFlag > 0 {
+		cfg.Budget.MaxSteps = *maxStepsFlag
+	}
+	if *maxTimeFlag > 0 {
+		cfg.Budget.MaxSeconds = int(maxTimeFlag.Round(time.Second).Seconds())
+	}
+	if err := compileDenylist(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := compileRedactions(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := compileRiskRules(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := compilePolicy(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := checkEnvPolicy(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := checkLimits(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := compileInteractive(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := checkHooks(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := checkNotify(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := startDebugLog(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := openCassette(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := loadPromptTemplate(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := checkAzure(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := checkProtocol(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := checkNonInteractive(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := checkPTY(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+	if err := checkEndpointStrategy(); err != nil {
+		log.Fatalf("Fatal Error loading configuration: %v", err)
+	}
+}
 
-		modelOutput := strings.TrimSpace(response)
-		action := ""
-		content := ""
+// defaultShell is the shell commands are run with.
+func defaultShell() string {
+	userShell := os.Getenv("SHELL")
+	if runtime.GOOS == "windows" {
+		return windowsShell(userShell)
+	}
+	if userShell == "" {
+		return "/bin/bash"
+	}
+	return userShell
+}
 
-		idxSeparator := strings.IndexFunc(modelOutput, func(r rune) bool {
-			return r == ' ' || r == '\n'
-		})
+// attachment is a named piece of context for the start of a task.
+type attachment struct {
+	name    string
+	content string
+}
 
-		if idxSeparator == -1 {
-			action = strings.ToUpper(modelOutput)
-			content = ""
-		} else {
-			action = strings.ToUpper(modelOutput[:idxSeparator])
-			content = strings.TrimSpace(modelOutput[idxSeparator+1:])
-		}
+// runTask runs a new top-level agent on a task, asking for one if it is
+// empty, with the attachments added as context.
+func runTask(initialTask string, attachments ...attachment) {
+	userShell := defaultShell()
+	currentOS := runtime.GOOS
 
-		if action == "TASK_COMPLETE" {
-			fmt.Println("✅ shai has completed the task successfully.")
-			fmt.Println(content)
-			return nil
+	if initialTask == "" {
+		initialTask = readResponse("🗣️  What should shai do?", stdinReader)
+		if initialTask == "" {
+			log.Fatalf("No task given")
 		}
-		if action == "TASK_STOPPED" {
-			fmt.Println("🛑 shai has stopped the task, as it cannot proceed or needs human input.")
-			fmt.Println(content)
-			return nil
-		}
-
-		if action == "RUN" {
-			if content == "" {
-				fmt.Printf("⚠️ shai provided a malformed RUN command (missing command line). Response:\n---\n%s\n---\n", modelOutput)
-				messages = append(messages, Message{
-					Role:    "user",
-					Content: fmt.Sprintf("CRITICAL ERROR: Previous response was RUN but provided no command. Full response was:\n%s", modelOutput),
-				})
-				continue
-			}
-
-			command := content
-			status, output := "", ""
-			if confirmAction(fmt.Sprintf("✨ shai wants to run this command:\n\n  $ %s\n\nAllow?", command), reader) {
-				fmt.Printf("🚀 Running command via %s...\n", userShell)
-				status, output = executeCommand(command, userShell)
-			} else {
-				fmt.Printf("🛑 Rejecting command.\n")
-				status, output = "REJECTED", "Command rejected by user."
-			}
-
-			var feedback strings.Builder
-			feedback.WriteString("PREVIOUS_COMMAND_RESULT:\n")
-			feedback.WriteString(fmt.Sprintf("STATUS: %s\n", status))
-			feedback.WriteString("OUTPUT:\n")
-			feedback.WriteString(output)
-			feedback.WriteString("\n\n")
-
-			messages = append(messages, Message{
-				Role:    "user",
-				Content: feedback.String(),
-			})
-
-		} else if action == "ASK" {
-			if content == "" {
-				fmt.Printf("⚠️ shai provided a malformed ASK request (missing question). Response:\n---\n%s\n---\n", modelOutput)
-				messages = append(messages, Message{
-					Role:    "user",
-					Content: fmt.Sprintf("CRITICAL ERROR: Previous response was ASK but provided no question. Full response was:\n%s", modelOutput),
-				})
-				continue
-			}
-
-			question := content
-			fmt.Printf("\n❓ shai needs clarification:\n%s\n", question)
+	}
+	initialTask, mentionsClipboard := resolveClipboardMention(initialTask)
 
-			fmt.Print("Your response to shai: ")
-			userInput, _ := reader.ReadString('\n')
+	fullSystemPrompt := generateSystemPrompt(initialTask, currentOS, userShell, true)
 
-			messages = append(messages, Message{
-				Role:    "user",
-				Content: fmt.Sprintf("USER_CLARIFICATION: %s", strings.TrimSpace(userInput)),
-			})
+	printBanner(initialTask, userShell)
 
-		} else {
-			fmt.Printf("⚠️ shai provided an UNRECOGNIZED response. Model response was:\n---\n%s\n---\n", modelOutput)
-			if !confirmAction("shai provided an unparseable response. Continue the loop?", reader) {
-				return fmt.Errorf("user rejected unparseable model output, terminating")
-			}
-			messages = append(messages, Message{
-				Role:    "user",
-				Content: fmt.Sprintf("UNPARSEABLE_RESPONSE_ERROR: Your previous response did not follow the protocol. Your previous output was:\n%s", modelOutput),
-			})
-		}
+	agent := newAgent("", initialTask, fullSystemPrompt, userShell, 0)
+	agent.attachTaskImages()
+	if err := agent.attachContextFiles(); err != nil {
+		log.Fatalf("%v", err)
 	}
-}
-
-func callOllama(messages []Message, systemInstruction string) (string, error) {
-	fullMessages := []Message{
-		{Role: "system", Content: systemInstruction},
+	if *pasteFlag || mentionsClipboard {
+		clipboard, err := readClipboard()
+		if err != nil {
+			log.Fatalf("Failed to read the clipboard: %v", err)
+		}
+		agent.attachContext("clipboard", clipboard)
 	}
-	fullMessages = append(fullMessages, messages...)
-
-	reqBody := ChatRequest{
-		Model:     cfg.OllamaModel,
-		Messages:  fullMessages,
-		Stream:    false,
-		KeepAlive: "5m",
+	for _, a := range attachments {
+		agent.attachContext(a.name, a.content)
 	}
-
-	jsonBody, _ := json.Marshal(reqBody)
-
-	req, err := http.NewRequest("POST", cfg.OllamaURL, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create HTTP request: %w", err)
+	agent.claimSession()
+	if err := agent.makePlan(); err != nil {
+		log.Fatalf("Planner error: %v", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	runAgent(agent)
+}
 
-	client := &http.Client{Timeout: 5 * time.Minute}
-	resp, err := client.Do(req)
+// runAgent runs the top-level agent and records how it ended.
+func runAgent(agent *Agent) {
+	trapInterrupts(agent)
+	started := time.Now()
+	before := snapshotTree(agent.shell.workDir())
+	result, err := agent.Run()
+	agent.reportChanges(before)
+	agent.finishRun(result, err, started)
+	agent.releaseSession()
+	closeCassette()
 	if err != nil {
-		return "", fmt.Errorf("failed to send request to Ollama: %w. Is Ollama running at %s?", err, cfg.OllamaURL)
+		log.Fatalf("Agent error: %v\nResume with: shai resume %s", err, agent.SessionID)
 	}
-	defer resp.Body.Close()
+	os.Exit(exitCode(result))
+}
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Ollama API returned non-200 status code: %d. Body: %s", resp.StatusCode, string(bodyBytes))
+func printBanner(task string, userShell string) {
+	fmt.Printf("🐚 shai — %s via %s\n", executorModel(), modelAPIURL())
+	if first, rest, multiline := strings.Cut(strings.TrimSpace(task), "\n"); multiline {
+		fmt.Printf("   Task:  %s (+%d lines)\n", first, strings.Count(rest, "\n")+1)
+	} else {
+		fmt.Printf("   Task:  %s\n", task)
 	}
-
-	var ollamaResp ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-		return "", fmt.Errorf("failed to decode Ollama chat response: %w", err)
+	fmt.Printf("   Shell: %s in %s\n", userShell, getwd())
+	if cfg.DryRun {
+		fmt.Println("   🧪 Dry run: nothing will be executed")
 	}
-
-	return ollamaResp.Message.Content, nil
+	fmt.Println()
 }
 
 func confirmAction(message string, reader *bufio.Reader) bool {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+
+	if cfg.NonInteractive {
+		fmt.Printf("\n%s [non-interactive: no]\n", message)
+		return false
+	}
+
 	fmt.Printf("\n%s [ (Y)es / (n)o / (q)uit ]: ", message)
 
 	input, _ := reader.ReadString('\n')
@@ -350,52 +716,88 @@ func confirmAction(message string, reader *bufio.Reader) bool {
 	return !strings.HasPrefix(input, "n")
 }
 
-func executeCommand(command string, shellPath string) (status string, output string) {
+// executeCommand runs a command through the user's shell, streaming its
+// output to console, or the terminal if console is nil. With a state, the
+// command runs in its directory and environment, and the state takes on the
+// shell's when it finishes; without one, it runs in shai's own. If monitor
+// is non-nil it is consulted periodically while a long-running command is
+// still going, and the command is killed when it returns false.
+func executeCommand(command string, shellPath string, state *shellState, console io.Writer, monitor commandMonitor) (status string, output string) {
 	var cmd *exec.Cmd
 
-	if runtime.GOOS != "windows" {
+	terminal := usePTY(command)
+	command, removeScript, err := scriptInvocation(command, shellPath)
+	if err != nil {
+		return "ERROR", err.Error()
+	}
+	defer removeScript()
+	command = withLimits(command, shellPath)
+
+	stateDir := ""
+	if state != nil && tracksShellState(shellPath) {
+		if dir, err := os.MkdirTemp("", "shai-state"); err == nil {
+			stateDir = dir
+			defer os.RemoveAll(stateDir)
+		}
+	}
+
+	if terminal {
+		script := command
+		if stateDir != "" {
+			script = wrapForStateCapture(command, stateDir)
+		}
+		cmd = ptyCommand(shellPath, script)
+	} else if stateDir != "" {
+		cmd = exec.Command(shellPath, "-c", wrapForStateCapture(command, stateDir))
+	} else if runtime.GOOS != "windows" {
 		cmd = exec.Command(shellPath, "-c", command)
-	} else if strings.EqualFold(shellPath, "powershell.exe") || strings.EqualFold(shellPath, "powershell") {
-		cmd = exec.Command("powershell.exe", "-Command", command)
 	} else {
-		cmd = exec.Command("cmd.exe", "/C", command)
+		cmd = windowsCommand(shellPath, command)
 	}
-
-	var outbuf bytes.Buffer
-
-	stdoutPipe, pipeErr := cmd.StdoutPipe()
-	if pipeErr != nil {
-		return "ERROR", fmt.Sprintf("Failed to create stdout pipe: %v", pipeErr)
+	if state != nil {
+		cmd.Dir = state.dir
+		cmd.Env = commandEnv(state.env)
+	} else {
+		cmd.Env = commandEnv(nil)
 	}
-	stderrPipe, pipeErr := cmd.StderrPipe()
-	if pipeErr != nil {
-		return "ERROR", fmt.Sprintf("Failed to create stderr pipe: %v", pipeErr)
+
+	// Let exec copy the output: unlike reading StdoutPipe in our own
+	// goroutines, Wait then also waits for the copying to finish, so no
+	// trailing output is lost.
+	outbuf := lockedBuffer{max: outputLimit(), overflow: make(chan struct{})}
+	if console != nil {
+		cmd.Stdout = io.MultiWriter(console, &outbuf)
+		cmd.Stderr = cmd.Stdout
+	} else {
+		cmd.Stdout = io.MultiWriter(os.Stdout, &outbuf)
+		cmd.Stderr = io.MultiWriter(os.Stderr, &outbuf)
 	}
+	// Don't hang forever on background processes that inherited the output.
+	cmd.WaitDelay = 5 * time.Second
 
+	isolate(cmd)
 	if startErr := cmd.Start(); startErr != nil {
 		return "ERROR", fmt.Sprintf("Failed to start command: %v", startErr)
 	}
+	tree := newProcessTree(cmd)
+	defer tree.release()
 
-	go func() {
-		defer stdoutPipe.Close()
-		multiWriter := io.MultiWriter(os.Stdout, &outbuf)
-		io.Copy(multiWriter, stdoutPipe)
-	}()
-
-	go func() {
-		defer stderrPipe.Close()
-		multiWriter := io.MultiWriter(os.Stderr, &outbuf)
-		io.Copy(multiWriter, stderrPipe)
-	}()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
 
-	execErr := cmd.Wait()
+	execErr, stopped := waitWithMonitor(tree, done, &outbuf, monitor)
 
-	if execErr != nil {
+	if stopped != "" {
+		status = stopped
+	} else if execErr != nil {
 		status = fmt.Sprintf("ERROR(%v)", execErr)
 	} else {
 		status = "SUCCESS"
 	}
-	output = fmt.Sprintf("OUTPUT:\n%s", outbuf.String())
+	output = fmt.Sprintf("OUTPUT:\n%s", cleanTerminalOutput(outbuf.String()))
+	if stateDir != "" && stopped == "" {
+		output += applyShellState(stateDir, state)
+	}
 
 	return status, output
 }
@@ -408,10 +810,43 @@ func getwd() string {
 	return wd
 }
 
-func generateSystemPrompt(initialTask string, currentOS string, userShell string) string {
-	if cfg.AdditionalContext == "" {
-		return fmt.Sprintf(systemPromptTemplate, initialTask, currentOS, userShell, getwd(), "")
-	} else {
-		return fmt.Sprintf(systemPromptTemplate, initialTask, currentOS, userShell, getwd(), fmt.Sprintf(additionalContextTemplate, cfg.AdditionalContext))
+func generateSystemPrompt(initialTask string, currentOS string, userShell string, allowSpawn bool) string {
+	var extra strings.Builder
+	if cfg.AdditionalContext != "" {
+		extra.WriteString(fmt.Sprintf(additionalContextTemplate, cfg.AdditionalContext))
+	}
+	extra.WriteString(databasePromptSection())
+	extra.WriteString(mcpPromptSection())
+	extra.WriteString(visionPromptSectionText())
+	extra.WriteString(installPromptSectionText())
+	extra.WriteString(toolCallingPromptSectionText())
+	extra.WriteString(jsonProtocolPromptSectionText())
+	extra.WriteString(fsToolsPromptSectionText())
+	extra.WriteString(runScriptPromptSectionText())
+	extra.WriteString(sudoPromptSectionText())
+	extra.WriteString(writeFilePromptSection)
+	extra.WriteString(searchPromptSection)
+	extra.WriteString(pickFilePromptSection)
+	if allowSpawn {
+		extra.WriteString(spawnPromptSection())
+	}
+	environment := environmentBlock(currentOS, userShell)
+	prompt := fmt.Sprintf(systemPromptTemplate, initialTask, environment, extra.String())
+	if promptTemplate == nil {
+		return prompt
 	}
+	return renderPromptTemplate(PromptData{
+		Task:        initialTask,
+		OS:          currentOS,
+		Shell:       userShell,
+		PWD:         getwd(),
+		Environment: environment,
+		Sections:    extra.String(),
+		Default:     prompt,
+		Vars:        cfg.PromptVars,
+	})
+}
+
+func environmentBlock(currentOS string, userShell string) string {
+	return fmt.Sprintf("Operating System: %s\nShell: %s\nCurrent Working Directory: %s%s%s%s%s%s", describeOS(currentOS), userShell, getwd(), packageManagerEnvironmentLine(), toolInventoryEnvironmentLine(), gitEnvironmentLine(), networkEnvironmentLine(), kubeEnvironmentLine())
 }
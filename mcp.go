@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MCPServerConfig declares a Model Context Protocol server that shai starts
+// and talks to over stdio. Its tools are offered to the model next to the
+// built-in actions.
+type MCPServerConfig struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"`
+}
+
+const mcpProtocolVersion = "2025-06-18"
+
+const mcpRequestTimeout = 2 * time.Minute
+
+const mcpPromptTemplate = `
+MCP TOOLS:
+You can call these tools of connected MCP servers. Output "MCP" followed by the tool name on the same line, and the arguments as a JSON object matching the tool's schema on the following lines.
+%s`
+
+type mcpTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+	Annotations struct {
+		ReadOnlyHint    bool `json:"readOnlyHint"`
+		DestructiveHint bool `json:"destructiveHint"`
+	} `json:"annotations"`
+}
+
+type mcpMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int            `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// mcpClient is a connection to one MCP server process. Requests may be made
+// concurrently; responses are matched to them by ID.
+type mcpClient struct {
+	name  string
+	cmd   *exec.Cmd
+	tools []mcpTool
+
+	writeMu sync.Mutex
+	stdin   io.WriteCloser
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan mcpMessage
+	err     error
+}
+
+// mcpClients are the connected servers, by name.
+var mcpClients = map[string]*mcpClient{}
+
+// startMCPServers connects to the configured servers. A server that fails to
+// start is reported and left out.
+func startMCPServers() {
+	names := make([]string, 0, len(cfg.MCPServers))
+	for name := range cfg.MCPServers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		client, err := startMCPServer(name, cfg.MCPServers[name])
+		if err != nil {
+			fmt.Printf("⚠️ MCP server %s is unavailable: %v\n", name, err)
+			continue
+		}
+		mcpClients[name] = client
+		fmt.Printf("🔌 Connected to MCP server %s (%d tools)\n", name, len(client.tools))
+	}
+}
+
+func startMCPServer(name string, sc MCPServerConfig) (*mcpClient, error) {
+	if sc.Command == "" {
+		return nil, fmt.Errorf("no command configured")
+	}
+	cmd := exec.Command(sc.Command, sc.Args...)
+	cmd.Env = os.Environ()
+	for key, value := range sc.Env {
+		cmd.Env = append(cmd.Env, key+"="+os.ExpandEnv(value))
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", sc.Command, err)
+	}
+
+	c := &mcpClient{name: name, cmd: cmd, stdin: stdin, pending: map[int]chan mcpMessage{}}
+	go c.readLoop(stdout)
+
+	var initialized struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	err = c.request("initialize", map[string]any{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "shai", "version": "1"},
+	}, &initialized)
+	if err == nil {
+		err = c.send(mcpMessage{JSONRPC: "2.0", Method: "notifications/initialized"})
+	}
+	if err == nil {
+		err = c.listTools()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *mcpClient) listTools() error {
+	cursor := ""
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var page struct {
+			Tools      []mcpTool `json:"tools"`
+			NextCursor string    `json:"nextCursor"`
+		}
+		if err := c.request("tools/list", params, &page); err != nil {
+			return err
+		}
+		c.tools = append(c.tools, page.Tools...)
+		if page.NextCursor == "" {
+			return nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func (c *mcpClient) send(message mcpMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.stdin.Write(append(data, '\n'))
+	return err
+}
+
+// readLoop delivers responses to their requests until the server exits.
+// Requests from the server are answered with an error, except for pings.
+func (c *mcpClient) readLoop(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20)
+	for scanner.Scan() {
+		var message mcpMessage
+		if json.Unmarshal(scanner.Bytes(), &message) != nil || message.ID == nil {
+			continue
+		}
+		if message.Method != "" {
+			reply := mcpMessage{JSONRPC: "2.0", ID: message.ID, Result: json.RawMessage("{}")}
+			if message.Method != "ping" {
+				reply.Result = nil
+				reply.Error = &struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				}{-32601, "method not supported by shai"}
+			}
+			c.send(reply)
+			continue
+		}
+		c.mu.Lock()
+		ch := c.pending[*message.ID]
+		delete(c.pending, *message.ID)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- message
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = fmt.Errorf("MCP server %s exited", c.name)
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+func (c *mcpClient) request(method string, params any, result any) error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan mcpMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if err := c.send(mcpMessage{JSONRPC: "2.0", ID: &id, Method: method, Params: params}); err != nil {
+		return fmt.Errorf("failed to send %s to MCP server %s: %w", method, c.name, err)
+	}
+
+	select {
+	case response, ok := <-ch:
+		if !ok {
+			return fmt.Errorf("MCP server %s exited", c.name)
+		}
+		if response.Error != nil {
+			return fmt.Errorf("MCP server %s: %s", c.name, response.Error.Message)
+		}
+		return json.Unmarshal(response.Result, result)
+	case <-time.After(mcpRequestTimeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("MCP server %s did not answer %s within %s", c.name, method, mcpRequestTimeout)
+	}
+}
+
+// findMCPTool resolves a "server.tool" name.
+func findMCPTool(name string) (*mcpClient, mcpTool, bool) {
+	server, tool, ok := strings.Cut(name, ".")
+	if !ok {
+		return nil, mcpTool{}, false
+	}
+	c, ok := mcpClients[server]
+	if !ok {
+		return nil, mcpTool{}, false
+	}
+	for _, t := range c.tools {
+		if t.Name == tool {
+			return c, t, true
+		}
+	}
+	return nil, mcpTool{}, false
+}
+
+func (t mcpTool) risk() string {
+	switch {
+	case t.Annotations.ReadOnlyHint:
+		return riskReadOnly
+	case t.Annotations.DestructiveHint:
+		return riskDestructive
+	default:
+		return riskMutating
+	}
+}
+
+// callTool runs a tool and renders its result as text.
+func (c *mcpClient) callTool(tool string, arguments map[string]any) (status string, output string) {
+	var result struct {
+		Content []struct {
+			Type     string `json:"type"`
+			Text     string `json:"text"`
+			MimeType string `json:"mimeType"`
+			Resource struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"resource"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	if err := c.request("tools/call", map[string]any{"name": tool, "arguments": arguments}, &result); err != nil {
+		return "ERROR", err.Error()
+	}
+
+	var out strings.Builder
+	for _, item := range result.Content {
+		switch item.Type {
+		case "text":
+			out.WriteString(item.Text)
+		case "resource":
+			fmt.Fprintf(&out, "[resource %s]\n%s", item.Resource.URI, item.Resource.Text)
+		default:
+			fmt.Fprintf(&out, "[%s content (%s) not shown]", item.Type, item.MimeType)
+		}
+		out.WriteString("\n")
+	}
+	if result.IsError {
+		return "ERROR", out.String()
+	}
+	return "SUCCESS", out.String()
+}
+
+// mcpToolNames lists the tools of every connected server as "server.tool".
+func mcpToolNames() []string {
+	var names []string
+	for server, c := range mcpClients {
+		for _, t := range c.tools {
+			names = append(names, server+"."+t.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func mcpPromptSection() string {
+	if len(mcpClients) == 0 {
+		return ""
+	}
+	var list strings.Builder
+	for _, name := range mcpToolNames() {
+		_, tool, _ := findMCPTool(name)
+		fmt.Fprintf(&list, "- %s: %s\n  Arguments schema: %s\n", name, strings.TrimSpace(tool.Description), compactJSON(tool.InputSchema))
+	}
+	return fmt.Sprintf(mcpPromptTemplate, list.String())
+}
+
+func compactJSON(raw json.RawMessage) string {
+	var value any
+	if json.Unmarshal(raw, &value) != nil {
+		return "{}"
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// mcpFunctionTools offers the MCP tools for native tool calling. Function
+// names cannot contain dots, so the server and tool are joined with "__".
+func mcpFunctionTools() []Tool {
+	var tools []Tool
+	for _, name := range mcpToolNames() {
+		_, tool, _ := findMCPTool(name)
+		var schema any = map[string]any{"type": "object", "properties": map[string]any{}}
+		if len(tool.InputSchema) > 0 {
+			schema = tool.InputSchema
+		}
+		tools = append(tools, Tool{Type: "function", Function: ToolFunction{
+			Name:        strings.Replace(name, ".", "__", 1),
+			Description: tool.Description,
+			Parameters:  schema,
+		}})
+	}
+	return tools
+}
+
+// runMCPTool handles an MCP action: the tool name on the first
+// line of content and its JSON arguments after it.
+func (a *Agent) runMCPTool(content string) (status string, output string) {
+	name, rawArgs, _ := strings.Cut(content, "\n")
+	name = strings.TrimSpace(name)
+	c, tool, ok := findMCPTool(name)
+	if !ok {
+		return "ERROR", fmt.Sprintf("Unknown MCP tool %q. Available tools: %s", name, strings.Join(mcpToolNames(), ", "))
+	}
+	arguments := map[string]any{}
+	if rawArgs = strings.TrimSpace(rawArgs); rawArgs != "" {
+		if err := json.Unmarshal([]byte(rawArgs), &arguments); err != nil {
+			return "ERROR", fmt.Sprintf("The arguments are not a JSON object: %v", err)
+		}
+	}
+
+	risk := tool.risk()
+	pretty, _ := json.MarshalIndent(arguments, "  ", "  ")
+	defer func() { a.audit("MCP", name+" "+string(pretty), risk, status, output) }()
+	if cfg.DryRun {
+		a.printf("🧪 Dry run, not calling %s\n", name)
+		return "DRY_RUN", dryRunOutput
+	}
+	if approvalFor(risk) == approvalDeny {
+		a.printf("⛔ The %s approval policy denies %s tools.\n", cfg.Approval, risk)
+		return "DENIED", fmt.Sprintf("The approval policy does not allow %s tools, so the tool was not called.", risk)
+	}
+	if !a.approve(risk, fmt.Sprintf("🔌 shai wants to call the %s MCP tool %s:\n\n  %s\n\nAllow?", risk, name, pretty)) {
+		a.printf("🛑 Rejecting tool call.\n")
+		return "REJECTED", "Tool call rejected by user."
+	}
+	a.printf("🔌 Calling %s...\n", name)
+	defer a.timeCommand(time.Now())
+	return cassetteResult("MCP", name+" "+string(pretty), func() (string, string) {
+		return c.callTool(tool.Name, arguments)
+	})
+}
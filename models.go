@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// backendModels lists the models served by the configured backend.
+func backendModels() ([]string, error) {
+	if cfg.Provider == "llamacpp" {
+		return []string{cfg.LlamaCpp.ModelPath}, nil
+	}
+	if cfg.Provider == "azure" {
+		return azureDeployments()
+	}
+
+	url := openAIEndpoint("/models")
+	if usesOllama() {
+		url = ollamaEndpoint("/api/tags")
+	} else if cfg.Provider == "anthropic" {
+		url = anthropicEndpoint("/models")
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p, err := currentProvider(); err == nil {
+		p.authorize(req)
+	}
+	client := &http.Client{Timeout: networkCheckTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+
+	// Ollama lists {"models": [{"name": ...}]}; OpenAI-compatible servers
+	// list {"data": [{"id": ...}]}.
+	var list struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode the model list: %w", err)
+	}
+	var names []string
+	for _, model := range list.Models {
+		names = append(names, model.Name)
+	}
+	for _, model := range list.Data {
+		names = append(names, model.ID)
+	}
+	sort.Strings(names)
+	cacheModels(names)
+	return names, nil
+}
+
+// modelCache is the last model list a backend served, kept for shell
+// completion.
+type modelCache struct {
+	URL    string   `json:"url"`
+	Models []string `json:"models"`
+}
+
+func modelCachePath() (string, error) {
+	dir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "models.json"), nil
+}
+
+// cacheModels remembers the models the backend serves. Failures only mean
+// completion knows fewer models.
+func cacheModels(names []string) {
+	path, err := modelCachePath()
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(modelCache{URL: modelAPIURL(), Models: names})
+	if err != nil {
+		return
+	}
+	os.WriteFile(path, data, 0600)
+}
+
+// cachedModels returns the models the configured backend served last time.
+func cachedModels() []string {
+	path, err := modelCachePath()
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cache modelCache
+	if json.Unmarshal(data, &cache) != nil || cache.URL != modelAPIURL() {
+		return nil
+	}
+	return cache.Models
+}
+
+func printModels() error {
+	if err := effectiveConfig(); err != nil {
+		return err
+	}
+	names, err := backendModels()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		fmt.Printf("🤷 %s serves no models.\n", modelAPIURL())
+		return nil
+	}
+	roles := map[string][]string{}
+	roles[executorModel()] = append(roles[executorModel()], "executor")
+	if cfg.PlannerModel != "" {
+		roles[cfg.PlannerModel] = append(roles[cfg.PlannerModel], "planner")
+	}
+	for _, name := range names {
+		if r := roles[name]; len(r) > 0 {
+			fmt.Printf("* %s (%s)\n", name, strings.Join(r, ", "))
+		} else {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+	return nil
+}
+
+// requiredModels are the models a run may call.
+func requiredModels() []string {
+	models := []string{executorModel()}
+	if plannerEnabled() {
+		models = append(models, cfg.PlannerModel)
+	}
+	if routerEnabled() {
+		models = append(models, cfg.Router.SmallModel, cfg.Router.LargeModel)
+	}
+	if criticEnabled() {
+		models = append(models, cfg.Critic.Model)
+	}
+	slices.Sort(models)
+	return slices.Compact(models)
+}
+
+// ollamaModelName adds the tag Ollama assumes when a name has none.
+func ollamaModelName(name string) string {
+	if !strings.Contains(name, ":") {
+		return name + ":latest"
+	}
+	return name
+}
+
+// ensureModels checks that Ollama has the models a run needs and offers to
+// pull any that are missing, rather than letting the first chat request
+// fail with a 404.
+func ensureModels() error {
+	if !cfg.NetworkCheck || !usesOllama() {
+		return nil
+	}
+	installed, err := backendModels()
+	if err != nil {
+		// checkNetwork has already reported an unreachable server.
+		return nil
+	}
+	if usesEndpoints() {
+		installed = append(installed, endpointModels()...)
+	}
+	have := map[string]bool{}
+	for _, name := range installed {
+		have[ollamaModelName(name)] = true
+	}
+	for _, model := range requiredModels() {
+		if have[ollamaModelName(model)] {
+			continue
+		}
+		if !cfg.AutoPull && !confirmAction(fmt.Sprintf("📦 %s is not installed in Ollama. Pull it now?", model), stdinReader) {
+			return fmt.Errorf("model %q is not installed in Ollama; pull it with `shai pull %s`", model, model)
+		}
+		if err := pullModel(model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pullCommand(args []string) error {
+	if err := effectiveConfig(); err != nil {
+		return err
+	}
+	if !usesOllama() {
+		return fmt.Errorf("pulling models needs the Ollama provider, not %q", cfg.Provider)
+	}
+	return pullModel(args[0])
+}
+
+// pullModel downloads a model into Ollama, showing its progress.
+func pullModel(model string) error {
+	jsonBody, _ := json.Marshal(map[string]any{"model": model, "stream": true})
+	resp, err := http.Post(ollamaEndpoint("/api/pull"), "application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", model, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to pull %s: Ollama returned status %d: %s", model, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	fmt.Printf("⬇️  Pulling %s...\n", model)
+	lastStatus, progress := "", false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var update struct {
+			Status    string `json:"status"`
+			Total     int64  `json:"total"`
+			Completed int64  `json:"completed"`
+			Error     string `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &update); err != nil {
+			continue
+		}
+		if update.Error != "" {
+			if progress {
+				fmt.Println()
+			}
+			return fmt.Errorf("failed to pull %s: %s", model, update.Error)
+		}
+		if update.Total > 0 {
+			fmt.Printf("\r   %s: %3d%% (%s / %s)  ", update.Status, update.Completed*100/update.Total, formatBytes(update.Completed), formatBytes(update.Total))
+			progress = true
+			continue
+		}
+		if update.Status != lastStatus {
+			if progress {
+				fmt.Println()
+				progress = false
+			}
+			fmt.Printf("   %s\n", update.Status)
+			lastStatus = update.Status
+		}
+	}
+	if progress {
+		fmt.Println()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to pull %s: %w", model, err)
+	}
+	if lastStatus != "success" {
+		return fmt.Errorf("pulling %s ended without success", model)
+	}
+	fmt.Printf("✅ Pulled %s.\n", model)
+	return nil
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
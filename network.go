@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const networkCheckTimeout = 5 * time.Second
+
+// internetReachable is false once the pre-check found no general internet
+// connectivity, so the model can be told to stay with local resources.
+var internetReachable = true
+
+// backendReachable reports whether anything answers HTTP at the model API's
+// host. Any response, even an error status, means the server is up.
+func backendReachable() error {
+	if cfg.Provider == "llamacpp" {
+		if !llamaCppAvailable {
+			return fmt.Errorf("this binary was built without llama.cpp support (rebuild with -tags llamacpp)")
+		}
+		_, err := os.Stat(cfg.LlamaCpp.ModelPath)
+		return err
+	}
+	u, err := url.Parse(modelAPIURL())
+	if err != nil {
+		return fmt.Errorf("invalid model API URL %q: %w", modelAPIURL(), err)
+	}
+	client := &http.Client{Timeout: networkCheckTimeout}
+	resp, err := client.Get(u.Scheme + "://" + u.Host + "/")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func internetConnectivity() bool {
+	if cfg.ConnectivityCheckAddress == "" {
+		return true
+	}
+	conn, err := net.DialTimeout("tcp", cfg.ConnectivityCheckAddress, networkCheckTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// checkNetwork runs before the agent starts so that an unreachable backend
+// fails fast with a clear message instead of timing out mid-task. If the
+// backend is down and an offline profile is configured, shai switches to it.
+func checkNetwork() error {
+	if !cfg.NetworkCheck {
+		return nil
+	}
+
+	internetReachable = internetConnectivity()
+	if !internetReachable {
+		fmt.Println("🌐 No internet connectivity detected; shai will tell the model to stay offline.")
+	}
+
+	err := backendReachable()
+	if err == nil {
+		return nil
+	}
+
+	if cfg.OfflineProfile != "" && cfg.Profile != cfg.OfflineProfile {
+		fmt.Printf("🌐 Cannot reach the model API at %s, switching to profile %q.\n", modelAPIURL(), cfg.OfflineProfile)
+		if err := applyProfile(cfg.OfflineProfile); err != nil {
+			return err
+		}
+		if err := backendReachable(); err == nil {
+			return nil
+		} else {
+			return fmt.Errorf("cannot reach the model API at %s (offline profile %q): %w", modelAPIURL(), cfg.OfflineProfile, err)
+		}
+	}
+
+	if cfg.Provider == "llamacpp" {
+		return fmt.Errorf("cannot use the in-process model %q: %w", modelAPIURL(), err)
+	}
+	if !usesOllama() {
+		return fmt.Errorf("cannot reach the model API at %s: %w. Is the server running and is api_url correct?", modelAPIURL(), err)
+	}
+	return fmt.Errorf("cannot reach the model API at %s: %w. Is Ollama running (try `ollama serve`) and is ollama_url correct?", modelAPIURL(), err)
+}
+
+func networkEnvironmentLine() string {
+	if internetReachable {
+		return ""
+	}
+	return "\nNetwork: offline (no internet access; do not try to download anything, use what is available locally)"
+}
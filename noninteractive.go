@@ -0,0 +1,39 @@
+package main
+
+import "fmt"
+
+// In non-interactive mode (--non-interactive, for cron jobs and CI) shai never
+// reads stdin: approvals the policy would ask for are denied, confirmations
+// are declined, and questions get a canned answer or stop the task.
+
+const noHumanAnswer = "No human is available to answer (shai is running non-interactively). Proceed with your best judgement; if the task cannot be done safely without an answer, stop it and explain what is needed."
+
+// Exit codes of a top-level run. Errors exit with 1 through log.Fatalf.
+const (
+	exitComplete        = 0
+	exitStopped         = 2
+	exitBudgetExhausted = 3
+)
+
+func exitCode(result AgentResult) int {
+	switch result.Status {
+	case ResultComplete:
+		return exitComplete
+	case ResultBudgetExhausted:
+		return exitBudgetExhausted
+	default:
+		return exitStopped
+	}
+}
+
+func checkNonInteractive() error {
+	if cfg.NonInteractive && cfg.Approval == "manual" {
+		fmt.Println("⚠️ Non-interactive mode with the manual approval policy denies every command; use --approval auto or unattended.")
+	}
+	switch cfg.OnAsk {
+	case "proceed", "abort":
+		return nil
+	default:
+		return fmt.Errorf("unknown on_ask %q (expected proceed or abort)", cfg.OnAsk)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NotifyConfig sends notifications to a generic webhook (a JSON POST), an
+// ntfy topic URL, a Slack incoming webhook and, with Desktop, the desktop
+// when shai waits for an answer or an approval, and when a task completes or
+// fails. A prompt is only notified once it has waited DelaySeconds, so
+// prompts answered at the terminal stay quiet.
+type NotifyConfig struct {
+	Desktop      bool     `json:"desktop"`
+	Webhook      string   `json:"webhook"`
+	Ntfy         string   `json:"ntfy"`
+	NtfyToken    string   `json:"ntfy_token"`
+	Slack        string   `json:"slack"`
+	Events       []string `json:"events"`
+	DelaySeconds int      `json:"delay_seconds"`
+}
+
+var notifyEvents = []string{"ask", "approval", "complete", "failed"}
+
+// maxNotificationLength bounds the message text; the terminal has the rest.
+const maxNotificationLength = 1000
+
+// notification is what the generic webhook receives.
+type notification struct {
+	Event   string    `json:"event"`
+	Title   string    `json:"title"`
+	Message string    `json:"message"`
+	Session string    `json:"session,omitempty"`
+	Task    string    `json:"task"`
+	Time    time.Time `json:"time"`
+}
+
+func checkNotify() error {
+	for _, event := range cfg.Notify.Events {
+		if !slices.Contains(notifyEvents, event) {
+			return fmt.Errorf("unknown notify event %q (use %s)", event, strings.Join(notifyEvents, ", "))
+		}
+	}
+	return nil
+}
+
+func notifyEnabled(event string) bool {
+	n := cfg.Notify
+	return (n.Desktop || n.Webhook != "" || n.Ntfy != "" || n.Slack != "") && slices.Contains(n.Events, event)
+}
+
+// notify sends a notification to every configured target. Failures are
+// reported but never stop the run.
+func (a *Agent) notify(event string, title string, message string) {
+	if !notifyEnabled(event) || replaying() {
+		return
+	}
+	if a.Name != "" {
+		title = "[" + a.Name + "] " + title
+	}
+	message = redact(message)
+	if len(message) > maxNotificationLength {
+		message = message[:maxNotificationLength] + "…"
+	}
+	n := notification{Event: event, Title: title, Message: message, Session: a.auditSession(), Task: redact(a.Task), Time: time.Now()}
+
+	var wg sync.WaitGroup
+	send := func(target string, req *http.Request, err error) {
+		defer wg.Done()
+		if err == nil {
+			var resp *http.Response
+			client := &http.Client{Timeout: 10 * time.Second}
+			if resp, err = client.Do(req); err == nil {
+				resp.Body.Close()
+				if resp.StatusCode >= 300 {
+					err = fmt.Errorf("status %d", resp.StatusCode)
+				}
+			}
+		}
+		if err != nil {
+			a.printf("⚠️ Failed to notify %s: %v\n", target, err)
+		}
+	}
+	if cfg.Notify.Desktop {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := desktopNotify(event, title, message); err != nil {
+				// Without a desktop session this fails every time.
+				desktopFailed.Do(func() { a.printf("⚠️ Failed to show a desktop notification: %v\n", err) })
+			}
+		}()
+	}
+	if url := cfg.Notify.Webhook; url != "" {
+		wg.Add(1)
+		body, _ := json.Marshal(n)
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		go send("the webhook", req, err)
+	}
+	if url := cfg.Notify.Ntfy; url != "" {
+		wg.Add(1)
+		req, err := http.NewRequest("POST", url, strings.NewReader(message))
+		if err == nil {
+			req.Header.Set("Title", title)
+			req.Header.Set("Tags", ntfyTags[event])
+			if event == "ask" || event == "approval" {
+				req.Header.Set("Priority", "high")
+			}
+			if cfg.Notify.NtfyToken != "" {
+				req.Header.Set("Authorization", "Bearer "+cfg.Notify.NtfyToken)
+			}
+		}
+		go send("ntfy", req, err)
+	}
+	if url := cfg.Notify.Slack; url != "" {
+		wg.Add(1)
+		body, _ := json.Marshal(map[string]string{"text": "*" + title + "*\n" + message})
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		go send("Slack", req, err)
+	}
+	wg.Wait()
+}
+
+var desktopFailed sync.Once
+
+var ntfyTags = map[string]string{
+	"ask":      "question",
+	"approval": "warning",
+	"complete": "white_check_mark",
+	"failed":   "x",
+}
+
+// notifyWaiting notifies that a prompt is waiting, once it has waited
+// delay_seconds. Call done when the prompt is answered.
+func (a *Agent) notifyWaiting(event string, title string, message string) (done func()) {
+	if !notifyEnabled(event) || cfg.NonInteractive {
+		return func() {}
+	}
+	timer := time.AfterFunc(time.Duration(cfg.Notify.DelaySeconds)*time.Second, func() {
+		a.notify(event, title, message)
+	})
+	return func() { timer.Stop() }
+}
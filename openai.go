@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// openAIProvider talks to any OpenAI-compatible /chat/completions endpoint:
+// OpenAI itself, vLLM, LM Studio, OpenRouter, the llama.cpp server and so on.
+type openAIProvider struct{}
+
+func (openAIProvider) name() string { return "OpenAI-compatible" }
+
+func (openAIProvider) request(model string, messages []Message, options map[string]any, stream bool) (string, any) {
+	req := newChatCompletionsRequest(model, messages, options, stream)
+	if stream {
+		req.StreamOptions = &chatCompletionsStreamOptions{IncludeUsage: true}
+	}
+	return openAIEndpoint("/chat/completions"), req
+}
+
+func (openAIProvider) withFormat(body any, schema any) any {
+	req := body.(chatCompletionsRequest)
+	req.ResponseFormat = &chatCompletionsResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: map[string]any{"name": "action", "strict": true, "schema": schema},
+	}
+	return req
+}
+
+func (openAIProvider) parse(body io.Reader, onToken func(string)) (ChatResponse, error) {
+	return parseChatCompletionsResponse(body, onToken)
+}
+
+func (openAIProvider) authorize(req *http.Request) {
+	bearerAuth(req, apiKey("OPENAI_API_KEY"))
+}
+
+// openAIEndpoint joins a path onto api_url, adding the /v1 prefix unless the
+// URL already ends with it (as in https://openrouter.ai/api/v1).
+func openAIEndpoint(path string) string {
+	base := strings.TrimRight(cfg.APIURL, "/")
+	if !strings.HasSuffix(base, "/v1") {
+		base += "/v1"
+	}
+	return base + path
+}
+
+// apiKey returns the configured api_key, or the given environment variable
+// when it is not set.
+func apiKey(envVar string) string {
+	if cfg.APIKey != "" {
+		return cfg.APIKey
+	}
+	return os.Getenv(envVar)
+}
+
+func bearerAuth(req *http.Request, key string) {
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+}
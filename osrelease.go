@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	osReleaseOnce sync.Once
+	osRelease     string
+)
+
+// describeOS names the operating system more precisely than GOOS, so the
+// model does not guess the distribution: "linux (Debian GNU/Linux 12
+// (bookworm), like debian, amd64)". It falls back to currentOS and the
+// architecture when the release cannot be determined.
+func describeOS(currentOS string) string {
+	osReleaseOnce.Do(func() {
+		switch runtime.GOOS {
+		case "darwin":
+			osRelease = macOSRelease()
+		case "windows":
+			osRelease = windowsRelease()
+		default:
+			osRelease = linuxRelease()
+		}
+	})
+	if osRelease == "" || currentOS != runtime.GOOS {
+		return currentOS + " (" + runtime.GOARCH + ")"
+	}
+	return currentOS + " (" + osRelease + ", " + runtime.GOARCH + ")"
+}
+
+// osReleaseFields reads os-release(5).
+func osReleaseFields() map[string]string {
+	fields := map[string]string{}
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+				fields[key] = strings.Trim(value, `"'`)
+			}
+		}
+		file.Close()
+		break
+	}
+	return fields
+}
+
+// distroPackageManagers maps os-release IDs to their native package manager.
+var distroPackageManagers = map[string]string{
+	"debian": "apt", "ubuntu": "apt",
+	"fedora": "dnf", "rhel": "dnf", "centos": "dnf",
+	"arch": "pacman", "suse": "zypper", "opensuse": "zypper",
+	"alpine": "apk", "void": "xbps", "gentoo": "emerge", "nixos": "nix",
+}
+
+// distroPackageManager is the native package manager of the Linux
+// distribution, or "" if it is not known.
+func distroPackageManager() string {
+	fields := osReleaseFields()
+	for _, id := range append([]string{fields["ID"]}, strings.Fields(fields["ID_LIKE"])...) {
+		if name, ok := distroPackageManagers[id]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// linuxRelease describes the distribution from os-release(5).
+func linuxRelease() string {
+	fields := osReleaseFields()
+	name := fields["PRETTY_NAME"]
+	if name == "" {
+		name = strings.TrimSpace(fields["NAME"] + " " + fields["VERSION_ID"])
+	}
+	if name == "" {
+		return ""
+	}
+	if like := fields["ID_LIKE"]; like != "" {
+		name += ", like " + like
+	}
+	return name
+}
+
+func macOSRelease() string {
+	out, err := exec.Command("sw_vers", "-productVersion").Output()
+	if err != nil {
+		return ""
+	}
+	return "macOS " + strings.TrimSpace(string(out))
+}
+
+// windowsRelease uses ver, which is much faster than systeminfo:
+// "Microsoft Windows [Version 10.0.22631.4317]".
+func windowsRelease() string {
+	out, err := exec.Command("cmd", "/d", "/c", "ver").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutputConfig limits how much command output is fed back to the model.
+// Longer output keeps its first HeadLines and last TailLines lines, and with
+// SaveFull the whole output is written to an artifact file the model can
+// search later.
+type OutputConfig struct {
+	MaxBytes  int  `json:"max_bytes"`
+	HeadLines int  `json:"head_lines"`
+	TailLines int  `json:"tail_lines"`
+	SaveFull  bool `json:"save_full"`
+}
+
+var (
+	artifactRunOnce sync.Once
+	artifactRunID   string
+)
+
+// artifactDir is where this run's full command outputs are kept: the
+// session's directory, or one per process for runs without a session.
+func (a *Agent) artifactDir() (string, error) {
+	dir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+	id := a.SessionID
+	if id == "" {
+		artifactRunOnce.Do(func() { artifactRunID = "run-" + time.Now().Format("20060102-150405") })
+		id = artifactRunID
+	}
+	dir = filepath.Join(dir, "artifacts", id)
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+func (a *Agent) saveArtifact(output string) (string, error) {
+	dir, err := a.artifactDir()
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("step-%d.log", a.Step)
+	if a.Name != "" {
+		name = strings.NewReplacer("/", "-", " ", "-").Replace(a.Name) + "-" + name
+	}
+	path := filepath.Join(dir, name)
+	return path, os.WriteFile(path, []byte(redact(output)), 0600)
+}
+
+// limitOutput shortens output that exceeds the configured limits, keeping
+// its head and tail and noting what was left out.
+func (a *Agent) limitOutput(output string) string {
+	limits := cfg.Output
+	if limits.MaxBytes <= 0 || len(output) <= limits.MaxBytes {
+		return output
+	}
+
+	lines := strings.SplitAfter(output, "\n")
+	head, tail := limits.HeadLines, limits.TailLines
+	if head+tail >= len(lines) {
+		head, tail = len(lines)/2, len(lines)-len(lines)/2
+	}
+	headText := capBytes(strings.Join(lines[:head], ""), limits.MaxBytes/2, false)
+	tailText := capBytes(strings.Join(lines[len(lines)-tail:], ""), limits.MaxBytes/2, true)
+	elidedBytes := len(output) - len(headText) - len(tailText)
+	elidedLines := strings.Count(output, "\n") - strings.Count(headText, "\n") - strings.Count(tailText, "\n")
+
+	var limited strings.Builder
+	limited.WriteString(headText)
+	if !strings.HasSuffix(headText, "\n") {
+		limited.WriteString("\n")
+	}
+	fmt.Fprintf(&limited, "[... %d lines (%d bytes) elided ...]\n", elidedLines, elidedBytes)
+	limited.WriteString(tailText)
+	if !strings.HasSuffix(tailText, "\n") {
+		limited.WriteString("\n")
+	}
+
+	if limits.SaveFull {
+		if path, err := a.saveArtifact(output); err == nil {
+			fmt.Fprintf(&limited, "[the full output (%d bytes) is saved in %s; search it with grep, HEAD or CAT instead of rerunning the command]\n", len(output), path)
+		} else {
+			a.printf("⚠️ Failed to save the full output: %v\n", err)
+		}
+	}
+	return limited.String()
+}
+
+// capBytes cuts s to at most n bytes, keeping its end instead of its start
+// when fromEnd is set.
+func capBytes(s string, n int, fromEnd bool) string {
+	if len(s) <= n {
+		return s
+	}
+	if fromEnd {
+		return strings.ToValidUTF8(s[len(s)-n:], "")
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+// terminalEscapes matches ANSI escape sequences: CSI (colors, cursor
+// movement), OSC (window titles, hyperlinks), DCS and similar strings, and
+// the short two-byte forms.
+var terminalEscapes = regexp.MustCompile(`\x1b\[[0-9;?<=>]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[P^_X][^\x1b]*\x1b\\|\x1b[()][0-9A-Za-z]|\x1b[=>78cDEHM]`)
+
+// collapseRepeats is how many identical consecutive lines are kept before
+// the rest are replaced by a count.
+const collapseRepeats = 2
+
+// cleanTerminalOutput turns what a command wrote for a terminal into plain
+// text for the model: escape sequences are dropped, backspaces are applied,
+// for lines redrawn with carriage returns (progress bars) only the final
+// version is kept, other control characters are removed, and runs of
+// identical lines are collapsed.
+func cleanTerminalOutput(output string) string {
+	output = terminalEscapes.ReplaceAllString(output, "")
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		if j := strings.LastIndex(strings.TrimRight(line, "\r"), "\r"); j >= 0 {
+			line = line[j+1:]
+		}
+		lines[i] = applyBackspaces(strings.Map(func(r rune) rune {
+			if (r < ' ' && r != '\t' && r != '\b') || r == 0x7f || (r >= 0x80 && r < 0xa0) {
+				return -1
+			}
+			return r
+		}, line))
+	}
+
+	var cleaned []string
+	for i := 0; i < len(lines); {
+		j := i + 1
+		for j < len(lines) && lines[j] == lines[i] {
+			j++
+		}
+		if repeats := j - i; repeats > collapseRepeats && lines[i] != "" {
+			cleaned = append(cleaned, lines[i:i+collapseRepeats]...)
+			cleaned = append(cleaned, fmt.Sprintf("[previous line repeated %d more times]", repeats-collapseRepeats))
+		} else {
+			cleaned = append(cleaned, lines[i:j]...)
+		}
+		i = j
+	}
+	return strings.Join(cleaned, "\n")
+}
+
+// applyBackspaces erases the character before each backspace, as a terminal
+// would; man pages and some progress output use it for overstriking.
+func applyBackspaces(line string) string {
+	if !strings.ContainsRune(line, '\b') {
+		return line
+	}
+	var out []rune
+	for _, r := range line {
+		if r == '\b' {
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Any config key can be overridden from the environment as SHAI_<KEY>, with
+// "__" between the parts of a nested key (SHAI_ROUTER__MODE). Values are
+// parsed as JSON, falling back to a plain string. SHAI_URL and SHAI_MODEL,
+// like the --url and --model flags, set the backend URL and model whatever
+// the provider. Flags take precedence over the environment, and both over
+// the config file.
+
+const envPrefix = "SHAI_"
+
+var (
+	urlFlag   = flag.String("url", "", "model API URL, overriding the config file")
+	modelFlag = flag.String("model", "", "model name, overriding the config file")
+)
+
+// effectiveConfig applies the profile and then the environment and flag
+// overrides to the loaded configuration.
+func effectiveConfig() error {
+	if err := applyProfile(cfg.Profile); err != nil {
+		return err
+	}
+	if err := applyEnvOverrides(os.Environ()); err != nil {
+		return err
+	}
+	if *urlFlag != "" {
+		setModelAPIURL(*urlFlag)
+	}
+	if *modelFlag != "" {
+		setModel(*modelFlag)
+	}
+	return nil
+}
+
+func applyEnvOverrides(environ []string) error {
+	overrides := map[string]string{}
+	var names []string
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		if key, ok := strings.CutPrefix(name, envPrefix); ok && key != "" {
+			overrides[strings.ToLower(key)] = value
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	effective, err := configMap()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, name := range names {
+		key := strings.ToLower(strings.TrimPrefix(name, envPrefix))
+		parts := strings.Split(key, "__")
+		if _, known := effective[parts[0]]; !known {
+			// Other programs, and shai's own helper scripts, use the
+			// prefix too.
+			continue
+		}
+		var value any
+		if err := json.Unmarshal([]byte(overrides[key]), &value); err != nil {
+			value = overrides[key]
+		}
+		if err := setNested(effective, parts, value); err != nil {
+			return fmt.Errorf("unknown config key %q in %s", strings.Join(parts, "."), name)
+		}
+		changed = true
+	}
+	if changed {
+		data, _ := json.Marshal(effective)
+		overridden := defaultConfig()
+		if err := json.Unmarshal(data, &overridden); err != nil {
+			return fmt.Errorf("invalid %s environment override: %w", envPrefix+"*", err)
+		}
+		cfg = overridden
+	}
+
+	if url, ok := overrides["url"]; ok && url != "" {
+		setModelAPIURL(url)
+	}
+	if model, ok := overrides["model"]; ok && model != "" {
+		setModel(model)
+	}
+	return nil
+}
+
+// setModelAPIURL sets the URL of the backend the provider talks to.
+func setModelAPIURL(url string) {
+	switch {
+	case usesOllama():
+		cfg.OllamaURL = url
+		cfg.OllamaEndpoints = nil
+	case cfg.Provider == "llamacpp":
+		cfg.LlamaCpp.ModelPath = url
+	default:
+		cfg.APIURL = url
+	}
+}
+
+// setModel makes model the one that executes tasks.
+func setModel(model string) {
+	cfg.OllamaModel = model
+	cfg.ExecutorModel = ""
+}
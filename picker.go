@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const pickFilePromptSection = `
+CHOOSING FILES:
+When the user has to choose among files (for example "which config should I edit?"), output "PICK_FILE" followed by the question on the same line. Optionally list the candidate paths or glob patterns (such as "**/*.yaml") on the following lines, one per line; without them the user picks from all files in the working directory. The user's choice is sent back as USER_PICKED_FILE.
+`
+
+const (
+	maxPickerCandidates = 20000
+	pickerPageSize      = 15
+)
+
+// pickerCandidates resolves the lines after the PICK_FILE question into
+// paths: plain paths are kept as given and glob patterns are matched against
+// the files under root. No lines means every file.
+func pickerCandidates(root string, specs []string) []string {
+	var candidates, patterns []string
+	for _, spec := range specs {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		if strings.ContainsAny(spec, "*?[") {
+			patterns = append(patterns, spec)
+		} else {
+			candidates = append(candidates, spec)
+		}
+	}
+	if len(candidates) > 0 && len(patterns) == 0 {
+		return candidates
+	}
+
+	walkFiles(root, func(rel string) error {
+		if len(patterns) == 0 || matchesAnyGlob(patterns, rel) {
+			candidates = append(candidates, rel)
+		}
+		if len(candidates) >= maxPickerCandidates {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return candidates
+}
+
+// matchesAnyGlob matches rel against patterns; "**/" matches any number of
+// directories and a pattern without a slash matches the base name.
+func matchesAnyGlob(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+				return true
+			}
+			continue
+		}
+		if rest, ok := strings.CutPrefix(pattern, "**/"); ok {
+			parts := strings.Split(rel, "/")
+			for i := range parts {
+				if ok, _ := path.Match(rest, strings.Join(parts[i:], "/")); ok {
+					return true
+				}
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzyScore returns how well query matches candidate as a subsequence,
+// higher being better, or -1 if it does not match. Consecutive characters
+// and matches at the start of a path component score higher.
+func fuzzyScore(query, candidate string) int {
+	if query == "" {
+		return 0
+	}
+	q := []rune(strings.ToLower(query))
+	c := []rune(strings.ToLower(candidate))
+	score, qi, prev := 0, 0, -2
+	for ci := 0; ci < len(c) && qi < len(q); ci++ {
+		if c[ci] != q[qi] {
+			continue
+		}
+		score++
+		if ci == prev+1 {
+			score += 3
+		}
+		if ci == 0 || !unicode.IsLetter(c[ci-1]) && !unicode.IsDigit(c[ci-1]) {
+			score += 2
+		}
+		prev = ci
+		qi++
+	}
+	if qi < len(q) {
+		return -1
+	}
+	// Prefer shorter paths among equal matches.
+	return score*100 - len(c)
+}
+
+func fuzzyFilter(query string, candidates []string) []string {
+	type scored struct {
+		path  string
+		score int
+	}
+	var matches []scored
+	for _, candidate := range candidates {
+		if s := fuzzyScore(query, candidate); s >= 0 {
+			matches = append(matches, scored{candidate, s})
+		}
+	}
+	if query != "" {
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	}
+	result := make([]string, len(matches))
+	for i, m := range matches {
+		result[i] = m.path
+	}
+	return result
+}
+
+// pickWithFzf lets the user choose with fzf, which draws on the terminal
+// itself. ok is false if fzf is unavailable or failed to run.
+func pickWithFzf(question string, candidates []string) (choice string, ok bool) {
+	if _, err := exec.LookPath("fzf"); err != nil || voiceMode {
+		return "", false
+	}
+	cmd := exec.Command("fzf", "--header", question, "--prompt", "file> ", "--height", "40%", "--reverse")
+	cmd.Stdin = strings.NewReader(strings.Join(candidates, "\n"))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// fzf exits 130 when the user cancels the picker.
+		if exitErr, isExit := err.(*exec.ExitError); isExit && exitErr.ExitCode() == 130 {
+			return "", true
+		}
+		return "", false
+	}
+	return strings.TrimSpace(string(out)), true
+}
+
+// pickWithPrompt is the built-in picker: type to filter, a number to choose.
+func pickWithPrompt(question string, candidates []string, reader *bufio.Reader) string {
+	fmt.Printf("\n📂 %s\n", question)
+	query := ""
+	for {
+		matches := fuzzyFilter(query, candidates)
+		for i, match := range matches {
+			if i == pickerPageSize {
+				fmt.Printf("   ... and %d more\n", len(matches)-pickerPageSize)
+				break
+			}
+			fmt.Printf("  %2d) %s\n", i+1, match)
+		}
+		if len(matches) == 0 {
+			fmt.Println("   (no matches)")
+		}
+
+		fmt.Print("Type to filter, a number to choose, Enter for the first match, or '-' to skip: ")
+		input, err := reader.ReadString('\n')
+		input = strings.TrimSpace(input)
+		switch {
+		case err != nil && input == "":
+			return ""
+		case input == "-":
+			return ""
+		case input == "" && len(matches) > 0:
+			return matches[0]
+		case input == "":
+			continue
+		}
+		if n, err := strconv.Atoi(input); err == nil && n >= 1 && n <= len(matches) && n <= pickerPageSize {
+			return matches[n-1]
+		}
+		query = input
+	}
+}
+
+// pickFile asks the user to choose one of candidates, with fzf when it is
+// installed and the built-in picker otherwise. An empty result means the
+// user declined to choose.
+func pickFile(question string, candidates []string, reader *bufio.Reader) string {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+
+	if choice, ok := pickWithFzf(question, candidates); ok {
+		return choice
+	}
+	return pickWithPrompt(question, candidates, reader)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+type packageManager struct {
+	Name string
+	// Install is the install invocation; packages are appended to it.
+	Install []string
+	// Remove is the matching uninstall invocation.
+	Remove []string
+	// Root marks package managers that need root to install packages.
+	Root bool
+}
+
+var packageManagers = map[string][]packageManager{
+	"linux": {
+		{Name: "apt", Install: []string{"apt-get", "install", "-y"}, Root: true, Remove: []string{"apt-get", "remove", "-y"}},
+		{Name: "dnf", Install: []string{"dnf", "install", "-y"}, Root: true, Remove: []string{"dnf", "remove", "-y"}},
+		{Name: "yum", Install: []string{"yum", "install", "-y"}, Root: true, Remove: []string{"yum", "remove", "-y"}},
+		{Name: "pacman", Install: []string{"pacman", "-S", "--noconfirm", "--needed"}, Root: true, Remove: []string{"pacman", "-R", "--noconfirm"}},
+		{Name: "zypper", Install: []string{"zypper", "--non-interactive", "install"}, Root: true, Remove: []string{"zypper", "--non-interactive", "remove"}},
+		{Name: "apk", Install: []string{"apk", "add"}, Root: true, Remove: []string{"apk", "del"}},
+		{Name: "xbps", Install: []string{"xbps-install", "-y"}, Root: true, Remove: []string{"xbps-remove", "-y"}},
+		{Name: "emerge", Install: []string{"emerge", "--ask=n"}, Root: true, Remove: []string{"emerge", "--ask=n", "--depclean"}},
+		{Name: "nix", Install: []string{"nix-env", "-iA"}, Remove: []string{"nix-env", "-e"}},
+		{Name: "brew", Install: []string{"brew", "install"}, Remove: []string{"brew", "uninstall"}},
+	},
+	"darwin": {
+		{Name: "brew", Install: []string{"brew", "install"}, Remove: []string{"brew", "uninstall"}},
+		{Name: "port", Install: []string{"port", "install"}, Root: true, Remove: []string{"port", "uninstall"}},
+	},
+	"windows": {
+		{Name: "winget", Install: []string{"winget", "install", "-e", "--accept-package-agreements", "--accept-source-agreements", "--id"}, Remove: []string{"winget", "uninstall", "-e", "--id"}},
+		{Name: "choco", Install: []string{"choco", "install", "-y"}, Remove: []string{"choco", "uninstall", "-y"}},
+		{Name: "scoop", Install: []string{"scoop", "install"}, Remove: []string{"scoop", "uninstall"}},
+	},
+}
+
+const installPromptSection = `
+PACKAGES:
+To install packages, output "INSTALL" followed by the package names on the same line; shai turns this into the right %s command for this system. Use the package names of that package manager.
+`
+
+var (
+	detectPackageManagerOnce sync.Once
+	detectedPackageManager   *packageManager
+)
+
+// systemPackageManager returns the configured package manager, or the first
+// known one found on PATH for this OS, preferring the Linux distribution's
+// native one.
+func systemPackageManager() *packageManager {
+	detectPackageManagerOnce.Do(func() {
+		candidates := packageManagers[runtime.GOOS]
+		if runtime.GOOS != "windows" && runtime.GOOS != "darwin" && runtime.GOOS != "linux" {
+			candidates = packageManagers["linux"]
+		}
+		if native := distroPackageManager(); runtime.GOOS == "linux" && cfg.PackageManager == "" && native != "" {
+			for i, pm := range candidates {
+				if _, err := exec.LookPath(pm.Install[0]); err == nil && pm.Name == native {
+					detectedPackageManager = &candidates[i]
+					return
+				}
+			}
+		}
+		for i, pm := range candidates {
+			if cfg.PackageManager != "" {
+				if pm.Name == cfg.PackageManager {
+					detectedPackageManager = &candidates[i]
+					return
+				}
+				continue
+			}
+			if _, err := exec.LookPath(pm.Install[0]); err == nil {
+				detectedPackageManager = &candidates[i]
+				return
+			}
+		}
+	})
+	return detectedPackageManager
+}
+
+func packageManagerEnvironmentLine() string {
+	pm := systemPackageManager()
+	if pm == nil {
+		return ""
+	}
+	return fmt.Sprintf("\nPackage Manager: %s", pm.Name)
+}
+
+func installPromptSectionText() string {
+	pm := systemPackageManager()
+	if !cfg.InstallAction || pm == nil {
+		return ""
+	}
+	return fmt.Sprintf(installPromptSection, pm.Name)
+}
+
+// installCommand translates an INSTALL action into a concrete command line.
+func installCommand(packages []string) (string, error) {
+	pm := systemPackageManager()
+	if pm == nil {
+		return "", fmt.Errorf("no supported package manager was found on this system")
+	}
+	if len(packages) == 0 {
+		return "", fmt.Errorf("no packages given")
+	}
+
+	argv := append([]string{}, pm.Install...)
+	if pm.Root && runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		if _, err := exec.LookPath("sudo"); err == nil {
+			argv = append([]string{"sudo"}, argv...)
+		}
+	}
+	for _, pkg := range packages {
+		if pm.Name == "nix" && !strings.Contains(pkg, ".") {
+			pkg = "nixpkgs." + pkg
+		}
+		argv = append(argv, pkg)
+	}
+	if pm.Name == "winget" && len(packages) > 1 {
+		return "", fmt.Errorf("winget installs one package per command")
+	}
+	return strings.Join(argv, " "), nil
+}
+
+// removeCommand builds the command that uninstalls packages installed with
+// the named package manager.
+func removeCommand(name string, packages []string) (string, error) {
+	var pm *packageManager
+	for _, candidates := range packageManagers {
+		for i := range candidates {
+			if candidates[i].Name == name {
+				pm = &candidates[i]
+			}
+		}
+	}
+	if pm == nil {
+		return "", fmt.Errorf("unknown package manager %q", name)
+	}
+
+	argv := append([]string{}, pm.Remove...)
+	if pm.Root && runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		if _, err := exec.LookPath("sudo"); err == nil {
+			argv = append([]string{"sudo"}, argv...)
+		}
+	}
+	return strings.Join(append(argv, packages...), " "), nil
+}
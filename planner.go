@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const maxPlannerRejections = 3
+
+const plannerSystemPromptTemplate = `You are the planner for 'shai', an autonomous shell agent. A separate, faster executor model carries out your plan one shell command at a time.
+
+CURRENT TASK GOAL: %s
+
+CURRENT ENVIRONMENT:
+%s
+
+Write a short numbered plan of concrete steps the executor should take to achieve the goal, ending with a step that verifies the result.
+Output only the plan.
+`
+
+const verifierSystemPromptTemplate = `You are the verifier for 'shai', an autonomous shell agent. You are shown the full transcript of an executor working on a task, which has just claimed the task is complete.
+
+CURRENT TASK GOAL: %s
+
+Judge from the command results in the transcript alone whether the goal has actually been achieved and verified.
+If it has, reply with "VERIFIED".
+If not, reply with "NOT_VERIFIED" followed by what is missing or wrong, in one or two sentences.
+`
+
+const planPinTemplate = `
+
+PLAN (follow it, adapting if a step turns out to be wrong):
+%s
+When you have finished a step, output "PLAN_DONE" followed by its number before moving on, so progress is tracked.`
+
+// PlanStep is one step of the plan, checked off by the PLAN_DONE action.
+type PlanStep struct {
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+var planStepLine = regexp.MustCompile(`^\s*(\d+)[.)]\s+(.*)$`)
+
+func plannerEnabled() bool {
+	return cfg.PlannerModel != ""
+}
+
+// planModel writes the plan: the planner model if there is one, otherwise
+// the executor itself.
+func (a *Agent) planModel() string {
+	if plannerEnabled() {
+		return cfg.PlannerModel
+	}
+	return a.Model
+}
+
+// makePlan has the model write a plan, lets the user approve or edit it,
+// and pins it into the first message of the executor's conversation. It
+// runs with a planner model or with "plan" (--plan).
+func (a *Agent) makePlan() error {
+	if (!plannerEnabled() && !cfg.Plan) || a.Depth > 0 {
+		return nil
+	}
+
+	a.printf("🗺️  shai is planning with %s...\n", a.planModel())
+	resp, err := callModel(a.planModel(), []Message{{Role: "user", Content: "Write the plan."}}, fmt.Sprintf(plannerSystemPromptTemplate, a.Task, environmentBlock(runtime.GOOS, a.Shell)))
+	if err != nil {
+		return fmt.Errorf("planner call failed: %w", err)
+	}
+	plan := strings.TrimSpace(resp.Message.Content)
+
+	a.printf("🗺️  Plan:\n%s\n", plan)
+	if cfg.Plan && !cfg.NonInteractive {
+		approved, edited := a.review("Follow this plan?", plan, nil)
+		if !approved {
+			a.printf("🗺️  Continuing without a plan.\n\n")
+			return nil
+		}
+		if edited != plan {
+			plan = strings.TrimSpace(edited)
+			a.printf("🗺️  Using your plan:\n%s\n", plan)
+		}
+	}
+	a.printf("\n")
+
+	a.Plan = parsePlan(plan)
+	a.Messages[0].Content += fmt.Sprintf(planPinTemplate, plan)
+	return nil
+}
+
+// parsePlan splits a numbered plan into steps; unnumbered lines continue
+// the step before them.
+func parsePlan(plan string) []PlanStep {
+	var steps []PlanStep
+	for _, line := range strings.Split(plan, "\n") {
+		if match := planStepLine.FindStringSubmatch(line); match != nil {
+			steps = append(steps, PlanStep{Text: strings.TrimSpace(match[2])})
+		} else if len(steps) > 0 && strings.TrimSpace(line) != "" {
+			steps[len(steps)-1].Text += " " + strings.TrimSpace(line)
+		}
+	}
+	return steps
+}
+
+// planChecklist renders the plan with its progress.
+func planChecklist(steps []PlanStep) string {
+	var checklist strings.Builder
+	for i, step := range steps {
+		mark := " "
+		if step.Done {
+			mark = "x"
+		}
+		fmt.Fprintf(&checklist, "[%s] %d. %s\n", mark, i+1, step.Text)
+	}
+	return checklist.String()
+}
+
+// markPlanStep handles PLAN_DONE, returning the feedback for the model.
+func (a *Agent) markPlanStep(content string) string {
+	fields := strings.Fields(content)
+	if len(a.Plan) == 0 {
+		return "PLAN_PROGRESS: There is no plan to track; continue with the task."
+	}
+	n := 0
+	if len(fields) > 0 {
+		n, _ = strconv.Atoi(strings.Trim(fields[0], ".):#"))
+	}
+	if n < 1 || n > len(a.Plan) {
+		return fmt.Sprintf("PLAN_PROGRESS: %q is not a step number between 1 and %d.\n%s", content, len(a.Plan), planChecklist(a.Plan))
+	}
+	a.Plan[n-1].Done = true
+	a.printf("☑️  Step %d done: %s\n", n, a.Plan[n-1].Text)
+	a.record("plan", fmt.Sprintf("step %d done", n))
+	return "PLAN_PROGRESS:\n" + planChecklist(a.Plan)
+}
+
+// verifyCompletion asks the planner model to confirm a TASK_COMPLETE claim
+// against the transcript. After maxPlannerRejections rejections the executor's
+// claim is accepted so that a stubborn verifier cannot loop forever.
+func (a *Agent) verifyCompletion(summary string) (bool, string) {
+	if !plannerEnabled() || a.Depth > 0 || a.verificationTries >= maxPlannerRejections {
+		return true, ""
+	}
+
+	a.printf("🔍 shai is verifying the result with %s...\n", cfg.PlannerModel)
+	resp, err := callModel(cfg.PlannerModel, []Message{{Role: "user", Content: renderTranscript(a.Messages)}}, fmt.Sprintf(verifierSystemPromptTemplate, a.Task))
+	if err != nil {
+		a.printf("⚠️ Verification failed, accepting the executor's result: %v\n", err)
+		return true, ""
+	}
+
+	review := strings.TrimSpace(resp.Message.Content)
+	if strings.HasPrefix(strings.ToUpper(review), "VERIFIED") {
+		return true, ""
+	}
+	a.verificationTries++
+	return false, strings.TrimSpace(strings.TrimPrefix(review, "NOT_VERIFIED"))
+}
+
+func renderTranscript(messages []Message) string {
+	var transcript strings.Builder
+	for _, message := range messages {
+		if message.Role == "assistant" {
+			transcript.WriteString("EXECUTOR:\n")
+		} else {
+			transcript.WriteString("RESULT:\n")
+		}
+		transcript.WriteString(strings.TrimSpace(messageText(message)))
+		transcript.WriteString("\n\n")
+	}
+	return transcript.String()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"regexp"
+)
+
+// PolicyRules map command patterns (regular expressions) to decisions: allow,
+// prompt or deny. They are checked in the order the config file lists them,
+// and the first match decides, ahead of the approval policy's risk tiers.
+type PolicyRules []PolicyRule
+
+type PolicyRule struct {
+	Pattern  string
+	Decision string
+}
+
+// UnmarshalJSON reads rules from a JSON object, keeping the key order.
+func (r *PolicyRules) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return fmt.Errorf("policy rules must be an object of pattern: decision")
+	}
+	*r = nil
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var decision string
+		if err := dec.Decode(&decision); err != nil {
+			return fmt.Errorf("policy rule %q: %w", tok, err)
+		}
+		*r = append(*r, PolicyRule{Pattern: tok.(string), Decision: decision})
+	}
+	_, err := dec.Token()
+	return err
+}
+
+func (r PolicyRules) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, rule := range r {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(rule.Pattern)
+		value, _ := json.Marshal(rule.Decision)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+var policyFlag = flag.String("policy", "", "name of the policy rule set to apply (see \"policies\" in the config)")
+
+type policyRule struct {
+	PolicyRule
+	pattern *regexp.Regexp
+}
+
+var policyRules []policyRule
+
+// compilePolicy compiles the selected rule set. Without a policy name, the
+// set named "default" applies if there is one.
+func compilePolicy() error {
+	if *policyFlag != "" {
+		cfg.Policy = *policyFlag
+	}
+	policyRules = nil
+	name := cfg.Policy
+	if name == "" {
+		name = "default"
+	}
+	rules, ok := cfg.Policies[name]
+	if !ok {
+		if cfg.Policy != "" {
+			return fmt.Errorf("unknown policy %q", cfg.Policy)
+		}
+		return nil
+	}
+	for _, rule := range rules {
+		switch rule.Decision {
+		case approvalAllow, approvalPrompt, approvalDeny:
+		default:
+			return fmt.Errorf("policy %s: rule %q has decision %q (use allow, prompt or deny)", name, rule.Pattern, rule.Decision)
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("policy %s: invalid pattern %q: %w", name, rule.Pattern, err)
+		}
+		policyRules = append(policyRules, policyRule{rule, pattern})
+	}
+	cfg.Policy = name
+	return nil
+}
+
+// matchPolicy returns the first policy rule that matches a command.
+func matchPolicy(command string) (PolicyRule, bool) {
+	for _, rule := range policyRules {
+		if rule.pattern.MatchString(command) {
+			return rule.PolicyRule, true
+		}
+	}
+	return PolicyRule{}, false
+}
+
+// commandApproval decides whether a command runs, is prompted for or is
+// denied: by the first matching policy rule, or else by the approval
+// policy for its risk tier. The reason names what decided, and ruled is
+// whether it was a policy rule.
+func commandApproval(command string, risk string) (decision string, reason string, ruled bool) {
+	rule, ok := matchPolicy(command)
+	if !ok {
+		return approvalFor(risk), fmt.Sprintf("the %s approval policy for %s commands", cfg.Approval, risk), false
+	}
+	decision = rule.Decision
+	if decision == approvalPrompt && cfg.NonInteractive {
+		decision = approvalDeny
+	}
+	return decision, fmt.Sprintf("the %s policy rule %q (%s)", cfg.Policy, rule.Pattern, rule.Decision), true
+}
+
+// checkCommand applies the checks that come before approval in the agent
+// loop to a command run outside it, as by shai suggest: the denylist, sudo
+// and doas being disabled, and the policy. It returns why the command must
+// not run, or nil.
+func checkCommand(command string) error {
+	if pattern, denied := deniedBy(command); denied {
+		return fmt.Errorf("the command matches the denylist pattern %q", pattern)
+	}
+	if elevates(command) && !cfg.Sudo.Allowed {
+		return fmt.Errorf("sudo and doas are disabled by the configuration")
+	}
+	if decision, reason, _ := commandApproval(command, classifyRisk(command)); decision == approvalDeny {
+		return fmt.Errorf("the command is denied by %s", reason)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Stopping only the shell leaves whatever it started running, holding the
+// output open. Commands therefore run as a process tree shai can stop as a
+// whole: a process group on Unix, a Job Object on Windows.
+
+// killGracePeriod is how long a stopped command has to exit after being
+// asked to before it is killed.
+const killGracePeriod = 3 * time.Second
+
+// stopTree stops a command and every process it started: it asks them to
+// terminate, and kills those still running after the grace period. done
+// delivers the command's exit error, which stopTree returns.
+func stopTree(tree *processTree, done <-chan error) error {
+	tree.terminate()
+	grace := time.NewTimer(killGracePeriod)
+	defer grace.Stop()
+	select {
+	case err := <-done:
+		// The shell is gone, but children that ignored the request and no
+		// longer hold the output may not be.
+		tree.kill()
+		return err
+	case <-grace.C:
+		fmt.Printf("💀 The command did not stop within %s; killing it.\n", killGracePeriod)
+		tree.kill()
+		return <-done
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// processTree is a command's process group.
+type processTree struct {
+	cmd   *exec.Cmd
+	group bool
+}
+
+// isolate has a command start its own process group, so that it and its
+// children can be signalled together. A command reading from the terminal
+// stays in shai's group: a background group would be stopped by the
+// terminal as soon as it read, and its session hangs up anyway when the
+// PTY it runs in closes.
+func isolate(cmd *exec.Cmd) {
+	if cmd.Stdin == os.Stdin && isTerminal(os.Stdin) {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// newProcessTree tracks a command started after isolate.
+func newProcessTree(cmd *exec.Cmd) *processTree {
+	return &processTree{cmd: cmd, group: cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid}
+}
+
+func (t *processTree) signal(sig syscall.Signal) {
+	if t.group {
+		syscall.Kill(-t.cmd.Process.Pid, sig)
+		return
+	}
+	t.cmd.Process.Signal(sig)
+}
+
+// terminate asks every process in the tree to exit.
+func (t *processTree) terminate() { t.signal(syscall.SIGTERM) }
+
+// kill kills every process still in the tree.
+func (t *processTree) kill() { t.signal(syscall.SIGKILL) }
+
+// release frees what tracking the tree holds.
+func (t *processTree) release() {}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObject          = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+
+	ntdll               = syscall.NewLazyDLL("ntdll.dll")
+	procNtResumeProcess = ntdll.NewProc("NtResumeProcess")
+)
+
+const (
+	jobObjectExtendedLimitInformationClass = 9
+
+	jobObjectLimitProcessTime   = 0x00000002
+	jobObjectLimitPriorityClass = 0x00000020
+	jobObjectLimitProcessMemory = 0x00000100
+
+	belowNormalPriorityClass = 0x00004000
+	idlePriorityClass        = 0x00000040
+
+	processSetQuota      = 0x0100
+	processTerminate     = 0x0001
+	processSuspendResume = 0x0800
+
+	createSuspended = 0x00000004
+)
+
+type jobObjectBasicLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+}
+
+type ioCounters struct {
+	ReadOperationCount, WriteOperationCount, OtherOperationCount uint64
+	ReadTransferCount, WriteTransferCount, OtherTransferCount    uint64
+}
+
+type jobObjectExtendedLimitInformation struct {
+	BasicLimitInformation jobObjectBasicLimitInformation
+	IoInfo                ioCounters
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+// processTree is a command's Job Object, which every process it starts
+// joins. The job also carries the resource limits.
+type processTree struct {
+	cmd *exec.Cmd
+	job uintptr // 0 if the job could not be set up
+}
+
+// isolate has a command start suspended, so that it cannot start processes
+// of its own before newProcessTree has put it in its job and resumed it.
+func isolate(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= createSuspended
+}
+
+// newProcessTree puts a command started after isolate in a new Job Object
+// with the CPU, memory and priority limits, then resumes it. Without a job,
+// stopping the command stops only its own process, and it runs without the
+// limits.
+func newProcessTree(cmd *exec.Cmd) *processTree {
+	t := &processTree{cmd: cmd}
+	process, err := syscall.OpenProcess(processSetQuota|processTerminate|processSuspendResume, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		// It cannot be resumed either, so it would never run.
+		cmd.Process.Kill()
+		return t
+	}
+	defer syscall.CloseHandle(process)
+	defer procNtResumeProcess.Call(uintptr(process))
+	t.job = newJob(process)
+	return t
+}
+
+// newJob creates a Job Object with the configured limits and assigns a
+// process to it. It returns 0 if that fails.
+func newJob(process syscall.Handle) uintptr {
+	var info jobObjectExtendedLimitInformation
+	basic := &info.BasicLimitInformation
+	l := cfg.Limits
+	if l.CPUSeconds > 0 {
+		basic.LimitFlags |= jobObjectLimitProcessTime
+		basic.PerProcessUserTimeLimit = int64(l.CPUSeconds) * 10_000_000 // 100ns units
+	}
+	if l.MemoryMB > 0 {
+		basic.LimitFlags |= jobObjectLimitProcessMemory
+		info.ProcessMemoryLimit = uintptr(l.MemoryMB) << 20
+	}
+	if l.Nice > 0 {
+		basic.LimitFlags |= jobObjectLimitPriorityClass
+		basic.PriorityClass = belowNormalPriorityClass
+		if l.Nice >= 15 {
+			basic.PriorityClass = idlePriorityClass
+		}
+	}
+
+	job, _, _ := procCreateJobObject.Call(0, 0)
+	if job == 0 {
+		return 0
+	}
+	if basic.LimitFlags != 0 {
+		procSetInformationJobObject.Call(job, jobObjectExtendedLimitInformationClass, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	}
+	if ok, _, _ := procAssignProcessToJobObject.Call(job, uintptr(process)); ok == 0 {
+		syscall.CloseHandle(syscall.Handle(job))
+		return 0
+	}
+	return job
+}
+
+// terminate stops the tree. Windows has no polite equivalent of SIGTERM for
+// console programs that reliably reaches a whole tree, so it kills.
+func (t *processTree) terminate() { t.kill() }
+
+// kill kills every process in the job.
+func (t *processTree) kill() {
+	if t.job == 0 {
+		t.cmd.Process.Kill()
+		return
+	}
+	procTerminateJobObject.Call(t.job, 1)
+}
+
+// release closes the job. Processes the command left running in the
+// background keep running.
+func (t *processTree) release() {
+	if t.job != 0 {
+		syscall.CloseHandle(syscall.Handle(t.job))
+	}
+}
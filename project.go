@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// A project may pin its own settings in a .shai.json file, found by walking
+// up from the working directory and merged over the global configuration.
+// Since a cloned repository could use it to loosen the safety policy, each
+// version of the file has to be trusted by the user once before it applies.
+
+const projectConfigName = ".shai.json"
+
+// projectConfigPath is the nearest .shai.json at or above the working
+// directory, or "" if there is none.
+func projectConfigPath() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, projectConfigName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func trustedProjectsPath() (string, error) {
+	dir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "trusted-projects.json"), nil
+}
+
+// loadTrustedProjects maps project config paths to the SHA-256 of the
+// contents the user trusted.
+func loadTrustedProjects() map[string]string {
+	trusted := map[string]string{}
+	if path, err := trustedProjectsPath(); err == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			json.Unmarshal(data, &trusted)
+		}
+	}
+	return trusted
+}
+
+func trustProject(path string, sum string) error {
+	trusted := loadTrustedProjects()
+	trusted[path] = sum
+	file, err := trustedProjectsPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(trusted, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0600)
+}
+
+// loadProjectConfig merges the project config, if any, into cfg, asking the
+// user to trust it first if it is new or has changed. Without ask, an
+// untrusted project config is skipped silently.
+func loadProjectConfig(ask bool) error {
+	path := projectConfigPath()
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read project config %s: %w", path, err)
+	}
+	digest := sha256.Sum256(data)
+	sum := hex.EncodeToString(digest[:])
+
+	if loadTrustedProjects()[path] != sum {
+		if !ask {
+			return nil
+		}
+		if cfg.NonInteractive || *nonInteractiveFlag {
+			fmt.Printf("⚠️ Ignoring the untrusted project config %s; run shai interactively once to trust it.\n", path)
+			return nil
+		}
+		fmt.Printf("📁 This project has a shai config, %s:\n\n%s\n", path, data)
+		if !confirmAction("Apply it? It can change the model and the safety policy.", stdinReader) {
+			return nil
+		}
+		if err := trustProject(path, sum); err != nil {
+			fmt.Printf("⚠️ Failed to remember that the project config is trusted: %v\n", err)
+		}
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("failed to parse project config %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// A custom system prompt is a Go text/template file named by
+// "system_prompt_template". It is executed with PromptData, so it can reword
+// the rules for a particular model while keeping the parts shai fills in, or
+// wrap the built-in prompt with {{.Default}}.
+
+// PromptData is what a system prompt template can use.
+type PromptData struct {
+	Task  string
+	OS    string
+	Shell string
+	PWD   string
+	// Environment is the CURRENT ENVIRONMENT block: OS release, shell,
+	// directory, package manager, tools, git and network state.
+	Environment string
+	// Sections are the instructions for the enabled actions and tools
+	// (WRITE_FILE, SEARCH_FILES, MCP and so on); without them the model
+	// does not know how to use them.
+	Sections string
+	// Default is the built-in prompt, fully rendered.
+	Default string
+	// Vars are the config file's "prompt_vars".
+	Vars map[string]string
+}
+
+var promptTemplate *template.Template
+
+// promptTemplatePath resolves system_prompt_template: ~ is expanded and a
+// relative path is taken relative to the config file.
+func promptTemplatePath() string {
+	path := expandPath(cfg.SystemPromptTemplate)
+	if !filepath.IsAbs(path) {
+		if configPath, err := getConfigFilePath(); err == nil {
+			path = filepath.Join(filepath.Dir(configPath), path)
+		}
+	}
+	return path
+}
+
+// loadPromptTemplate parses the system prompt template, if one is set, so
+// mistakes in it are reported before a run starts.
+func loadPromptTemplate() error {
+	promptTemplate = nil
+	if cfg.SystemPromptTemplate == "" {
+		return nil
+	}
+	path := promptTemplatePath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read system_prompt_template: %w", err)
+	}
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").Funcs(template.FuncMap{
+		"env":   os.Getenv,
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+	}).Parse(string(data))
+	if err != nil {
+		return fmt.Errorf("invalid system_prompt_template: %w", err)
+	}
+	promptTemplate = tmpl
+	return nil
+}
+
+// renderPromptTemplate executes the custom template, falling back to the
+// built-in prompt if it fails.
+func renderPromptTemplate(data PromptData) string {
+	var out strings.Builder
+	if err := promptTemplate.Execute(&out, data); err != nil {
+		fmt.Printf("⚠️ The system prompt template failed (%v); using the built-in prompt.\n", err)
+		return data.Default
+	}
+	return out.String()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// PTYConfig controls running commands in a pseudo-terminal, for programs
+// that misbehave or hang without one. Mode is "off" (the default), "auto"
+// to use a PTY for known interactive and TTY-aware programs plus Programs,
+// or "always". The PTY is provided by the system's script(1).
+type PTYConfig struct {
+	Mode     string   `json:"mode"`
+	Programs []string `json:"programs"`
+}
+
+// ptyPrograms are programs that need a terminal to work properly: full
+// screen and interactive tools, and ones that only show progress or prompt
+// when attached to one.
+var ptyPrograms = map[string]bool{
+	"top": true, "htop": true, "btop": true, "atop": true, "watch": true,
+	"vi": true, "vim": true, "nvim": true, "nano": true, "emacs": true,
+	"less": true, "more": true, "man": true,
+	"ssh": true, "telnet": true, "sftp": true, "ftp": true, "mosh": true,
+	"apt": true, "aptitude": true, "passwd": true,
+	"tmux": true, "screen": true,
+}
+
+func checkPTY() error {
+	switch cfg.PTY.Mode {
+	case "off", "auto", "always":
+		return nil
+	}
+	return fmt.Errorf("unknown pty mode %q (expected off, auto or always)", cfg.PTY.Mode)
+}
+
+// usePTY reports whether command should run in a PTY. Commands that
+// elevate always do when someone is at the terminal, so that the password
+// prompt reaches them.
+func usePTY(command string) bool {
+	if runtime.GOOS == "windows" {
+		return false
+	}
+	switch {
+	case cfg.PTY.Mode == "always":
+	case elevates(command) && canPromptForPassword():
+	case cfg.PTY.Mode == "auto":
+		if !needsTerminal(command) {
+			return false
+		}
+	default:
+		return false
+	}
+	_, err := exec.LookPath("script")
+	return err == nil
+}
+
+func needsTerminal(command string) bool {
+	for _, words := range splitPipeline(command) {
+		for len(words) > 1 && (strings.Contains(words[0], "=") || words[0] == "sudo" || words[0] == "env" || words[0] == "exec") {
+			words = words[1:]
+		}
+		name := filepath.Base(words[0])
+		if ptyPrograms[name] || slices.Contains(cfg.PTY.Programs, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ptyCommand runs script in shell inside a PTY. The terminal is connected
+// so the user can interact with the program; script puts it in raw mode.
+func ptyCommand(shellPath string, script string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "linux" {
+		cmd = exec.Command("script", "-qefc", shellQuote(shellPath)+" -c "+shellQuote(script), "/dev/null")
+	} else {
+		cmd = exec.Command("script", "-q", "/dev/null", shellPath, "-c", script)
+	}
+	if !cfg.NonInteractive && isTerminal(os.Stdin) {
+		cmd.Stdin = os.Stdin
+	}
+	return cmd
+}
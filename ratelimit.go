@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig caps how hard shai hits the model API. Zero means no limit.
+// Hosted providers answer 429 when a quota is exceeded; those responses are
+// retried up to MaxRetries times, honouring Retry-After when it is sent.
+type RateLimitConfig struct {
+	RequestsPerMinute int `json:"requests_per_minute"`
+	TokensPerMinute   int `json:"tokens_per_minute"`
+	MaxConcurrent     int `json:"max_concurrent"`
+	MaxRetries        int `json:"max_retries"`
+}
+
+type rateLimitUsage struct {
+	at     time.Time
+	tokens int
+}
+
+// rateLimiter keeps a one-minute sliding window of requests and their token
+// usage, shared by every agent in the process.
+type rateLimiter struct {
+	mu      sync.Mutex
+	window  []*rateLimitUsage
+	slots   chan struct{}
+	slotsMu sync.Mutex
+}
+
+var limiter rateLimiter
+
+func estimateRequestTokens(messages []Message) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += estimateTokens(message.Content)
+	}
+	return tokens
+}
+
+// acquireRateLimit blocks until a request of roughly the given size fits
+// within the configured limits. The returned release function must be called
+// with the actual number of tokens used (or 0 if unknown) once the request is
+// done.
+func acquireRateLimit(ctx context.Context, estimatedTokens int) (release func(tokens int), err error) {
+	limits := cfg.RateLimit
+	slots := limiter.concurrencySlots(limits.MaxConcurrent)
+
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	releaseSlot := func() {
+		if slots != nil {
+			<-slots
+		}
+	}
+
+	usage, err := limiter.reserve(ctx, limits, estimatedTokens)
+	if err != nil {
+		releaseSlot()
+		return nil, err
+	}
+
+	return func(tokens int) {
+		if tokens > 0 {
+			limiter.mu.Lock()
+			usage.tokens = tokens
+			limiter.mu.Unlock()
+		}
+		releaseSlot()
+	}, nil
+}
+
+func (l *rateLimiter) concurrencySlots(max int) chan struct{} {
+	if max <= 0 {
+		return nil
+	}
+	l.slotsMu.Lock()
+	defer l.slotsMu.Unlock()
+	if l.slots == nil || cap(l.slots) != max {
+		l.slots = make(chan struct{}, max)
+	}
+	return l.slots
+}
+
+func (l *rateLimiter) reserve(ctx context.Context, limits RateLimitConfig, tokens int) (*rateLimitUsage, error) {
+	announced := false
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		for len(l.window) > 0 && now.Sub(l.window[0].at) >= time.Minute {
+			l.window = l.window[1:]
+		}
+
+		used := 0
+		for _, usage := range l.window {
+			used += usage.tokens
+		}
+
+		requestsOK := limits.RequestsPerMinute <= 0 || len(l.window) < limits.RequestsPerMinute
+		// A single request larger than the whole budget is let through once
+		// the window is empty, otherwise it could never be sent.
+		tokensOK := limits.TokensPerMinute <= 0 || used+tokens <= limits.TokensPerMinute || len(l.window) == 0
+		if requestsOK && tokensOK {
+			usage := &rateLimitUsage{at: now, tokens: tokens}
+			l.window = append(l.window, usage)
+			l.mu.Unlock()
+			return usage, nil
+		}
+
+		wait := time.Minute - now.Sub(l.window[0].at)
+		l.mu.Unlock()
+
+		if !announced {
+			noticef(ctx, "⏳ Local rate limit reached, waiting %s before the next request...\n", wait.Round(time.Second))
+			announced = true
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date, falling back to exponential backoff.
+func retryAfter(header string, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return time.Duration(1<<attempt) * time.Second
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
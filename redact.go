@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RedactConfig controls the masking of secrets in command output before it
+// is sent to the model or written to disk. Patterns are RE2 regular
+// expressions; if a pattern has a capturing group, only the first group is
+// masked, so the surrounding context stays readable.
+type RedactConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Builtin  bool     `json:"builtin"`
+	Patterns []string `json:"patterns"`
+}
+
+const redactedText = "[REDACTED]"
+
+// builtinRedactions match common credentials: AWS keys, bearer tokens,
+// GitHub and Slack tokens, private key blocks and password assignments.
+var builtinRedactions = []string{
+	`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`,
+	`(?i)aws_secret_access_key["']?\s*[=:]\s*["']?([A-Za-z0-9/+=]{40})`,
+	`(?i)\bbearer\s+([A-Za-z0-9._~+/=-]{8,})`,
+	`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`,
+	`\b(xox[abposr]-[A-Za-z0-9-]{10,})\b`,
+	`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`,
+	`(?i)\b(?:password|passwd|pwd|secret|token|api[_-]?key)["']?\s*[=:]\s*["']?([^\s"',;]{4,})`,
+	`(?i)\b[a-z][a-z0-9+.-]*://[^\s:/@]+:([^\s@/]+)@`,
+}
+
+var redactRules []*regexp.Regexp
+
+// compileRedactions compiles the built-in and configured patterns so that a
+// bad pattern is reported before the agent starts.
+func compileRedactions() error {
+	redactRules = nil
+	if !cfg.Redact.Enabled {
+		return nil
+	}
+	var patterns []string
+	if cfg.Redact.Builtin {
+		patterns = append(patterns, builtinRedactions...)
+	}
+	patterns = append(patterns, cfg.Redact.Patterns...)
+
+	for _, source := range patterns {
+		pattern, err := regexp.Compile(source)
+		if err != nil {
+			return fmt.Errorf("invalid redact pattern %q: %w", source, err)
+		}
+		redactRules = append(redactRules, pattern)
+	}
+	return nil
+}
+
+// redact masks every secret the redaction rules find in text.
+func redact(text string) string {
+	for _, pattern := range redactRules {
+		if pattern.NumSubexp() == 0 {
+			text = pattern.ReplaceAllLiteralString(text, redactedText)
+			continue
+		}
+		text = pattern.ReplaceAllStringFunc(text, func(match string) string {
+			group := pattern.FindStringSubmatchIndex(match)
+			if group[2] < 0 {
+				return match
+			}
+			return match[:group[2]] + redactedText + match[group[3]:]
+		})
+	}
+	return text
+}
+
+// redactMessages returns a copy of messages with secrets masked, for
+// writing transcripts to disk.
+func redactMessages(messages []Message) []Message {
+	if len(redactRules) == 0 {
+		return messages
+	}
+	redacted := make([]Message, len(messages))
+	for i, message := range messages {
+		message.Content = redact(message.Content)
+		redacted[i] = message
+	}
+	return redacted
+}
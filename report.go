@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StepRecord is one command, query, tool call or file write of a session as
+// it appears in the Markdown transcript. Output is trimmed and redacted.
+type StepRecord struct {
+	Step     int       `json:"step"`
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Command  string    `json:"command"`
+	Risk     string    `json:"risk,omitempty"`
+	Decision string    `json:"decision,omitempty"`
+	Status   string    `json:"status"`
+	Output   string    `json:"output,omitempty"`
+}
+
+// reportOutputLines is how many lines of output each step keeps in the
+// transcript, split between the head and the tail.
+const reportOutputLines = 40
+
+func (a *Agent) recordStep(action, command, risk, decision, status, output string) {
+	a.Steps = append(a.Steps, StepRecord{
+		Step:     a.Step,
+		Time:     time.Now(),
+		Action:   action,
+		Command:  redact(command),
+		Risk:     risk,
+		Decision: decision,
+		Status:   status,
+		Output:   redact(trimReportOutput(output)),
+	})
+}
+
+func trimReportOutput(output string) string {
+	output = strings.TrimPrefix(strings.TrimSpace(output), "OUTPUT:\n")
+	lines := strings.Split(output, "\n")
+	if len(lines) <= reportOutputLines {
+		return output
+	}
+	head, tail := lines[:reportOutputLines/2], lines[len(lines)-reportOutputLines/2:]
+	return fmt.Sprintf("%s\n[... %d lines elided ...]\n%s", strings.Join(head, "\n"), len(lines)-reportOutputLines, strings.Join(tail, "\n"))
+}
+
+func reportsDir() (string, error) {
+	dir := expandPath(cfg.ReportsDir)
+	if dir == "" {
+		state, err := getStateDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(state, "reports")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create reports directory %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// writeReport writes a session's Markdown transcript to the reports
+// directory and returns its path.
+func writeReport(session Session) (string, error) {
+	dir, err := reportsDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, session.ID+".md")
+	return path, os.WriteFile(path, []byte(renderReport(session)), 0600)
+}
+
+// renderReport formats a session for pasting into pull requests and
+// incident documents.
+func renderReport(session Session) string {
+	var md strings.Builder
+	fmt.Fprintf(&md, "# shai session %s\n\n", session.ID)
+	fmt.Fprintf(&md, "- **Task:** %s\n", strings.TrimSpace(session.Task))
+	fmt.Fprintf(&md, "- **Directory:** `%s`\n", session.Dir)
+	fmt.Fprintf(&md, "- **Model:** %s\n", session.Model)
+	fmt.Fprintf(&md, "- **Started:** %s\n", session.Created.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&md, "- **Duration:** %s\n", session.Updated.Sub(session.Created).Round(time.Second))
+	fmt.Fprintf(&md, "- **Status:** %s\n", session.Status)
+	if len(session.Usage) > 0 {
+		fmt.Fprintf(&md, "- **Usage:** %s\n", session.Usage.describe())
+	}
+
+	if len(session.Plan) > 0 {
+		md.WriteString("\n## Plan\n\n")
+		for i, step := range session.Plan {
+			mark := " "
+			if step.Done {
+				mark = "x"
+			}
+			fmt.Fprintf(&md, "- [%s] %d. %s\n", mark, i+1, step.Text)
+		}
+	}
+
+	md.WriteString("\n## Steps\n")
+	if len(session.Steps) == 0 {
+		md.WriteString("\nNo commands were proposed.\n")
+	}
+	for i, step := range session.Steps {
+		fmt.Fprintf(&md, "\n### %d. %s", i+1, step.Action)
+		var details []string
+		for _, detail := range []string{step.Risk, step.Decision} {
+			if detail != "" {
+				details = append(details, strings.ReplaceAll(detail, "_", "-"))
+			}
+		}
+		if len(details) > 0 {
+			fmt.Fprintf(&md, " (%s)", strings.Join(details, ", "))
+		}
+		fmt.Fprintf(&md, ": %s\n\n", step.Status)
+
+		language := ""
+		if step.Action == "RUN" {
+			language = "sh"
+		} else if step.Action == "SQL" {
+			language = "sql"
+		}
+		md.WriteString(fenced(step.Command, language))
+		if step.Output != "" {
+			label := "Output"
+			if step.Action == "WRITE_FILE" {
+				label = "New contents"
+			}
+			fmt.Fprintf(&md, "\n<details><summary>%s</summary>\n\n%s\n</details>\n", label, fenced(step.Output, ""))
+		}
+	}
+
+	if len(session.Changes) > 0 {
+		md.WriteString("\n## Files changed\n\n")
+		for _, change := range session.Changes {
+			fmt.Fprintf(&md, "- %s `%s`\n", change.Change, change.Path)
+		}
+		for _, change := range session.Changes {
+			if change.Diff != "" {
+				md.WriteString("\n" + fenced(change.Diff, "diff"))
+			}
+		}
+	}
+
+	if len(session.Timings) > 0 {
+		md.WriteString("\n## Timing\n\n" + renderTimings(session.Timings))
+	}
+
+	if summary := strings.TrimSpace(session.Summary); summary != "" {
+		fmt.Fprintf(&md, "\n## Result\n\n%s\n", summary)
+	}
+	return md.String()
+}
+
+// fenced wraps text in a code fence longer than any backtick run inside it.
+func fenced(text string, language string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fmt.Sprintf("%s%s\n%s\n%s\n", fence, language, strings.TrimRight(text, "\n"), fence)
+}
+
+// exportCommand regenerates a session's transcript (the latest session's
+// without an ID), writing it to the reports directory, to the given file, or
+// to stdout for "-".
+func exportCommand(args []string) error {
+	id, file := "", []string(nil)
+	if len(args) > 0 {
+		id, file = args[0], args[1:]
+	}
+	session, err := findSession(id)
+	if err != nil {
+		return err
+	}
+	if len(file) == 1 && file[0] == "-" {
+		fmt.Print(renderReport(session))
+		return nil
+	}
+	path := ""
+	if len(file) == 1 {
+		path = expandPath(file[0])
+		err = os.WriteFile(path, []byte(renderReport(session)), 0644)
+	} else {
+		path, err = writeReport(session)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the transcript: %w", err)
+	}
+	fmt.Printf("📝 Transcript written to %s\n", path)
+	return nil
+}
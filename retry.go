@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// RetryConfig controls how model API calls that fail transiently (network
+// errors, 5xx responses, a model that is still loading) are retried. Rate
+// limiting (429) is retried separately, under rate_limit.max_retries.
+type RetryConfig struct {
+	MaxRetries       int     `json:"max_retries"`
+	BaseDelaySeconds float64 `json:"base_delay_seconds"`
+	MaxDelaySeconds  float64 `json:"max_delay_seconds"`
+}
+
+// retryableStatus reports whether an HTTP status is worth retrying: server
+// errors and timeouts are, client errors are not.
+func retryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusRequestTimeout
+}
+
+// retryableError reports whether a failed request is worth retrying. A
+// cancelled context is the user stopping the call.
+func retryableError(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && !errors.Is(err, context.Canceled)
+}
+
+// backoffDelay is the wait before retry number attempt (from 0): the base
+// delay doubled per attempt, capped, with jitter so that parallel agents do
+// not retry in lockstep.
+func backoffDelay(attempt int) time.Duration {
+	delay := cfg.Retry.BaseDelaySeconds * float64(int(1)<<min(attempt, 16))
+	if cfg.Retry.MaxDelaySeconds > 0 {
+		delay = min(delay, cfg.Retry.MaxDelaySeconds)
+	}
+	delay = delay/2 + rand.Float64()*delay/2
+	return time.Duration(delay * float64(time.Second))
+}
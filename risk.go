@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Risk tiers of a proposed command.
+const (
+	riskReadOnly    = "read-only"
+	riskMutating    = "mutating"
+	riskDestructive = "destructive"
+)
+
+// Approval decisions.
+const (
+	approvalAllow  = "allow"
+	approvalPrompt = "prompt"
+	approvalDeny   = "deny"
+)
+
+// RiskConfig extends the built-in risk ruleset: ReadOnlyCommands are extra
+// program names that never change anything, Destructive extra regular
+// expressions for commands that destroy data.
+type RiskConfig struct {
+	ReadOnlyCommands []string `json:"read_only_commands"`
+	Destructive      []string `json:"destructive"`
+}
+
+// approvalPolicies map each risk tier to a decision. "manual" is the
+// default; --yes selects "auto".
+var approvalPolicies = map[string]map[string]string{
+	"manual":     {riskReadOnly: approvalPrompt, riskMutating: approvalPrompt, riskDestructive: approvalPrompt},
+	"auto":       {riskReadOnly: approvalAllow, riskMutating: approvalPrompt, riskDestructive: approvalDeny},
+	"unattended": {riskReadOnly: approvalAllow, riskMutating: approvalAllow, riskDestructive: approvalDeny},
+}
+
+// readOnlyCommands never modify anything on their own; a command line made
+// only of these (and without redirections or substitutions) is read-only.
+// Some are read-only only with the arguments readOnlyArgs allows.
+var readOnlyCommands = map[string]bool{
+	"ls": true, "cat": true, "head": true, "tail": true, "less": true, "grep": true,
+	"egrep": true, "rg": true, "wc": true, "stat": true, "file": true, "pwd": true,
+	"echo": true, "printf": true, "which": true, "type": true, "whoami": true, "id": true,
+	"uname": true, "df": true, "du": true, "ps": true, "date": true, "find": true,
+	"cd": true, "test": true, "[": true, "true": true, "diff": true, "sort": true,
+	"uniq": true, "cut": true, "tr": true, "jq": true, "tree": true,
+	"realpath": true, "readlink": true, "basename": true, "dirname": true, "hostname": true,
+}
+
+// readOnlySubcommands are read-only invocations of otherwise mutating tools.
+// git branch and git remote only list with the flags in gitListingFlags.
+var readOnlySubcommands = map[string][]string{
+	"git":       {"status", "log", "diff", "show", "branch", "remote", "rev-parse", "ls-files", "blame"},
+	"kubectl":   {"get", "describe", "logs", "top", "explain", "version", "api-resources"},
+	"docker":    {"ps", "images", "logs", "inspect", "version", "info"},
+	"systemctl": {"status", "is-active", "is-enabled", "list-units"},
+}
+
+// gitListingFlags are the flags with which git branch and git remote only
+// list.
+var gitListingFlags = map[string][]string{
+	"branch": {"-a", "-r", "-v", "-vv", "--all", "--remotes", "--verbose", "--list", "--show-current"},
+	"remote": {"-v", "--verbose"},
+}
+
+// readOnlyArgs report whether the arguments keep a program in
+// readOnlyCommands from writing: sort -o and tree -o write a file, uniq
+// writes its second operand, date -s and hostname NAME set the clock and the
+// hostname, and find has actions that delete, run or write.
+var readOnlyArgs = map[string]func(args []string) bool{
+	"uniq": func(args []string) bool {
+		return len(operands(args, "-f", "-s", "-w")) <= 1
+	},
+	"tree": func(args []string) bool {
+		return !slices.ContainsFunc(args, func(arg string) bool { return arg == "-o" })
+	},
+	"hostname": func(args []string) bool {
+		return len(operands(args)) == 0 && !slices.ContainsFunc(args, func(arg string) bool {
+			return strings.HasPrefix(arg, "--file") || strings.HasPrefix(arg, "--boot") || shortOption(arg, 'F') || shortOption(arg, 'b')
+		})
+	},
+	"sort": func(args []string) bool {
+		return !slices.ContainsFunc(args, func(arg string) bool {
+			return strings.HasPrefix(arg, "--output") || shortOption(arg, 'o')
+		})
+	},
+	"date": func(args []string) bool {
+		return !slices.ContainsFunc(args, func(arg string) bool {
+			return strings.HasPrefix(arg, "--set") || shortOption(arg, 's')
+		})
+	},
+	"find": func(args []string) bool {
+		return !slices.ContainsFunc(args, func(arg string) bool {
+			return arg == "-delete" || strings.HasPrefix(arg, "-exec") || strings.HasPrefix(arg, "-ok") || strings.HasPrefix(arg, "-fprint") || arg == "-fls"
+		})
+	},
+}
+
+// shellExpansion matches command and process substitutions, which run
+// commands the classifier does not see.
+var shellExpansion = regexp.MustCompile("\\$\\(|`|[<>]\\(")
+
+// harmlessRedirection matches redirections that neither read nor write a
+// file: duplicating a descriptor and discarding output.
+var harmlessRedirection = regexp.MustCompile(`\d*>&\d+|&?\d*>\s*/dev/null\b`)
+
+var builtinDestructive = []string{
+	`\brm\s+(-[a-zA-Z]*\s+)*-[a-zA-Z]*[rRf]`,
+	`\brm\s(.*\s)?--(recursive|force)\b`,
+	`\b(dd|mkfs(\.\w+)?|wipefs|shred|fdisk|parted|sgdisk)\b`,
+	`\bgit\s+(reset\s+--hard|clean\s+-[a-zA-Z]*f|push\s+.*(--force|-f\b)|branch\s+-D|checkout\s+--\s)`,
+	`\bfind\b.*\s-delete\b`,
+	`\b(kubectl|helm)\s+(delete|uninstall|drain)\b`,
+	`\bdocker\s+(rm|rmi|system\s+prune|volume\s+(rm|prune))\b`,
+	`\bterraform\s+destroy\b`,
+	`(?i)\b(drop|truncate)\s+(table|database|schema)\b`,
+	`(?i)\bdelete\s+from\b`,
+	`\btruncate\s`,
+	`\bchmod\s+(-[a-zA-Z]*\s+)*-[a-zA-Z]*R`,
+	`\bchown\s+(-[a-zA-Z]*\s+)*-[a-zA-Z]*R`,
+}
+
+var destructivePatterns []*regexp.Regexp
+
+// compileRiskRules compiles the built-in and configured destructive
+// patterns and checks the approval policy.
+func compileRiskRules() error {
+	if _, ok := approvalPolicies[cfg.Approval]; !ok {
+		return fmt.Errorf("unknown approval policy %q (use manual, auto or unattended)", cfg.Approval)
+	}
+	destructivePatterns = nil
+	for _, source := range append(slices.Clone(builtinDestructive), cfg.Risk.Destructive...) {
+		pattern, err := regexp.Compile(source)
+		if err != nil {
+			return fmt.Errorf("invalid destructive pattern %q: %w", source, err)
+		}
+		destructivePatterns = append(destructivePatterns, pattern)
+	}
+	return nil
+}
+
+// classifyRisk tags a command line as read-only, mutating or destructive.
+func classifyRisk(command string) string {
+	for _, pattern := range destructivePatterns {
+		if pattern.MatchString(command) {
+			return riskDestructive
+		}
+	}
+	if len(writtenPaths(command)) > 0 || shellExpansion.MatchString(command) || strings.ContainsAny(harmlessRedirection.ReplaceAllString(command, ""), "<>") {
+		return riskMutating
+	}
+	for _, words := range splitPipeline(command) {
+		if !readOnlyWords(words) {
+			return riskMutating
+		}
+	}
+	return riskReadOnly
+}
+
+// readOnlyWords reports whether a simple command only reads.
+func readOnlyWords(words []string) bool {
+	name := filepath.Base(words[0])
+	args := words[1:]
+	if name == "env" {
+		return len(args) == 0
+	}
+	if readOnlyCommands[name] || slices.Contains(cfg.Risk.ReadOnlyCommands, name) {
+		check, ok := readOnlyArgs[name]
+		return !ok || check(args)
+	}
+	if len(args) == 0 || !slices.Contains(readOnlySubcommands[name], args[0]) {
+		return false
+	}
+	if name != "git" {
+		return true
+	}
+	if flags, ok := gitListingFlags[args[0]]; ok {
+		return !slices.ContainsFunc(args[1:], func(arg string) bool { return !slices.Contains(flags, arg) })
+	}
+	// git diff, log and show write their output to a file with --output.
+	return !slices.ContainsFunc(args[1:], func(arg string) bool { return strings.HasPrefix(arg, "--output") })
+}
+
+// operands returns the arguments that are not options, skipping the values
+// of the short options in valued when given as separate words.
+func operands(args []string, valued ...string) []string {
+	var operands []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--":
+			return append(operands, args[i+1:]...)
+		case slices.Contains(valued, args[i]):
+			i++
+		case !strings.HasPrefix(args[i], "-") || args[i] == "-":
+			operands = append(operands, args[i])
+		}
+	}
+	return operands
+}
+
+// shortOption reports whether arg is a cluster of short options that
+// includes letter.
+func shortOption(arg string, letter rune) bool {
+	return strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.ContainsRune(arg[1:], letter)
+}
+
+// approvalFor returns the approval policy's decision for a risk tier.
+func approvalFor(risk string) string {
+	approval := approvalPolicies[cfg.Approval][risk]
+	if approval == approvalPrompt && cfg.NonInteractive {
+		return approvalDeny
+	}
+	return approval
+}
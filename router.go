@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RouterConfig enables picking between a small and a large model based on
+// how complex the task looks. In "task" mode the choice is made once per
+// task; in "step" mode the agent additionally escalates to the large model
+// after a failed step and drops back once things are going well again.
+type RouterConfig struct {
+	Enabled    bool   `json:"enabled"`
+	SmallModel string `json:"small_model"`
+	LargeModel string `json:"large_model"`
+	Mode       string `json:"mode"`
+	Method     string `json:"method"`
+}
+
+const routerClassifierPrompt = `You decide how capable a model a shell automation task needs.
+Reply with exactly one word: SIMPLE if the task is a single, well-defined action a small model can do in a few commands, or COMPLEX if it needs investigation, multiple stages, debugging or careful reasoning.`
+
+var multiStepPattern = regexp.MustCompile(`(?i)\b(then|after that|afterwards|each|every|all|recursively|and also)\b|;`)
+var hardTaskPattern = regexp.MustCompile(`(?i)\b(why|debug|diagnose|investigate|fix|migrate|refactor|optimi[sz]e|deploy|configure|troubleshoot|upgrade)\b`)
+
+func routerEnabled() bool {
+	return cfg.Router.Enabled && cfg.Router.SmallModel != "" && cfg.Router.LargeModel != ""
+}
+
+// estimateComplexity is a cheap heuristic: long tasks, tasks made of several
+// stages and tasks that need investigation go to the large model.
+func estimateComplexity(task string) (isComplex bool, reason string) {
+	var reasons []string
+	if len(strings.Fields(task)) > 25 {
+		reasons = append(reasons, "long description")
+	}
+	if multiStepPattern.MatchString(task) {
+		reasons = append(reasons, "multiple stages")
+	}
+	if hardTaskPattern.MatchString(task) {
+		reasons = append(reasons, "needs investigation")
+	}
+	if len(reasons) >= 2 {
+		return true, strings.Join(reasons, ", ")
+	}
+	if len(reasons) == 0 {
+		return false, "short single-stage task"
+	}
+	return false, reasons[0] + " only"
+}
+
+func classifyComplexity(task string) (isComplex bool, reason string) {
+	resp, err := callModel(cfg.Router.SmallModel, []Message{{Role: "user", Content: task}}, routerClassifierPrompt)
+	if err != nil {
+		isComplex, reason = estimateComplexity(task)
+		return isComplex, fmt.Sprintf("classifier failed (%v), heuristic: %s", err, reason)
+	}
+	verdict := strings.ToUpper(strings.TrimSpace(resp.Message.Content))
+	return strings.Contains(verdict, "COMPLEX"), "classifier verdict " + strings.Fields(verdict + " ?")[0]
+}
+
+func (a *Agent) useModel(model string, reason string) {
+	if model == a.Model {
+		return
+	}
+	a.Model = model
+	a.printf("🧭 Using model %s (%s)\n", model, reason)
+	a.record("model", fmt.Sprintf("%s: %s", model, reason))
+}
+
+// routeTask picks the model for the whole task before the first step.
+func (a *Agent) routeTask() {
+	if !routerEnabled() {
+		return
+	}
+
+	var isComplex bool
+	var reason string
+	if cfg.Router.Method == "classifier" {
+		isComplex, reason = classifyComplexity(a.Task)
+	} else {
+		isComplex, reason = estimateComplexity(a.Task)
+	}
+
+	a.Model = ""
+	if isComplex {
+		a.taskModel = cfg.Router.LargeModel
+		a.useModel(a.taskModel, "complex task: "+reason)
+	} else {
+		a.taskModel = cfg.Router.SmallModel
+		a.useModel(a.taskModel, "simple task: "+reason)
+	}
+}
+
+// routeStep escalates to the large model after a failed step and returns to
+// the task's model once the last step succeeded.
+func (a *Agent) routeStep() {
+	if !routerEnabled() || cfg.Router.Mode != "step" || a.Step == 0 {
+		return
+	}
+	if a.failures > 0 {
+		a.useModel(cfg.Router.LargeModel, "escalating after a failed step")
+	} else {
+		a.useModel(a.taskModel, "previous step succeeded")
+	}
+}
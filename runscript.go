@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// RUN_SCRIPT runs a multi-line script in bash, Python or PowerShell, so the
+// model need not cram logic and quoting onto one shell line. The script goes
+// through the same checks and approval as RUN, is written to a temporary
+// file and runs with the language's interpreter, started from the user's
+// shell.
+
+const runScriptPromptSection = `
+SCRIPTS:
+   - "RUN_SCRIPT" followed by a language (%s) on the same line, and the script on the following lines, runs the script with that language's interpreter once the user approves it. Prefer it to RUN for anything that needs several lines, loops or heavy quoting. Do not put the script in a code fence.
+`
+
+// scriptLanguage is a RUN_SCRIPT language: its interpreters in order of
+// preference, the script file's extension and the arguments that run it.
+type scriptLanguage struct {
+	interpreters []string
+	extension    string
+	args         func(path string) []string
+}
+
+var scriptLanguages = map[string]scriptLanguage{
+	"bash":   {[]string{"bash"}, ".sh", func(path string) []string { return []string{path} }},
+	"python": {[]string{"python3", "python"}, ".py", func(path string) []string { return []string{path} }},
+	"powershell": {[]string{"pwsh", "powershell"}, ".ps1", func(path string) []string {
+		return []string{"-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", path}
+	}},
+}
+
+// scriptLanguageNames are the languages in the order the prompt lists them.
+var scriptLanguageNames = []string{"bash", "python", "powershell"}
+
+// scriptAliases map other names models use to a language.
+var scriptAliases = map[string]string{
+	"sh": "bash", "shell": "bash",
+	"py": "python", "python3": "python",
+	"pwsh": "powershell", "ps1": "powershell", "ps": "powershell",
+}
+
+// interpreter returns the first of the language's interpreters on the PATH.
+func (l scriptLanguage) interpreter() (string, bool) {
+	for _, name := range l.interpreters {
+		if _, err := exec.LookPath(name); err == nil {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// availableScriptLanguages are the languages whose interpreter is installed.
+func availableScriptLanguages() []string {
+	var names []string
+	for _, name := range scriptLanguageNames {
+		if _, ok := scriptLanguages[name].interpreter(); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func runScriptPromptSectionText() string {
+	names := availableScriptLanguages()
+	if len(names) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(runScriptPromptSection, strings.Join(names, ", "))
+}
+
+// parseRunScript splits RUN_SCRIPT content into the language and the script.
+// A code fence around the script, or one whose tag names the language, is
+// removed.
+func parseRunScript(content string) (language string, script string, err error) {
+	first, rest, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	first = strings.TrimSpace(first)
+	if tag, ok := strings.CutPrefix(first, "```"); ok {
+		first, rest = tag, "```\n"+rest
+	}
+	language = strings.ToLower(strings.TrimSpace(first))
+	if alias, ok := scriptAliases[language]; ok {
+		language = alias
+	}
+	if _, ok := scriptLanguages[language]; !ok {
+		return "", "", fmt.Errorf("unknown script language %q; use one of %s", first, strings.Join(scriptLanguageNames, ", "))
+	}
+	script = strings.TrimSpace(unfence(rest))
+	if script == "" {
+		return "", "", fmt.Errorf("the script is empty")
+	}
+	if _, ok := scriptLanguages[language].interpreter(); !ok {
+		return "", "", fmt.Errorf("no %s interpreter is installed (looked for %s)", language, strings.Join(scriptLanguages[language].interpreters, ", "))
+	}
+	return language, script, nil
+}
+
+// scriptRisk is classifyRisk for a command or script. Only bash is read
+// closely enough to call a script read-only.
+func scriptRisk(language string, command string) string {
+	risk := classifyRisk(command)
+	if language != "" && language != "bash" && risk == riskReadOnly {
+		return riskMutating
+	}
+	return risk
+}
+
+// lintScript lints commands and bash scripts.
+func lintScript(language string, command string, shellPath string) []string {
+	switch language {
+	case "":
+		return lintCommand(command, shellPath)
+	case "bash":
+		return lintCommand(command, "bash")
+	}
+	return nil
+}
+
+// showScript is showCommand for a command or a script.
+func showScript(language string, command string) string {
+	if language == "" {
+		return showCommand(command)
+	}
+	lines := strings.Split(command, "\n")
+	var b strings.Builder
+	fmt.Fprintf(&b, "  📜 A %d-line %s script:\n", len(lines), language)
+	for i, line := range lines {
+		fmt.Fprintf(&b, "\n  %3d │ %s", i+1, line)
+	}
+	return b.String()
+}
+
+// executeScript is executeCommand for a command or a script. A script is
+// written to a temporary file, which the user's shell runs with the
+// language's interpreter.
+func executeScript(language string, command string, shellPath string, state *shellState, console io.Writer, monitor commandMonitor) (status string, output string) {
+	if language == "" {
+		return executeCommand(command, shellPath, state, console, monitor)
+	}
+	lang := scriptLanguages[language]
+	interpreter, ok := lang.interpreter()
+	if !ok {
+		return "ERROR", fmt.Sprintf("No %s interpreter is installed.", language)
+	}
+	f, err := os.CreateTemp("", "shai-script-*"+lang.extension)
+	if err != nil {
+		return "ERROR", fmt.Sprintf("Failed to create the script file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(command + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "ERROR", fmt.Sprintf("Failed to write the script file: %v", err)
+	}
+	return executeCommand(shellInvocation(shellPath, interpreter, lang.args(f.Name())...), shellPath, state, console, monitor)
+}
+
+// shellInvocation quotes a program and its arguments for the user's shell.
+func shellInvocation(shellPath string, program string, args ...string) string {
+	words := append([]string{program}, args...)
+	switch {
+	case isPowerShell(shellPath):
+		for i, word := range words {
+			words[i] = "'" + strings.ReplaceAll(word, "'", "''") + "'"
+		}
+		return "& " + strings.Join(words, " ")
+	case runtime.GOOS == "windows":
+		for i, word := range words {
+			words[i] = `"` + word + `"`
+		}
+		return strings.Join(words, " ")
+	}
+	for i, word := range words {
+		words[i] = shellQuote(word)
+	}
+	return strings.Join(words, " ")
+}
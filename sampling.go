@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"strconv"
+)
+
+// Sampling options are Ollama's "options" block, set in the config file as
+// "options" or with flags, and sent with every model call. Other providers
+// map the ones they understand (temperature, top_p, seed, num_predict, stop)
+// onto their own parameters.
+
+var (
+	optionFlags = map[string]any{}
+	numCtxFlag  = flag.Int("num-ctx", 0, "context window in tokens (context.num_ctx)")
+)
+
+func init() {
+	flag.Func("temperature", "sampling temperature, e.g. 0.2 for more deterministic commands", floatOption("temperature"))
+	flag.Func("top-p", "nucleus sampling threshold, e.g. 0.9", floatOption("top_p"))
+	flag.Func("seed", "fixed sampling seed, for reproducible runs", intOption("seed"))
+}
+
+func floatOption(key string) func(string) error {
+	return func(value string) error {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		optionFlags[key] = v
+		return nil
+	}
+}
+
+func intOption(key string) func(string) error {
+	return func(value string) error {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		optionFlags[key] = v
+		return nil
+	}
+}
+
+// applyOptionFlags adds the sampling flags to the configured options.
+// num_ctx lives in context.num_ctx, which the context manager also uses.
+func applyOptionFlags() {
+	if len(optionFlags) > 0 {
+		options := maps.Clone(cfg.Options)
+		if options == nil {
+			options = map[string]any{}
+		}
+		maps.Copy(options, optionFlags)
+		cfg.Options = options
+	}
+	if numCtx, ok := optionInt(cfg.Options, "num_ctx"); ok {
+		cfg.Context.NumCtx = numCtx
+	}
+	if *numCtxFlag > 0 {
+		cfg.Context.NumCtx = *numCtxFlag
+	}
+}
+
+// withConfigOptions adds the configured options to those of a call, which
+// take precedence.
+func withConfigOptions(options map[string]any) map[string]any {
+	if len(cfg.Options) == 0 {
+		return options
+	}
+	merged := maps.Clone(cfg.Options)
+	maps.Copy(merged, options)
+	return merged
+}
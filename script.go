@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Despite the prompt, models wrap RUN commands in code fences and spread
+// them over several lines. cleanCommand undoes what is only formatting;
+// whatever is still several lines after that is a script, which runs from a
+// temporary file.
+
+// fencedBlock matches the first fenced code block. A language tag is only
+// taken as one when the code starts on the next line.
+var fencedBlock = regexp.MustCompile("(?s)(?:```+|~~~+)(?:[\\w+.-]*[ \\t]*\\n)?(.*?)(?:```+|~~~+|$)")
+
+// continued matches the end of a line the next line continues.
+var continued = regexp.MustCompile(`(\\|\||&&|\|\|)[ \t]*$`)
+
+// unfence returns the contents of the first code fence in text, dropping
+// anything around it, or text itself without one.
+func unfence(text string) string {
+	match := fencedBlock.FindStringSubmatch(text)
+	if match == nil {
+		return text
+	}
+	return strings.TrimSpace(match[1])
+}
+
+// unfenceResponse unwraps a whole response the model put in a code fence,
+// such as "```\nRUN ls\n```".
+func unfenceResponse(response string) string {
+	if !strings.HasPrefix(response, "```") && !strings.HasPrefix(response, "~~~") {
+		return response
+	}
+	return unfence(response)
+}
+
+// cleanCommand strips code fences, a single pair of backticks around the
+// whole command and "$ " prompts, and joins lines that end in a backslash,
+// a pipe, && or ||.
+func cleanCommand(command string) string {
+	command = strings.TrimSpace(unfence(strings.TrimSpace(command)))
+	if len(command) > 1 && strings.Count(command, "`") == 2 && command[0] == '`' && command[len(command)-1] == '`' {
+		command = strings.TrimSpace(command[1 : len(command)-1])
+	}
+
+	lines := strings.Split(command, "\n")
+	prompted := true
+	for _, line := range lines {
+		if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "$ ") {
+			prompted = false
+		}
+	}
+	if prompted {
+		for i, line := range lines {
+			lines[i] = strings.TrimPrefix(strings.TrimSpace(line), "$ ")
+		}
+	}
+
+	// Here-documents keep their lines as they are.
+	if strings.Contains(command, "<<") {
+		return strings.Join(lines, "\n")
+	}
+	var joined []string
+	for _, line := range lines {
+		n := len(joined)
+		if n > 0 && continued.MatchString(joined[n-1]) {
+			previous := strings.TrimRight(joined[n-1], " \t")
+			previous = strings.TrimSuffix(previous, `\`)
+			joined[n-1] = strings.TrimRight(previous, " \t") + " " + strings.TrimSpace(line)
+			continue
+		}
+		joined = append(joined, line)
+	}
+	return strings.TrimSpace(strings.Join(joined, "\n"))
+}
+
+// isScript reports whether a cleaned command is several lines long.
+func isScript(command string) bool {
+	return strings.Contains(strings.TrimSpace(command), "\n")
+}
+
+// showCommand formats a command for a prompt: "$ command", or a script with
+// numbered lines.
+func showCommand(command string) string {
+	if !isScript(command) {
+		return "  $ " + command
+	}
+	lines := strings.Split(strings.TrimSpace(command), "\n")
+	var b strings.Builder
+	fmt.Fprintf(&b, "  📜 A %d-line script:\n", len(lines))
+	for i, line := range lines {
+		fmt.Fprintf(&b, "\n  %3d │ %s", i+1, line)
+	}
+	return b.String()
+}
+
+// scriptInvocation writes a multi-line command to a temporary file and
+// returns the command that runs it in the shell, and a function that
+// removes the file. POSIX shells source the file, so a cd or export in it
+// carries over as it would inline. PowerShell runs scripts inline already,
+// and a single line needs no file.
+func scriptInvocation(command string, shellPath string) (string, func(), error) {
+	if !isScript(command) || isPowerShell(shellPath) {
+		return command, func() {}, nil
+	}
+	ext, body := ".sh", command+"\n"
+	if runtime.GOOS == "windows" {
+		ext, body = ".cmd", "@echo off\r\n"+strings.ReplaceAll(command, "\n", "\r\n")+"\r\n"
+	}
+	f, err := os.CreateTemp("", "shai-script-*"+ext)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create a script file: %w", err)
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	if _, err := f.WriteString(body); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write the script file: %w", err)
+	}
+	f.Close()
+
+	switch {
+	case runtime.GOOS == "windows":
+		return `call "` + f.Name() + `"`, cleanup, nil
+	case filepath.Base(shellPath) == "fish":
+		return "source " + shellQuote(f.Name()), cleanup, nil
+	}
+	return ". " + shellQuote(f.Name()), cleanup, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const searchPromptSection = `
+SEARCHING FILES:
+To search file contents, output "SEARCH_FILES" followed by a regular expression (RE2 syntax) on the same line. Optionally put glob patterns (such as "*.go" or "src/**/*.ts") on the next line, separated by spaces, to limit which files are searched. The search covers the working directory, skips files ignored by .gitignore and binary files, and is case-insensitive unless the expression contains an uppercase letter. Prefer this over grep or find pipelines.
+`
+
+const (
+	maxSearchMatches  = 200
+	maxSearchFileSize = 5 << 20
+	maxSearchLineLen  = 300
+)
+
+// searchFiles greps the directory root, ripgrep style: gitignore-aware,
+// smart case, and bounded to maxSearchMatches results.
+func searchFiles(root string, expr string, globs []string) (status string, output string) {
+	if !strings.ContainsFunc(expr, unicode.IsUpper) {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return "ERROR", fmt.Sprintf("Invalid regular expression: %v", err)
+	}
+
+	var out strings.Builder
+	matches, files := 0, 0
+	truncated := false
+	walkFiles(root, func(rel string) error {
+		if len(globs) > 0 && !matchesAnyGlob(globs, rel) {
+			return nil
+		}
+		files++
+		n := searchFile(root, rel, re, maxSearchMatches-matches, &out)
+		matches += n
+		if matches >= maxSearchMatches {
+			truncated = true
+			return fs.SkipAll
+		}
+		return nil
+	})
+
+	if matches == 0 {
+		return "SUCCESS", fmt.Sprintf("No matches in %d files.", files)
+	}
+	if truncated {
+		out.WriteString(fmt.Sprintf("[results truncated at %d matches; narrow the expression or the globs]\n", maxSearchMatches))
+	}
+	return "SUCCESS", out.String()
+}
+
+// searchFile writes up to limit matching lines of one file under root as
+// path:line:text and returns how many it wrote. Binary and very large files
+// are skipped.
+func searchFile(root string, rel string, re *regexp.Regexp, limit int, out *strings.Builder) int {
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.Size() > maxSearchFileSize {
+		return 0
+	}
+
+	reader := bufio.NewReader(f)
+	head, _ := reader.Peek(8000)
+	if bytes.IndexByte(head, 0) >= 0 {
+		return 0
+	}
+
+	found := 0
+	for lineNo := 1; found < limit; lineNo++ {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if re.MatchString(line) {
+			if len(line) > maxSearchLineLen {
+				line = line[:maxSearchLineLen] + "…"
+			}
+			fmt.Fprintf(out, "%s:%d:%s\n", rel, lineNo, line)
+			found++
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return found
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// `shai serve` runs tasks on behalf of other programs. Each task is a session
+// with its own agent goroutine. The agent reports through the session instead
+// of the console: what it prints becomes events, and its prompts wait until
+// they are answered over the API.
+
+// ServeConfig configures `shai serve`.
+type ServeConfig struct {
+	// Listen is the address to listen on.
+	Listen string `json:"listen"`
+	// Token must be sent as "Authorization: Bearer <token>". It is
+	// required to listen on anything but a loopback address; without it,
+	// shai makes up one each time it starts.
+	Token string `json:"token"`
+}
+
+// ServeEvent is something that happened in a served session, in order.
+type ServeEvent struct {
+	Seq    int            `json:"seq"`
+	Time   time.Time      `json:"time"`
+	Kind   string         `json:"kind"` // output, token, prompt, answer or done
+	Text   string         `json:"text,omitempty"`
+	Prompt *PendingPrompt `json:"prompt,omitempty"`
+}
+
+// PendingPrompt is a question the agent is waiting on. Kind is "confirm"
+// (approve or deny), "command" (approve, optionally with an edited Text, or
+// deny) or "question" (answer with free text).
+type PendingPrompt struct {
+	ID      int    `json:"id"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Text    string `json:"text,omitempty"`
+}
+
+type promptAnswer struct {
+	approved bool
+	text     string
+}
+
+// serveSession is one task run by the server.
+type serveSession struct {
+	ID      string
+	Task    string
+	Created time.Time
+
+	mu      sync.Mutex
+	active  time.Time // when an event was added or a client asked
+	status  string
+	summary string
+	events  []ServeEvent
+	changed chan struct{} // closed and replaced whenever an event is added
+	pending *PendingPrompt
+	answers chan promptAnswer
+	prompts int
+	// promptMu keeps a session's sub-agents from asking two things at once.
+	promptMu sync.Mutex
+}
+
+func newServeSession(id string, task string) *serveSession {
+	return &serveSession{
+		ID:      id,
+		Task:    task,
+		Created: time.Now(),
+		active:  time.Now(),
+		status:  sessionRunning,
+		changed: make(chan struct{}),
+		answers: make(chan promptAnswer, 1),
+	}
+}
+
+func (s *serveSession) emit(kind string, text string, prompt *PendingPrompt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ServeEvent{Seq: len(s.events) + 1, Time: time.Now(), Kind: kind, Text: text, Prompt: prompt})
+	s.active = time.Now()
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Write makes the session the console of the commands its agent runs.
+func (s *serveSession) Write(p []byte) (int, error) {
+	s.emit("output", terminalEscapes.ReplaceAllString(string(p), ""), nil)
+	return len(p), nil
+}
+
+// ask publishes a prompt and waits for its answer.
+func (s *serveSession) ask(kind string, message string, text string) promptAnswer {
+	s.promptMu.Lock()
+	defer s.promptMu.Unlock()
+
+	s.mu.Lock()
+	s.prompts++
+	prompt := &PendingPrompt{ID: s.prompts, Kind: kind, Message: message, Text: text}
+	s.pending = prompt
+	s.mu.Unlock()
+	s.emit("prompt", "", prompt)
+
+	answer := <-s.answers
+	s.emit("answer", answerText(kind, answer), nil)
+	return answer
+}
+
+func answerText(kind string, answer promptAnswer) string {
+	switch {
+	case kind == "question":
+		return answer.text
+	case !answer.approved:
+		return "denied"
+	case answer.text != "":
+		return "approved: " + answer.text
+	}
+	return "approved"
+}
+
+// answer resolves the pending prompt. id, if not 0, must match it, so a
+// client cannot answer a prompt it has not seen.
+func (s *serveSession) answer(id int, answer promptAnswer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		return fmt.Errorf("nothing is waiting for an answer")
+	}
+	if id != 0 && id != s.pending.ID {
+		return fmt.Errorf("prompt %d is not pending; prompt %d is", id, s.pending.ID)
+	}
+	if s.pending.Kind != "command" && s.pending.Kind != "question" {
+		answer.text = ""
+	}
+	s.pending = nil
+	s.answers <- answer
+	return nil
+}
+
+// touch notes that a client asked about the session.
+func (s *serveSession) touch() {
+	s.mu.Lock()
+	s.active = time.Now()
+	s.mu.Unlock()
+}
+
+// expired reports whether the session finished and has been left alone for
+// longer than serveSessionIdle.
+func (s *serveSession) expired(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status != sessionRunning && now.Sub(s.active) > serveSessionIdle
+}
+
+func (s *serveSession) finish(status string, summary string) {
+	s.mu.Lock()
+	s.status, s.summary = status, summary
+	s.mu.Unlock()
+	s.emit("done", summary, nil)
+}
+
+// sessionInfo is how a session is listed by the API.
+type sessionInfo struct {
+	ID      string         `json:"id"`
+	Task    string         `json:"task"`
+	Created time.Time      `json:"created"`
+	Status  string         `json:"status"`
+	Summary string         `json:"summary,omitempty"`
+	Pending *PendingPrompt `json:"pending,omitempty"`
+	Events  int            `json:"events"`
+}
+
+func (s *serveSession) info() sessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sessionInfo{ID: s.ID, Task: s.Task, Created: s.Created, Status: s.status, Summary: s.summary, Pending: s.pending, Events: len(s.events)}
+}
+
+// serveSessionIdle is how long a finished session is kept after its last
+// event or request.
+const serveSessionIdle = time.Hour
+
+// server holds the sessions of `shai serve`.
+type server struct {
+	mu       sync.Mutex
+	sessions map[string]*serveSession
+	// address is the address the server listens on, and token the bearer
+	// token every API request needs.
+	address string
+	token   string
+}
+
+func serveCommand(args []string) error {
+	prepareRun()
+	address := cfg.Serve.Listen
+	if len(args) > 0 {
+		address = args[0]
+	}
+	if err := checkServeAddress(address); err != nil {
+		return err
+	}
+
+	srv := &server{sessions: map[string]*serveSession{}, address: address, token: cfg.Serve.Token}
+	fmt.Printf("🌐 shai is serving on http://%s (%s via %s)\n", address, executorModel(), modelAPIURL())
+	if srv.token != "" {
+		fmt.Printf("   Web UI: http://%s/?token=<serve.token>\n", address)
+	} else {
+		token, err := randomHex(16)
+		if err != nil {
+			return fmt.Errorf("failed to make up a token: %w", err)
+		}
+		srv.token = token
+		fmt.Printf("   Token:  %s (set serve.token to choose one)\n", srv.token)
+		fmt.Printf("   Web UI: http://%s/?token=%s\n", address, srv.token)
+	}
+	go srv.expireSessions()
+	return http.ListenAndServe(address, srv.handler())
+}
+
+// expireSessions forgets finished sessions no one has looked at for
+// serveSessionIdle, so that a long-running server does not keep them all.
+// Their session files and transcripts stay in the state directory.
+func (srv *server) expireSessions() {
+	for now := range time.Tick(time.Minute) {
+		srv.mu.Lock()
+		for id, s := range srv.sessions {
+			if s.expired(now) {
+				delete(srv.sessions, id)
+			}
+		}
+		srv.mu.Unlock()
+	}
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// checkServeAddress refuses to expose the agent beyond this machine without
+// a token.
+func checkServeAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", address, err)
+	}
+	if cfg.Serve.Token != "" {
+		return nil
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+	return fmt.Errorf("listening on %s needs serve.token to be set", address)
+}
+
+func (srv *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", srv.listSessions)
+	mux.HandleFunc("POST /sessions", srv.submitTask)
+	mux.HandleFunc("GET /sessions/{id}", srv.withSession(srv.getSession))
+	mux.HandleFunc("GET /sessions/{id}/events", srv.withSession(srv.streamEvents))
+	mux.HandleFunc("POST /sessions/{id}/approve", srv.withSession(srv.answerPrompt(true)))
+	mux.HandleFunc("POST /sessions/{id}/deny", srv.withSession(srv.answerPrompt(false)))
+	mux.HandleFunc("POST /sessions/{id}/answer", srv.withSession(srv.answerPrompt(true)))
+	mux.HandleFunc("GET /sessions/{id}/transcript", srv.withSession(srv.getTranscript))
+
+	root := http.NewServeMux()
+	root.HandleFunc("GET /{$}", serveWebUI)
+	root.Handle("/", srv.authorize(mux))
+	return root
+}
+
+// authorize checks the bearer token, which browsers may also pass as
+// ?token=, since EventSource cannot set headers. Against web pages that
+// call the API from the user's browser, it also turns away requests for
+// another host name (DNS rebinding), from another origin and, for POST,
+// with a body that is not JSON, which a form cannot send.
+func (srv *server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !srv.allowedHost(r.Host) {
+			writeJSONError(w, http.StatusForbidden, fmt.Errorf("unexpected host %q", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+			writeJSONError(w, http.StatusForbidden, fmt.Errorf("requests from %s are not allowed", origin))
+			return
+		}
+		if r.Method == http.MethodPost && r.ContentLength != 0 {
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+				writeJSONError(w, http.StatusUnsupportedMediaType, fmt.Errorf("the request body must be application/json"))
+				return
+			}
+		}
+		given := []byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if query := r.URL.Query().Get("token"); query != "" {
+			given = []byte(query)
+		}
+		if subtle.ConstantTimeCompare(given, []byte(srv.token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, fmt.Errorf("missing or wrong bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost reports whether a Host header names this server: the listen
+// address's host, localhost, a loopback address, the address listened on
+// (any address of this machine when listening on all of them) or the
+// machine's host name. A page whose own name resolves to this machine is
+// refused.
+func (srv *server) allowedHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	listen, _, _ := net.SplitHostPort(srv.address)
+	if host == strings.ToLower(listen) || host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		listenIP := net.ParseIP(listen)
+		return ip.IsLoopback() || ip.Equal(listenIP) || ((listen == "" || listenIP.IsUnspecified()) && localAddress(ip))
+	}
+	name, err := os.Hostname()
+	return err == nil && (host == strings.ToLower(name) || host == strings.ToLower(name)+".local")
+}
+
+// localAddress reports whether ip is an address of one of this machine's
+// network interfaces.
+func localAddress(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if prefix, ok := addr.(*net.IPNet); ok && prefix.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (srv *server) withSession(handle func(http.ResponseWriter, *http.Request, *serveSession)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srv.mu.Lock()
+		s, ok := srv.sessions[r.PathValue("id")]
+		srv.mu.Unlock()
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("no session %q", r.PathValue("id")))
+			return
+		}
+		s.touch()
+		handle(w, r, s)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (srv *server) listSessions(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	infos := make([]sessionInfo, 0, len(srv.sessions))
+	for _, s := range srv.sessions {
+		infos = append(infos, s.info())
+	}
+	srv.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Created.After(infos[j].Created) })
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (srv *server) getSession(w http.ResponseWriter, r *http.Request, s *serveSession) {
+	writeJSON(w, http.StatusOK, s.info())
+}
+
+// submitTask starts a session for {"task": "...", "model": "..."}.
+func (srv *server) submitTask(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Task  string `json:"task"`
+		Model string `json:"model"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if strings.TrimSpace(req.Task) == "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("no task given"))
+		return
+	}
+
+	// Served session IDs end in a random part, so that they cannot be
+	// guessed from when the session started.
+	suffix, err := randomHex(4)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	srv.mu.Lock()
+	id, release := claimSessionIDFrom(newSessionID() + "-" + suffix)
+	s := newServeSession(id, req.Task)
+	srv.sessions[id] = s
+	srv.mu.Unlock()
+
+	go func() {
+		defer release()
+		srv.run(s, req.Model)
+	}()
+	writeJSON(w, http.StatusCreated, s.info())
+}
+
+// run is the goroutine of a session.
+func (srv *server) run(s *serveSession, model string) {
+	fmt.Printf("🚀 Session %s started: %s\n", s.ID, truncateLine(s.Task, 60))
+	shell := defaultShell()
+	agent := newAgent("", s.Task, generateSystemPrompt(s.Task, runtime.GOOS, shell, true), shell, 0)
+	agent.SessionID = s.ID
+	agent.remote = s
+	// Anything that still reads from the console gets no answer.
+	agent.reader = bufio.NewReader(strings.NewReader(""))
+	if model != "" {
+		agent.Model = model
+	}
+
+	started := time.Now()
+	var result AgentResult
+	err := agent.makePlan()
+	if err == nil {
+		result, err = agent.Run()
+	}
+	agent.finishRun(result, err, started)
+	summary := result.Summary
+	if err != nil {
+		summary = err.Error()
+	}
+	s.finish(sessionStatus(result, err), summary)
+	fmt.Printf("🏁 Session %s finished: %s\n", s.ID, sessionStatus(result, err))
+}
+
+// streamEvents sends the session's events as server-sent events, starting
+// after ?after=<seq> or the Last-Event-ID header, until the session is done.
+func (srv *server) streamEvents(w http.ResponseWriter, r *http.Request, s *serveSession) {
+	after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		after, _ = strconv.Atoi(id)
+	}
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		s.mu.Lock()
+		var events []ServeEvent
+		if after < len(s.events) {
+			events = append(events, s.events[after:]...)
+		}
+		done := s.status != sessionRunning
+		changed := s.changed
+		s.mu.Unlock()
+
+		for _, event := range events {
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Kind, data)
+			after = event.Seq
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if done && len(events) == 0 {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-time.After(30 * time.Second):
+			// Keep proxies from closing an idle stream.
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+	}
+}
+
+// answerPrompt answers the pending prompt with an optional JSON body of
+// {"id": <prompt id>, "text": "<edited command or answer>"}.
+func (srv *server) answerPrompt(approved bool) func(http.ResponseWriter, *http.Request, *serveSession) {
+	return func(w http.ResponseWriter, r *http.Request, s *serveSession) {
+		var req struct {
+			ID   int    `json:"id"`
+			Text string `json:"text"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+				return
+			}
+		}
+		if err := s.answer(req.ID, promptAnswer{approved: approved, text: req.Text}); err != nil {
+			writeJSONError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.info())
+	}
+}
+
+func (srv *server) getTranscript(w http.ResponseWriter, r *http.Request, s *serveSession) {
+	session, err := findSession(s.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	fmt.Fprint(w, renderReport(session))
+}
+
+// Agent hooks: a served agent talks to its session instead of the console.
+
+// console is where commands the agent runs show their output; nil for the
+// terminal.
+func (a *Agent) console() io.Writer {
+	if a.remote == nil {
+		return nil
+	}
+	return a.remote
+}
+
+// review is confirmEditable for the agent: it reports whether text was
+// approved, and the text to use.
+func (a *Agent) review(message string, text string, explain func(string)) (bool, string) {
+	defer a.timeHuman(time.Now())
+	return cassetteAnswer(message, func() (bool, string) {
+		if a.remote != nil {
+			answer := a.remote.ask("command", message, text)
+			if answer.approved && strings.TrimSpace(answer.text) != "" {
+				return true, answer.text
+			}
+			return answer.approved, text
+		}
+		return confirmEditable(message, text, a.reader, explain)
+	})
+}
+
+// readAnswer asks the user a free-text question.
+func (a *Agent) readAnswer(prompt string) string {
+	defer a.timeHuman(time.Now())
+	_, answer := cassetteAnswer(prompt, func() (bool, string) {
+		if a.remote != nil {
+			return true, strings.TrimSpace(a.remote.ask("question", prompt, "").text)
+		}
+		consoleMu.Lock()
+		defer consoleMu.Unlock()
+		return true, readResponse(prompt, a.reader)
+	})
+	return answer
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Session is the persisted state of a top-level agent, saved before every
+// step so an interrupted run can be resumed with `shai resume`.
+type Session struct {
+	ID           string       `json:"id"`
+	Created      time.Time    `json:"created"`
+	Updated      time.Time    `json:"updated"`
+	Status       string       `json:"status"`
+	Task         string       `json:"task"`
+	Dir          string       `json:"dir"`
+	Shell        string       `json:"shell"`
+	Model        string       `json:"model"`
+	SystemPrompt string       `json:"system_prompt"`
+	Messages     []Message    `json:"messages"`
+	Step         int          `json:"step"`
+	Events       []AgentEvent `json:"events,omitempty"`
+	Plan         []PlanStep   `json:"plan,omitempty"`
+	Steps        []StepRecord `json:"steps,omitempty"`
+	Changes      []FileChange `json:"changes,omitempty"`
+	Summary      string       `json:"summary,omitempty"`
+	Usage        Usage        `json:"usage,omitempty"`
+	Timings      []StepTiming `json:"timings,omitempty"`
+}
+
+// sessionRunning marks a session that has not finished, whether it is still
+// going or was interrupted.
+const sessionRunning = "RUNNING"
+
+func sessionsDir() (string, error) {
+	dir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "sessions")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create sessions directory %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+func newSessionID() string {
+	return time.Now().Format("20060102-150405")
+}
+
+// saveSession writes the agent's state. Failures are reported once per call
+// but never stop the run.
+func (a *Agent) saveSession(status string) {
+	if a.SessionID == "" {
+		return
+	}
+	dir, err := sessionsDir()
+	if err != nil {
+		a.printf("⚠️ Failed to save the session: %v\n", err)
+		return
+	}
+
+	path := filepath.Join(dir, a.SessionID+".json")
+	created := time.Now()
+	if existing, err := loadSessionFile(path); err == nil {
+		created = existing.Created
+	}
+	data, err := json.MarshalIndent(Session{
+		ID:           a.SessionID,
+		Created:      created,
+		Updated:      time.Now(),
+		Status:       status,
+		Task:         a.Task,
+		Dir:          a.shell.workDir(),
+		Shell:        a.Shell,
+		Model:        a.Model,
+		SystemPrompt: a.SystemPrompt,
+		Messages:     redactMessages(a.Messages),
+		Step:         a.Step,
+		Events:       a.Events,
+		Plan:         a.Plan,
+		Changes:      a.Changes,
+		Steps:        a.Steps,
+		Summary:      redact(a.summary),
+		Usage:        a.usage,
+		Timings:      a.Timings,
+	}, "", "  ")
+	if err != nil {
+		a.printf("⚠️ Failed to save the session: %v\n", err)
+		return
+	}
+	// Each save has its own temporary file, so that one made on exit from
+	// the signal goroutine cannot mix with the agent's own.
+	if err := writeFileAtomic(path, data); err != nil {
+		a.printf("⚠️ Failed to save the session: %v\n", err)
+	}
+}
+
+func loadSessionFile(path string) (Session, error) {
+	var session Session
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return session, err
+	}
+	err = json.Unmarshal(data, &session)
+	return session, err
+}
+
+// listSessions returns all saved sessions, most recently updated first.
+func listSessions() ([]Session, error) {
+	dir, err := sessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []Session
+	for _, file := range files {
+		if session, err := loadSessionFile(file); err == nil {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Updated.After(sessions[j].Updated) })
+	return sessions, nil
+}
+
+func printSessions() error {
+	sessions, err := listSessions()
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		fmt.Println("🤷 No saved sessions.")
+		return nil
+	}
+	for _, session := range sessions {
+		fmt.Printf("%s  %-16s step %-3d %s\n", session.ID, session.Status, session.Step, truncateLine(session.Task, 60))
+	}
+	return nil
+}
+
+// resumeSession rebuilds the agent of a saved session: the named one, or the
+// most recent unfinished one when id is empty.
+func resumeSession(id string) (*Agent, error) {
+	var session Session
+	if id != "" {
+		var err error
+		if session, err = findSession(id); err != nil {
+			return nil, err
+		}
+	} else {
+		sessions, err := listSessions()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sessions {
+			if s.Status == sessionRunning && !sessionInUse(s.ID) {
+				session = s
+				break
+			}
+		}
+		if session.ID == "" {
+			return nil, fmt.Errorf("no unfinished session to resume; see `shai sessions`")
+		}
+	}
+
+	release, err := lockSession(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(session.Dir); err != nil {
+		release()
+		return nil, fmt.Errorf("failed to return to %s: %w", session.Dir, err)
+	}
+	agent := newAgent("", session.Task, session.SystemPrompt, session.Shell, 0)
+	agent.SessionID = session.ID
+	agent.unlockSession = release
+	debugSession(session.ID)
+	agent.Messages = session.Messages
+	agent.Step = session.Step
+	agent.Events = session.Events
+	agent.Plan = session.Plan
+	agent.Steps = session.Steps
+	agent.usage = session.Usage
+	agent.Timings = session.Timings
+	if session.Model != "" {
+		agent.Model = session.Model
+	}
+
+	// The last message may be the model's reply to a step that never ran.
+	if n := len(agent.Messages); n > 0 && agent.Messages[n-1].Role == "assistant" {
+		agent.Messages = agent.Messages[:n-1]
+	}
+	agent.addUserMessage("SESSION_RESUMED: shai was interrupted and has been restarted. Commands may have been partially applied; check the current state before continuing.")
+	fmt.Printf("⏯️  Resuming session %s at step %d\n", session.ID, session.Step)
+	return agent, nil
+}
+
+// sessionStatus is the status saved for a finished run.
+func sessionStatus(result AgentResult, err error) string {
+	if err != nil {
+		return "ERROR: " + strings.SplitN(err.Error(), "\n", 2)[0]
+	}
+	return result.Status
+}
+
+// findSession loads the session with the given ID, or the most recent one
+// if id is empty.
+func findSession(id string) (Session, error) {
+	if id == "" {
+		sessions, err := listSessions()
+		if err != nil {
+			return Session{}, err
+		}
+		if len(sessions) == 0 {
+			return Session{}, fmt.Errorf("no saved sessions")
+		}
+		return sessions[0], nil
+	}
+	dir, err := sessionsDir()
+	if err != nil {
+		return Session{}, err
+	}
+	session, err := loadSessionFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to load session %s: %w", id, err)
+	}
+	return session, nil
+}
+
+// finishRun records how a top-level run ended: in its session, the task
+// history and, with "reports" on, a Markdown transcript.
+func (a *Agent) finishRun(result AgentResult, err error, started time.Time) {
+	a.summary = result.Summary
+	if err != nil {
+		a.summary = err.Error()
+	}
+	a.saveSession(sessionStatus(result, err))
+	appendHistory(a, result, err, started)
+	if _, prompt, output := a.usage.totals(); prompt+output > 0 {
+		fmt.Printf("🧮 Usage: %s\n", a.usage.describe())
+	}
+	if cfg.Timings {
+		printTimings(a.Timings[a.timingsAtStart:])
+	}
+	event := "stopped"
+	if err == nil && result.Status == ResultComplete {
+		event = "complete"
+	}
+	a.hook(event, HookPayload{Status: sessionStatus(result, err), Summary: a.summary})
+	if event == "complete" {
+		a.notify("complete", "shai finished the task", a.summary)
+	} else {
+		a.notify("failed", "shai stopped: "+sessionStatus(result, err), a.summary)
+	}
+	if !cfg.Reports || a.SessionID == "" {
+		return
+	}
+	session, loadErr := findSession(a.SessionID)
+	if loadErr == nil {
+		var path string
+		if path, loadErr = writeReport(session); loadErr == nil {
+			fmt.Printf("📝 Transcript saved to %s\n", path)
+		}
+	}
+	if loadErr != nil {
+		fmt.Printf("⚠️ Failed to write the transcript: %v\n", loadErr)
+	}
+}
+
+// writeFileAtomic replaces a file by renaming a new temporary file over it.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
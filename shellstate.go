@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Every RUN starts a fresh shell, so shai carries the shell's state over
+// itself: after each command the shell reports its working directory and
+// exported variables, and the agent keeps them for its next command. Shell
+// functions and aliases do not survive.
+
+// shellState is the working directory and environment an agent's commands
+// run in. Each agent keeps its own rather than changing shai's process, which
+// parallel sub-agents and the sessions of `shai serve` share.
+type shellState struct {
+	// dir is the working directory, or "" for shai's own.
+	dir string
+	// env is the environment, or nil for shai's own.
+	env []string
+}
+
+// workDir returns the directory commands run in.
+func (s *shellState) workDir() string {
+	if s.dir != "" {
+		return s.dir
+	}
+	return getwd()
+}
+
+// environ returns the environment commands start from, before the env
+// policy.
+func (s *shellState) environ() []string {
+	if s.env != nil {
+		return s.env
+	}
+	return os.Environ()
+}
+
+// resolve makes a path the model gave relative to the working directory
+// absolute.
+func (s *shellState) resolve(path string) string {
+	path = expandPath(path)
+	if path == "" || filepath.IsAbs(path) || s.dir == "" {
+		return path
+	}
+	return filepath.Join(s.dir, path)
+}
+
+// volatileVariables are maintained by the shell itself and never copied.
+var volatileVariables = map[string]bool{
+	"PWD": true, "OLDPWD": true, "SHLVL": true, "_": true, "PS1": true,
+}
+
+// tracksShellState reports whether the shell understands the POSIX trap and
+// export syntax used to capture its state.
+func tracksShellState(shellPath string) bool {
+	if runtime.GOOS == "windows" {
+		return false
+	}
+	switch filepath.Base(shellPath) {
+	case "sh", "bash", "zsh", "dash", "ksh", "mksh", "ash", "busybox":
+		return true
+	}
+	return false
+}
+
+// wrapForStateCapture prefixes command with an EXIT trap that writes the
+// final working directory and environment into stateDir, whatever way the
+// command exits.
+func wrapForStateCapture(command, stateDir string) string {
+	cwdFile := shellQuote(filepath.Join(stateDir, "cwd"))
+	envFile := shellQuote(filepath.Join(stateDir, "env"))
+	trap := fmt.Sprintf(`__shai_rc=$?; pwd > %s; env -0 > %s 2>/dev/null || env > %s; exit $__shai_rc`, cwdFile, envFile, envFile)
+	return "trap " + shellQuote(trap) + " EXIT\n" + command
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// applyShellState adopts the working directory and environment captured in
+// stateDir into state and returns a note for the model when the directory
+// changed.
+func applyShellState(stateDir string, state *shellState) string {
+	note := ""
+	if data, err := os.ReadFile(filepath.Join(stateDir, "cwd")); err == nil {
+		dir := strings.TrimRight(string(data), "\n")
+		if dir != "" && dir != state.workDir() {
+			state.dir = dir
+			note = fmt.Sprintf("\n[working directory is now %s]\n", dir)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(stateDir, "env"))
+	if err != nil || len(data) == 0 {
+		return note
+	}
+	separator := "\x00"
+	if !strings.Contains(string(data), separator) {
+		separator = "\n"
+	}
+	captured := map[string]string{}
+	for _, entry := range strings.Split(string(data), separator) {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || volatileVariables[name] {
+			continue
+		}
+		captured[name] = value
+	}
+
+	// Variables the env policy withheld or set are not the shell's doing.
+	injected := injectedEnv()
+	var env []string
+	for _, entry := range state.environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		value, ok := captured[name]
+		if !ok {
+			// Unless the command could not see it, it unset it.
+			if name == "" || volatileVariables[name] || !passesEnv(name) {
+				env = append(env, entry)
+			}
+			continue
+		}
+		delete(captured, name)
+		if set, ok := injected[name]; ok && set == value {
+			env = append(env, entry)
+		} else {
+			env = append(env, name+"="+value)
+		}
+	}
+	for name, value := range captured {
+		if set, ok := injected[name]; !ok || set != value {
+			env = append(env, name+"="+value)
+		}
+	}
+	state.env = env
+	return note
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Snapshot is a filesystem snapshot or git checkpoint taken before the first
+// mutating command of a run, recorded so that `shai rollback` can restore it.
+type Snapshot struct {
+	Time time.Time `json:"time"`
+	Task string    `json:"task"`
+	Dir  string    `json:"dir"`
+	// FS is "zfs", "btrfs" or "git". For ZFS, Source is the dataset and Name
+	// the snapshot name; for btrfs, Source is the subvolume mount point and
+	// Name the path of the read-only snapshot subvolume; for git, Source is
+	// the repository and Name the ref of the checkpoint commit.
+	FS     string `json:"fs"`
+	Source string `json:"source"`
+	Name   string `json:"name"`
+	// Head, Branch and IndexTree are what HEAD, the branch and the index
+	// were at a git checkpoint.
+	Head      string `json:"head,omitempty"`
+	Branch    string `json:"branch,omitempty"`
+	IndexTree string `json:"index_tree,omitempty"`
+}
+
+var (
+	snapshotMu    sync.Mutex
+	snapshotTaken bool
+)
+
+func snapshotsPath() (string, error) {
+	dir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "snapshots.jsonl"), nil
+}
+
+// snapshotCommandOutput runs a filesystem tool, through sudo when not root.
+func snapshotCommandOutput(name string, args ...string) (string, error) {
+	if os.Geteuid() != 0 {
+		if _, err := exec.LookPath("sudo"); err == nil {
+			args = append([]string{name}, args...)
+			name = "sudo"
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// filesystemType returns the type of the filesystem holding dir.
+func filesystemType(dir string) string {
+	out, err := exec.Command("stat", "-f", "-c", "%T", dir).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func takeSnapshot(task, dir string) (Snapshot, error) {
+	snap := Snapshot{Time: time.Now(), Task: task, Dir: dir}
+	name := "shai-" + snap.Time.Format("20060102-150405")
+
+	switch fs := filesystemType(dir); fs {
+	case "zfs":
+		out, err := exec.Command("zfs", "list", "-H", "-o", "name", dir).Output()
+		if err != nil {
+			return snap, fmt.Errorf("failed to find the ZFS dataset of %s: %w", dir, err)
+		}
+		snap.FS, snap.Source, snap.Name = fs, strings.TrimSpace(string(out)), name
+		if _, err := snapshotCommandOutput("zfs", "snapshot", snap.Source+"@"+name); err != nil {
+			return snap, err
+		}
+	case "btrfs":
+		out, err := exec.Command("findmnt", "-n", "-o", "TARGET", "-T", dir).Output()
+		if err != nil {
+			return snap, fmt.Errorf("failed to find the btrfs subvolume of %s: %w", dir, err)
+		}
+		snap.FS, snap.Source = fs, strings.TrimSpace(string(out))
+		snap.Name = filepath.Join(snap.Source, ".shai-snapshots", name)
+		if _, err := snapshotCommandOutput("mkdir", "-p", filepath.Dir(snap.Name)); err != nil {
+			return snap, err
+		}
+		if _, err := snapshotCommandOutput("btrfs", "subvolume", "snapshot", "-r", snap.Source, snap.Name); err != nil {
+			return snap, err
+		}
+	default:
+		return snap, fmt.Errorf("%s is not on ZFS or btrfs", dir)
+	}
+	return snap, recordSnapshot(snap)
+}
+
+// recordSnapshot adds a snapshot to the list `shai rollback` reads.
+func recordSnapshot(snap Snapshot) error {
+	path, err := snapshotsPath()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, _ := json.Marshal(snap)
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// maybeSnapshot takes a git checkpoint and a filesystem snapshot, as enabled,
+// before the first mutating command of the run. Failing to take one is
+// reported but does not stop the command.
+func (a *Agent) maybeSnapshot(command string) {
+	if (!cfg.Snapshots && !cfg.GitCheckpoint) || classifyRisk(command) == riskReadOnly || replaying() {
+		return
+	}
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	if snapshotTaken {
+		return
+	}
+	snapshotTaken = true
+
+	if cfg.GitCheckpoint {
+		if snap, err := takeGitCheckpoint(a.Task, a.shell.workDir()); err != nil {
+			a.printf("⚠️ No git checkpoint taken: %v\n", err)
+		} else {
+			a.record("snapshot", snap.FS+" "+snap.Source+" "+snap.Name)
+			a.printf("📌 Saved a git checkpoint (%s); `shai rollback` restores it.\n", snap.Name)
+		}
+	}
+	if !cfg.Snapshots || runtime.GOOS == "windows" {
+		return
+	}
+	snap, err := takeSnapshot(a.Task, a.shell.workDir())
+	if err != nil {
+		a.printf("⚠️ No snapshot taken: %v\n", err)
+		return
+	}
+	a.record("snapshot", snap.FS+" "+snap.Source+" "+snap.Name)
+	a.printf("📸 Took a %s snapshot (%s); `shai rollback` restores it.\n", snap.FS, snap.Name)
+}
+
+func readSnapshots() ([]Snapshot, error) {
+	path, err := snapshotsPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snaps []Snapshot
+	for _, line := range strings.Split(string(data), "\n") {
+		var snap Snapshot
+		if json.Unmarshal([]byte(line), &snap) == nil {
+			snaps = append(snaps, snap)
+		}
+	}
+	return snaps, nil
+}
+
+// rollback restores the most recent snapshot. ZFS datasets are rolled back
+// as a whole; for btrfs, whose mounted subvolume cannot be swapped in place,
+// the working directory of the run is synced back from the snapshot.
+func rollback(reader *bufio.Reader) error {
+	snaps, err := readSnapshots()
+	if err != nil {
+		return fmt.Errorf("failed to read snapshots: %w", err)
+	}
+	if len(snaps) == 0 {
+		fmt.Println("🤷 No snapshots to roll back to.")
+		return nil
+	}
+	snap := snaps[len(snaps)-1]
+
+	var command []string
+	var scope string
+	switch snap.FS {
+	case "zfs":
+		command = []string{"zfs", "rollback", "-r", snap.Source + "@" + snap.Name}
+		scope = "the whole dataset " + snap.Source
+	case "btrfs":
+		if _, err := exec.LookPath("rsync"); err != nil {
+			return fmt.Errorf("rolling back a btrfs snapshot needs rsync")
+		}
+		rel, err := filepath.Rel(snap.Source, snap.Dir)
+		if err != nil {
+			return err
+		}
+		from := filepath.Join(snap.Name, rel) + string(filepath.Separator)
+		command = []string{"rsync", "-a", "--delete", from, snap.Dir + string(filepath.Separator)}
+		scope = snap.Dir
+	case "git":
+		fmt.Printf("⏪ Git checkpoint from %s, taken before: %s\n", snap.Time.Format(time.DateTime), snap.Task)
+		if !confirmAction(fmt.Sprintf("This discards every change made since then to the files of %s that are not ignored, and returns %s to %s. Roll back?", snap.Source, cmp.Or(snap.Branch, "HEAD"), shortHash(snap.Head)), reader) {
+			return nil
+		}
+		if err := rollbackGit(snap); err != nil {
+			return fmt.Errorf("failed to roll back: %w", err)
+		}
+		fmt.Printf("✅ Rolled back. The checkpoint stays at %s.\n", snap.Name)
+		return nil
+	default:
+		return fmt.Errorf("unknown snapshot filesystem %q", snap.FS)
+	}
+
+	fmt.Printf("⏪ Snapshot from %s, taken before: %s\n", snap.Time.Format(time.DateTime), snap.Task)
+	if !confirmAction(fmt.Sprintf("This discards every change made since then to %s:\n\n  $ %s\n\nRoll back?", scope, strings.Join(command, " ")), reader) {
+		return nil
+	}
+	if _, err := snapshotCommandOutput(command[0], command[1:]...); err != nil {
+		return err
+	}
+	fmt.Println("✅ Rolled back.")
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"slices"
+)
+
+// startSpeculation uses the time the user spends on an approval prompt to get
+// the next step started. The next step cannot be generated before the command
+// has run, since it depends on the output, but everything up to that output
+// is already known: the conversation so far, the proposed command and the
+// header of the result message. Evaluating that prefix now leaves Ollama with
+// only the command output to process once the user has decided. Other
+// providers would bill the request without keeping anything, so it is only
+// made for Ollama.
+//
+// The request goes straight to the provider: it is not part of the
+// conversation, so it is neither recorded nor replayed by a cassette.
+//
+// The returned function discards the speculation; call it when the command
+// is rejected so the next step is not kept waiting behind it.
+func (a *Agent) startSpeculation(resultHeader string) (discard func()) {
+	if !cfg.SpeculativePrefill || !usesOllama() || replaying() {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages := append(slices.Clone(a.Messages), Message{Role: "user", Content: resultHeader})
+	model, system := a.Model, a.SystemPrompt
+
+	go func() {
+		// Generating a single token is enough to have the prompt evaluated
+		// and its KV cache kept for the real request.
+		sendModelRequest(ctx, model, messages, system, map[string]any{"num_predict": 1}, nil, nil, nil)
+	}()
+	return cancel
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const statsWeeks = 8
+
+type countedName struct {
+	name  string
+	count int
+}
+
+func topCounts(counts map[string]int, n int) []countedName {
+	var sorted []countedName
+	for name, count := range counts {
+		sorted = append(sorted, countedName{name, count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// weekStart returns the Monday starting t's week.
+func weekStart(t time.Time) time.Time {
+	t = t.Local()
+	day := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-day, 0, 0, 0, 0, time.Local)
+}
+
+// printStats reports on the task history and the command journal.
+func printStats() error {
+	history, err := readHistory()
+	if err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+	journal, err := readJournal()
+	if err != nil {
+		return fmt.Errorf("failed to read the journal: %w", err)
+	}
+	if len(history) == 0 {
+		fmt.Println("📊 No tasks recorded yet.")
+		return nil
+	}
+
+	statuses := map[string]int{}
+	models := map[string]int{}
+	perWeek := map[time.Time]int{}
+	steps, promptTokens, outputTokens := 0, 0, 0
+	var duration, cost float64
+	priced := 0
+	for _, entry := range history {
+		statuses[entry.Status]++
+		for model, n := range entry.ModelSteps {
+			models[model] += n
+		}
+		perWeek[weekStart(entry.Time)]++
+		steps += entry.Steps
+		promptTokens += entry.PromptTokens
+		outputTokens += entry.OutputTokens
+		duration += entry.Duration
+		cost += entry.Cost
+		if entry.Cost > 0 {
+			priced++
+		}
+	}
+	tasks := len(history)
+
+	fmt.Printf("📊 shai stats — %d tasks since %s\n\n", tasks, history[0].Time.Local().Format(time.DateOnly))
+
+	fmt.Println("Tasks per week:")
+	this := weekStart(time.Now())
+	for i := statsWeeks - 1; i >= 0; i-- {
+		week := this.AddDate(0, 0, -7*i)
+		n := perWeek[week]
+		fmt.Printf("  %s  %3d %s\n", week.Format(time.DateOnly), n, strings.Repeat("█", n))
+	}
+
+	fmt.Println("\nOutcomes:")
+	for _, s := range topCounts(statuses, len(statuses)) {
+		fmt.Printf("  %-18s %4d (%d%%)\n", s.name, s.count, s.count*100/tasks)
+	}
+	fmt.Printf("  Success rate: %d%%\n", statuses[ResultComplete]*100/tasks)
+
+	fmt.Println("\nPer task (average):")
+	fmt.Printf("  Steps:  %.1f\n", float64(steps)/float64(tasks))
+	fmt.Printf("  Tokens: %d prompt, %d output (%s prompt, %s output in total)\n", promptTokens/tasks, outputTokens/tasks, formatCount(promptTokens), formatCount(outputTokens))
+	if priced > 0 {
+		fmt.Printf("  Cost:   %s (%s in total, over %d priced tasks)\n", formatCost(cost/float64(priced)), formatCost(cost), priced)
+	}
+	fmt.Printf("  Time:   %s (%s in total)\n",
+		(time.Duration(duration/float64(tasks)) * time.Second).Round(time.Second),
+		(time.Duration(duration) * time.Second).Round(time.Second))
+
+	fmt.Println("\nMost-used models (steps):")
+	for _, m := range topCounts(models, 5) {
+		fmt.Printf("  %-30s %5d\n", m.name, m.count)
+	}
+
+	failing := map[string]int{}
+	examples := map[string]string{}
+	commands := 0
+	for _, entry := range journal {
+		commands++
+		if !strings.HasPrefix(entry.Status, "ERROR") {
+			continue
+		}
+		name := entry.Command
+		if words := splitPipeline(entry.Command); len(words) > 0 {
+			name = filepath.Base(words[0][0])
+		}
+		failing[name]++
+		if examples[name] == "" {
+			examples[name] = entry.Command
+		}
+	}
+	fmt.Printf("\nTop failing commands (of %d run):\n", commands)
+	if len(failing) == 0 {
+		fmt.Println("  none")
+	}
+	for _, f := range topCounts(failing, 5) {
+		fmt.Printf("  %-12s %4d  e.g. %s\n", f.name, f.count, truncateLine(examples[f.name], 60))
+	}
+	return nil
+}
+
+func truncateLine(s string, n int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if len([]rune(s)) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// readServerSentEvents calls fn with the data of each event in a
+// text/event-stream body, stopping at the OpenAI-style "[DONE]" marker.
+func readServerSentEvents(body io.Reader, fn func(data []byte) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 8<<20)
+	for scanner.Scan() {
+		data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+		if !ok {
+			continue
+		}
+		data = bytes.TrimSpace(data)
+		if string(data) == "[DONE]" {
+			return nil
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// streamTokens returns the callback that renders a response live, dimmed
+// so it stands apart from shai's own messages, and a function to call when
+// the response is complete. Only the top-level agent streams: sub-agents run
+// concurrently and their output would interleave. A served agent streams
+// the tokens as events.
+func (a *Agent) streamTokens() (onToken func(string), finish func()) {
+	if !cfg.Stream || a.Depth > 0 || (a.remote == nil && !isTerminal(os.Stdout)) {
+		return nil, func() {}
+	}
+	started := false
+	var filter *reasoningFilter
+	if !cfg.ShowThinking {
+		filter = &reasoningFilter{}
+	}
+	show := func(text string) {
+		if text == "" {
+			return
+		}
+		if a.remote != nil {
+			a.remote.emit("token", text, nil)
+			return
+		}
+		if !started {
+			started = true
+			fmt.Print("\033[2m")
+		}
+		fmt.Print(text)
+	}
+	onToken = func(text string) {
+		if filter != nil {
+			text = filter.write(text)
+		}
+		show(text)
+	}
+	finish = func() {
+		if filter != nil {
+			show(filter.flush())
+		}
+		if started {
+			fmt.Print("\033[0m\n")
+		}
+	}
+	return onToken, finish
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const spawnPromptTemplate = `
+SUB-AGENTS:
+If the task contains independent subtasks that do not depend on each other's results, you may delegate them to up to %d parallel sub-agents.
+To do so, output "SPAWN" followed by one self-contained subtask description per line on the following lines.
+Each sub-agent has its own conversation and a budget of %d steps, and cannot spawn further sub-agents. You will receive their results as a SUBAGENT_RESULTS message, and remain responsible for verifying the overall goal.
+`
+
+const subagentTaskTemplate = `%s
+(You are a sub-agent working on one part of a larger task handled by a parent agent. The overall task is: %s. Only do your part.)`
+
+func spawnPromptSection() string {
+	if cfg.MaxSubagents <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(spawnPromptTemplate, cfg.MaxSubagents, cfg.SubagentMaxSteps)
+}
+
+func (a *Agent) canSpawn() bool {
+	return a.Depth == 0 && cfg.MaxSubagents > 0
+}
+
+// listMarker matches the bullet or number of a list item.
+var listMarker = regexp.MustCompile(`^(\d+[.)]|[-*])\s+`)
+
+func parseSubtasks(content string) []string {
+	var subtasks []string
+	for _, line := range strings.Split(content, "\n") {
+		line = listMarker.ReplaceAllString(strings.TrimSpace(line), "")
+		if line != "" {
+			subtasks = append(subtasks, line)
+		}
+	}
+	return subtasks
+}
+
+// runSubagents runs one child agent per subtask in parallel and returns the
+// aggregated results as a feedback message for the parent.
+func (a *Agent) runSubagents(subtasks []string) string {
+	results := make([]AgentResult, len(subtasks))
+	errs := make([]error, len(subtasks))
+
+	children := make([]*Agent, len(subtasks))
+	var wg sync.WaitGroup
+	for i, subtask := range subtasks {
+		name := fmt.Sprintf("sub-agent %d", i+1)
+		task := fmt.Sprintf(subagentTaskTemplate, subtask, a.Task)
+		child := newAgent(name, subtask, generateSystemPrompt(task, runtime.GOOS, a.Shell, false), a.Shell, a.Depth+1)
+		child.MaxSteps = cfg.SubagentMaxSteps
+		child.parentSession = a.auditSession()
+		child.remote, child.reader = a.remote, a.reader
+		// Sub-agents start in their parent's directory and environment.
+		child.shell = a.shell
+		if a.shell.dir != "" {
+			child.Messages[0].Content += fmt.Sprintf("\n[working directory is now %s]", a.shell.dir)
+		}
+		children[i] = child
+
+		a.printf("🧬 Starting %s: %s\n", name, subtask)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = child.Run()
+		}()
+	}
+	wg.Wait()
+	for _, child := range children {
+		a.usage.merge(child.usage)
+	}
+
+	var feedback strings.Builder
+	feedback.WriteString("SUBAGENT_RESULTS:\n")
+	for i, subtask := range subtasks {
+		feedback.WriteString(fmt.Sprintf("[%d] %s\n", i+1, subtask))
+		if errs[i] != nil {
+			feedback.WriteString(fmt.Sprintf("STATUS: ERROR\nSUMMARY: %v\n\n", errs[i]))
+			continue
+		}
+		feedback.WriteString(fmt.Sprintf("STATUS: %s\nSUMMARY: %s\n\n", results[i].Status, results[i].Summary))
+	}
+	a.printf("🧬 All %d sub-agents finished.\n", len(subtasks))
+	return feedback.String()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// SudoConfig controls commands that run as root. Allowed false blocks sudo
+// and doas entirely. Offer has shai offer to add sudo to commands that
+// usually need root when it is not running as root.
+type SudoConfig struct {
+	Allowed bool `json:"allowed"`
+	Offer   bool `json:"offer"`
+}
+
+const noSudoPromptSection = `
+ROOT ACCESS:
+You cannot run commands as root: sudo and doas are disabled. If a step needs root, do everything else you can, then stop and tell the user the exact commands to run themselves.
+`
+
+func sudoPromptSectionText() string {
+	if cfg.Sudo.Allowed {
+		return ""
+	}
+	return noSudoPromptSection
+}
+
+// escalationPrograms run their arguments as another user, root by default.
+var escalationPrograms = []string{"sudo", "doas"}
+
+// rootSubcommands are invocations of tools that change the system and
+// fail without root.
+var rootSubcommands = map[string][]string{
+	"apt":       {"install", "remove", "purge", "upgrade", "full-upgrade", "dist-upgrade", "update", "autoremove"},
+	"apt-get":   {"install", "remove", "purge", "upgrade", "dist-upgrade", "update", "autoremove"},
+	"dnf":       {"install", "remove", "erase", "upgrade", "update", "autoremove"},
+	"yum":       {"install", "remove", "erase", "upgrade", "update", "autoremove"},
+	"zypper":    {"install", "in", "remove", "rm", "update", "up", "dist-upgrade", "dup"},
+	"apk":       {"add", "del", "upgrade", "update"},
+	"systemctl": {"start", "stop", "restart", "reload", "enable", "disable", "mask", "unmask", "daemon-reload"},
+}
+
+// rootCommands are programs that always need root to change anything.
+var rootCommands = []string{
+	"mount", "umount", "modprobe", "rmmod", "insmod", "swapon", "swapoff",
+	"useradd", "userdel", "usermod", "groupadd", "groupdel", "visudo", "chroot",
+	"iptables", "ip6tables", "nft", "ufw", "reboot", "shutdown", "poweroff",
+}
+
+// systemDirs are where a non-root user cannot write.
+var systemDirs = []string{"/etc/", "/usr/", "/boot/", "/opt/", "/lib/", "/srv/", "/var/lib/", "/var/log/"}
+
+// commandWords returns a simple command's words without leading variable
+// assignments, env and exec.
+func commandWords(words []string) []string {
+	for len(words) > 1 && (strings.Contains(words[0], "=") || words[0] == "env" || words[0] == "exec") {
+		words = words[1:]
+	}
+	return words
+}
+
+// elevates reports whether a command runs anything through sudo or doas.
+func elevates(command string) bool {
+	return escalationProgram(command) != ""
+}
+
+// escalationProgram returns the first of sudo and doas the command runs.
+func escalationProgram(command string) string {
+	for _, words := range splitPipeline(command) {
+		if name := filepath.Base(commandWords(words)[0]); slices.Contains(escalationPrograms, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// needsRoot guesses whether a command that does not elevate will fail for
+// lack of root: it changes packages, services, mounts or users, or writes
+// under a system directory.
+func needsRoot(command string) bool {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 || elevates(command) {
+		return false
+	}
+	for _, words := range splitPipeline(command) {
+		words = commandWords(words)
+		name := filepath.Base(words[0])
+		if slices.Contains(rootCommands, name) {
+			return true
+		}
+		if subcommands, ok := rootSubcommands[name]; ok && !slices.Contains(words, "--user") {
+			for _, word := range words[1:] {
+				if !strings.HasPrefix(word, "-") {
+					if slices.Contains(subcommands, word) {
+						return true
+					}
+					break
+				}
+			}
+		}
+		if name == "pacman" && len(words) > 1 {
+			op := words[1]
+			if strings.HasPrefix(op, "-R") || strings.HasPrefix(op, "-U") || (strings.HasPrefix(op, "-S") && !strings.ContainsAny(op[2:], "sil")) {
+				return true
+			}
+		}
+	}
+	for _, path := range writtenPaths(command) {
+		for _, dir := range systemDirs {
+			if strings.HasPrefix(path, dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withSudo prefixes a command with sudo, running it through sh when sudo
+// alone would not cover all of it: pipelines, lists and redirections.
+func withSudo(command string) string {
+	stages := splitPipeline(command)
+	if len(stages) == 1 && len(writtenPaths(command)) == 0 && !strings.ContainsAny(command, "<>$`") {
+		return "sudo " + command
+	}
+	return "sudo sh -c " + shellQuote(command)
+}
+
+// passwordless reports whether the command's sudo or doas can run without
+// asking for a password: it is configured not to, or has a cached login.
+func passwordless(command string) bool {
+	program := escalationProgram(command)
+	return program != "" && exec.Command(program, "-n", "true").Run() == nil
+}
+
+// authenticate has sudo or doas ask for the password on the terminal before
+// the command runs, when it cannot run in a PTY that would pass the prompt
+// through. sudo caches the login for the command; doas cannot be asked
+// ahead, and prompts on the terminal itself.
+func authenticate(command string) {
+	if escalationProgram(command) != "sudo" || usePTY(command) || passwordless(command) {
+		return
+	}
+	cmd := exec.Command("sudo", "-v")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Run()
+}
+
+// canPromptForPassword reports whether a password prompt would reach
+// someone at a terminal.
+func canPromptForPassword() bool {
+	return !cfg.NonInteractive && isTerminal(os.Stdin)
+}
+
+// elevate is asked before a command runs. For a command that uses sudo or
+// doas, the user approves the elevation separately; for one that needs
+// root, shai offers to add sudo. It returns why the command must not run,
+// or "" once it may, having updated command if sudo was added.
+func (a *Agent) elevate(command *string) string {
+	if !elevates(*command) {
+		if !cfg.Sudo.Allowed || !cfg.Sudo.Offer || !needsRoot(*command) || !canPromptForPassword() || a.remote != nil {
+			return ""
+		}
+		if _, err := exec.LookPath("sudo"); err != nil {
+			return ""
+		}
+		// The elevated command is a different command: it is only offered
+		// if the denylist and the policy would let it run.
+		elevated := withSudo(*command)
+		if _, denied := deniedBy(elevated); denied {
+			return ""
+		}
+		risk := classifyRisk(elevated)
+		if decision, _, _ := commandApproval(elevated, risk); decision == approvalDeny {
+			return ""
+		}
+		if a.confirm("🔐 This command usually needs root, and shai is not running as root. Run it with sudo instead, as a " + risk + " command?\n\n  $ " + elevated + "\n\n(No runs it as it is.)") {
+			*command = elevated
+		}
+		return ""
+	}
+
+	if !cfg.Sudo.Allowed {
+		return "POLICY_VIOLATION: sudo and doas are disabled by the configuration, so the command was not executed. Find a way that does not need root, or stop and tell the user what to run."
+	}
+	if a.remote == nil && !canPromptForPassword() {
+		if passwordless(*command) {
+			return ""
+		}
+		a.printf("🔐 %s needs a password and no one is at the terminal to type it.\n", escalationProgram(*command))
+		return "The command needs a password for root, and no one is at the terminal to type it, so it was not executed. Find a way that does not need root, or stop and tell the user what to run."
+	}
+	if !a.confirm("🔐 This requires elevated privileges: the command runs as root. Allow?") {
+		return "The user did not allow the command to run as root."
+	}
+	if a.remote != nil && !passwordless(*command) {
+		return "The command needs a password for root, which cannot be typed into a shai serve session, so it was not executed."
+	}
+	if a.remote == nil {
+		authenticate(*command)
+	}
+	return ""
+}
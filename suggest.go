@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+const suggestSystemPrompt = `You suggest a single shell command for what the user wants to do. You do not run anything.
+
+ENVIRONMENT:
+%s
+
+Reply in exactly this format and nothing else:
+COMMAND: <one command line for this shell, without code fences>
+EXPLANATION: <one to three sentences on what the command does and what its important options mean>`
+
+// suggestCommand runs `shai suggest`: one model call for one command, shown
+// with an explanation and offered to run, edit or copy, without the agent
+// loop.
+func suggestCommand(args []string) error {
+	prepareCall()
+	request := strings.Join(args, " ")
+	shell := defaultShell()
+
+	fmt.Println("🤔 shai is thinking...")
+	resp, err := callModel(executorModel(), []Message{{Role: "user", Content: request}}, fmt.Sprintf(suggestSystemPrompt, environmentBlock(runtime.GOOS, shell)))
+	if err != nil {
+		return fmt.Errorf("model API call failed: %w", err)
+	}
+	command, explanation := parseSuggestion(resp.Message.Content)
+	if command == "" {
+		return fmt.Errorf("the model did not suggest a command:\n%s", resp.Message.Content)
+	}
+	return offerSuggestion(command, explanation, shell, stdinReader)
+}
+
+// parseSuggestion reads the COMMAND and EXPLANATION of a reply, tolerating a
+// bare command, possibly fenced, from models that ignore the format.
+func parseSuggestion(reply string) (command string, explanation string) {
+	reply = strings.TrimSpace(reply)
+	for _, line := range strings.Split(reply, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(trimmed, "COMMAND:"); ok && command == "" {
+			command = strings.Trim(strings.TrimSpace(rest), "`")
+		} else if rest, ok := strings.CutPrefix(trimmed, "EXPLANATION:"); ok {
+			explanation = strings.TrimSpace(rest)
+		} else if explanation != "" && trimmed != "" {
+			explanation += " " + trimmed
+		}
+	}
+	if command == "" {
+		for _, line := range strings.Split(reply, "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "```") {
+				command = strings.TrimPrefix(line, "$ ")
+				break
+			}
+		}
+	}
+	return command, explanation
+}
+
+func offerSuggestion(command string, explanation string, shell string, reader *bufio.Reader) error {
+	for {
+		fmt.Printf("\n💡 [%s]\n\n  $ %s\n\n", classifyRisk(command), command)
+		if explanation != "" {
+			fmt.Printf("%s\n", explanation)
+		}
+		if cfg.NonInteractive {
+			return nil
+		}
+
+		fmt.Printf("\n[ (r)un / (e)dit / (c)opy / (q)uit ]: ")
+		input, _ := reader.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(input)) {
+		case "r", "run":
+			if err := checkCommand(command); err != nil {
+				return err
+			}
+			fmt.Printf("🚀 Running command via %s...\n", shell)
+			if status, _ := executeCommand(command, shell, nil, nil, nil); status != "SUCCESS" {
+				return fmt.Errorf("the command failed: %s", status)
+			}
+			return nil
+		case "e", "edit":
+			edited, err := editText(command, reader)
+			if err != nil {
+				fmt.Printf("⚠️ %v\n", err)
+			} else if strings.TrimSpace(edited) != "" {
+				command = strings.TrimSpace(edited)
+				explanation = ""
+			}
+		case "c", "copy":
+			if err := writeClipboard(command); err != nil {
+				return err
+			}
+			fmt.Println("📋 Copied to the clipboard.")
+			return nil
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// taskInput works out the task from the arguments, reading it from a file
+// with -f, or from stdin with -f - or a lone "-", so long task descriptions
+// need no shell quoting. With --stdin, what is piped in is returned
+// separately, to be attached as context. Arguments given alongside a task
+// file come before its contents.
+func taskInput(args []string) (task string, piped []attachment, err error) {
+	source := *taskFileFlag
+	if source == "" && len(args) == 1 && args[0] == "-" {
+		source, args = "-", nil
+	}
+	if source == "-" && *stdinFlag {
+		return "", nil, fmt.Errorf("--stdin cannot be combined with reading the task from stdin")
+	}
+
+	task = strings.Join(args, " ")
+	if source != "" {
+		var data []byte
+		if source == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(source)
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read the task: %w", err)
+		}
+		task = strings.TrimSpace(task + "\n\n" + string(data))
+		if task == "" {
+			return "", nil, fmt.Errorf("the task read from %s is empty", source)
+		}
+	}
+	if *stdinFlag {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read stdin: %w", err)
+		}
+		piped = append(piped, attachment{"stdin", string(data)})
+	}
+	if source == "-" || *stdinFlag {
+		reattachTerminal()
+	}
+	return task, piped, nil
+}
+
+// reattachTerminal reads answers from the terminal once stdin has been used
+// up for input. Without one, shai cannot ask anything, and an empty answer
+// must not count as approval, so it runs non-interactively.
+func reattachTerminal() {
+	name := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		name = "CONIN$"
+	}
+	if tty, err := os.Open(name); err == nil {
+		os.Stdin = tty
+		stdinReader = bufio.NewReader(tty)
+		return
+	}
+	if !cfg.NonInteractive {
+		fmt.Println("ℹ️ stdin was used for input and there is no terminal to ask you on, so shai runs non-interactively.")
+		cfg.NonInteractive = true
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// tgiChatProvider talks to a Hugging Face text-generation-inference server
+// through its OpenAI-compatible Messages API.
+type tgiChatProvider struct{}
+
+func (tgiChatProvider) name() string { return "TGI" }
+
+func (tgiChatProvider) request(model string, messages []Message, options map[string]any, stream bool) (string, any) {
+	// TGI serves a single model and ignores the name, but requires one.
+	if model == "" {
+		model = "tgi"
+	}
+	return openAIEndpoint("/chat/completions"), newChatCompletionsRequest(model, messages, options, stream)
+}
+
+// authorize sends api_key (or HF_TOKEN) for Hugging Face Inference Endpoints.
+func (tgiChatProvider) authorize(req *http.Request) {
+	bearerAuth(req, apiKey("HF_TOKEN"))
+}
+
+func (tgiChatProvider) parse(body io.Reader, onToken func(string)) (ChatResponse, error) {
+	return parseChatCompletionsResponse(body, onToken)
+}
+
+// tgiGenerateProvider uses TGI's native /generate endpoint with a prompt
+// built by shai, for models served without a chat template.
+type tgiGenerateProvider struct{}
+
+type tgiGenerateRequest struct {
+	Inputs     string             `json:"inputs"`
+	Parameters tgiGenerateOptions `json:"parameters"`
+}
+
+type tgiGenerateOptions struct {
+	MaxNewTokens int      `json:"max_new_tokens"`
+	Temperature  *float64 `json:"temperature,omitempty"`
+	TopP         *float64 `json:"top_p,omitempty"`
+	Seed         *int     `json:"seed,omitempty"`
+	Stop         []string `json:"stop,omitempty"`
+	Details      bool     `json:"details"`
+}
+
+// tgiStreamEvent is one server-sent event from /generate_stream.
+type tgiStreamEvent struct {
+	Token struct {
+		Text    string `json:"text"`
+		Special bool   `json:"special"`
+	} `json:"token"`
+	Details *struct {
+		GeneratedTokens int `json:"generated_tokens"`
+	} `json:"details"`
+}
+
+type tgiGenerateResponse struct {
+	GeneratedText string `json:"generated_text"`
+	Details       struct {
+		GeneratedTokens int `json:"generated_tokens"`
+	} `json:"details"`
+}
+
+const tgiDefaultMaxNewTokens = 1024
+
+func (tgiGenerateProvider) name() string { return "TGI" }
+
+func (tgiGenerateProvider) request(model string, messages []Message, options map[string]any, stream bool) (string, any) {
+	format, ok := promptFormats[cfg.PromptFormat]
+	if !ok {
+		format = promptFormats["chatml"]
+	}
+
+	params := tgiGenerateOptions{
+		MaxNewTokens: tgiDefaultMaxNewTokens,
+		Stop:         format.stop,
+		Details:      true,
+	}
+	if v, ok := optionInt(options, "num_predict"); ok {
+		params.MaxNewTokens = v
+	}
+	// TGI rejects a temperature of zero; greedy decoding is its default.
+	if v, ok := optionFloat(options, "temperature"); ok && v > 0 {
+		params.Temperature = &v
+	}
+	if v, ok := optionFloat(options, "top_p"); ok {
+		params.TopP = &v
+	}
+	if v, ok := optionInt(options, "seed"); ok {
+		params.Seed = &v
+	}
+
+	endpoint := "/generate"
+	if stream {
+		endpoint = "/generate_stream"
+	}
+	return apiEndpoint(endpoint), tgiGenerateRequest{
+		Inputs:     buildPrompt(format, messages),
+		Parameters: params,
+	}
+}
+
+func (tgiGenerateProvider) authorize(req *http.Request) {
+	bearerAuth(req, apiKey("HF_TOKEN"))
+}
+
+func (tgiGenerateProvider) parse(body io.Reader, onToken func(string)) (ChatResponse, error) {
+	if onToken != nil {
+		result := ChatResponse{Done: true}
+		var content strings.Builder
+		err := readServerSentEvents(body, func(data []byte) error {
+			var event tgiStreamEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				return err
+			}
+			if !event.Token.Special {
+				content.WriteString(event.Token.Text)
+				onToken(event.Token.Text)
+			}
+			if event.Details != nil {
+				result.EvalCount = event.Details.GeneratedTokens
+			}
+			return nil
+		})
+		result.Message = Message{Role: "assistant", Content: content.String()}
+		return result, err
+	}
+
+	var generated tgiGenerateResponse
+	if err := json.NewDecoder(body).Decode(&generated); err != nil {
+		return ChatResponse{}, err
+	}
+	result := ChatResponse{Done: true, EvalCount: generated.Details.GeneratedTokens}
+	result.Message = Message{Role: "assistant", Content: generated.GeneratedText}
+	return result, nil
+}
+
+// apiEndpoint joins a path onto the configured api_url, used by the
+// non-Ollama providers.
+func apiEndpoint(path string) string {
+	return strings.TrimRight(cfg.APIURL, "/") + path
+}
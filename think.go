@@ -0,0 +1,135 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Reasoning models (deepseek-r1, qwen3 and others) wrap their chain of
+// thought in <think>...</think> before the answer. It is removed before the
+// response is parsed, kept out of the conversation sent back to the model,
+// and only shown, dimmed, with "show_thinking".
+
+var (
+	reasoningOpen  = regexp.MustCompile(`<(think|thinking|reasoning)>`)
+	reasoningClose = regexp.MustCompile(`</(think|thinking|reasoning)>`)
+)
+
+// splitReasoning separates reasoning blocks from the answer. A block that
+// is never closed runs to the end, and a closing tag without an opening one
+// (some chat templates open the block in the prompt) ends a block that
+// started at the beginning.
+func splitReasoning(content string) (answer string, reasoning string) {
+	var answerText, reasoningText strings.Builder
+	open := reasoningOpen.FindStringIndex(content)
+	if close := reasoningClose.FindStringIndex(content); close != nil && (open == nil || close[0] < open[0]) {
+		reasoningText.WriteString(content[:close[0]])
+		content = content[close[1]:]
+	}
+	for {
+		open := reasoningOpen.FindStringIndex(content)
+		if open == nil {
+			answerText.WriteString(content)
+			break
+		}
+		answerText.WriteString(content[:open[0]])
+		content = content[open[1]:]
+		close := reasoningClose.FindStringIndex(content)
+		if close == nil {
+			reasoningText.WriteString(content)
+			break
+		}
+		reasoningText.WriteString(content[:close[0]] + "\n")
+		content = content[close[1]:]
+	}
+	return strings.TrimSpace(answerText.String()), strings.TrimSpace(reasoningText.String())
+}
+
+// withoutReasoning moves reasoning blocks out of a response's content and
+// into its Thinking field, next to any reasoning the API reported
+// separately.
+func withoutReasoning(message Message) Message {
+	answer, reasoning := splitReasoning(message.Content)
+	if reasoning == "" {
+		return message
+	}
+	message.Content = answer
+	message.Thinking = strings.TrimSpace(message.Thinking + "\n" + reasoning)
+	return message
+}
+
+// thinkingStream passes streamed reasoning and content to onToken, with the
+// reasoning in <think> tags.
+type thinkingStream struct {
+	onToken  func(string)
+	thinking bool
+}
+
+func (s *thinkingStream) write(reasoning string, content string) {
+	if reasoning != "" {
+		if !s.thinking {
+			s.thinking = true
+			s.onToken("<think>")
+		}
+		s.onToken(reasoning)
+	}
+	if content != "" {
+		if s.thinking {
+			s.thinking = false
+			s.onToken("</think>\n")
+		}
+		s.onToken(content)
+	}
+}
+
+// maxTagLength bounds how much streamed text is held back because it might
+// be the start of a tag.
+const maxTagLength = len("</reasoning>")
+
+// reasoningFilter hides reasoning blocks from streamed text. Tags may be
+// split across tokens, so text that could begin one is held back until it
+// is complete.
+type reasoningFilter struct {
+	pending string
+	inside  bool
+}
+
+func (f *reasoningFilter) write(text string) string {
+	f.pending += text
+	var out strings.Builder
+	for {
+		if f.inside {
+			close := reasoningClose.FindStringIndex(f.pending)
+			if close == nil {
+				f.pending = f.pending[max(len(f.pending)-maxTagLength, 0):]
+				return out.String()
+			}
+			f.pending = f.pending[close[1]:]
+			f.inside = false
+			continue
+		}
+		open := reasoningOpen.FindStringIndex(f.pending)
+		if open == nil {
+			keep := 0
+			if i := strings.LastIndex(f.pending, "<"); i >= 0 && len(f.pending)-i < maxTagLength && !strings.Contains(f.pending[i:], ">") {
+				keep = len(f.pending) - i
+			}
+			out.WriteString(f.pending[:len(f.pending)-keep])
+			f.pending = f.pending[len(f.pending)-keep:]
+			return out.String()
+		}
+		out.WriteString(f.pending[:open[0]])
+		f.pending = f.pending[open[1]:]
+		f.inside = true
+	}
+}
+
+// flush returns text held back at the end of the stream.
+func (f *reasoningFilter) flush() string {
+	if f.inside {
+		return ""
+	}
+	text := f.pending
+	f.pending = ""
+	return text
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// StepTiming is where the time of one step went: waiting for the model,
+// running commands (and queries and tool calls), and waiting for the user.
+// The rest of Total is shai itself, the critic, the linter and the like.
+type StepTiming struct {
+	Step     int     `json:"step"`
+	Model    float64 `json:"model_seconds"`
+	Commands float64 `json:"command_seconds"`
+	Human    float64 `json:"human_seconds"`
+	Total    float64 `json:"total_seconds"`
+}
+
+// stepClock times the current step.
+type stepClock struct {
+	running                bool
+	step                   int
+	start                  time.Time
+	model, commands, human time.Duration
+}
+
+// maxTimingRows is how many steps the summary lists before it keeps to
+// the slowest ones.
+const maxTimingRows = 20
+
+// startStepClock starts timing a step, finishing the previous one.
+func (a *Agent) startStepClock() {
+	a.stopStepClock()
+	a.clock = stepClock{running: true, step: a.Step + 1, start: time.Now()}
+}
+
+func (a *Agent) stopStepClock() {
+	c := a.clock
+	if !c.running {
+		return
+	}
+	a.clock.running = false
+	a.Timings = append(a.Timings, StepTiming{
+		Step:     c.step,
+		Model:    c.model.Seconds(),
+		Commands: c.commands.Seconds(),
+		Human:    c.human.Seconds(),
+		Total:    time.Since(c.start).Seconds(),
+	})
+}
+
+// The time* helpers add the time since start to the current step; use them
+// as defer a.timeHuman(time.Now()).
+func (a *Agent) timeModel(start time.Time)   { a.clock.model += time.Since(start) }
+func (a *Agent) timeCommand(start time.Time) { a.clock.commands += time.Since(start) }
+func (a *Agent) timeHuman(start time.Time)   { a.clock.human += time.Since(start) }
+
+// printTimings prints where the time of a run's steps went, with totals
+// and shares, so slowness can be put down to the model, the machine or the
+// user.
+func printTimings(timings []StepTiming) {
+	if len(timings) == 0 {
+		return
+	}
+	var total StepTiming
+	for _, t := range timings {
+		total.Model += t.Model
+		total.Commands += t.Commands
+		total.Human += t.Human
+		total.Total += t.Total
+	}
+
+	rows := timings
+	note := ""
+	if len(rows) > maxTimingRows {
+		rows = slices.Clone(rows)
+		slices.SortFunc(rows, func(a, b StepTiming) int { return int(b.Total*1000) - int(a.Total*1000) })
+		rows = rows[:maxTimingRows]
+		slices.SortFunc(rows, func(a, b StepTiming) int { return a.Step - b.Step })
+		note = fmt.Sprintf(" (the %d slowest of %d steps)", maxTimingRows, len(timings))
+	}
+
+	fmt.Printf("⏱️  Where the time went%s:\n", note)
+	fmt.Printf("  %-6s %9s %9s %9s %9s %9s\n", "step", "model", "commands", "you", "other", "total")
+	row := func(label string, t StepTiming) {
+		other := max(t.Total-t.Model-t.Commands-t.Human, 0)
+		fmt.Printf("  %-6s %9s %9s %9s %9s %9s\n", label, formatSeconds(t.Model), formatSeconds(t.Commands), formatSeconds(t.Human), formatSeconds(other), formatSeconds(t.Total))
+	}
+	for _, t := range rows {
+		row(fmt.Sprint(t.Step), t)
+	}
+	row("total", total)
+	if total.Total > 0 {
+		share := func(s float64) string { return fmt.Sprintf("%.0f%%", 100*s/total.Total) }
+		other := max(total.Total-total.Model-total.Commands-total.Human, 0)
+		fmt.Printf("  %-6s %9s %9s %9s %9s\n", "share", share(total.Model), share(total.Commands), share(total.Human), share(other))
+	}
+}
+
+// renderTimings is the transcript's table of step timings.
+func renderTimings(timings []StepTiming) string {
+	var md strings.Builder
+	md.WriteString("| Step | Model | Commands | User | Total |\n| --- | --- | --- | --- | --- |\n")
+	for _, t := range timings {
+		fmt.Fprintf(&md, "| %d | %s | %s | %s | %s |\n", t.Step, formatSeconds(t.Model), formatSeconds(t.Commands), formatSeconds(t.Human), formatSeconds(t.Total))
+	}
+	return md.String()
+}
+
+func formatSeconds(s float64) string {
+	if s < 60 {
+		return fmt.Sprintf("%.1fs", s)
+	}
+	return (time.Duration(s) * time.Second).Round(time.Second).String()
+}
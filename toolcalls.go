@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Native tool calling. With Ollama's chat API, the core actions are offered
+// as tools so the model answers with structured tool calls instead of
+// keywords, which smaller models often get wrong. Tool calls are translated
+// back into the text protocol, so every other action and every model without
+// tool support keeps working unchanged.
+
+// Tool is a function the model may call, in Ollama's tools format.
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Parameters  any    `json:"parameters"`
+}
+
+type ToolParameters struct {
+	Type       string                  `json:"type"`
+	Properties map[string]ToolProperty `json:"properties"`
+	Required   []string                `json:"required"`
+}
+
+type ToolProperty struct {
+	Type        string   `json:"type"`
+	Description string   `json:"description"`
+	Enum        []string `json:"enum,omitempty"`
+}
+
+// ToolCall is a call made by the model in an assistant message.
+type ToolCall struct {
+	Function struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	} `json:"function"`
+}
+
+// toolCaller is implemented by providers whose API supports tool calling.
+type toolCaller interface {
+	withTools(body any, tools []Tool) any
+}
+
+var agentTools = []Tool{
+	{Type: "function", Function: ToolFunction{
+		Name:        "run_command",
+		Description: "Run a shell command on the user's machine, after the user approves it, and return its status and output.",
+		Parameters: ToolParameters{
+			Type: "object",
+			Properties: map[string]ToolProperty{
+				"command": {Type: "string", Description: "The complete command line, for the user's shell."},
+			},
+			Required: []string{"command"},
+		},
+	}},
+	{Type: "function", Function: ToolFunction{
+		Name:        "run_script",
+		Description: "Run a multi-line script with the interpreter of its language, after the user approves it, and return its status and output. Prefer it to run_command for loops, several steps or heavy quoting.",
+		Parameters: ToolParameters{
+			Type: "object",
+			Properties: map[string]ToolProperty{
+				"language": {Type: "string", Description: "The script's language.", Enum: scriptLanguageNames},
+				"script":   {Type: "string", Description: "The complete script, without a code fence."},
+			},
+			Required: []string{"language", "script"},
+		},
+	}},
+	{Type: "function", Function: ToolFunction{
+		Name:        "ask_user",
+		Description: "Ask the user a question when the task is ambiguous or needs information only they have, and return their answer.",
+		Parameters: ToolParameters{
+			Type: "object",
+			Properties: map[string]ToolProperty{
+				"question": {Type: "string", Description: "The question to ask."},
+			},
+			Required: []string{"question"},
+		},
+	}},
+	{Type: "function", Function: ToolFunction{
+		Name:        "finish",
+		Description: "End the task, either because it is complete and verified or because it cannot proceed.",
+		Parameters: ToolParameters{
+			Type: "object",
+			Properties: map[string]ToolProperty{
+				"status":  {Type: "string", Description: "complete if the goal was achieved and verified, stopped otherwise.", Enum: []string{"complete", "stopped"}},
+				"summary": {Type: "string", Description: "What was done, or why the task cannot proceed."},
+			},
+			Required: []string{"status", "summary"},
+		},
+	}},
+}
+
+const toolCallingPromptSection = `
+TOOL CALLING:
+If you have the run_command, run_script, ask_user and finish tools, call them instead of writing RUN, RUN_SCRIPT, ASK, TASK_COMPLETE or TASK_STOPPED. Every other action is still written as text.
+`
+
+var (
+	toolsMu sync.Mutex
+	// textOnlyModels are models that rejected tools; they use the text
+	// protocol for the rest of the run.
+	textOnlyModels = map[string]bool{}
+)
+
+func checkProtocol() error {
+	switch cfg.Protocol {
+	case "auto", "text", "json":
+		return nil
+	case "tools":
+		if p, err := currentProvider(); cfg.Provider == "llamacpp" || err != nil {
+			return fmt.Errorf("protocol \"tools\" is not supported by provider %q", cfg.Provider)
+		} else if _, ok := p.(toolCaller); !ok {
+			return fmt.Errorf("protocol \"tools\" is not supported by provider %q", cfg.Provider)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown protocol %q (expected auto, tools, json or text)", cfg.Protocol)
+	}
+}
+
+func toolCallingPromptSectionText() string {
+	if cfg.Protocol == "text" || cfg.Protocol == "json" {
+		return ""
+	}
+	return toolCallingPromptSection
+}
+
+// toolsFor returns the tools to offer model, or nil to use the text protocol.
+func toolsFor(model string) []Tool {
+	if cfg.Protocol == "text" || cfg.Protocol == "json" || cfg.Provider == "llamacpp" {
+		return nil
+	}
+	if p, err := currentProvider(); err != nil {
+		return nil
+	} else if _, ok := p.(toolCaller); !ok {
+		return nil
+	}
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	if textOnlyModels[model] {
+		return nil
+	}
+	return append(slices.Clone(agentTools), mcpFunctionTools()...)
+}
+
+// fallBackToText handles a request that failed because the model does not
+// support tools. It reports whether the request should be retried with the
+// text protocol.
+func fallBackToText(model string, err error) bool {
+	if cfg.Protocol != "auto" || !strings.Contains(err.Error(), "does not support tools") {
+		return false
+	}
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	textOnlyModels[model] = true
+	return true
+}
+
+// toolCallAction translates a tool call into a text protocol action and its
+// content.
+func toolCallAction(call ToolCall) (action string, content string) {
+	argument := func(name string) string {
+		if value, ok := call.Function.Arguments[name]; ok {
+			if s, ok := value.(string); ok {
+				return strings.TrimSpace(s)
+			}
+			return strings.TrimSpace(fmt.Sprint(value))
+		}
+		return ""
+	}
+
+	switch call.Function.Name {
+	case "run_command":
+		return "RUN", argument("command")
+	case "run_script":
+		return "RUN_SCRIPT", argument("language") + "\n" + argument("script")
+	case "ask_user":
+		return "ASK", argument("question")
+	case "finish":
+		if strings.EqualFold(argument("status"), "complete") {
+			return "TASK_COMPLETE", argument("summary")
+		}
+		return "TASK_STOPPED", argument("summary")
+	}
+	if server, tool, ok := strings.Cut(call.Function.Name, "__"); ok {
+		arguments, _ := json.Marshal(call.Function.Arguments)
+		return "MCP", server + "." + tool + "\n" + string(arguments)
+	}
+	return strings.ToUpper(call.Function.Name), ""
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// defaultToolInventory lists the programs models most often reach for. Telling
+// the model which of them are installed saves the steps it would spend on
+// commands that cannot run here.
+var defaultToolInventory = []string{
+	"git", "docker", "podman", "python3", "python", "node", "npm", "go", "make", "gcc",
+	"curl", "wget", "jq", "rg", "ffmpeg", "systemctl", "sudo", "kubectl",
+}
+
+var (
+	toolInventoryOnce sync.Once
+	installedTools    []string
+	missingTools      []string
+)
+
+// probeTools looks up the tool_inventory programs on PATH, once per run.
+func probeTools() (installed []string, missing []string) {
+	toolInventoryOnce.Do(func() {
+		for _, tool := range cfg.ToolInventory {
+			if _, err := exec.LookPath(tool); err == nil {
+				installedTools = append(installedTools, tool)
+			} else {
+				missingTools = append(missingTools, tool)
+			}
+		}
+	})
+	return installedTools, missingTools
+}
+
+func toolInventoryEnvironmentLine() string {
+	installed, missing := probeTools()
+	var line strings.Builder
+	if len(installed) > 0 {
+		fmt.Fprintf(&line, "\nInstalled Tools: %s", strings.Join(installed, ", "))
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(&line, "\nNot Installed: %s (do not use these; install them first or use an alternative)", strings.Join(missing, ", "))
+	}
+	return line.String()
+}
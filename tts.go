@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// TTSConfig makes shai speak its questions and completion notices. By default
+// the platform's speech command is used (say, spd-say, espeak-ng, espeak or
+// the .NET synthesizer on Windows); Command overrides it with an argv where
+// "{text}" is replaced by the text. If URL is set, text is instead sent to an
+// OpenAI-compatible /v1/audio/speech endpoint and the returned audio is played
+// with PlayCommand ("{file}" is replaced by the audio file).
+type TTSConfig struct {
+	Enabled     bool     `json:"enabled"`
+	Command     []string `json:"command"`
+	URL         string   `json:"url"`
+	Model       string   `json:"model"`
+	Voice       string   `json:"voice"`
+	APIKey      string   `json:"api_key"`
+	PlayCommand []string `json:"play_command"`
+}
+
+const windowsSpeakScript = `Add-Type -AssemblyName System.Speech; (New-Object System.Speech.Synthesis.SpeechSynthesizer).Speak($env:SHAI_TTS_TEXT)`
+
+// speak says text in the background; failures are reported but never
+// interrupt the agent.
+func speak(text string) {
+	go speakWait(text)
+}
+
+// speakWait says text and returns once it has been spoken, for
+// notifications right before shai exits.
+func speakWait(text string) {
+	if !cfg.TTS.Enabled || strings.TrimSpace(text) == "" {
+		return
+	}
+	var err error
+	if cfg.TTS.URL != "" {
+		err = speakHTTP(text)
+	} else {
+		err = speakCommand(text)
+	}
+	if err != nil {
+		fmt.Printf("⚠️ Text-to-speech failed: %v\n", err)
+	}
+}
+
+func speakCommand(text string) error {
+	if len(cfg.TTS.Command) > 0 {
+		argv := make([]string, len(cfg.TTS.Command))
+		for i, arg := range cfg.TTS.Command {
+			argv[i] = strings.ReplaceAll(arg, "{text}", text)
+		}
+		return exec.Command(argv[0], argv[1:]...).Run()
+	}
+
+	if runtime.GOOS == "windows" {
+		cmd := exec.Command("powershell.exe", "-NoProfile", "-Command", windowsSpeakScript)
+		cmd.Env = append(os.Environ(), "SHAI_TTS_TEXT="+text)
+		return cmd.Run()
+	}
+	for _, name := range []string{"say", "spd-say", "espeak-ng", "espeak"} {
+		if _, err := exec.LookPath(name); err == nil {
+			args := []string{text}
+			if name == "spd-say" {
+				args = []string{"--wait", text}
+			}
+			return exec.Command(name, args...).Run()
+		}
+	}
+	return fmt.Errorf("no speech command found; install espeak-ng or set tts.command")
+}
+
+func speakHTTP(text string) error {
+	jsonBody, _ := json.Marshal(map[string]string{
+		"model": cfg.TTS.Model,
+		"voice": cfg.TTS.Voice,
+		"input": text,
+	})
+	req, err := http.NewRequest("POST", cfg.TTS.URL, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.TTS.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.TTS.APIKey)
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach TTS server at %s: %w", cfg.TTS.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("TTS server returned status code %d. Body: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	audio, err := os.CreateTemp("", "shai-tts-*.mp3")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(audio.Name())
+	_, err = io.Copy(audio, resp.Body)
+	audio.Close()
+	if err != nil {
+		return err
+	}
+	return playAudio(audio.Name())
+}
+
+func playAudio(file string) error {
+	argv := cfg.TTS.PlayCommand
+	if len(argv) == 0 {
+		for _, candidate := range [][]string{
+			{"afplay", "{file}"},
+			{"ffplay", "-nodisp", "-autoexit", "-loglevel", "quiet", "{file}"},
+			{"mpv", "--really-quiet", "{file}"},
+			{"paplay", "{file}"},
+		} {
+			if _, err := exec.LookPath(candidate[0]); err == nil {
+				argv = candidate
+				break
+			}
+		}
+	}
+	if len(argv) == 0 {
+		return fmt.Errorf("no audio player found; set tts.play_command")
+	}
+
+	resolved := make([]string, len(argv))
+	for i, arg := range argv {
+		resolved[i] = strings.ReplaceAll(arg, "{file}", file)
+	}
+	return exec.Command(resolved[0], resolved[1:]...).Run()
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JournalEntry records one executed command and what is needed to reverse
+// it: backups of the files it was expected to modify and the packages it
+// installed.
+type JournalEntry struct {
+	ID             string       `json:"id"`
+	Time           time.Time    `json:"time"`
+	Task           string       `json:"task"`
+	Session        string       `json:"session,omitempty"`
+	Command        string       `json:"command"`
+	Dir            string       `json:"dir"`
+	Status         string       `json:"status"`
+	Backups        []FileBackup `json:"backups,omitempty"`
+	Packages       []string     `json:"packages,omitempty"`
+	PackageManager string       `json:"package_manager,omitempty"`
+	Undone         bool         `json:"undone,omitempty"`
+}
+
+// FileBackup is the state of a file before a command ran. Backup is empty
+// when the file did not exist, so undoing removes it.
+type FileBackup struct {
+	Path   string      `json:"path"`
+	Backup string      `json:"backup,omitempty"`
+	Mode   os.FileMode `json:"mode,omitempty"`
+}
+
+const maxBackupSize = 50 << 20
+
+func getStateDir() (string, error) {
+	var dir string
+	const appName = "shai"
+
+	switch runtime.GOOS {
+	case "windows":
+		dir = os.Getenv("LOCALAPPDATA")
+	default:
+		dir = os.Getenv("XDG_STATE_HOME")
+		if dir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			dir = filepath.Join(home, ".local", "state")
+		}
+	}
+
+	appDir := filepath.Join(dir, appName)
+	if err := os.MkdirAll(appDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create state directory %s: %w", appDir, err)
+	}
+	return appDir, nil
+}
+
+func journalPath() (string, error) {
+	dir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "journal.jsonl"), nil
+}
+
+// writtenPaths guesses which files a command will create or modify, from
+// redirections and the arguments of common file-changing commands. It is a
+// heuristic: anything it misses simply cannot be undone.
+func writtenPaths(command string) []string {
+	var paths []string
+	for _, words := range splitPipeline(command) {
+		for i := 0; i < len(words); i++ {
+			word := words[i]
+			if target, ok := strings.CutPrefix(strings.TrimLeft(word, "0123456789&"), ">"); ok {
+				target = strings.TrimPrefix(target, ">")
+				if target == "" && i+1 < len(words) {
+					i++
+					target = words[i]
+				}
+				if target != "" && !strings.HasPrefix(target, "&") && target != "/dev/null" {
+					paths = append(paths, target)
+				}
+			}
+		}
+		if len(words) == 0 {
+			continue
+		}
+
+		name := filepath.Base(words[0])
+		args := words[1:]
+		if name == "sudo" && len(args) > 0 {
+			name, args = filepath.Base(args[0]), args[1:]
+		}
+		operands := nonFlagArgs(args)
+		switch name {
+		case "rm", "truncate", "touch", "chmod", "chown", "tee", "shred":
+			if name == "chmod" || name == "chown" {
+				if len(operands) > 0 {
+					operands = operands[1:]
+				}
+			}
+			paths = append(paths, operands...)
+		case "sed", "perl":
+			if hasInPlaceFlag(args) && len(operands) > 1 {
+				paths = append(paths, operands[1:]...)
+			}
+		case "mv":
+			paths = append(paths, operands...)
+		case "cp", "install", "ln":
+			if len(operands) > 1 {
+				paths = append(paths, operands[len(operands)-1])
+			}
+		}
+	}
+	return paths
+}
+
+func nonFlagArgs(args []string) []string {
+	var operands []string
+	for _, arg := range args {
+		if arg == ">" || arg == ">>" || strings.HasPrefix(arg, ">") || strings.HasPrefix(arg, "2>") {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			operands = append(operands, arg)
+		}
+	}
+	return operands
+}
+
+func hasInPlaceFlag(args []string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--in-place") || (strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "i")) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPipeline splits a command line into simple commands and those into
+// words, honouring quotes. Command substitutions and the like are not
+// understood.
+func splitPipeline(command string) [][]string {
+	var commands [][]string
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(words) > 0 {
+			commands = append(commands, words)
+			words = nil
+		}
+	}
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case r == ';' || r == '|' || r == '\n' || (r == '&' && (i+1 >= len(runes) || runes[i+1] != '>') && (i == 0 || runes[i-1] != '>')):
+			endCommand()
+		case r == ' ' || r == '\t':
+			endWord()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	endCommand()
+	return commands
+}
+
+// newJournalEntry backs up the files command, run in dir, is expected to
+// modify before it runs. Backup failures only mean the step cannot be undone
+// later.
+func newJournalEntry(session, task, dir, command string, packages []string) *JournalEntry {
+	entry := newJournalEntryForPaths(session, task, dir, command, writtenPaths(command))
+	entry.Packages = packages
+	if len(packages) > 0 {
+		if pm := systemPackageManager(); pm != nil {
+			entry.PackageManager = pm.Name
+		}
+	}
+	return entry
+}
+
+// newJournalEntryForPaths journals an action that writes the given paths,
+// relative to dir, backing them up first. The backups of a session's commands are kept
+// together, under backups/<session>/.
+func newJournalEntryForPaths(session, task, dir, command string, paths []string) *JournalEntry {
+	now := time.Now()
+	entry := &JournalEntry{
+		ID:      now.Format("20060102-150405.000000000"),
+		Time:    now,
+		Task:    task,
+		Session: session,
+		Command: command,
+		Dir:     dir,
+	}
+
+	stateDir, err := getStateDir()
+	if err != nil {
+		return entry
+	}
+	backupDir := filepath.Join(stateDir, "backups", session, entry.ID)
+
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(entry.Dir, path)
+		}
+		path = filepath.Clean(path)
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			entry.Backups = append(entry.Backups, FileBackup{Path: path})
+			continue
+		}
+		if err != nil || !info.Mode().IsRegular() || info.Size() > maxBackupSize {
+			continue
+		}
+		backup := filepath.Join(backupDir, strconv.Itoa(len(entry.Backups)))
+		if err := copyFile(path, backup, 0600); err != nil {
+			continue
+		}
+		entry.Backups = append(entry.Backups, FileBackup{Path: path, Backup: backup, Mode: info.Mode().Perm()})
+	}
+	return entry
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// appendJournal records a finished command. It writes nothing unless it
+// holds the journal lock, since markUndone may be rewriting the journal.
+func appendJournal(entry *JournalEntry, status string) error {
+	if replaying() {
+		return nil
+	}
+	entry.Status = status
+	path, err := journalPath()
+	if err != nil {
+		return err
+	}
+	release, err := lockJournal()
+	if err != nil {
+		return fmt.Errorf("failed to lock the undo journal: %w", err)
+	}
+	defer release()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, _ := json.Marshal(entry)
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+func readJournal() ([]JournalEntry, error) {
+	path, err := journalPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1<<20), 1<<24)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// markUndone flags journal entries as undone. The journal is read again
+// under its lock, so entries other processes added meanwhile are kept.
+func markUndone(ids ...string) error {
+	release, err := lockJournal()
+	if err != nil {
+		return err
+	}
+	defer release()
+	entries, err := readJournal()
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		if slices.Contains(ids, entries[i].ID) {
+			entries[i].Undone = true
+		}
+	}
+	return writeJournal(entries)
+}
+
+func writeJournal(entries []JournalEntry) error {
+	path, err := journalPath()
+	if err != nil {
+		return err
+	}
+	var buf strings.Builder
+	for _, entry := range entries {
+		data, _ := json.Marshal(entry)
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(buf.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// undoLast reverses the most recent command shai ran that has not been
+// undone yet, as far as the journal allows, and reports what it could not
+// reverse.
+func undoLast(reader *bufio.Reader) error {
+	entries, err := readJournal()
+	if err != nil {
+		return fmt.Errorf("failed to read the journal: %w", err)
+	}
+	last := -1
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].Undone {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		fmt.Println("🤷 Nothing to undo.")
+		return nil
+	}
+	entry := &entries[last]
+
+	fmt.Printf("↩️  Undoing the last command (%s, in %s):\n\n  $ %s\n", entry.Time.Format(time.DateTime), entry.Dir, entry.Command)
+	if !confirmAction("Undo it?", reader) {
+		return nil
+	}
+
+	failed := reverseEntry(entry, reader)
+	if len(entry.Backups) == 0 && len(entry.Packages) == 0 {
+		fmt.Println("⚠️ shai did not track any changes made by this command, so there is nothing it can restore.")
+	} else {
+		fmt.Println("⚠️ Only the files and packages listed above were reversed; other effects of the command (network calls, processes, files it was not expected to touch) cannot be undone.")
+	}
+	for _, f := range failed {
+		fmt.Printf("❌ Not undone: %s\n", f)
+	}
+
+	if err := markUndone(entry.ID); err != nil {
+		return fmt.Errorf("failed to update the journal: %w", err)
+	}
+	return nil
+}
+
+// undoSession reverses every command of a session that has not been undone
+// yet, newest first, so each file ends up as it was before the session
+// touched it.
+func undoSession(id string, reader *bufio.Reader) error {
+	entries, err := readJournal()
+	if err != nil {
+		return fmt.Errorf("failed to read the journal: %w", err)
+	}
+	var pending []int
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Session == id && !entries[i].Undone {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		fmt.Printf("🤷 Nothing to undo in session %s.\n", id)
+		return nil
+	}
+
+	fmt.Printf("↩️  Undoing %d command(s) of session %s, newest first:\n\n", len(pending), id)
+	for _, i := range pending {
+		fmt.Printf("  $ %s\n", entries[i].Command)
+	}
+	if !confirmAction("Undo them?", reader) {
+		return nil
+	}
+
+	var failed []string
+	var ids []string
+	untracked := 0
+	for _, i := range pending {
+		entry := &entries[i]
+		failed = append(failed, reverseEntry(entry, reader)...)
+		if len(entry.Backups) == 0 && len(entry.Packages) == 0 {
+			untracked++
+		}
+		ids = append(ids, entry.ID)
+	}
+	if untracked > 0 {
+		fmt.Printf("⚠️ shai did not track the changes of %d of these commands, so it could not restore them.\n", untracked)
+	}
+	fmt.Println("⚠️ Only the files and packages listed above were reversed; other effects of the commands cannot be undone.")
+	for _, f := range failed {
+		fmt.Printf("❌ Not undone: %s\n", f)
+	}
+
+	if err := markUndone(ids...); err != nil {
+		return fmt.Errorf("failed to update the journal: %w", err)
+	}
+	return nil
+}
+
+// undoCommand runs `shai undo`: the last command, or a whole session.
+func undoCommand(args []string) error {
+	if len(args) == 0 {
+		return undoLast(stdinReader)
+	}
+	return undoSession(args[0], stdinReader)
+}
+
+// reverseEntry restores the files a journaled command changed and offers to
+// uninstall the packages it installed. It returns what it could not reverse.
+func reverseEntry(entry *JournalEntry, reader *bufio.Reader) []string {
+	var failed []string
+	for _, backup := range entry.Backups {
+		if backup.Backup == "" {
+			if _, err := os.Lstat(backup.Path); os.IsNotExist(err) {
+				continue
+			}
+			if err := os.Remove(backup.Path); err != nil {
+				failed = append(failed, fmt.Sprintf("%s (could not remove: %v)", backup.Path, err))
+				continue
+			}
+			fmt.Printf("🗑️  Removed %s, which the command created\n", backup.Path)
+			continue
+		}
+		if err := copyFile(backup.Backup, backup.Path, backup.Mode); err != nil {
+			failed = append(failed, fmt.Sprintf("%s (could not restore: %v)", backup.Path, err))
+			continue
+		}
+		os.Chmod(backup.Path, backup.Mode)
+		fmt.Printf("♻️  Restored %s\n", backup.Path)
+	}
+
+	if len(entry.Packages) > 0 {
+		command, err := removeCommand(entry.PackageManager, entry.Packages)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("installed packages %s (%v)", strings.Join(entry.Packages, " "), err))
+		} else if confirmAction(fmt.Sprintf("📦 The command installed %s. Uninstall them with:\n\n  $ %s\n\nRun it?", strings.Join(entry.Packages, " "), command), reader) {
+			if status, _ := executeCommand(command, defaultShell(), nil, nil, nil); status != "SUCCESS" {
+				failed = append(failed, fmt.Sprintf("installed packages %s (uninstall: %s)", strings.Join(entry.Packages, " "), status))
+			}
+		} else {
+			failed = append(failed, fmt.Sprintf("installed packages %s (kept)", strings.Join(entry.Packages, " ")))
+		}
+	}
+	return failed
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// Usage counts an agent's model calls and tokens per model. It is saved
+// with the session, so the totals of a resumed session carry on.
+type Usage map[string]ModelUsage
+
+type ModelUsage struct {
+	Steps        int `json:"steps"`
+	PromptTokens int `json:"prompt_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// ModelPrice is what a model costs, in dollars per million tokens.
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+func (u *Usage) add(model string, resp ChatResponse) {
+	if *u == nil {
+		*u = Usage{}
+	}
+	m := (*u)[model]
+	m.Steps++
+	m.PromptTokens += resp.PromptEvalCount
+	m.OutputTokens += resp.EvalCount
+	(*u)[model] = m
+}
+
+// merge adds another agent's usage, such as a sub-agent's.
+func (u *Usage) merge(other Usage) {
+	if *u == nil {
+		*u = Usage{}
+	}
+	for model, o := range other {
+		m := (*u)[model]
+		m.Steps += o.Steps
+		m.PromptTokens += o.PromptTokens
+		m.OutputTokens += o.OutputTokens
+		(*u)[model] = m
+	}
+}
+
+// since is the usage added after an earlier copy of u.
+func (u Usage) since(earlier Usage) Usage {
+	diff := Usage{}
+	for model, m := range u {
+		e := earlier[model]
+		if d := (ModelUsage{m.Steps - e.Steps, m.PromptTokens - e.PromptTokens, m.OutputTokens - e.OutputTokens}); d != (ModelUsage{}) {
+			diff[model] = d
+		}
+	}
+	return diff
+}
+
+func (u Usage) clone() Usage {
+	return maps.Clone(u)
+}
+
+func (u Usage) totals() (steps int, prompt int, output int) {
+	for _, m := range u {
+		steps += m.Steps
+		prompt += m.PromptTokens
+		output += m.OutputTokens
+	}
+	return steps, prompt, output
+}
+
+// cost prices the usage with cfg.Prices. priced is false when none of the
+// models has a price, as with local models.
+func (u Usage) cost() (dollars float64, priced bool) {
+	for model, m := range u {
+		if price, ok := cfg.Prices[model]; ok {
+			dollars += (float64(m.PromptTokens)*price.Input + float64(m.OutputTokens)*price.Output) / 1e6
+			priced = true
+		}
+	}
+	return dollars, priced
+}
+
+func (u Usage) modelSteps() map[string]int {
+	steps := map[string]int{}
+	for model, m := range u {
+		steps[model] = m.Steps
+	}
+	return steps
+}
+
+// describe summarizes the usage for the end of a task.
+func (u Usage) describe() string {
+	steps, prompt, output := u.totals()
+	text := fmt.Sprintf("%s prompt + %s output tokens over %d model calls", formatCount(prompt), formatCount(output), steps)
+	if dollars, ok := u.cost(); ok {
+		text += fmt.Sprintf(" (%s)", formatCost(dollars))
+	}
+	return text
+}
+
+// progress is the short running total shown as each step starts.
+func (u Usage) progress() string {
+	_, prompt, output := u.totals()
+	if prompt+output == 0 {
+		return ""
+	}
+	text := formatTokens(prompt+output) + " tokens"
+	if dollars, ok := u.cost(); ok {
+		text += ", " + formatCost(dollars)
+	}
+	return text
+}
+
+// formatTokens abbreviates a token count: 950, 12.3k, 1.2M.
+func formatTokens(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fk", float64(n)/1e3)
+	}
+	return fmt.Sprint(n)
+}
+
+// formatCount writes a count with thousands separators.
+func formatCount(n int) string {
+	s := fmt.Sprint(n)
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func formatCost(dollars float64) string {
+	if dollars < 0.01 {
+		return fmt.Sprintf("~$%.4f", dollars)
+	}
+	return fmt.Sprintf("~$%.2f", dollars)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+const visionPromptSection = `
+IMAGES:
+You can see images. Images mentioned in the task are attached to the first message.
+To look at the screen, output "SCREENSHOT". To look at an image file, output "VIEW_IMAGE" followed by its path. The image is attached to the next message.
+`
+
+const maxImageSize = 20 << 20
+
+const windowsScreenshotScript = `Add-Type -AssemblyName System.Windows.Forms,System.Drawing; ` +
+	`$b = [System.Windows.Forms.Screen]::PrimaryScreen.Bounds; ` +
+	`$bmp = New-Object System.Drawing.Bitmap $b.Width, $b.Height; ` +
+	`[System.Drawing.Graphics]::FromImage($bmp).CopyFromScreen($b.Location, [System.Drawing.Point]::Empty, $b.Size); ` +
+	`$bmp.Save($env:SHAI_SCREENSHOT, [System.Drawing.Imaging.ImageFormat]::Png)`
+
+var imagePathPattern = regexp.MustCompile(`(?i)[^\s"'` + "`" + `]+\.(png|jpe?g|gif|webp|bmp)\b`)
+
+func visionPromptSectionText() string {
+	if !cfg.Vision {
+		return ""
+	}
+	return visionPromptSection
+}
+
+func loadImage(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > maxImageSize {
+		return "", fmt.Errorf("%s is larger than %d MB", path, maxImageSize>>20)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// attachTaskImages attaches image files mentioned in the task that exist on
+// disk to the first message of the conversation.
+func (a *Agent) attachTaskImages() {
+	if !cfg.Vision {
+		return
+	}
+
+	var names []string
+	for _, path := range imagePathPattern.FindAllString(a.Task, -1) {
+		image, err := loadImage(path)
+		if err != nil {
+			continue
+		}
+		a.Messages[0].Images = append(a.Messages[0].Images, image)
+		names = append(names, path)
+	}
+	if len(names) > 0 {
+		a.printf("🖼️  Attached %s\n", strings.Join(names, ", "))
+		a.Messages[0].Content += "\n\nATTACHED IMAGES (in order): " + strings.Join(names, ", ")
+	}
+}
+
+// screenshotCommand returns a command that captures the screen to file.
+func screenshotCommand(file string) (*exec.Cmd, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("screencapture", "-x", file), nil
+	case "windows":
+		cmd := exec.Command("powershell.exe", "-NoProfile", "-Command", windowsScreenshotScript)
+		cmd.Env = append(os.Environ(), "SHAI_SCREENSHOT="+file)
+		return cmd, nil
+	}
+
+	candidates := [][]string{
+		{"grim", file},
+		{"gnome-screenshot", "-f", file},
+		{"spectacle", "-b", "-n", "-o", file},
+		{"scrot", "-o", file},
+		{"import", "-window", "root", file},
+	}
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate[0]); err == nil {
+			return exec.Command(candidate[0], candidate[1:]...), nil
+		}
+	}
+	return nil, fmt.Errorf("no screenshot tool found (tried grim, gnome-screenshot, spectacle, scrot, import)")
+}
+
+func takeScreenshot() (string, error) {
+	dir, err := os.MkdirTemp("", "shai-screenshot")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "screen.png")
+
+	cmd, err := screenshotCommand(file)
+	if err != nil {
+		return "", err
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("screenshot failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return loadImage(file)
+}
+
+// imageFeedback builds the message answering a SCREENSHOT or VIEW_IMAGE
+// action, with the image attached when it could be captured.
+func imageFeedback(kind string, image string, err error) Message {
+	if err != nil {
+		return Message{Role: "user", Content: fmt.Sprintf("%s_RESULT:\nSTATUS: ERROR\nOUTPUT:\n%v\n\n", kind, err)}
+	}
+	return Message{
+		Role:    "user",
+		Content: fmt.Sprintf("%s_RESULT:\nSTATUS: SUCCESS\nThe image is attached to this message.\n\n", kind),
+		Images:  []string{image},
+	}
+}
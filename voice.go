@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// VoiceConfig configures speech-to-text for --voice. Audio is recorded with
+// RecordCommand (an argv where "{file}" is replaced by the WAV file to write)
+// and sent to STTURL, either a whisper.cpp server ("whisper.cpp", the
+// /inference endpoint) or an OpenAI-compatible transcription endpoint
+// ("openai", /v1/audio/transcriptions).
+type VoiceConfig struct {
+	STTURL        string   `json:"stt_url"`
+	STTAPI        string   `json:"stt_api"`
+	STTModel      string   `json:"stt_model"`
+	APIKey        string   `json:"api_key"`
+	RecordCommand []string `json:"record_command"`
+}
+
+const defaultSTTURL = "http://localhost:8080/inference"
+
+// voiceMode is set by --voice; tasks and clarifications are then dictated.
+var voiceMode bool
+
+// recordCommand returns the configured recorder or the first known one that
+// is installed.
+func recordCommand(file string) ([]string, error) {
+	argv := cfg.Voice.RecordCommand
+	if len(argv) == 0 {
+		candidates := [][]string{
+			{"arecord", "-q", "-f", "S16_LE", "-r", "16000", "-c", "1", "{file}"},
+			{"rec", "-q", "-r", "16000", "-c", "1", "-b", "16", "{file}"},
+		}
+		if runtime.GOOS == "darwin" {
+			candidates = append(candidates, []string{"ffmpeg", "-loglevel", "quiet", "-f", "avfoundation", "-i", ":0", "-ar", "16000", "-ac", "1", "-y", "{file}"})
+		}
+		for _, candidate := range candidates {
+			if _, err := exec.LookPath(candidate[0]); err == nil {
+				argv = candidate
+				break
+			}
+		}
+	}
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("no audio recorder found; install arecord or sox, or set voice.record_command")
+	}
+
+	resolved := make([]string, len(argv))
+	for i, arg := range argv {
+		resolved[i] = strings.ReplaceAll(arg, "{file}", file)
+	}
+	return resolved, nil
+}
+
+// recordAudio records from the microphone until the user presses Enter.
+func recordAudio(reader *bufio.Reader) (string, error) {
+	dir, err := os.MkdirTemp("", "shai-voice")
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, "input.wav")
+
+	argv, err := recordCommand(file)
+	if err != nil {
+		return "", err
+	}
+	recorder := exec.Command(argv[0], argv[1:]...)
+	if err := recorder.Start(); err != nil {
+		return "", fmt.Errorf("failed to start recorder %s: %w", argv[0], err)
+	}
+
+	fmt.Print("🎙️  Recording... press Enter to stop. ")
+	reader.ReadString('\n')
+
+	// Interrupt rather than kill so the recorder finalizes the WAV header.
+	if err := recorder.Process.Signal(os.Interrupt); err != nil {
+		recorder.Process.Kill()
+	}
+	recorder.Wait()
+	return file, nil
+}
+
+func transcribe(file string) (string, error) {
+	audio, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer audio.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filepath.Base(file))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, audio); err != nil {
+		return "", err
+	}
+	form.WriteField("response_format", "json")
+	if cfg.Voice.STTAPI == "openai" {
+		form.WriteField("model", cfg.Voice.STTModel)
+	}
+	form.Close()
+
+	req, err := http.NewRequest("POST", cfg.Voice.STTURL, &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create transcription request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if cfg.Voice.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Voice.APIKey)
+	}
+
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach speech-to-text server at %s: %w", cfg.Voice.STTURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("speech-to-text server returned status code %d. Body: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode transcription: %w", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// dictate records and transcribes one utterance, letting the user retry or
+// fall back to typing.
+func dictate(reader *bufio.Reader) (string, error) {
+	for {
+		file, err := recordAudio(reader)
+		if err != nil {
+			return "", err
+		}
+		text, err := transcribe(file)
+		os.RemoveAll(filepath.Dir(file))
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Printf("\n📝 Heard: %s\n", text)
+		fmt.Print("Use this? [ (Y)es / (r)ecord again / (t)ype instead ]: ")
+		input, _ := reader.ReadString('\n')
+		input = strings.TrimSpace(strings.ToLower(input))
+		switch {
+		case strings.HasPrefix(input, "r"):
+			continue
+		case strings.HasPrefix(input, "t"):
+			fmt.Print("> ")
+			typed, _ := reader.ReadString('\n')
+			return strings.TrimSpace(typed), nil
+		default:
+			return text, nil
+		}
+	}
+}
+
+// readResponse reads the user's answer to a prompt, by voice when --voice is
+// active and from the keyboard otherwise.
+func readResponse(prompt string, reader *bufio.Reader) string {
+	fmt.Print(prompt)
+	if cfg.NonInteractive {
+		fmt.Println("[non-interactive: no answer]")
+		return ""
+	}
+	if voiceMode {
+		fmt.Println()
+		text, err := dictate(reader)
+		if err == nil {
+			return text
+		}
+		fmt.Printf("⚠️ Voice input failed (%v), please type instead: ", err)
+	}
+	input, _ := readLine(reader)
+	return input
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// warmUpModels returns the models the first steps of a run will need, in the
+// order they will be needed.
+func warmUpModels() []string {
+	var models []string
+	if plannerEnabled() {
+		models = append(models, cfg.PlannerModel)
+	}
+	if routerEnabled() {
+		models = append(models, cfg.Router.SmallModel)
+	} else {
+		models = append(models, executorModel())
+	}
+	return slices.Compact(models)
+}
+
+// startWarmUp loads the models in the background so the first real step
+// does not pay the model load latency. Ollama loads a model without
+// generating anything when it receives a chat request with no messages.
+func startWarmUp() {
+	if !cfg.WarmUp || !usesOllama() {
+		return
+	}
+	go func() {
+		for _, model := range warmUpModels() {
+			if err := warmUp(model); err != nil {
+				fmt.Printf("⚠️ Failed to warm up %s: %v\n", model, err)
+			}
+		}
+	}()
+}
+
+func warmUp(model string) error {
+	jsonBody, _ := json.Marshal(ChatRequest{
+		Model:     model,
+		Messages:  []Message{},
+		KeepAlive: cfg.KeepAlive,
+	})
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Post(ollamaURL(), "application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Ollama returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// webUI is a single-page app on top of the `shai serve` API, for following
+// and approving long-running sessions from a browser or phone.
+//
+//go:embed webui.html
+var webUI []byte
+
+// serveWebUI serves the page. It holds no data, so it needs no token; the
+// page asks the API with the token it was opened with (/?token=...).
+func serveWebUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(webUI)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/base64"
+	"os/exec"
+	"strings"
+	"unicode/utf16"
+)
+
+// Windows shells do not parse their command line the way Go quotes
+// arguments, so commands are not passed as a single -Command or /C
+// argument. PowerShell gets the script base64-encoded with -EncodedCommand,
+// which survives any quoting, and cmd.exe gets a verbatim command line.
+
+// powerShellEpilogue makes the script's exit status that of the command:
+// the last native program's exit code, or 1 if a cmdlet failed.
+const powerShellEpilogue = "\n$__shaiOK = $?\nif ($LASTEXITCODE) { exit $LASTEXITCODE }\nif (-not $__shaiOK) { exit 1 }\nexit 0\n"
+
+// isPowerShell reports whether a shell is Windows PowerShell or PowerShell
+// Core (pwsh).
+func isPowerShell(shellPath string) bool {
+	name := strings.ToLower(shellPath[strings.LastIndexAny(shellPath, `\/`)+1:])
+	name = strings.TrimSuffix(name, ".exe")
+	return name == "powershell" || name == "pwsh"
+}
+
+// windowsShell picks the shell from $SHELL, which is set by hand or by
+// environments such as MSYS: pwsh, Windows PowerShell, or cmd.exe.
+func windowsShell(userShell string) string {
+	lower := strings.ToLower(userShell)
+	switch {
+	case strings.Contains(lower, "pwsh"):
+		return "pwsh.exe"
+	case strings.Contains(lower, "powershell"):
+		return "powershell.exe"
+	}
+	return "cmd.exe"
+}
+
+// encodePowerShell encodes a script for -EncodedCommand: base64 of its
+// UTF-16LE bytes.
+func encodePowerShell(script string) string {
+	units := utf16.Encode([]rune(script))
+	bytes := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		bytes = append(bytes, byte(u), byte(u>>8))
+	}
+	return base64.StdEncoding.EncodeToString(bytes)
+}
+
+// windowsCommand builds the command that runs command in a Windows shell.
+func windowsCommand(shellPath string, command string) *exec.Cmd {
+	if isPowerShell(shellPath) {
+		script := "[Console]::OutputEncoding = [System.Text.Encoding]::UTF8\n" + command + powerShellEpilogue
+		return exec.Command(shellPath, "-NoProfile", "-NonInteractive", "-EncodedCommand", encodePowerShell(script))
+	}
+	cmd := exec.Command(shellPath)
+	setCommandLine(cmd, cmdCommandLine(shellPath, command))
+	return cmd
+}
+
+// cmdCommandLine is the verbatim command line that runs command in cmd.exe;
+// /s strips the outer quotes and runs the rest exactly as written.
+func cmdCommandLine(shellPath string, command string) string {
+	return shellPath + ` /d /s /c "` + command + `"`
+}
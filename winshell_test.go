@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func decodePowerShell(t *testing.T, encoded string) string {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", encoded, err)
+	}
+	if len(data)%2 != 0 {
+		t.Fatalf("odd number of UTF-16LE bytes: %d", len(data))
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+	}
+	return string(utf16.Decode(units))
+}
+
+func TestEncodePowerShell(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{"", ""},
+		{"dir", "ZABpAHIA"},
+		{"echo 'a\"b'", "ZQBjAGgAbwAgACcAYQAiAGIAJwA="},
+	}
+	for _, tt := range tests {
+		if got := encodePowerShell(tt.script); got != tt.want {
+			t.Errorf("encodePowerShell(%q) = %q, want %q", tt.script, got, tt.want)
+		}
+	}
+}
+
+func TestEncodePowerShellRoundTrip(t *testing.T) {
+	scripts := []string{
+		`Write-Output "it's ""quoted"""`,
+		"$env:PATH -split ';' | % { $_ }",
+		"echo 100% ^& done & exit",
+		`Get-ChildItem C:\Program Files\`,
+		"Write-Output 'héllo wörld — ✓'",
+		"Write-Output '😀 outside the BMP'",
+		"line one\r\nline two\n",
+	}
+	for _, script := range scripts {
+		if got := decodePowerShell(t, encodePowerShell(script)); got != script {
+			t.Errorf("round trip of %q gave %q", script, got)
+		}
+	}
+}
+
+func TestCmdCommandLine(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{`dir`, `cmd.exe /d /s /c "dir"`},
+		{`echo "hello world"`, `cmd.exe /d /s /c "echo "hello world""`},
+		{`"C:\Program Files\app.exe" "arg one"`, `cmd.exe /d /s /c ""C:\Program Files\app.exe" "arg one""`},
+		{`echo %PATH%`, `cmd.exe /d /s /c "echo %PATH%"`},
+		{`echo a ^& b`, `cmd.exe /d /s /c "echo a ^& b"`},
+		{`cd build & make`, `cmd.exe /d /s /c "cd build & make"`},
+		{`dir C:\temp\`, `cmd.exe /d /s /c "dir C:\temp\"`},
+		{`dir "C:\temp\\"`, `cmd.exe /d /s /c "dir "C:\temp\\""`},
+	}
+	for _, tt := range tests {
+		if got := cmdCommandLine("cmd.exe", tt.command); got != tt.want {
+			t.Errorf("cmdCommandLine(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestWindowsCommandPowerShell(t *testing.T) {
+	command := `& "C:\tools\x.exe" --flag='a b' 100%`
+	cmd := windowsCommand(`C:\Program Files\PowerShell\7\pwsh.exe`, command)
+
+	args := cmd.Args[1:]
+	if len(args) != 4 || args[0] != "-NoProfile" || args[1] != "-NonInteractive" || args[2] != "-EncodedCommand" {
+		t.Fatalf("unexpected arguments %q", args)
+	}
+	script := decodePowerShell(t, args[3])
+	if !strings.Contains(script, "\n"+command+"\n") {
+		t.Errorf("script does not contain the command verbatim:\n%s", script)
+	}
+	if !strings.HasSuffix(script, powerShellEpilogue) {
+		t.Errorf("script does not end with the exit-code epilogue:\n%s", script)
+	}
+}
+
+func TestPowerShellEpilogueExitCode(t *testing.T) {
+	// The status is captured before anything else runs, a native exit code
+	// wins, and a failed cmdlet without one still exits non-zero.
+	lines := strings.Split(strings.TrimSpace(powerShellEpilogue), "\n")
+	want := []string{
+		"$__shaiOK = $?",
+		"if ($LASTEXITCODE) { exit $LASTEXITCODE }",
+		"if (-not $__shaiOK) { exit 1 }",
+		"exit 0",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("epilogue is\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestIsPowerShell(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"pwsh", true},
+		{"pwsh.exe", true},
+		{`C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`, true},
+		{"/usr/bin/pwsh", true},
+		{`C:\Program Files\PowerShell\7\PWSH.EXE`, true},
+		{"cmd.exe", false},
+		{`C:\Windows\System32\cmd.exe`, false},
+		{"/bin/bash", false},
+		{`C:\pwsh-tools\bash.exe`, false},
+	}
+	for _, tt := range tests {
+		if got := isPowerShell(tt.path); got != tt.want {
+			t.Errorf("isPowerShell(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWindowsShell(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  string
+	}{
+		{"", "cmd.exe"},
+		{"/usr/bin/bash", "cmd.exe"},
+		{"pwsh", "pwsh.exe"},
+		{`C:\Program Files\PowerShell\7\pwsh.exe`, "pwsh.exe"},
+		{`C:\Windows\System32\WindowsPowerShell\v1.0\PowerShell.exe`, "powershell.exe"},
+	}
+	for _, tt := range tests {
+		if got := windowsShell(tt.shell); got != tt.want {
+			t.Errorf("windowsShell(%q) = %q, want %q", tt.shell, got, tt.want)
+		}
+	}
+}
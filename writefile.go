@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const writeFilePromptSection = `
+EDITING FILES:
+Do not write files with heredocs or echo; use these actions instead:
+   - "READ_FILE" followed by a path reads the whole file, so you can rewrite it.
+   - "WRITE_FILE" followed by a path on the same line, and the complete new contents of the file on the following lines, replaces the file (or creates it, with any missing parent directories). The user is shown a diff and must approve it.
+`
+
+// maxReadFileSize bounds READ_FILE. Unlike CAT, it refuses larger files
+// rather than truncating them, since the contents are meant to be written
+// back whole.
+const maxReadFileSize = 256 << 10
+
+// maxDiffCells bounds the line diff, which is quadratic in the file length.
+const maxDiffCells = 4_000_000
+
+func readFileAction(state *shellState, arg string) (string, string) {
+	path := state.resolve(arg)
+	data, truncated, err := readTextFile(path, maxReadFileSize)
+	if err != nil {
+		return "ERROR", err.Error()
+	}
+	if truncated {
+		return "ERROR", fmt.Sprintf("%s is larger than %d KB; use HEAD or SEARCH_FILES, and edit it with a command instead", path, maxReadFileSize>>10)
+	}
+	return "SUCCESS", string(data)
+}
+
+// parseWriteFile splits WRITE_FILE content into the path and the new file
+// contents. A Markdown code fence around the contents is removed.
+func parseWriteFile(content string) (path string, body string, ok bool) {
+	path, body, _ = strings.Cut(content, "\n")
+	path = expandPath(path)
+	if path == "" {
+		return "", "", false
+	}
+
+	lines := strings.Split(strings.TrimRight(body, "\n"), "\n")
+	if len(lines) >= 2 && strings.HasPrefix(lines[0], "```") && strings.TrimSpace(lines[len(lines)-1]) == "```" {
+		lines = lines[1 : len(lines)-1]
+	}
+	body = strings.Join(lines, "\n")
+	if body != "" {
+		body += "\n"
+	}
+	return path, body, true
+}
+
+// writeFile handles a WRITE_FILE action: it shows a diff against the current
+// file and writes the new contents once approved.
+func (a *Agent) writeFile(path string, body string) (status string, output string) {
+	path = a.shell.resolve(path)
+	old, exists := "", false
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() {
+			return "ERROR", fmt.Sprintf("%s is a directory", path)
+		}
+		data, truncated, err := readTextFile(path, maxReadFileSize)
+		if err != nil {
+			return "ERROR", err.Error()
+		}
+		if truncated {
+			return "ERROR", fmt.Sprintf("%s is larger than %d KB and cannot be replaced with WRITE_FILE", path, maxReadFileSize>>10)
+		}
+		old, exists = string(data), true
+	}
+	if exists && old == body {
+		return "SUCCESS", "The file already has these contents; nothing was written."
+	}
+
+	var diff string
+	if exists {
+		diff = unifiedDiff(path, old, body)
+	} else {
+		diff = unifiedDiff("/dev/null", "", body)
+	}
+	command := "WRITE_FILE " + path
+
+	defer func() { a.audit("WRITE_FILE", path, riskMutating, status, body) }()
+
+	if cfg.DryRun {
+		a.printf("🧪 Dry run, not writing %s:\n\n%s\n", path, diff)
+		return "DRY_RUN", dryRunOutput
+	}
+	if approvalFor(riskMutating) == approvalDeny {
+		a.printf("⛔ The %s approval policy denies writing files.\n", cfg.Approval)
+		return "DENIED", "The approval policy does not allow writing files, so the file was not written."
+	}
+	verb := "change"
+	if !exists {
+		verb = "create"
+	}
+	if !a.approve(riskMutating, fmt.Sprintf("✍️ shai wants to %s %s:\n\n%s\nAllow?", verb, path, diff)) {
+		a.printf("🛑 Rejecting file write.\n")
+		return "REJECTED", "File write rejected by user."
+	}
+
+	a.maybeSnapshot(command)
+	entry := newJournalEntryForPaths(a.auditSession(), a.Task, a.shell.workDir(), command, []string{path})
+	status, output = cassetteResult("WRITE_FILE", path, func() (string, string) {
+		if err := writeFileContents(path, body); err != nil {
+			return "ERROR", err.Error()
+		}
+		return "SUCCESS", fmt.Sprintf("Wrote %d bytes to %s.", len(body), path)
+	})
+	if err := appendJournal(entry, status); err != nil {
+		a.printf("⚠️ Failed to record the change for shai undo: %v\n", err)
+	}
+	return status, output
+}
+
+// writeFileContents replaces a file, keeping its permissions.
+func writeFileContents(path string, body string) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	return os.WriteFile(path, []byte(body), mode)
+}
+
+// unifiedDiff returns a unified diff of two versions of a file, with three
+// lines of context.
+func unifiedDiff(name string, old string, new string) string {
+	a, b := splitLines(old), splitLines(new)
+	header := fmt.Sprintf("--- %s\n+++ %s\n", name, name)
+	if name == "/dev/null" {
+		header = "--- /dev/null\n+++ new file\n"
+	}
+	if len(a)*len(b) > maxDiffCells {
+		return header + fmt.Sprintf("@@ too large to diff: %d lines -> %d lines @@\n", len(a), len(b))
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type line struct {
+		op           byte
+		text         string
+		aLine, bLine int
+	}
+	var lines []line
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, line{' ', a[i], i, j})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, line{'-', a[i], i, j})
+			i++
+		default:
+			lines = append(lines, line{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	const context = 3
+	var out strings.Builder
+	out.WriteString(header)
+	for start := 0; start < len(lines); {
+		if lines[start].op == ' ' {
+			start++
+			continue
+		}
+		// Grow the hunk until the changes are more than two contexts apart.
+		from := max(0, start-context)
+		end := start
+		for k := start; k < len(lines) && k <= end+2*context; k++ {
+			if lines[k].op != ' ' {
+				end = k
+			}
+		}
+		to := min(len(lines), end+context+1)
+
+		aCount, bCount := 0, 0
+		for _, l := range lines[from:to] {
+			if l.op != '+' {
+				aCount++
+			}
+			if l.op != '-' {
+				bCount++
+			}
+		}
+		// An empty range starts at the line before it, as in diff -u.
+		aStart, bStart := lines[from].aLine+1, lines[from].bLine+1
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, l := range lines[from:to] {
+			fmt.Fprintf(&out, "%c%s\n", l.op, l.text)
+		}
+		start = to
+	}
+	return out.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}